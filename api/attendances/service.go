@@ -0,0 +1,252 @@
+package attendances
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	. "github.com/Vinubaba/SANTC-API/common/api"
+	"github.com/Vinubaba/SANTC-API/common/firebase/claims"
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/store"
+
+	"github.com/araddon/dateparse"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrEmptyChild            = errors.New("childId cannot be empty")
+	ErrEmptyDroppedBy        = errors.New("droppedBy is mandatory")
+	ErrEmptyPickedUpBy       = errors.New("pickedUpBy is mandatory")
+	ErrCheckOutBeforeCheckIn = errors.New("checkOut cannot be before checkIn")
+	ErrInvalidDate           = errors.New("invalid date")
+	ErrUnauthorizedPickup    = errors.New("child cannot be picked up by someone who is not on the authorized pickup list")
+	ErrUnknownPickup         = errors.New("authorizedPickupId does not match any approved pickup, pickedUpBy is mandatory")
+)
+
+type Service interface {
+	CheckIn(ctx context.Context, request AttendanceTransport) (store.Attendance, error)
+	CheckOut(ctx context.Context, request AttendanceTransport) (store.Attendance, error)
+	ListAttendances(ctx context.Context, request AttendanceSearchTransport) ([]store.Attendance, error)
+}
+
+type AttendanceService struct {
+	Store interface {
+		CheckIn(tx *gorm.DB, attendance store.Attendance) (store.Attendance, error)
+		CheckOut(tx *gorm.DB, attendance store.Attendance) (store.Attendance, error)
+		ListAttendances(tx *gorm.DB, options store.AttendanceSearchOptions) ([]store.Attendance, error)
+
+		ListAuthorizedPickups(tx *gorm.DB, options store.AuthorizedPickupSearchOptions) ([]store.AuthorizedPickup, error)
+
+		GetChild(tx *gorm.DB, childId string, options store.SearchOptions) (store.Child, error)
+		GetUser(tx *gorm.DB, userId string, searchOptions store.SearchOptions) (store.User, error)
+	} `inject:""`
+	Logger *log.Logger `inject:""`
+}
+
+func (c *AttendanceService) CheckIn(ctx context.Context, request AttendanceTransport) (store.Attendance, error) {
+	if IsNilOrEmpty(request.ChildId) {
+		return store.Attendance{}, ErrEmptyChild
+	}
+	if IsNilOrEmpty(request.DroppedBy) {
+		return store.Attendance{}, ErrEmptyDroppedBy
+	}
+
+	if _, err := c.Store.GetChild(nil, *request.ChildId, claims.GetDefaultSearchOptions(ctx)); err != nil {
+		return store.Attendance{}, errors.Wrap(err, "failed to check in child")
+	}
+
+	checkIn, err := parseDateOrNow(request.CheckIn)
+	if err != nil {
+		return store.Attendance{}, err
+	}
+
+	userId := claims.GetUserId(ctx)
+	attendance, err := c.Store.CheckIn(nil, store.Attendance{
+		ChildId:     store.DbNullString(request.ChildId),
+		CheckIn:     checkIn,
+		DroppedBy:   store.DbNullString(request.DroppedBy),
+		CheckedInBy: store.DbNullString(nullIfEmpty(userId)),
+	})
+	if err != nil {
+		return store.Attendance{}, errors.Wrap(err, "failed to check in child")
+	}
+
+	return attendance, nil
+}
+
+func (c *AttendanceService) CheckOut(ctx context.Context, request AttendanceTransport) (store.Attendance, error) {
+	if IsNilOrEmpty(request.ChildId) {
+		return store.Attendance{}, ErrEmptyChild
+	}
+	if IsNilOrEmpty(request.PickedUpBy) && IsNilOrEmpty(request.AuthorizedPickupId) {
+		return store.Attendance{}, ErrEmptyPickedUpBy
+	}
+
+	child, err := c.Store.GetChild(nil, *request.ChildId, claims.GetDefaultSearchOptions(ctx))
+	if err != nil {
+		return store.Attendance{}, errors.Wrap(err, "failed to check out child")
+	}
+
+	checkOut, err := parseDateOrNow(request.CheckOut)
+	if err != nil {
+		return store.Attendance{}, err
+	}
+
+	openAttendances, err := c.Store.ListAttendances(nil, store.AttendanceSearchOptions{ChildId: *request.ChildId})
+	if err != nil {
+		return store.Attendance{}, errors.Wrap(err, "failed to check out child")
+	}
+	checkedIn := false
+	for _, attendance := range openAttendances {
+		if attendance.CheckOut.Valid {
+			continue
+		}
+		checkedIn = true
+		if checkOut.Before(attendance.CheckIn) {
+			return store.Attendance{}, ErrCheckOutBeforeCheckIn
+		}
+	}
+	if !checkedIn {
+		return store.Attendance{}, errors.Wrap(store.ErrChildNotCheckedIn, "failed to check out child")
+	}
+
+	pickedUpBy, pickupId, authorized, err := c.checkPickup(ctx, child, request, checkOut)
+	if err != nil {
+		return store.Attendance{}, errors.Wrap(err, "failed to check out child")
+	}
+	// only an office manager can let someone who is not on the list pick up the child, and it is recorded
+	forced := request.Force != nil && *request.Force && (claims.IsOfficeManager(ctx) || claims.IsAdmin(ctx))
+	if !authorized && !forced {
+		return store.Attendance{}, ErrUnauthorizedPickup
+	}
+
+	userId := claims.GetUserId(ctx)
+	attendance, err := c.Store.CheckOut(nil, store.Attendance{
+		ChildId:            store.DbNullString(request.ChildId),
+		CheckOut:           pq.NullTime{Time: checkOut, Valid: true},
+		PickedUpBy:         store.DbNullString(&pickedUpBy),
+		CheckedOutBy:       store.DbNullString(nullIfEmpty(userId)),
+		PickupId:           store.DbNullString(nullIfEmpty(pickupId)),
+		UnauthorizedPickup: sql.NullBool{Bool: !authorized, Valid: true},
+	})
+	if err != nil {
+		return store.Attendance{}, errors.Wrap(err, "failed to check out child")
+	}
+
+	return attendance, nil
+}
+
+func (c *AttendanceService) ListAttendances(ctx context.Context, request AttendanceSearchTransport) ([]store.Attendance, error) {
+	if IsNilOrEmpty(request.ChildId) {
+		return []store.Attendance{}, ErrEmptyChild
+	}
+
+	if _, err := c.Store.GetChild(nil, *request.ChildId, claims.GetDefaultSearchOptions(ctx)); err != nil {
+		return []store.Attendance{}, errors.Wrap(err, "failed to list attendances")
+	}
+
+	options := store.AttendanceSearchOptions{ChildId: *request.ChildId}
+	if !IsNilOrEmpty(request.From) {
+		from, err := dateparse.ParseIn(*request.From, time.UTC)
+		if err != nil {
+			return []store.Attendance{}, errors.Wrap(ErrInvalidDate, err.Error())
+		}
+		options.From = from
+	}
+	if !IsNilOrEmpty(request.To) {
+		to, err := dateparse.ParseIn(*request.To, time.UTC)
+		if err != nil {
+			return []store.Attendance{}, errors.Wrap(ErrInvalidDate, err.Error())
+		}
+		options.To = to
+	}
+
+	attendances, err := c.Store.ListAttendances(nil, options)
+	if err != nil {
+		return []store.Attendance{}, errors.Wrap(err, "failed to list attendances")
+	}
+
+	return attendances, nil
+}
+
+// checkPickup tells who is picking up the child and whether this person is allowed to.
+// The child's responsible and the approved pickups valid at that time are allowed.
+func (c *AttendanceService) checkPickup(ctx context.Context, child store.Child, request AttendanceTransport, at time.Time) (pickedUpBy string, pickupId string, authorized bool, err error) {
+	if request.PickedUpBy != nil {
+		pickedUpBy = *request.PickedUpBy
+	}
+
+	pickups, err := c.Store.ListAuthorizedPickups(nil, store.AuthorizedPickupSearchOptions{
+		ChildId:      child.ChildId.String,
+		OnlyApproved: true,
+	})
+	if err != nil {
+		return "", "", false, err
+	}
+
+	for _, pickup := range pickups {
+		if !pickup.IsValidAt(at) {
+			continue
+		}
+		fullName := pickup.FirstName.String + " " + pickup.LastName.String
+		if !IsNilOrEmpty(request.AuthorizedPickupId) {
+			if pickup.PickupId.String != *request.AuthorizedPickupId {
+				continue
+			}
+			if pickedUpBy == "" {
+				pickedUpBy = fullName
+			}
+			return pickedUpBy, pickup.PickupId.String, true, nil
+		}
+		if sameName(pickedUpBy, fullName) {
+			return pickedUpBy, pickup.PickupId.String, true, nil
+		}
+	}
+
+	if pickedUpBy == "" {
+		// nobody to record as picking up the child, even when an office manager forces the check out
+		return "", "", false, ErrUnknownPickup
+	}
+
+	if IsNilOrEmpty(request.AuthorizedPickupId) && child.ResponsibleId.String != "" {
+		responsible, err := c.Store.GetUser(nil, child.ResponsibleId.String, store.SearchOptions{})
+		if err != nil && errors.Cause(err) != store.ErrUserNotFound {
+			return "", "", false, err
+		}
+		if err == nil && sameName(pickedUpBy, responsible.FirstName.String+" "+responsible.LastName.String) {
+			return pickedUpBy, "", true, nil
+		}
+	}
+
+	return pickedUpBy, "", false, nil
+}
+
+func sameName(a, b string) bool {
+	return strings.EqualFold(strings.Join(strings.Fields(a), " "), strings.Join(strings.Fields(b), " "))
+}
+
+// When no date is given, the child is considered checked in (or out) right now
+func parseDateOrNow(date *string) (time.Time, error) {
+	if IsNilOrEmpty(date) {
+		return time.Now().UTC(), nil
+	}
+	parsed, err := dateparse.ParseIn(*date, time.UTC)
+	if err != nil {
+		return time.Time{}, errors.Wrap(ErrInvalidDate, err.Error())
+	}
+	return parsed, nil
+}
+
+func nullIfEmpty(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// ServiceMiddleware is a chainable behavior modifier for attendanceService.
+type ServiceMiddleware func(AttendanceService) AttendanceService
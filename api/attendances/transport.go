@@ -0,0 +1,182 @@
+package attendances
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	"github.com/Vinubaba/SANTC-API/common/store"
+
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrBadRouting = errors.New("inconsistent mapping between route and handler (programmer error)")
+)
+
+type AttendanceTransport struct {
+	Id           *string `json:"id"`
+	ChildId      *string `json:"childId"`
+	CheckIn      *string `json:"checkIn"`
+	CheckOut     *string `json:"checkOut"`
+	DroppedBy    *string `json:"droppedBy"`
+	PickedUpBy   *string `json:"pickedUpBy"`
+	CheckedInBy  *string `json:"checkedInBy"`
+	CheckedOutBy *string `json:"checkedOutBy"`
+	// Id of the authorized pickup who picked up the child
+	AuthorizedPickupId *string `json:"authorizedPickupId"`
+	UnauthorizedPickup *bool   `json:"unauthorizedPickup"`
+	// Lets an office manager check out a child picked up by someone who is not authorized
+	Force *bool `json:"force,omitempty"`
+}
+
+type AttendanceSearchTransport struct {
+	ChildId *string
+	From    *string
+	To      *string
+}
+
+type HandlerFactory struct {
+	Service Service `inject:""`
+}
+
+func (h *HandlerFactory) CheckIn(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeCheckInEndpoint(h.Service),
+		decodeAttendanceTransport,
+		shared.EncodeResponse201,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) CheckOut(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeCheckOutEndpoint(h.Service),
+		decodeAttendanceTransport,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) List(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeListEndpoint(h.Service),
+		decodeAttendanceSearchTransport,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
+func makeCheckInEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(AttendanceTransport)
+		attendance, err := svc.CheckIn(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return storeToTransport(attendance), nil
+	}
+}
+
+func makeCheckOutEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(AttendanceTransport)
+		attendance, err := svc.CheckOut(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return storeToTransport(attendance), nil
+	}
+}
+
+func makeListEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(AttendanceSearchTransport)
+		attendances, err := svc.ListAttendances(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		attendancesRet := []AttendanceTransport{}
+		for _, attendance := range attendances {
+			attendancesRet = append(attendancesRet, storeToTransport(attendance))
+		}
+
+		return attendancesRet, nil
+	}
+}
+
+func decodeAttendanceTransport(_ context.Context, r *http.Request) (interface{}, error) {
+	// get id from url
+	vars := mux.Vars(r)
+	childId, ok := vars["childId"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+	// get informations from payload
+	var request AttendanceTransport
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	request.ChildId = &childId
+	return request, nil
+}
+
+func decodeAttendanceSearchTransport(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	childId, ok := vars["childId"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	return AttendanceSearchTransport{
+		ChildId: &childId,
+		From:    &from,
+		To:      &to,
+	}, nil
+}
+
+// encode errors from business-logic
+func EncodeError(_ context.Context, err error, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	switch errors.Cause(err) {
+	case ErrEmptyChild, ErrEmptyDroppedBy, ErrEmptyPickedUpBy, ErrCheckOutBeforeCheckIn, ErrInvalidDate, ErrUnknownPickup,
+		store.ErrChildAlreadyCheckedIn, store.ErrChildNotCheckedIn:
+		w.WriteHeader(http.StatusBadRequest)
+	case ErrUnauthorizedPickup:
+		w.WriteHeader(http.StatusForbidden)
+	case store.ErrChildNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": err.Error(),
+	})
+}
+
+func storeToTransport(attendance store.Attendance) AttendanceTransport {
+	checkIn := attendance.CheckIn.UTC().String()
+	ret := AttendanceTransport{
+		Id:                 &attendance.AttendanceId.String,
+		ChildId:            &attendance.ChildId.String,
+		CheckIn:            &checkIn,
+		DroppedBy:          &attendance.DroppedBy.String,
+		PickedUpBy:         &attendance.PickedUpBy.String,
+		CheckedInBy:        &attendance.CheckedInBy.String,
+		CheckedOutBy:       &attendance.CheckedOutBy.String,
+		AuthorizedPickupId: &attendance.PickupId.String,
+		UnauthorizedPickup: &attendance.UnauthorizedPickup.Bool,
+	}
+	if attendance.CheckOut.Valid {
+		checkOut := attendance.CheckOut.Time.UTC().String()
+		ret.CheckOut = &checkOut
+	}
+	return ret
+}
@@ -0,0 +1,487 @@
+package attendances_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/Vinubaba/SANTC-API/api/attendances"
+	"github.com/Vinubaba/SANTC-API/api/authentication"
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	. "github.com/Vinubaba/SANTC-API/api/shared/mocks"
+	"github.com/Vinubaba/SANTC-API/api/users"
+	"github.com/Vinubaba/SANTC-API/common/store"
+
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/roles"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/lib/pq"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Transport", func() {
+
+	var (
+		router   *mux.Router
+		recorder *httptest.ResponseRecorder
+
+		concreteStore       *store.Store
+		concreteDb          *gorm.DB
+		mockStringGenerator *MockStringGenerator
+
+		authenticator *authentication.Authenticator
+
+		claims                                            map[string]interface{}
+		reqToUse                                          *http.Request
+		httpMethodToUse, httpEndpointToUse, httpBodyToUse string
+	)
+
+	var (
+		assertHttpCode = func(code int) {
+			It(fmt.Sprintf("should respond with status code %d", code), func() {
+				Expect(recorder.Code).To(Equal(code))
+			})
+		}
+
+		assertReturnedNoPayload = func() {
+			It("should respond with no payload", func() {
+				Expect(recorder.Body.String()).To(Equal(""))
+			})
+		}
+
+		assertJsonResponse = func(response string) {
+			It("should respond with json response", func() {
+				Expect(recorder.Header().Get("Content-Type")).To(ContainSubstring("application/json"))
+				Expect(recorder.Body.String()).To(MatchJSON(response))
+			})
+		}
+	)
+
+	BeforeEach(func() {
+		concreteDb = shared.NewDbInstance(false)
+
+		mockStringGenerator = &MockStringGenerator{}
+		mockStringGenerator.On("GenerateUuid").Return("aaa").Once()
+		mockStringGenerator.On("GenerateUuid").Return("bbb").Once()
+
+		concreteStore = &store.Store{
+			Db:              concreteDb,
+			StringGenerator: mockStringGenerator,
+		}
+
+		userService := &users.UserService{
+			Store: concreteStore,
+		}
+		logger := log.NewLogger("teddycare")
+
+		authenticator = &authentication.Authenticator{
+			UserService: userService,
+			Logger:      logger,
+		}
+
+		attendanceService := &AttendanceService{
+			Store:  concreteStore,
+			Logger: logger,
+		}
+
+		httpMethodToUse = ""
+		httpEndpointToUse = ""
+		httpBodyToUse = ""
+
+		router = mux.NewRouter()
+		opts := []kithttp.ServerOption{
+			kithttp.ServerErrorLogger(logger),
+			kithttp.ServerErrorEncoder(EncodeError),
+		}
+
+		handlerFactory := HandlerFactory{
+			Service: attendanceService,
+		}
+
+		router.Handle("/children/{childId}/attendance", authenticator.Roles(handlerFactory.List(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADULT, roles.ROLE_ADMIN, roles.ROLE_TEACHER)).Methods(http.MethodGet)
+		router.Handle("/children/{childId}/attendance/check-in", authenticator.Roles(handlerFactory.CheckIn(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN, roles.ROLE_TEACHER)).Methods(http.MethodPost)
+		router.Handle("/children/{childId}/attendance/check-out", authenticator.Roles(handlerFactory.CheckOut(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN, roles.ROLE_TEACHER)).Methods(http.MethodPost)
+
+		recorder = httptest.NewRecorder()
+
+		shared.SetDbInitialState()
+	})
+
+	AfterEach(func() {
+		concreteDb.Close()
+	})
+
+	BeforeEach(func() {
+		claims = map[string]interface{}{
+			"userId":                  "",
+			"daycareId":               "peyredragon",
+			roles.ROLE_TEACHER:        false,
+			roles.ROLE_OFFICE_MANAGER: false,
+			roles.ROLE_ADULT:          false,
+			roles.ROLE_ADMIN:          false,
+		}
+	})
+
+	JustBeforeEach(func() {
+		reqToUse, _ = http.NewRequest(httpMethodToUse, httpEndpointToUse, strings.NewReader(httpBodyToUse))
+		reqToUse = reqToUse.WithContext(context.WithValue(context.Background(), "claims", claims))
+		router.ServeHTTP(recorder, reqToUse)
+	})
+
+	Describe("LIST", func() {
+
+		var (
+			expectedJsonAttendances = `[
+			  {
+				"id": "attendanceid-1",
+				"childId": "childid-3",
+				"checkIn": "2018-05-14 08:30:00 +0000 UTC",
+				"checkOut": "2018-05-14 17:45:00 +0000 UTC",
+				"droppedBy": "Caitlyn Stark",
+				"pickedUpBy": "Caitlyn Stark",
+				"checkedInBy": "id4",
+				"checkedOutBy": "id4",
+				"authorizedPickupId": "",
+				"unauthorizedPickup": false
+			  }
+			]`
+		)
+
+		BeforeEach(func() {
+			httpMethodToUse = http.MethodGet
+			httpEndpointToUse = "/children/childid-3/attendance"
+		})
+
+		Context("When user is an admin", func() {
+			BeforeEach(func() { claims[roles.ROLE_ADMIN] = true })
+			assertJsonResponse(expectedJsonAttendances)
+			assertHttpCode(http.StatusOK)
+		})
+
+		Context("When user is an office manager of the same daycare", func() {
+			BeforeEach(func() { claims[roles.ROLE_OFFICE_MANAGER] = true })
+			assertJsonResponse(expectedJsonAttendances)
+			assertHttpCode(http.StatusOK)
+		})
+
+		Context("When user is an office manager of another daycare", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				claims["daycareId"] = "namek"
+			})
+			assertJsonResponse(`{"error":"failed to list attendances: child not found"}`)
+			assertHttpCode(http.StatusNotFound)
+		})
+
+		Context("When user is an adult responsible of the child", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_ADULT] = true
+				claims["userId"] = "id4"
+			})
+			assertJsonResponse(expectedJsonAttendances)
+			assertHttpCode(http.StatusOK)
+		})
+
+		Context("When user is a random adult", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_ADULT] = true
+				claims["userId"] = "id5"
+			})
+			assertJsonResponse(`{"error":"failed to list attendances: child not found"}`)
+			assertHttpCode(http.StatusNotFound)
+		})
+
+		Context("When filtering on a period without attendance", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpEndpointToUse = "/children/childid-3/attendance?from=2018-05-15&to=2018-05-16"
+			})
+			assertJsonResponse(`[]`)
+			assertHttpCode(http.StatusOK)
+		})
+
+		Context("When the date filter is invalid", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpEndpointToUse = "/children/childid-3/attendance?from=foo"
+			})
+			assertHttpCode(http.StatusBadRequest)
+		})
+
+		Context("When database is closed", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_ADMIN] = true
+				concreteDb.Close()
+			})
+			assertJsonResponse(`{"error":"failed to list attendances: sql: database is closed"}`)
+			assertHttpCode(http.StatusInternalServerError)
+		})
+	})
+
+	Describe("CHECK IN", func() {
+
+		BeforeEach(func() {
+			httpMethodToUse = http.MethodPost
+			httpEndpointToUse = "/children/childid-3/attendance/check-in"
+			httpBodyToUse = `{"droppedBy": "Caitlyn Stark", "checkIn": "2018-05-16T08:15:00Z"}`
+		})
+
+		Context("When user is a teacher of the same daycare", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_TEACHER] = true
+				claims["userId"] = "id4"
+			})
+			assertJsonResponse(`{
+				"id": "aaa",
+				"childId": "childid-3",
+				"checkIn": "2018-05-16 08:15:00 +0000 UTC",
+				"checkOut": null,
+				"droppedBy": "Caitlyn Stark",
+				"pickedUpBy": "",
+				"checkedInBy": "id4",
+				"checkedOutBy": "",
+				"authorizedPickupId": "",
+				"unauthorizedPickup": false
+			}`)
+			assertHttpCode(http.StatusCreated)
+		})
+
+		Context("When user is an office manager of another daycare", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				claims["daycareId"] = "namek"
+			})
+			assertJsonResponse(`{"error":"failed to check in child: child not found"}`)
+			assertHttpCode(http.StatusNotFound)
+		})
+
+		Context("When user is an adult", func() {
+			BeforeEach(func() { claims[roles.ROLE_ADULT] = true })
+			assertReturnedNoPayload()
+			assertHttpCode(http.StatusUnauthorized)
+		})
+
+		Context("When droppedBy is missing", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpBodyToUse = `{}`
+			})
+			assertJsonResponse(`{"error":"droppedBy is mandatory"}`)
+			assertHttpCode(http.StatusBadRequest)
+		})
+
+		Context("When the child is already checked in", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpEndpointToUse = "/children/childid-4/attendance/check-in"
+			})
+			assertJsonResponse(`{"error":"failed to check in child: child is already checked in"}`)
+			assertHttpCode(http.StatusBadRequest)
+			It("should not let a concurrent check in open a second attendance", func() {
+				err := concreteDb.Exec("INSERT INTO attendances (attendance_id, child_id, check_in, dropped_by) VALUES ('attendanceid-3', 'childid-4', now(), 'Tyrion Lannister')").Error
+				Expect(err).NotTo(BeNil())
+				Expect(err.Error()).To(ContainSubstring("attendances_open_child_id_key"))
+			})
+		})
+
+		Context("When database is closed", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_ADMIN] = true
+				concreteDb.Close()
+			})
+			assertJsonResponse(`{"error":"failed to check in child: sql: database is closed"}`)
+			assertHttpCode(http.StatusInternalServerError)
+		})
+	})
+
+	Describe("CHECK OUT", func() {
+
+		BeforeEach(func() {
+			httpMethodToUse = http.MethodPost
+			httpEndpointToUse = "/children/childid-4/attendance/check-out"
+			httpBodyToUse = `{"pickedUpBy": "Tyrion Lannister", "checkOut": "2018-05-15T17:30:00Z"}`
+		})
+
+		Context("When user is an office manager of the same daycare", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				claims["userId"] = "id2"
+			})
+			assertJsonResponse(`{
+				"id": "attendanceid-2",
+				"childId": "childid-4",
+				"checkIn": "2018-05-15 08:30:00 +0000 UTC",
+				"checkOut": "2018-05-15 17:30:00 +0000 UTC",
+				"droppedBy": "Tyrion Lannister",
+				"pickedUpBy": "Tyrion Lannister",
+				"checkedInBy": "id4",
+				"checkedOutBy": "id2",
+				"authorizedPickupId": "",
+				"unauthorizedPickup": false
+			}`)
+			assertHttpCode(http.StatusOK)
+		})
+
+		Context("When two check outs happen at the same time", func() {
+			It("should only let the first one close the attendance", func() {
+				checkOut := func(tx *gorm.DB, pickedUpBy string) error {
+					_, err := concreteStore.CheckOut(tx, store.Attendance{
+						ChildId:    sql.NullString{String: "childid-4", Valid: true},
+						CheckOut:   pq.NullTime{Time: time.Now(), Valid: true},
+						PickedUpBy: sql.NullString{String: pickedUpBy, Valid: true},
+					})
+					return err
+				}
+
+				first := concreteDb.Begin()
+				defer first.Rollback()
+				Expect(checkOut(first, "Tyrion Lannister")).To(BeNil())
+
+				secondDone := make(chan error, 1)
+				go func() { secondDone <- checkOut(nil, "Jaime Lannister") }()
+				Consistently(secondDone, "200ms").ShouldNot(Receive())
+
+				Expect(first.Commit().Error).To(BeNil())
+				Eventually(secondDone).Should(Receive(Equal(store.ErrChildNotCheckedIn)))
+
+				var pickedUpBy string
+				Expect(concreteDb.Raw("SELECT picked_up_by FROM attendances WHERE attendance_id = 'attendanceid-2'").Row().Scan(&pickedUpBy)).To(BeNil())
+				Expect(pickedUpBy).To(Equal("Tyrion Lannister"))
+			})
+		})
+
+		Context("When the child is picked up by someone from the authorized pickup list", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_TEACHER] = true
+				claims["userId"] = "id4"
+				httpBodyToUse = `{"pickedUpBy": "cersei  lannister", "checkOut": "2018-05-15T17:30:00Z"}`
+			})
+			assertJsonResponse(`{
+				"id": "attendanceid-2",
+				"childId": "childid-4",
+				"checkIn": "2018-05-15 08:30:00 +0000 UTC",
+				"checkOut": "2018-05-15 17:30:00 +0000 UTC",
+				"droppedBy": "Tyrion Lannister",
+				"pickedUpBy": "cersei  lannister",
+				"checkedInBy": "id4",
+				"checkedOutBy": "id4",
+				"authorizedPickupId": "pickupid-1",
+				"unauthorizedPickup": false
+			}`)
+			assertHttpCode(http.StatusOK)
+		})
+
+		Context("When only the authorized pickup id is given", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_TEACHER] = true
+				claims["userId"] = "id4"
+				httpBodyToUse = `{"authorizedPickupId": "pickupid-1", "checkOut": "2018-05-15T17:30:00Z"}`
+			})
+			assertJsonResponse(`{
+				"id": "attendanceid-2",
+				"childId": "childid-4",
+				"checkIn": "2018-05-15 08:30:00 +0000 UTC",
+				"checkOut": "2018-05-15 17:30:00 +0000 UTC",
+				"droppedBy": "Tyrion Lannister",
+				"pickedUpBy": "Cersei Lannister",
+				"checkedInBy": "id4",
+				"checkedOutBy": "id4",
+				"authorizedPickupId": "pickupid-1",
+				"unauthorizedPickup": false
+			}`)
+			assertHttpCode(http.StatusOK)
+		})
+
+		Context("When an office manager forces the check out with an unknown authorized pickup id", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				claims["userId"] = "id2"
+				httpBodyToUse = `{"authorizedPickupId": "foo", "checkOut": "2018-05-15T17:30:00Z", "force": true}`
+			})
+			assertJsonResponse(`{"error":"authorizedPickupId does not match any approved pickup, pickedUpBy is mandatory"}`)
+			assertHttpCode(http.StatusBadRequest)
+		})
+
+		Context("When the child is picked up by someone whose proposal is not approved", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_TEACHER] = true
+				claims["userId"] = "id4"
+				httpBodyToUse = `{"pickedUpBy": "Jaime Lannister", "checkOut": "2018-05-15T17:30:00Z"}`
+			})
+			assertJsonResponse(`{"error":"child cannot be picked up by someone who is not on the authorized pickup list"}`)
+			assertHttpCode(http.StatusForbidden)
+		})
+
+		Context("When a teacher forces the check out of an unauthorized person", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_TEACHER] = true
+				claims["userId"] = "id4"
+				httpBodyToUse = `{"pickedUpBy": "Jaime Lannister", "checkOut": "2018-05-15T17:30:00Z", "force": true}`
+			})
+			assertJsonResponse(`{"error":"child cannot be picked up by someone who is not on the authorized pickup list"}`)
+			assertHttpCode(http.StatusForbidden)
+		})
+
+		Context("When an office manager forces the check out of an unauthorized person", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				claims["userId"] = "id2"
+				httpBodyToUse = `{"pickedUpBy": "Jaime Lannister", "checkOut": "2018-05-15T17:30:00Z", "force": true}`
+			})
+			assertJsonResponse(`{
+				"id": "attendanceid-2",
+				"childId": "childid-4",
+				"checkIn": "2018-05-15 08:30:00 +0000 UTC",
+				"checkOut": "2018-05-15 17:30:00 +0000 UTC",
+				"droppedBy": "Tyrion Lannister",
+				"pickedUpBy": "Jaime Lannister",
+				"checkedInBy": "id4",
+				"checkedOutBy": "id2",
+				"authorizedPickupId": "",
+				"unauthorizedPickup": true
+			}`)
+			assertHttpCode(http.StatusOK)
+		})
+
+		Context("When the child is not checked in", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpEndpointToUse = "/children/childid-3/attendance/check-out"
+			})
+			assertJsonResponse(`{"error":"failed to check out child: child is not checked in"}`)
+			assertHttpCode(http.StatusBadRequest)
+		})
+
+		Context("When check out happens before check in", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpBodyToUse = `{"pickedUpBy": "Tyrion Lannister", "checkOut": "2018-05-15T07:30:00Z"}`
+			})
+			assertJsonResponse(`{"error":"checkOut cannot be before checkIn"}`)
+			assertHttpCode(http.StatusBadRequest)
+		})
+
+		Context("When pickedUpBy is missing", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpBodyToUse = `{}`
+			})
+			assertJsonResponse(`{"error":"pickedUpBy is mandatory"}`)
+			assertHttpCode(http.StatusBadRequest)
+		})
+
+		Context("When user is an adult", func() {
+			BeforeEach(func() { claims[roles.ROLE_ADULT] = true })
+			assertReturnedNoPayload()
+			assertHttpCode(http.StatusUnauthorized)
+		})
+	})
+})
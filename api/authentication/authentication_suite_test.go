@@ -0,0 +1,13 @@
+package authentication_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestAuthentication(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Authentication Suite")
+}
@@ -0,0 +1,344 @@
+package authentication
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"sync"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	"github.com/Vinubaba/SANTC-API/common/jwt"
+	"github.com/Vinubaba/SANTC-API/common/store"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidCredentials  = errors.New("invalid email or password")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrPasswordTooShort    = errors.New("password must be at least 6 characters long")
+	ErrInvalidIssuer       = errors.New("token was not issued by this api")
+)
+
+const (
+	signingKeySize   = 2048
+	refreshTokenSize = 32
+)
+
+// Tokens are returned by the sign in and the refresh of the local provider
+type Tokens struct {
+	IdToken      string `json:"idToken"`
+	RefreshToken string `json:"refreshToken"`
+	// Lifetime of the id token, in seconds
+	ExpiresIn int64  `json:"expiresIn"`
+	TokenType string `json:"tokenType"`
+}
+
+// LocalProvider keeps the identities in the database and signs its own tokens, the api runs without any other
+// service. The id tokens are short lived, the refresh tokens are used once: using one again revokes them all
+type LocalProvider struct {
+	Store interface {
+		AddLocalIdentity(tx *gorm.DB, identity store.LocalIdentity) (store.LocalIdentity, error)
+		GetLocalIdentity(tx *gorm.DB, uid string) (store.LocalIdentity, error)
+		GetLocalIdentityByEmail(tx *gorm.DB, email string) (store.LocalIdentity, error)
+		SetLocalIdentityClaims(tx *gorm.DB, uid string, claims map[string]interface{}) error
+		DeleteLocalIdentityByEmail(tx *gorm.DB, email string) error
+
+		AddRefreshToken(tx *gorm.DB, token store.RefreshToken) error
+		GetRefreshToken(tx *gorm.DB, tokenHash string) (store.RefreshToken, error)
+		RevokeRefreshToken(tx *gorm.DB, tokenHash string) (bool, error)
+		RevokeRefreshTokens(tx *gorm.DB, uid string) error
+
+		AddSigningKey(tx *gorm.DB, key store.SigningKey) (store.SigningKey, error)
+		GetSigningKey(tx *gorm.DB, keyId string) (store.SigningKey, error)
+		ListSigningKeys(tx *gorm.DB, createdAfter time.Time) ([]store.SigningKey, error)
+		DeleteSigningKeys(tx *gorm.DB, createdBefore time.Time) error
+	} `inject:""`
+	StringGenerator interface {
+		GenerateUuid() string
+	} `inject:""`
+	Config *shared.AppConfig `inject:""`
+
+	// keys already read from the database, by key id
+	keys         map[string]*rsa.PrivateKey
+	signingKeyId string
+	signingSince time.Time
+	keysMutex    sync.Mutex
+}
+
+// CreateIdentity creates the account an api user signs in with, the email is the one of the user. It is part of the
+// transaction when one is given
+func (p *LocalProvider) CreateIdentity(ctx context.Context, tx *gorm.DB, email, password string) (Identity, error) {
+	if len(password) < 6 {
+		return Identity{}, ErrPasswordTooShort
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "failed to hash password")
+	}
+	identity, err := p.Store.AddLocalIdentity(tx, store.LocalIdentity{Email: email, PasswordHash: hash})
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "failed to create identity")
+	}
+	return toIdentity(identity), nil
+}
+
+func (p *LocalProvider) SignIn(ctx context.Context, email, password string) (Tokens, error) {
+	identity, err := p.Store.GetLocalIdentityByEmail(nil, email)
+	if err == store.ErrLocalIdentityNotFound {
+		// spends the time of a hash, the response time does not tell whether the email is known
+		checkPassword(password, dummyPasswordHash)
+		return Tokens{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return Tokens{}, errors.Wrap(err, "failed to sign in")
+	}
+	if !checkPassword(password, identity.PasswordHash) {
+		return Tokens{}, ErrInvalidCredentials
+	}
+	return p.issueTokens(identity)
+}
+
+// Refresh exchanges a refresh token for new tokens, the refresh token cannot be used again
+func (p *LocalProvider) Refresh(ctx context.Context, refreshToken string) (Tokens, error) {
+	token, err := p.Store.GetRefreshToken(nil, hashRefreshToken(refreshToken))
+	if err == store.ErrRefreshTokenNotFound {
+		return Tokens{}, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return Tokens{}, errors.Wrap(err, "failed to refresh tokens")
+	}
+	if token.ExpiresAt.Before(time.Now()) {
+		return Tokens{}, ErrInvalidRefreshToken
+	}
+
+	revoked, err := p.Store.RevokeRefreshToken(nil, token.TokenHash)
+	if err != nil {
+		return Tokens{}, errors.Wrap(err, "failed to refresh tokens")
+	}
+	if !revoked {
+		// a used token comes back: it may have been stolen, the legitimate holder signs in again
+		if err := p.Store.RevokeRefreshTokens(nil, token.Uid); err != nil {
+			return Tokens{}, errors.Wrap(err, "failed to revoke refresh tokens")
+		}
+		return Tokens{}, ErrInvalidRefreshToken
+	}
+
+	identity, err := p.Store.GetLocalIdentity(nil, token.Uid)
+	if err != nil {
+		return Tokens{}, errors.Wrap(err, "failed to refresh tokens")
+	}
+	return p.issueTokens(identity)
+}
+
+// SignOut revokes the refresh token, the id token works until it expires
+func (p *LocalProvider) SignOut(ctx context.Context, refreshToken string) error {
+	if _, err := p.Store.RevokeRefreshToken(nil, hashRefreshToken(refreshToken)); err != nil {
+		return errors.Wrap(err, "failed to sign out")
+	}
+	return nil
+}
+
+func (p *LocalProvider) VerifyToken(ctx context.Context, token string) (Identity, error) {
+	claims, err := jwt.Verify(token, p.publicKey)
+	if err != nil {
+		return Identity{}, err
+	}
+	if claims.String("iss") != p.Config.LocalAuthIssuer {
+		return Identity{}, ErrInvalidIssuer
+	}
+	// the claims of the token may be older than the ones of the identity
+	identity, err := p.Store.GetLocalIdentity(nil, claims.String("sub"))
+	if err != nil {
+		return Identity{}, err
+	}
+	return toIdentity(identity), nil
+}
+
+func (p *LocalProvider) SetClaims(ctx context.Context, uid string, claims map[string]interface{}) error {
+	return p.Store.SetLocalIdentityClaims(nil, uid, claims)
+}
+
+func (p *LocalProvider) DeleteUserByEmail(ctx context.Context, email string) error {
+	return p.Store.DeleteLocalIdentityByEmail(nil, email)
+}
+
+// KeySet returns the public keys verifying the tokens not expired yet, see /auth/jwks.json
+func (p *LocalProvider) KeySet() (jwt.KeySet, error) {
+	keys, err := p.Store.ListSigningKeys(nil, time.Now().Add(-p.Config.LocalAuthKeyRotation-p.Config.LocalAuthTokenLifetime))
+	if err != nil {
+		return jwt.KeySet{}, errors.Wrap(err, "failed to list signing keys")
+	}
+	keySet := jwt.KeySet{Keys: []jwt.JSONWebKey{}}
+	for _, key := range keys {
+		privateKey, err := parsePrivateKey(key.PrivateKey)
+		if err != nil {
+			return jwt.KeySet{}, errors.Wrapf(err, "invalid signing key %s", key.KeyId)
+		}
+		keySet.Keys = append(keySet.Keys, jwt.NewJSONWebKey(key.KeyId, &privateKey.PublicKey))
+	}
+	return keySet, nil
+}
+
+func (p *LocalProvider) issueTokens(identity store.LocalIdentity) (Tokens, error) {
+	keyId, key, err := p.signingKey()
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	now := time.Now()
+	claims := jwt.Claims{
+		"iss":   p.Config.LocalAuthIssuer,
+		"aud":   p.Config.LocalAuthIssuer,
+		"sub":   identity.Uid,
+		"email": identity.Email,
+		"iat":   now.Unix(),
+		"exp":   now.Add(p.Config.LocalAuthTokenLifetime).Unix(),
+	}
+	for name, value := range identity.Claims {
+		if _, reserved := claims[name]; !reserved {
+			claims[name] = value
+		}
+	}
+	idToken, err := jwt.Sign(claims, keyId, key)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	refreshToken := make([]byte, refreshTokenSize)
+	if _, err := rand.Read(refreshToken); err != nil {
+		return Tokens{}, err
+	}
+	encodedRefreshToken := base64.RawURLEncoding.EncodeToString(refreshToken)
+	if err := p.Store.AddRefreshToken(nil, store.RefreshToken{
+		TokenHash: hashRefreshToken(encodedRefreshToken),
+		Uid:       identity.Uid,
+		ExpiresAt: now.Add(p.Config.LocalAuthRefreshTokenLifetime),
+	}); err != nil {
+		return Tokens{}, errors.Wrap(err, "failed to store refresh token")
+	}
+
+	return Tokens{
+		IdToken:      idToken,
+		RefreshToken: encodedRefreshToken,
+		ExpiresIn:    int64(p.Config.LocalAuthTokenLifetime / time.Second),
+		TokenType:    "Bearer",
+	}, nil
+}
+
+// signingKey returns the newest key, a new one is generated once it is older than LocalAuthKeyRotation. The keys
+// too old to have signed a token still valid are deleted then
+func (p *LocalProvider) signingKey() (string, *rsa.PrivateKey, error) {
+	p.keysMutex.Lock()
+	defer p.keysMutex.Unlock()
+
+	rotation := p.Config.LocalAuthKeyRotation
+	if p.signingKeyId != "" && time.Since(p.signingSince) < rotation {
+		return p.signingKeyId, p.keys[p.signingKeyId], nil
+	}
+
+	// another instance of the api may have rotated the key already
+	keys, err := p.Store.ListSigningKeys(nil, time.Now().Add(-rotation))
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to list signing keys")
+	}
+	if len(keys) == 0 {
+		newKey, err := p.generateSigningKey()
+		if err != nil {
+			return "", nil, err
+		}
+		keys = append(keys, newKey)
+		if err := p.Store.DeleteSigningKeys(nil, time.Now().Add(-rotation-p.Config.LocalAuthTokenLifetime)); err != nil {
+			return "", nil, errors.Wrap(err, "failed to delete old signing keys")
+		}
+	}
+
+	privateKey, err := parsePrivateKey(keys[0].PrivateKey)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "invalid signing key %s", keys[0].KeyId)
+	}
+	p.cacheKey(keys[0].KeyId, privateKey)
+	p.signingKeyId = keys[0].KeyId
+	p.signingSince = keys[0].CreatedAt
+	return p.signingKeyId, privateKey, nil
+}
+
+func (p *LocalProvider) generateSigningKey() (store.SigningKey, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, signingKeySize)
+	if err != nil {
+		return store.SigningKey{}, errors.Wrap(err, "failed to generate signing key")
+	}
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	key, err := p.Store.AddSigningKey(nil, store.SigningKey{KeyId: p.StringGenerator.GenerateUuid(), PrivateKey: string(encoded)})
+	if err != nil {
+		return store.SigningKey{}, errors.Wrap(err, "failed to store signing key")
+	}
+	return key, nil
+}
+
+func (p *LocalProvider) publicKey(keyId string) (*rsa.PublicKey, error) {
+	p.keysMutex.Lock()
+	defer p.keysMutex.Unlock()
+
+	if key, ok := p.keys[keyId]; ok {
+		return &key.PublicKey, nil
+	}
+	key, err := p.Store.GetSigningKey(nil, keyId)
+	if err == store.ErrSigningKeyNotFound {
+		return nil, jwt.ErrUnknownKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := parsePrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid signing key %s", keyId)
+	}
+	p.cacheKey(keyId, privateKey)
+	return &privateKey.PublicKey, nil
+}
+
+func (p *LocalProvider) cacheKey(keyId string, key *rsa.PrivateKey) {
+	if p.keys == nil {
+		p.keys = map[string]*rsa.PrivateKey{}
+	}
+	p.keys[keyId] = key
+}
+
+func parsePrivateKey(encoded string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// only the hash of the refresh tokens is stored, a leak of the table does not let anyone in
+func hashRefreshToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func toIdentity(identity store.LocalIdentity) Identity {
+	return Identity{
+		Uid:    identity.Uid,
+		Email:  identity.Email,
+		Claims: identity.Claims,
+	}
+}
+
+// hash of no password, checked against when the email is unknown
+var dummyPasswordHash = func() string {
+	hash, err := hashPassword("")
+	if err != nil {
+		panic(err)
+	}
+	return hash
+}()
@@ -0,0 +1,171 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	. "github.com/Vinubaba/SANTC-API/api/shared"
+	"github.com/Vinubaba/SANTC-API/api/users"
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/store"
+
+	"github.com/pkg/errors"
+)
+
+type credentialsRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// LocalAuthHandlers serve the routes of the local provider, they are public
+type LocalAuthHandlers struct {
+	Provider    *LocalProvider `inject:"identityProvider"`
+	UserService interface {
+		GetUserByEmail(ctx context.Context, request users.UserTransport) (store.User, error)
+	} `inject:""`
+	Config *AppConfig  `inject:""`
+	Logger *log.Logger `inject:""`
+}
+
+func (h *LocalAuthHandlers) SignIn(w http.ResponseWriter, r *http.Request) {
+	request := credentialsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		HttpError(w, NewError("invalid request"), http.StatusBadRequest)
+		return
+	}
+	tokens, err := h.Provider.SignIn(r.Context(), request.Email, request.Password)
+	if err == ErrInvalidCredentials {
+		HttpError(w, NewError(err.Error()), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		h.Logger.Err(r.Context(), "failed to sign in", "err", err.Error())
+		HttpError(w, ServerError, http.StatusInternalServerError)
+		return
+	}
+	WriteJSON(w, tokens, http.StatusOK)
+}
+
+func (h *LocalAuthHandlers) Refresh(w http.ResponseWriter, r *http.Request) {
+	request := refreshRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		HttpError(w, NewError("invalid request"), http.StatusBadRequest)
+		return
+	}
+	tokens, err := h.Provider.Refresh(r.Context(), request.RefreshToken)
+	if err == ErrInvalidRefreshToken {
+		HttpError(w, NewError(err.Error()), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		h.Logger.Err(r.Context(), "failed to refresh tokens", "err", err.Error())
+		HttpError(w, ServerError, http.StatusInternalServerError)
+		return
+	}
+	WriteJSON(w, tokens, http.StatusOK)
+}
+
+func (h *LocalAuthHandlers) SignOut(w http.ResponseWriter, r *http.Request) {
+	request := refreshRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		HttpError(w, NewError("invalid request"), http.StatusBadRequest)
+		return
+	}
+	if err := h.Provider.SignOut(r.Context(), request.RefreshToken); err != nil {
+		h.Logger.Err(r.Context(), "failed to sign out", "err", err.Error())
+		HttpError(w, ServerError, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Register creates the identity of an existing user, only when LocalAuthRegistration is set
+func (h *LocalAuthHandlers) Register(w http.ResponseWriter, r *http.Request) {
+	if !h.Config.LocalAuthRegistration {
+		HttpError(w, NewError("registration is disabled"), http.StatusForbidden)
+		return
+	}
+	request := credentialsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		HttpError(w, NewError("invalid request"), http.StatusBadRequest)
+		return
+	}
+	if _, err := h.UserService.GetUserByEmail(r.Context(), users.UserTransport{Email: &request.Email}); err != nil {
+		HttpError(w, NewError("user not registered"), http.StatusForbidden)
+		return
+	}
+
+	identity, err := h.Provider.CreateIdentity(r.Context(), nil, request.Email, request.Password)
+	switch {
+	case err == ErrPasswordTooShort:
+		HttpError(w, NewError(err.Error()), http.StatusBadRequest)
+		return
+	case errors.Cause(err) == store.ErrLocalIdentityAlreadyExists:
+		HttpError(w, NewError(store.ErrLocalIdentityAlreadyExists.Error()), http.StatusConflict)
+		return
+	case err != nil:
+		h.Logger.Err(r.Context(), "failed to register", "err", err.Error())
+		HttpError(w, ServerError, http.StatusInternalServerError)
+		return
+	}
+	WriteJSON(w, map[string]string{"uid": identity.Uid, "email": identity.Email}, http.StatusCreated)
+}
+
+// KeySet serves the public keys of the tokens, other services verify them without calling the api
+func (h *LocalAuthHandlers) KeySet(w http.ResponseWriter, r *http.Request) {
+	keySet, err := h.Provider.KeySet()
+	if err != nil {
+		h.Logger.Err(r.Context(), "failed to list keys", "err", err.Error())
+		HttpError(w, ServerError, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	WriteJSON(w, keySet, http.StatusOK)
+}
+
+func ServeLocalTestAuth(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(
+		`    <!DOCTYPE html>
+    <html>
+    <head>
+        <meta charset="UTF-8">
+        <title>Sample local sign in</title>
+        <script>
+            function signIn(event) {
+                event.preventDefault();
+                fetch('/auth/sign-in', {
+                    method: 'POST',
+                    headers: {'Content-Type': 'application/json'},
+                    body: JSON.stringify({
+                        email: document.getElementById('email').value,
+                        password: document.getElementById('password').value
+                    })
+                }).then(function(response) {
+                    return response.json();
+                }).then(function(tokens) {
+                    console.log(tokens.idToken)
+                    document.getElementById('tokens').textContent = JSON.stringify(tokens, null, 2);
+                }).catch(function(error) {
+                    console.log(error)
+                });
+            }
+        </script>
+    </head>
+    <body>
+    <h1>Welcome to My Awesome App</h1>
+    <form onsubmit="signIn(event)">
+        <input id="email" type="email" placeholder="email">
+        <input id="password" type="password" placeholder="password">
+        <button type="submit">Sign in</button>
+    </form>
+    <pre id="tokens"></pre>
+    </body>
+    </html>
+`))
+}
@@ -0,0 +1,393 @@
+package authentication_test
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/Vinubaba/SANTC-API/api/authentication"
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	"github.com/Vinubaba/SANTC-API/common/jwt"
+	"github.com/Vinubaba/SANTC-API/common/store"
+
+	"github.com/jinzhu/gorm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeLocalStore keeps the identities, refresh tokens and signing keys like the tables of the store
+type fakeLocalStore struct {
+	identities    map[string]store.LocalIdentity
+	refreshTokens map[string]store.RefreshToken
+	signingKeys   map[string]store.SigningKey
+	sync.Mutex
+}
+
+func newFakeLocalStore() *fakeLocalStore {
+	return &fakeLocalStore{
+		identities:    map[string]store.LocalIdentity{},
+		refreshTokens: map[string]store.RefreshToken{},
+		signingKeys:   map[string]store.SigningKey{},
+	}
+}
+
+func (s *fakeLocalStore) AddLocalIdentity(tx *gorm.DB, identity store.LocalIdentity) (store.LocalIdentity, error) {
+	s.Lock()
+	defer s.Unlock()
+	identity.Uid = fmt.Sprintf("uid-%d", len(s.identities)+1)
+	identity.Email = strings.ToLower(identity.Email)
+	if identity.Claims == nil {
+		identity.Claims = map[string]interface{}{}
+	}
+	s.identities[identity.Uid] = identity
+	return identity, nil
+}
+
+func (s *fakeLocalStore) GetLocalIdentity(tx *gorm.DB, uid string) (store.LocalIdentity, error) {
+	s.Lock()
+	defer s.Unlock()
+	identity, ok := s.identities[uid]
+	if !ok {
+		return store.LocalIdentity{}, store.ErrLocalIdentityNotFound
+	}
+	return identity, nil
+}
+
+func (s *fakeLocalStore) GetLocalIdentityByEmail(tx *gorm.DB, email string) (store.LocalIdentity, error) {
+	s.Lock()
+	defer s.Unlock()
+	for _, identity := range s.identities {
+		if identity.Email == strings.ToLower(email) {
+			return identity, nil
+		}
+	}
+	return store.LocalIdentity{}, store.ErrLocalIdentityNotFound
+}
+
+func (s *fakeLocalStore) SetLocalIdentityClaims(tx *gorm.DB, uid string, claims map[string]interface{}) error {
+	s.Lock()
+	defer s.Unlock()
+	identity, ok := s.identities[uid]
+	if !ok {
+		return store.ErrLocalIdentityNotFound
+	}
+	identity.Claims = claims
+	s.identities[uid] = identity
+	return nil
+}
+
+func (s *fakeLocalStore) DeleteLocalIdentityByEmail(tx *gorm.DB, email string) error {
+	s.Lock()
+	defer s.Unlock()
+	for uid, identity := range s.identities {
+		if identity.Email == strings.ToLower(email) {
+			delete(s.identities, uid)
+			return nil
+		}
+	}
+	return store.ErrLocalIdentityNotFound
+}
+
+func (s *fakeLocalStore) AddRefreshToken(tx *gorm.DB, token store.RefreshToken) error {
+	s.Lock()
+	defer s.Unlock()
+	s.refreshTokens[token.TokenHash] = token
+	return nil
+}
+
+func (s *fakeLocalStore) GetRefreshToken(tx *gorm.DB, tokenHash string) (store.RefreshToken, error) {
+	s.Lock()
+	defer s.Unlock()
+	token, ok := s.refreshTokens[tokenHash]
+	if !ok {
+		return store.RefreshToken{}, store.ErrRefreshTokenNotFound
+	}
+	return token, nil
+}
+
+func (s *fakeLocalStore) RevokeRefreshToken(tx *gorm.DB, tokenHash string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	token, ok := s.refreshTokens[tokenHash]
+	if !ok || token.Revoked {
+		return false, nil
+	}
+	token.Revoked = true
+	s.refreshTokens[tokenHash] = token
+	return true, nil
+}
+
+func (s *fakeLocalStore) RevokeRefreshTokens(tx *gorm.DB, uid string) error {
+	s.Lock()
+	defer s.Unlock()
+	for hash, token := range s.refreshTokens {
+		if token.Uid == uid {
+			token.Revoked = true
+			s.refreshTokens[hash] = token
+		}
+	}
+	return nil
+}
+
+func (s *fakeLocalStore) AddSigningKey(tx *gorm.DB, key store.SigningKey) (store.SigningKey, error) {
+	s.Lock()
+	defer s.Unlock()
+	key.CreatedAt = time.Now()
+	s.signingKeys[key.KeyId] = key
+	return key, nil
+}
+
+func (s *fakeLocalStore) GetSigningKey(tx *gorm.DB, keyId string) (store.SigningKey, error) {
+	s.Lock()
+	defer s.Unlock()
+	key, ok := s.signingKeys[keyId]
+	if !ok {
+		return store.SigningKey{}, store.ErrSigningKeyNotFound
+	}
+	return key, nil
+}
+
+func (s *fakeLocalStore) ListSigningKeys(tx *gorm.DB, createdAfter time.Time) ([]store.SigningKey, error) {
+	s.Lock()
+	defer s.Unlock()
+	keys := []store.SigningKey{}
+	for _, key := range s.signingKeys {
+		if key.CreatedAt.After(createdAfter) {
+			keys = append(keys, key)
+		}
+	}
+	// the newest first
+	for i := 1; i < len(keys); i++ {
+		for j := i; j > 0 && keys[j].CreatedAt.After(keys[j-1].CreatedAt); j-- {
+			keys[j], keys[j-1] = keys[j-1], keys[j]
+		}
+	}
+	return keys, nil
+}
+
+func (s *fakeLocalStore) DeleteSigningKeys(tx *gorm.DB, createdBefore time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	for keyId, key := range s.signingKeys {
+		if key.CreatedAt.Before(createdBefore) {
+			delete(s.signingKeys, keyId)
+		}
+	}
+	return nil
+}
+
+// age makes a signing key look created that long ago
+func (s *fakeLocalStore) age(keyId string, age time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	key := s.signingKeys[keyId]
+	key.CreatedAt = time.Now().Add(-age)
+	s.signingKeys[keyId] = key
+}
+
+type fakeUuidGenerator struct {
+	generated int
+	sync.Mutex
+}
+
+func (g *fakeUuidGenerator) GenerateUuid() string {
+	g.Lock()
+	defer g.Unlock()
+	g.generated++
+	return fmt.Sprintf("key-%d", g.generated)
+}
+
+var _ = Describe("LocalProvider", func() {
+
+	var (
+		ctx        = context.Background()
+		localStore *fakeLocalStore
+		generator  *fakeUuidGenerator
+		config     *shared.AppConfig
+		provider   *LocalProvider
+	)
+
+	newProvider := func() *LocalProvider {
+		return &LocalProvider{Store: localStore, StringGenerator: generator, Config: config}
+	}
+
+	keyIdOf := func(token string) string {
+		keyId := ""
+		jwt.Verify(token, func(kid string) (*rsa.PublicKey, error) {
+			keyId = kid
+			return nil, jwt.ErrUnknownKey
+		})
+		return keyId
+	}
+
+	BeforeEach(func() {
+		localStore = newFakeLocalStore()
+		generator = &fakeUuidGenerator{}
+		config = &shared.AppConfig{
+			LocalAuthIssuer:               "teddycare",
+			LocalAuthTokenLifetime:        time.Hour,
+			LocalAuthRefreshTokenLifetime: 24 * time.Hour,
+			LocalAuthKeyRotation:          720 * time.Hour,
+		}
+		provider = newProvider()
+
+		_, err := provider.CreateIdentity(ctx, nil, "Goku@dbz.com", "kamehameha")
+		Expect(err).To(BeNil())
+		identity, err := localStore.GetLocalIdentityByEmail(nil, "goku@dbz.com")
+		Expect(err).To(BeNil())
+		Expect(localStore.SetLocalIdentityClaims(nil, identity.Uid, map[string]interface{}{"userId": "goku"})).To(BeNil())
+	})
+
+	Describe("SignIn", func() {
+		It("should issue tokens verified by the provider", func() {
+			tokens, err := provider.SignIn(ctx, "goku@dbz.com", "kamehameha")
+			Expect(err).To(BeNil())
+			Expect(tokens.TokenType).To(Equal("Bearer"))
+			Expect(tokens.ExpiresIn).To(Equal(int64(3600)))
+			Expect(tokens.RefreshToken).NotTo(BeEmpty())
+
+			identity, err := provider.VerifyToken(ctx, tokens.IdToken)
+			Expect(err).To(BeNil())
+			Expect(identity.Email).To(Equal("goku@dbz.com"))
+			Expect(identity.Claims).To(Equal(map[string]interface{}{"userId": "goku"}))
+
+			keySet, err := provider.KeySet()
+			Expect(err).To(BeNil())
+			claims, err := jwt.Verify(tokens.IdToken, keySet.Key)
+			Expect(err).To(BeNil())
+			Expect(claims.String("iss")).To(Equal("teddycare"))
+			Expect(claims.String("sub")).To(Equal(identity.Uid))
+			Expect(claims.String("userId")).To(Equal("goku"))
+		})
+
+		It("should refuse a wrong password", func() {
+			_, err := provider.SignIn(ctx, "goku@dbz.com", "genkidama")
+			Expect(err).To(Equal(ErrInvalidCredentials))
+		})
+
+		It("should refuse an unknown email the same way", func() {
+			_, err := provider.SignIn(ctx, "vegeta@dbz.com", "kamehameha")
+			Expect(err).To(Equal(ErrInvalidCredentials))
+		})
+	})
+
+	Describe("Refresh", func() {
+		var tokens Tokens
+
+		BeforeEach(func() {
+			var err error
+			tokens, err = provider.SignIn(ctx, "goku@dbz.com", "kamehameha")
+			Expect(err).To(BeNil())
+		})
+
+		It("should exchange the refresh token for new tokens", func() {
+			refreshed, err := provider.Refresh(ctx, tokens.RefreshToken)
+			Expect(err).To(BeNil())
+			Expect(refreshed.RefreshToken).NotTo(Equal(tokens.RefreshToken))
+
+			_, err = provider.VerifyToken(ctx, refreshed.IdToken)
+			Expect(err).To(BeNil())
+			_, err = provider.Refresh(ctx, refreshed.RefreshToken)
+			Expect(err).To(BeNil())
+		})
+
+		It("should refuse an unknown refresh token", func() {
+			_, err := provider.Refresh(ctx, "unknown")
+			Expect(err).To(Equal(ErrInvalidRefreshToken))
+		})
+
+		Context("When a refresh token is used again", func() {
+			It("should revoke all the refresh tokens of the identity", func() {
+				refreshed, err := provider.Refresh(ctx, tokens.RefreshToken)
+				Expect(err).To(BeNil())
+				other, err := provider.SignIn(ctx, "goku@dbz.com", "kamehameha")
+				Expect(err).To(BeNil())
+
+				_, err = provider.Refresh(ctx, tokens.RefreshToken)
+				Expect(err).To(Equal(ErrInvalidRefreshToken))
+				_, err = provider.Refresh(ctx, refreshed.RefreshToken)
+				Expect(err).To(Equal(ErrInvalidRefreshToken))
+				_, err = provider.Refresh(ctx, other.RefreshToken)
+				Expect(err).To(Equal(ErrInvalidRefreshToken))
+			})
+		})
+
+		Context("When the signed out refresh token is used", func() {
+			It("should refuse it", func() {
+				Expect(provider.SignOut(ctx, tokens.RefreshToken)).To(BeNil())
+				_, err := provider.Refresh(ctx, tokens.RefreshToken)
+				Expect(err).To(Equal(ErrInvalidRefreshToken))
+			})
+		})
+	})
+
+	Describe("Key rotation", func() {
+		BeforeEach(func() {
+			config.LocalAuthKeyRotation = 50 * time.Millisecond
+		})
+
+		It("should sign with a new key and verify with the old ones until their tokens expire", func() {
+			old, err := provider.SignIn(ctx, "goku@dbz.com", "kamehameha")
+			Expect(err).To(BeNil())
+			oldKeyId := keyIdOf(old.IdToken)
+
+			time.Sleep(100 * time.Millisecond)
+			current, err := provider.SignIn(ctx, "goku@dbz.com", "kamehameha")
+			Expect(err).To(BeNil())
+			Expect(keyIdOf(current.IdToken)).NotTo(Equal(oldKeyId))
+
+			// another instance of the api reads the keys from the store
+			_, err = newProvider().VerifyToken(ctx, old.IdToken)
+			Expect(err).To(BeNil())
+			keySet, err := provider.KeySet()
+			Expect(err).To(BeNil())
+			Expect(keySet.Keys).To(HaveLen(2))
+
+			// no token signed by the old key is valid anymore once it is older than a rotation and a token lifetime
+			localStore.age(oldKeyId, 2*time.Hour)
+			time.Sleep(100 * time.Millisecond)
+			_, err = provider.SignIn(ctx, "goku@dbz.com", "kamehameha")
+			Expect(err).To(BeNil())
+
+			_, err = localStore.GetSigningKey(nil, oldKeyId)
+			Expect(err).To(Equal(store.ErrSigningKeyNotFound))
+			_, err = newProvider().VerifyToken(ctx, old.IdToken)
+			Expect(err).To(Equal(jwt.ErrUnknownKey))
+		})
+	})
+
+	Describe("VerifyToken", func() {
+		It("should refuse a token issued by someone else with a key of the provider", func() {
+			tokens, err := provider.SignIn(ctx, "goku@dbz.com", "kamehameha")
+			Expect(err).To(BeNil())
+			keyId := keyIdOf(tokens.IdToken)
+			signingKey, err := localStore.GetSigningKey(nil, keyId)
+			Expect(err).To(BeNil())
+			block, _ := pem.Decode([]byte(signingKey.PrivateKey))
+			privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			Expect(err).To(BeNil())
+
+			identity, err := localStore.GetLocalIdentityByEmail(nil, "goku@dbz.com")
+			Expect(err).To(BeNil())
+			forged, err := jwt.Sign(jwt.Claims{
+				"iss": "someone-else",
+				"sub": identity.Uid,
+				"exp": time.Now().Add(time.Hour).Unix(),
+			}, keyId, privateKey)
+			Expect(err).To(BeNil())
+
+			_, err = provider.VerifyToken(ctx, forged)
+			Expect(err).To(Equal(ErrInvalidIssuer))
+		})
+
+		It("should refuse a token signed by an unknown key", func() {
+			_, err := provider.VerifyToken(ctx, "eyJhbGciOiJSUzI1NiIsImtpZCI6InVua25vd24ifQ.e30.c2lnbmF0dXJl")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -10,31 +10,44 @@ import (
 	"github.com/Vinubaba/SANTC-API/api/users"
 	"github.com/Vinubaba/SANTC-API/common/store"
 
-	"firebase.google.com/go/auth"
 	"github.com/Vinubaba/SANTC-API/common/log"
 	"github.com/Vinubaba/SANTC-API/common/roles"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+const (
+	claimsVersionClaim = "claimsVersion"
 )
 
 type Authenticator struct {
-	FirebaseClient interface {
-		VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error)
-		GetUser(ctx context.Context, uid string) (*auth.UserRecord, error)
-		SetCustomUserClaims(ctx context.Context, uid string, customClaims map[string]interface{}) error
-	} `inject:""`
+	Provider    IdentityProvider `inject:"identityProvider"`
 	UserService interface {
 		GetUserByEmail(ctx context.Context, request users.UserTransport) (store.User, error)
 	} `inject:""`
-	Logger *log.Logger `inject:""`
+	Store interface {
+		GetClaimsVersion(tx *gorm.DB, userId string) (int, error)
+	} `inject:""`
+	Logger   *log.Logger      `inject:""`
+	Services *ServiceVerifier `inject:""`
 }
 
+// Roles lets through the users having one of the roles, the services are refused
 func (f *Authenticator) Roles(next http.Handler, roles ...string) http.Handler {
+	return f.Scope(next, "", roles...)
+}
+
+// Scope lets through the services given the scope, and the users having one of the roles
+func (f *Authenticator) Scope(next http.Handler, scope string, roles ...string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		if f.isService(req) {
-			next.ServeHTTP(w, req)
-			return
-		}
 		claims := req.Context().Value("claims").(map[string]interface{})
-		if !f.hasRole(roles, claims) {
+		if f.isService(claims) {
+			if scope == "" || !f.hasScope(scope, claims) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		} else if !f.hasRole(roles, claims) {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
@@ -42,29 +55,11 @@ func (f *Authenticator) Roles(next http.Handler, roles ...string) http.Handler {
 	})
 }
 
-func (f *Authenticator) Firebase(next http.Handler, excludePath []string) http.Handler {
+func (f *Authenticator) Authenticate(next http.Handler, excludePath []string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		// Allow internal requests
-		if req.Header.Get("X-Forwarded-For") == "" {
-			ctx := req.Context()
-			claims := map[string]interface{}{
-				"userId":                  "",
-				"daycareId":               "",
-				roles.ROLE_TEACHER:        false,
-				roles.ROLE_OFFICE_MANAGER: false,
-				roles.ROLE_ADULT:          false,
-				roles.ROLE_ADMIN:          false,
-				roles.ROLE_SERVICE:        true,
-			}
-			ctx = context.WithValue(ctx, "claims", claims)
-			req = req.WithContext(context.WithValue(ctx, "claims", claims))
-			next.ServeHTTP(w, req)
-			return
-		}
-
-		// Some route are public (users does not need to be authenticated)
+		// Some route are public (users does not need to be authenticated), the paths ending with / are prefixes
 		for _, path := range excludePath {
-			if req.RequestURI == path {
+			if req.URL.Path == path || (strings.HasSuffix(path, "/") && strings.HasPrefix(req.URL.Path, path)) {
 				next.ServeHTTP(w, req)
 				return
 			}
@@ -84,52 +79,103 @@ func (f *Authenticator) Firebase(next http.Handler, excludePath []string) http.H
 			return
 		}
 
-		token, err := f.FirebaseClient.VerifyIDToken(ctx, bearerToken[1])
-		if err != nil {
+		// the other services of teddycare sign their own tokens
+		serviceClaims, err := f.Services.VerifyToken(bearerToken[1])
+		if err == nil {
+			next.ServeHTTP(w, req.WithContext(context.WithValue(ctx, "claims", serviceClaims)))
+			return
+		}
+		if err != ErrNotServiceToken {
 			HttpError(w, NewError(fmt.Sprintf("invalid authorization token: %s", err.Error())), http.StatusBadRequest)
 			return
 		}
 
-		// Lookup the user associated with the specified uid.
-		firebaseUser, err := f.FirebaseClient.GetUser(ctx, token.UID)
+		identity, err := f.Provider.VerifyToken(ctx, bearerToken[1])
 		if err != nil {
-			HttpError(w, NewError(fmt.Sprintf("failed to retrieve user from firebase: %s", err.Error())), http.StatusBadRequest)
+			HttpError(w, NewError(fmt.Sprintf("invalid authorization token: %s", err.Error())), http.StatusBadRequest)
 			return
 		}
 
-		if !f.hasAtLeastOneRoleInCustomClaim(firebaseUser.CustomClaims) {
-			// lookup database user with email
-			user, err := f.UserService.GetUserByEmail(ctx, users.UserTransport{Email: &firebaseUser.Email})
+		if f.hasAtLeastOneRoleInCustomClaim(identity.Claims) && !identity.IssuerClaims {
+			stale, err := f.hasStaleClaims(identity.Claims)
 			if err != nil {
-				HttpError(w, NewError(fmt.Sprintf("user not registered: %s", err.Error())), http.StatusForbidden)
+				HttpError(w, NewError(err.Error()), http.StatusInternalServerError)
 				return
 			}
+			if stale {
+				// the roles or the daycare of the user changed, the claims are looked up again below
+				identity.Claims = map[string]interface{}{}
+			}
+		}
 
-			claims := map[string]interface{}{
-				"userId":                  user.UserId.String,
-				"daycareId":               user.DaycareId.String,
-				roles.ROLE_TEACHER:        false,
-				roles.ROLE_OFFICE_MANAGER: false,
-				roles.ROLE_ADULT:          false,
-				roles.ROLE_ADMIN:          false,
+		if !f.hasAtLeastOneRoleInCustomClaim(identity.Claims) {
+			// lookup database user with email
+			user, err := f.UserService.GetUserByEmail(ctx, users.UserTransport{Email: &identity.Email})
+			if err != nil {
+				HttpError(w, NewError(fmt.Sprintf("user not registered: %s", err.Error())), http.StatusForbidden)
+				return
 			}
-			for _, role := range user.Roles.ToList() {
-				claims[role] = true
+			// the user may change after its version is read, never before
+			version, err := f.Store.GetClaimsVersion(nil, user.UserId.String)
+			if err != nil {
+				HttpError(w, NewError(fmt.Sprintf("user not registered: %s", err.Error())), http.StatusForbidden)
+				return
 			}
-			if err = f.FirebaseClient.SetCustomUserClaims(ctx, firebaseUser.UID, claims); err != nil {
+
+			claims := UserClaims(user, version)
+			if err = f.Provider.SetClaims(ctx, identity.Uid, claims); err != nil {
 				HttpError(w, NewError(err.Error()), http.StatusInternalServerError)
 				return
 			}
 
-			firebaseUser.CustomClaims = claims
-			ctx = context.WithValue(ctx, "claims", claims)
+			identity.Claims = claims
 		}
 
-		req = req.WithContext(context.WithValue(ctx, "claims", firebaseUser.CustomClaims))
+		req = req.WithContext(context.WithValue(ctx, "claims", identity.Claims))
 		next.ServeHTTP(w, req)
 	})
 }
 
+// UserClaims are the claims kept by the identity provider for a user of the api, see Store.GetClaimsVersion for the
+// version
+func UserClaims(user store.User, claimsVersion int) map[string]interface{} {
+	claims := map[string]interface{}{
+		"userId":                  user.UserId.String,
+		"daycareId":               user.DaycareId.String,
+		claimsVersionClaim:        claimsVersion,
+		roles.ROLE_TEACHER:        false,
+		roles.ROLE_OFFICE_MANAGER: false,
+		roles.ROLE_ADULT:          false,
+		roles.ROLE_ADMIN:          false,
+	}
+	for _, role := range user.Roles.ToList() {
+		claims[role] = true
+	}
+	return claims
+}
+
+// hasStaleClaims tells whether the claims were given before the last change of the user, or to a deleted user. The
+// claims given before the versions have none
+func (f *Authenticator) hasStaleClaims(claims map[string]interface{}) (bool, error) {
+	userId, _ := claims["userId"].(string)
+	version, err := f.Store.GetClaimsVersion(nil, userId)
+	if errors.Cause(err) == store.ErrUserNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// the claims read back from json hold numbers as float64
+	switch claimsVersion := claims[claimsVersionClaim].(type) {
+	case int:
+		return claimsVersion != version, nil
+	case float64:
+		return int(claimsVersion) != version, nil
+	}
+	return true, nil
+}
+
 func (f *Authenticator) hasAtLeastOneRoleInCustomClaim(claims map[string]interface{}) bool {
 	if isAdult, ok := claims[roles.ROLE_ADULT]; ok && isAdult.(bool) {
 		return true
@@ -157,10 +203,17 @@ func (f *Authenticator) hasRole(listRoles []string, customClaim map[string]inter
 	return false
 }
 
-func (f *Authenticator) isService(r *http.Request) bool {
-	if strings.ToLower(r.Header.Get(roles.ROLE_REQUEST_HEADER)) == roles.ROLE_SERVICE {
-		return true
-	}
+func (f *Authenticator) isService(claims map[string]interface{}) bool {
+	isService, _ := claims[roles.ROLE_SERVICE].(bool)
+	return isService
+}
 
+func (f *Authenticator) hasScope(scope string, claims map[string]interface{}) bool {
+	scopes, _ := claims["scopes"].([]string)
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
 	return false
 }
@@ -0,0 +1,228 @@
+package authentication_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/Vinubaba/SANTC-API/api/authentication"
+	"github.com/Vinubaba/SANTC-API/api/users"
+	"github.com/Vinubaba/SANTC-API/common/jwt"
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/roles"
+	"github.com/Vinubaba/SANTC-API/common/store"
+
+	"github.com/jinzhu/gorm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeProvider struct {
+	identity Identity
+	claims   map[string]map[string]interface{}
+}
+
+func (p *fakeProvider) VerifyToken(ctx context.Context, token string) (Identity, error) {
+	identity := p.identity
+	if claims, ok := p.claims[identity.Uid]; ok {
+		identity.Claims = claims
+	}
+	return identity, nil
+}
+
+func (p *fakeProvider) SetClaims(ctx context.Context, uid string, claims map[string]interface{}) error {
+	p.claims[uid] = claims
+	return nil
+}
+
+func (p *fakeProvider) DeleteUserByEmail(ctx context.Context, email string) error {
+	return nil
+}
+
+type fakeUsers struct {
+	users    map[string]store.User
+	versions map[string]int
+}
+
+func (u *fakeUsers) GetUserByEmail(ctx context.Context, request users.UserTransport) (store.User, error) {
+	user, ok := u.users[*request.Email]
+	if !ok {
+		return store.User{}, store.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (u *fakeUsers) GetClaimsVersion(tx *gorm.DB, userId string) (int, error) {
+	version, ok := u.versions[userId]
+	if !ok {
+		return 0, store.ErrUserNotFound
+	}
+	return version, nil
+}
+
+var _ = Describe("Authenticator", func() {
+
+	var (
+		provider      *fakeProvider
+		fakeStore     *fakeUsers
+		authenticator *Authenticator
+		recorder      *httptest.ResponseRecorder
+		servedClaims  map[string]interface{}
+		token         string
+	)
+
+	serve := func() {
+		req, _ := http.NewRequest(http.MethodGet, "/children", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder = httptest.NewRecorder()
+		servedClaims = nil
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			servedClaims = r.Context().Value("claims").(map[string]interface{})
+			w.WriteHeader(http.StatusOK)
+		})
+		authenticator.Authenticate(authenticator.Roles(handler, roles.ROLE_OFFICE_MANAGER), nil).ServeHTTP(recorder, req)
+	}
+
+	goku := store.User{
+		UserId:    sql.NullString{String: "goku", Valid: true},
+		Email:     sql.NullString{String: "goku@dbz.com", Valid: true},
+		DaycareId: sql.NullString{String: "namek", Valid: true},
+		Roles:     store.Roles{{UserId: "goku", Role: roles.ROLE_OFFICE_MANAGER}},
+	}
+
+	BeforeEach(func() {
+		// the provider checks the token, the key is only unknown to the services
+		key, err := rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).To(BeNil())
+		token, err = jwt.Sign(jwt.Claims{"sub": "goku-uid"}, "firebase-key", key)
+		Expect(err).To(BeNil())
+
+		provider = &fakeProvider{
+			identity: Identity{Uid: "goku-uid", Email: "goku@dbz.com"},
+			claims:   map[string]map[string]interface{}{},
+		}
+		fakeStore = &fakeUsers{
+			users:    map[string]store.User{"goku@dbz.com": goku},
+			versions: map[string]int{"goku": 1},
+		}
+		authenticator = &Authenticator{
+			Provider:    provider,
+			UserService: fakeStore,
+			Store:       fakeStore,
+			Logger:      log.NewLogger("AuthenticatorTest"),
+			Services:    &ServiceVerifier{KeySet: jwt.KeySet{Keys: []jwt.JSONWebKey{}}},
+		}
+	})
+
+	It("should give the claims of the user to an identity without claims", func() {
+		serve()
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(servedClaims).To(Equal(UserClaims(goku, 1)))
+		Expect(provider.claims["goku-uid"]).To(Equal(UserClaims(goku, 1)))
+	})
+
+	It("should let through the claims of the current version", func() {
+		serve()
+		serve()
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should let through the public paths, whatever their query", func() {
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/invitations/accept?token=abc", nil)
+		req.RequestURI = "/api/v1/invitations/accept?token=abc"
+		recorder = httptest.NewRecorder()
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		authenticator.Authenticate(handler, []string{"/api/v1/invitations/accept"}).ServeHTTP(recorder, req)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+	})
+
+	Context("When the roles of the user changed", func() {
+		BeforeEach(func() {
+			provider.claims["goku-uid"] = UserClaims(goku, 1)
+			fakeStore.versions["goku"] = 2
+			teacher := goku
+			teacher.Roles = store.Roles{{UserId: "goku", Role: roles.ROLE_TEACHER}}
+			fakeStore.users["goku@dbz.com"] = teacher
+		})
+		It("should look up the claims again in the same request", func() {
+			serve()
+			// a teacher is not let through
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+			Expect(servedClaims).To(BeNil())
+			Expect(provider.claims["goku-uid"][roles.ROLE_OFFICE_MANAGER]).To(BeFalse())
+			Expect(provider.claims["goku-uid"][roles.ROLE_TEACHER]).To(BeTrue())
+			Expect(provider.claims["goku-uid"]["claimsVersion"]).To(Equal(2))
+		})
+	})
+
+	Context("When the daycare of the user changed", func() {
+		var moved store.User
+
+		BeforeEach(func() {
+			provider.claims["goku-uid"] = UserClaims(goku, 1)
+			fakeStore.versions["goku"] = 2
+			moved = goku
+			moved.DaycareId = sql.NullString{String: "earth", Valid: true}
+			fakeStore.users["goku@dbz.com"] = moved
+		})
+		It("should serve the request with the new claims", func() {
+			serve()
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(servedClaims).To(Equal(UserClaims(moved, 2)))
+		})
+	})
+
+	Context("When the claims were read back from json", func() {
+		BeforeEach(func() {
+			claims := UserClaims(goku, 1)
+			claims["claimsVersion"] = float64(1)
+			provider.claims["goku-uid"] = claims
+		})
+		It("should let them through", func() {
+			serve()
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("When the claims have no version", func() {
+		BeforeEach(func() {
+			claims := UserClaims(goku, 1)
+			delete(claims, "claimsVersion")
+			provider.claims["goku-uid"] = claims
+		})
+		It("should look up the claims again", func() {
+			serve()
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(servedClaims).To(Equal(UserClaims(goku, 1)))
+		})
+	})
+
+	Context("When the user was deleted", func() {
+		BeforeEach(func() {
+			provider.claims["goku-uid"] = UserClaims(goku, 1)
+			delete(fakeStore.versions, "goku")
+			delete(fakeStore.users, "goku@dbz.com")
+		})
+		It("should refuse the identity", func() {
+			serve()
+			Expect(recorder.Code).To(Equal(http.StatusForbidden))
+		})
+	})
+
+	Context("When the claims come from the issuer of the token", func() {
+		BeforeEach(func() {
+			provider.identity.IssuerClaims = true
+			provider.claims["goku-uid"] = UserClaims(goku, 0)
+			delete(fakeStore.versions, "goku")
+		})
+		It("should not check their version", func() {
+			serve()
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+		})
+	})
+})
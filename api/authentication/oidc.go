@@ -0,0 +1,172 @@
+package authentication
+
+import (
+	"context"
+	"crypto/rsa"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	"github.com/Vinubaba/SANTC-API/common/jwt"
+	"github.com/Vinubaba/SANTC-API/common/roles"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidAudience = errors.New("token was not issued for this api")
+	ErrMissingEmail    = errors.New("token has no email")
+)
+
+// OIDCProvider verifies the tokens of an OpenID Connect issuer, e.g the one of a daycare network. The userId,
+// daycareId and roles are read from the claims configured; when the token does not have them the user is looked
+// up by email and its claims are kept in memory, the issuer cannot store them
+type OIDCProvider struct {
+	KeySet interface {
+		Key(keyId string) (*rsa.PublicKey, error)
+	}
+	Config *shared.AppConfig `inject:""`
+
+	claims      map[string]cachedClaims
+	claimsMutex sync.Mutex
+}
+
+type cachedClaims struct {
+	email     string
+	claims    map[string]interface{}
+	expiresAt time.Time
+}
+
+func (p *OIDCProvider) VerifyToken(ctx context.Context, token string) (Identity, error) {
+	claims, err := jwt.Verify(token, p.KeySet.Key)
+	if err != nil {
+		return Identity{}, err
+	}
+	if claims.String("iss") != p.Config.OidcIssuer {
+		return Identity{}, ErrInvalidIssuer
+	}
+	if p.Config.OidcAudience != "" && !contains(claims.Audiences(), p.Config.OidcAudience) {
+		return Identity{}, ErrInvalidAudience
+	}
+
+	identity := Identity{
+		Uid:   claims.String("sub"),
+		Email: claims.String(p.Config.OidcEmailClaim),
+	}
+	if identity.Email == "" {
+		return Identity{}, ErrMissingEmail
+	}
+	identity.Claims = p.mapClaims(claims)
+	identity.IssuerClaims = identity.Claims != nil
+	if identity.Claims == nil {
+		identity.Claims = p.cachedClaims(identity.Uid, identity.Email)
+	}
+	return identity, nil
+}
+
+// SetClaims keeps the claims of a user looked up by email until OidcClaimsCacheLifetime
+func (p *OIDCProvider) SetClaims(ctx context.Context, uid string, claims map[string]interface{}) error {
+	p.claimsMutex.Lock()
+	defer p.claimsMutex.Unlock()
+
+	if p.claims == nil {
+		p.claims = map[string]cachedClaims{}
+	}
+	p.claims[uid] = cachedClaims{
+		email:     p.claims[uid].email,
+		claims:    claims,
+		expiresAt: time.Now().Add(p.Config.OidcClaimsCacheLifetime),
+	}
+	return nil
+}
+
+// DeleteUserByEmail only forgets the claims of the user, its account belongs to the issuer
+func (p *OIDCProvider) DeleteUserByEmail(ctx context.Context, email string) error {
+	p.claimsMutex.Lock()
+	defer p.claimsMutex.Unlock()
+
+	for uid, cached := range p.claims {
+		if strings.EqualFold(cached.email, email) {
+			delete(p.claims, uid)
+		}
+	}
+	return nil
+}
+
+// mapClaims returns nil when the token does not name the user or has none of the roles of the api
+func (p *OIDCProvider) mapClaims(token jwt.Claims) map[string]interface{} {
+	if p.Config.OidcUserIdClaim == "" || token.String(p.Config.OidcUserIdClaim) == "" {
+		return nil
+	}
+	claims := map[string]interface{}{
+		"userId":                  token.String(p.Config.OidcUserIdClaim),
+		"daycareId":               "",
+		roles.ROLE_TEACHER:        false,
+		roles.ROLE_OFFICE_MANAGER: false,
+		roles.ROLE_ADULT:          false,
+		roles.ROLE_ADMIN:          false,
+	}
+	if p.Config.OidcDaycareIdClaim != "" {
+		claims["daycareId"] = token.String(p.Config.OidcDaycareIdClaim)
+	}
+
+	hasRole := false
+	for _, value := range stringList(token[p.Config.OidcRolesClaim]) {
+		role := value
+		if len(p.Config.OidcRoleMapping) > 0 {
+			role = p.Config.OidcRoleMapping[value]
+		}
+		switch role {
+		case roles.ROLE_TEACHER, roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADULT, roles.ROLE_ADMIN:
+			claims[role] = true
+			hasRole = true
+		}
+	}
+	if !hasRole {
+		return nil
+	}
+	return claims
+}
+
+// cachedClaims remembers the email of the identity, SetClaims is only given its uid
+func (p *OIDCProvider) cachedClaims(uid, email string) map[string]interface{} {
+	p.claimsMutex.Lock()
+	defer p.claimsMutex.Unlock()
+
+	cached, ok := p.claims[uid]
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.claims
+	}
+	if p.claims == nil {
+		p.claims = map[string]cachedClaims{}
+	}
+	p.claims[uid] = cachedClaims{email: email}
+	return nil
+}
+
+// stringList reads a claim holding either a string or a list of strings
+func stringList(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		list := []string{}
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
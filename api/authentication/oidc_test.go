@@ -0,0 +1,140 @@
+package authentication_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"time"
+
+	. "github.com/Vinubaba/SANTC-API/api/authentication"
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	"github.com/Vinubaba/SANTC-API/common/jwt"
+	"github.com/Vinubaba/SANTC-API/common/roles"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OIDCProvider", func() {
+
+	var (
+		ctx      = context.Background()
+		key      *rsa.PrivateKey
+		config   *shared.AppConfig
+		provider *OIDCProvider
+	)
+
+	sign := func(claims jwt.Claims) string {
+		claims["iss"] = "https://idp.daycares.net"
+		claims["aud"] = "teddycare"
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		token, err := jwt.Sign(claims, "key-1", key)
+		Expect(err).To(BeNil())
+		return token
+	}
+
+	BeforeEach(func() {
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).To(BeNil())
+		config = &shared.AppConfig{
+			OidcIssuer:              "https://idp.daycares.net",
+			OidcAudience:            "teddycare",
+			OidcEmailClaim:          "email",
+			OidcUserIdClaim:         "teddycare_user",
+			OidcDaycareIdClaim:      "teddycare_daycare",
+			OidcRolesClaim:          "groups",
+			OidcRoleMapping:         map[string]string{"staff": roles.ROLE_TEACHER, "parents": roles.ROLE_ADULT},
+			OidcClaimsCacheLifetime: time.Minute,
+		}
+		provider = &OIDCProvider{
+			KeySet: jwt.KeySet{Keys: []jwt.JSONWebKey{jwt.NewJSONWebKey("key-1", &key.PublicKey)}},
+			Config: config,
+		}
+	})
+
+	It("should map the claims of the token", func() {
+		identity, err := provider.VerifyToken(ctx, sign(jwt.Claims{
+			"sub":               "goku",
+			"email":             "goku@dbz.com",
+			"teddycare_user":    "id1",
+			"teddycare_daycare": "daycare1",
+			"groups":            []interface{}{"staff", "unknown"},
+		}))
+		Expect(err).To(BeNil())
+		Expect(identity.Uid).To(Equal("goku"))
+		Expect(identity.Email).To(Equal("goku@dbz.com"))
+		Expect(identity.Claims).To(Equal(map[string]interface{}{
+			"userId":                  "id1",
+			"daycareId":               "daycare1",
+			roles.ROLE_TEACHER:        true,
+			roles.ROLE_OFFICE_MANAGER: false,
+			roles.ROLE_ADULT:          false,
+			roles.ROLE_ADMIN:          false,
+		}))
+	})
+
+	It("should read the roles of the api when there is no mapping", func() {
+		config.OidcRoleMapping = nil
+
+		identity, err := provider.VerifyToken(ctx, sign(jwt.Claims{
+			"sub":            "goku",
+			"email":          "goku@dbz.com",
+			"teddycare_user": "id1",
+			"groups":         roles.ROLE_OFFICE_MANAGER,
+		}))
+		Expect(err).To(BeNil())
+		Expect(identity.Claims[roles.ROLE_OFFICE_MANAGER]).To(BeTrue())
+	})
+
+	It("should keep the claims of the users without roles in their token", func() {
+		token := sign(jwt.Claims{"sub": "goku", "email": "goku@dbz.com"})
+
+		identity, err := provider.VerifyToken(ctx, token)
+		Expect(err).To(BeNil())
+		Expect(identity.Claims).To(BeNil())
+
+		claims := map[string]interface{}{"userId": "id1", roles.ROLE_ADULT: true}
+		Expect(provider.SetClaims(ctx, "goku", claims)).To(BeNil())
+		identity, err = provider.VerifyToken(ctx, token)
+		Expect(err).To(BeNil())
+		Expect(identity.Claims).To(Equal(claims))
+
+		Expect(provider.DeleteUserByEmail(ctx, "GOKU@dbz.com")).To(BeNil())
+		identity, err = provider.VerifyToken(ctx, token)
+		Expect(err).To(BeNil())
+		Expect(identity.Claims).To(BeNil())
+	})
+
+	It("should refuse the tokens of another issuer", func() {
+		config.OidcIssuer = "https://accounts.google.com"
+
+		_, err := provider.VerifyToken(ctx, sign(jwt.Claims{"sub": "goku", "email": "goku@dbz.com"}))
+		Expect(err).To(Equal(ErrInvalidIssuer))
+	})
+
+	It("should refuse the tokens of another audience", func() {
+		config.OidcAudience = "another-api"
+
+		_, err := provider.VerifyToken(ctx, sign(jwt.Claims{"sub": "goku", "email": "goku@dbz.com"}))
+		Expect(err).To(Equal(ErrInvalidAudience))
+	})
+
+	It("should refuse the tokens without expiration", func() {
+		token, err := jwt.Sign(jwt.Claims{
+			"iss":   "https://idp.daycares.net",
+			"aud":   "teddycare",
+			"sub":   "goku",
+			"email": "goku@dbz.com",
+		}, "key-1", key)
+		Expect(err).To(BeNil())
+
+		_, err = provider.VerifyToken(ctx, token)
+		Expect(err).To(Equal(jwt.ErrMalformedToken))
+	})
+
+	It("should refuse the tokens without email", func() {
+		_, err := provider.VerifyToken(ctx, sign(jwt.Claims{"sub": "goku"}))
+		Expect(err).To(Equal(ErrMissingEmail))
+	})
+})
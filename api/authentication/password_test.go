@@ -0,0 +1,48 @@
+package authentication
+
+import (
+	"encoding/hex"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Password", func() {
+
+	// well known PBKDF2-HMAC-SHA256 vectors, RFC 6070 only covers SHA-1
+	It("should derive the keys like the reference implementations", func() {
+		Expect(hex.EncodeToString(pbkdf2([]byte("password"), []byte("salt"), 1, 32))).
+			To(Equal("120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"))
+		Expect(hex.EncodeToString(pbkdf2([]byte("password"), []byte("salt"), 4096, 32))).
+			To(Equal("c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"))
+		Expect(hex.EncodeToString(pbkdf2([]byte("passwordPASSWORDpassword"), []byte("saltSALTsaltSALTsaltSALTsaltSALTsalt"), 4096, 40))).
+			To(Equal("348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9"))
+	})
+
+	It("should check the password it hashed", func() {
+		hashed, err := hashPassword("teddycare")
+		Expect(err).To(BeNil())
+		Expect(hashed).To(HavePrefix("pbkdf2-sha256$100000$"))
+
+		Expect(checkPassword("teddycare", hashed)).To(BeTrue())
+		Expect(checkPassword("Teddycare", hashed)).To(BeFalse())
+	})
+
+	It("should salt the hashes", func() {
+		first, err := hashPassword("teddycare")
+		Expect(err).To(BeNil())
+		second, err := hashPassword("teddycare")
+		Expect(err).To(BeNil())
+		Expect(first).NotTo(Equal(second))
+	})
+
+	It("should refuse the malformed hashes", func() {
+		hashed, err := hashPassword("teddycare")
+		Expect(err).To(BeNil())
+
+		Expect(checkPassword("teddycare", "")).To(BeFalse())
+		Expect(checkPassword("teddycare", strings.Replace(hashed, "pbkdf2-sha256", "md5", 1))).To(BeFalse())
+		Expect(checkPassword("teddycare", strings.Replace(hashed, "$100000$", "$0$", 1))).To(BeFalse())
+	})
+})
@@ -0,0 +1,66 @@
+package authentication
+
+import (
+	"context"
+
+	"firebase.google.com/go/auth"
+)
+
+const (
+	PROVIDER_FIREBASE = "firebase"
+	PROVIDER_LOCAL    = "local"
+	PROVIDER_OIDC     = "oidc"
+)
+
+// Identity is the account a token was issued for
+type Identity struct {
+	Uid   string
+	Email string
+	// Roles and daycare of the user, stored on the identity the first time it is authenticated
+	Claims map[string]interface{}
+	// The claims come from the issuer of the token, the api does not keep them
+	IssuerClaims bool
+}
+
+// IdentityProvider verifies the bearer tokens and keeps the claims of the identities. The users of the api are
+// matched to their identity by email
+type IdentityProvider interface {
+	VerifyToken(ctx context.Context, token string) (Identity, error)
+	SetClaims(ctx context.Context, uid string, claims map[string]interface{}) error
+	DeleteUserByEmail(ctx context.Context, email string) error
+}
+
+// FirebaseProvider checks the tokens of the users signed in with Firebase, the claims are its custom claims
+type FirebaseProvider struct {
+	FirebaseClient interface {
+		VerifyIDToken(ctx context.Context, idToken string) (*auth.Token, error)
+		GetUser(ctx context.Context, uid string) (*auth.UserRecord, error)
+		SetCustomUserClaims(ctx context.Context, uid string, customClaims map[string]interface{}) error
+		DeleteUserByEmail(ctx context.Context, email string) error
+	} `inject:"teddyFirebaseClient"`
+}
+
+func (p *FirebaseProvider) VerifyToken(ctx context.Context, token string) (Identity, error) {
+	verified, err := p.FirebaseClient.VerifyIDToken(ctx, token)
+	if err != nil {
+		return Identity{}, err
+	}
+	// the custom claims of the token may be older than the ones of the user
+	firebaseUser, err := p.FirebaseClient.GetUser(ctx, verified.UID)
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{
+		Uid:    firebaseUser.UID,
+		Email:  firebaseUser.Email,
+		Claims: firebaseUser.CustomClaims,
+	}, nil
+}
+
+func (p *FirebaseProvider) SetClaims(ctx context.Context, uid string, claims map[string]interface{}) error {
+	return p.FirebaseClient.SetCustomUserClaims(ctx, uid, claims)
+}
+
+func (p *FirebaseProvider) DeleteUserByEmail(ctx context.Context, email string) error {
+	return p.FirebaseClient.DeleteUserByEmail(ctx, email)
+}
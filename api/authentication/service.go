@@ -0,0 +1,75 @@
+package authentication
+
+import (
+	"crypto/rsa"
+	"strings"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	"github.com/Vinubaba/SANTC-API/common/jwt"
+	"github.com/Vinubaba/SANTC-API/common/roles"
+
+	"github.com/pkg/errors"
+)
+
+// a leaked service token is useless after that
+const maxServiceTokenLifetime = time.Hour
+
+var (
+	ErrNotServiceToken     = errors.New("not a service token")
+	ErrInvalidServiceToken = errors.New("invalid service token")
+)
+
+// ServiceVerifier authenticates the other services of teddycare, e.g the event-manager. A service signs its tokens
+// with its own key, the key id and the subject being its name
+type ServiceVerifier struct {
+	KeySet interface {
+		Key(keyId string) (*rsa.PublicKey, error)
+	}
+	Config *shared.AppConfig `inject:""`
+}
+
+// VerifyToken returns the claims of the service, ErrNotServiceToken when the token was signed by no service key
+func (v *ServiceVerifier) VerifyToken(token string) (map[string]interface{}, error) {
+	keyId := ""
+	claims, err := jwt.Verify(token, func(kid string) (*rsa.PublicKey, error) {
+		keyId = kid
+		return v.key(kid)
+	})
+	if err == jwt.ErrUnknownKey {
+		return nil, ErrNotServiceToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// a service only signs tokens for itself
+	service := claims.String("sub")
+	if keyId != service || claims.String("iss") != service || !contains(claims.Audiences(), v.Config.ServiceTokenAudience) {
+		return nil, ErrInvalidServiceToken
+	}
+	exp, ok := claims.Time("exp")
+	if !ok || exp.After(time.Now().Add(maxServiceTokenLifetime)) {
+		return nil, ErrInvalidServiceToken
+	}
+
+	return map[string]interface{}{
+		"userId":                  "",
+		"daycareId":               "",
+		roles.ROLE_TEACHER:        false,
+		roles.ROLE_OFFICE_MANAGER: false,
+		roles.ROLE_ADULT:          false,
+		roles.ROLE_ADMIN:          false,
+		roles.ROLE_SERVICE:        true,
+		"serviceName":             service,
+		"scopes":                  strings.Fields(v.Config.ServiceScopes[service]),
+	}, nil
+}
+
+// the tokens of the users have the kid of their issuer, or none
+func (v *ServiceVerifier) key(keyId string) (*rsa.PublicKey, error) {
+	if keyId == "" || v.KeySet == nil {
+		return nil, jwt.ErrUnknownKey
+	}
+	return v.KeySet.Key(keyId)
+}
@@ -0,0 +1,138 @@
+package authentication_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/Vinubaba/SANTC-API/api/authentication"
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	"github.com/Vinubaba/SANTC-API/common/api"
+	"github.com/Vinubaba/SANTC-API/common/jwt"
+	"github.com/Vinubaba/SANTC-API/common/roles"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ServiceVerifier", func() {
+
+	var (
+		key           *rsa.PrivateKey
+		credentials   *api.ServiceCredentials
+		verifier      *ServiceVerifier
+		authenticator *Authenticator
+		recorder      *httptest.ResponseRecorder
+	)
+
+	serve := func(handler http.Handler, token string) {
+		req, _ := http.NewRequest(http.MethodGet, "/children/1", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder = httptest.NewRecorder()
+		authenticator.Authenticate(handler, nil).ServeHTTP(recorder, req)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	BeforeEach(func() {
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).To(BeNil())
+		credentials = &api.ServiceCredentials{
+			Service:  "event-manager",
+			Audience: "teddycare-api",
+			Key:      key,
+			Lifetime: 5 * time.Minute,
+		}
+		verifier = &ServiceVerifier{
+			KeySet: jwt.KeySet{Keys: []jwt.JSONWebKey{jwt.NewJSONWebKey("event-manager", &key.PublicKey)}},
+			Config: &shared.AppConfig{
+				ServiceTokenAudience: "teddycare-api",
+				ServiceScopes:        map[string]string{"event-manager": "children.read photos.write"},
+			},
+		}
+		authenticator = &Authenticator{Services: verifier}
+	})
+
+	It("should give the service its scopes", func() {
+		token, err := credentials.Token()
+		Expect(err).To(BeNil())
+
+		claims, err := verifier.VerifyToken(token)
+		Expect(err).To(BeNil())
+		Expect(claims[roles.ROLE_SERVICE]).To(BeTrue())
+		Expect(claims["serviceName"]).To(Equal("event-manager"))
+		Expect(claims["scopes"]).To(Equal([]string{roles.SCOPE_CHILDREN_READ, roles.SCOPE_PHOTOS_WRITE}))
+	})
+
+	It("should reuse the token until it is about to expire", func() {
+		first, err := credentials.Token()
+		Expect(err).To(BeNil())
+		second, err := credentials.Token()
+		Expect(err).To(BeNil())
+		Expect(second).To(Equal(first))
+	})
+
+	It("should not take the tokens of other keys for service tokens", func() {
+		token, err := jwt.Sign(jwt.Claims{"sub": "goku"}, "firebase-key", key)
+		Expect(err).To(BeNil())
+
+		_, err = verifier.VerifyToken(token)
+		Expect(err).To(Equal(ErrNotServiceToken))
+	})
+
+	It("should refuse a service signing tokens for another service", func() {
+		token, err := jwt.Sign(jwt.Claims{"iss": "storage-gc", "sub": "storage-gc", "aud": "teddycare-api",
+			"exp": time.Now().Add(time.Minute).Unix()}, "event-manager", key)
+		Expect(err).To(BeNil())
+
+		_, err = verifier.VerifyToken(token)
+		Expect(err).To(Equal(ErrInvalidServiceToken))
+	})
+
+	It("should refuse the tokens living too long", func() {
+		credentials.Lifetime = 24 * time.Hour
+		token, err := credentials.Token()
+		Expect(err).To(BeNil())
+
+		_, err = verifier.VerifyToken(token)
+		Expect(err).To(Equal(ErrInvalidServiceToken))
+	})
+
+	It("should refuse the tokens of another audience", func() {
+		credentials.Audience = "another-api"
+		token, err := credentials.Token()
+		Expect(err).To(BeNil())
+
+		_, err = verifier.VerifyToken(token)
+		Expect(err).To(Equal(ErrInvalidServiceToken))
+	})
+
+	It("should let the service call the routes of its scopes", func() {
+		token, err := credentials.Token()
+		Expect(err).To(BeNil())
+
+		serve(authenticator.Scope(ok, roles.SCOPE_CHILDREN_READ, roles.ROLE_ADMIN), token)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should refuse the service on the routes of other scopes", func() {
+		token, err := credentials.Token()
+		Expect(err).To(BeNil())
+
+		serve(authenticator.Scope(ok, roles.SCOPE_PHOTO_CONSENT_READ, roles.ROLE_ADMIN), token)
+		Expect(recorder.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should refuse the service on the routes of the users", func() {
+		token, err := credentials.Token()
+		Expect(err).To(BeNil())
+
+		serve(authenticator.Roles(ok, roles.ROLE_ADMIN, roles.ROLE_SERVICE), token)
+		Expect(recorder.Code).To(Equal(http.StatusForbidden))
+	})
+})
@@ -2,8 +2,12 @@ package children
 
 import (
 	"context"
+	"database/sql"
 	"path"
+	"strconv"
 
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	"github.com/Vinubaba/SANTC-API/common/events"
 	"github.com/Vinubaba/SANTC-API/common/firebase/claims"
 	"github.com/Vinubaba/SANTC-API/common/storage"
 	"github.com/Vinubaba/SANTC-API/common/store"
@@ -21,17 +25,40 @@ var (
 	ErrEmptyChild       = errors.New("childId cannot be empty")
 	ErrDifferentDaycare = errors.New("child does not belong to this daycare")
 	ErrUpdateDaycare    = errors.New("you can't update a child daycare")
+	ErrEmptyPhoto       = errors.New("photoId cannot be empty")
+	ErrEmptyReason      = errors.New("a reason is mandatory to reject a photo")
+	ErrPhotoReviewed    = store.ErrPhotoReviewed
+	ErrInvalidPaging    = errors.New("limit and offset must be positive integers")
+	ErrInvalidDate      = errors.New("invalid date")
+	ErrEmptyGranted     = errors.New("granted is mandatory")
+	ErrInvalidScope     = errors.New("scope must be one of internal, shareable")
+	ErrNoPhotoConsent   = errors.New("photo consent has not been granted for every child on the photo")
+)
+
+const (
+	REJECTED_PHOTOS_DELETE = "delete"
+	REJECTED_PHOTOS_KEEP   = "keep"
+
+	defaultPhotosLimit = 20
+	maxPhotosLimit     = 100
 )
 
 type Service interface {
 	AddChild(ctx context.Context, request ChildTransport) (store.Child, error)
 	DeleteChild(ctx context.Context, request ChildTransport) error
 	UpdateChild(ctx context.Context, request ChildTransport) (store.Child, error)
+	UpdateChildImage(ctx context.Context, request ImageUploadTransport) (store.Child, error)
 	GetChild(ctx context.Context, request ChildTransport) (store.Child, error)
 	ListChildren(ctx context.Context) ([]store.Child, error)
 
 	AddPhoto(ctx context.Context, request PhotoRequestTransport) error
 	GetPhotosToApprove(ctx context.Context) ([]store.ChildPhoto, error)
+	ApprovePhoto(ctx context.Context, request PhotoRequestTransport) (store.ChildPhoto, error)
+	RejectPhoto(ctx context.Context, request PhotoRequestTransport) (store.ChildPhoto, error)
+	ListChildPhotos(ctx context.Context, request PhotoSearchTransport) ([]store.ChildPhoto, error)
+
+	GetPhotoConsent(ctx context.Context, request PhotoConsentTransport) (store.PhotoConsent, error)
+	SetPhotoConsent(ctx context.Context, request PhotoConsentTransport) (store.PhotoConsent, error)
 }
 
 type ChildService struct {
@@ -45,12 +72,21 @@ type ChildService struct {
 
 		AddChildPhoto(tx *gorm.DB, childPhoto store.ChildPhoto) error
 		ListPhotos(tx *gorm.DB, options store.ChildPhotosSearchOptions) ([]store.ChildPhoto, error)
+		GetPhoto(tx *gorm.DB, photoId string) (store.ChildPhoto, error)
+		ApprovePhoto(tx *gorm.DB, photoId, approvedBy string) error
+		RejectPhoto(tx *gorm.DB, photoId, rejectedBy, reason string) error
+
+		GetPhotoConsent(tx *gorm.DB, childId string) (store.PhotoConsent, error)
+		SetPhotoConsent(tx *gorm.DB, consent store.PhotoConsent) (store.PhotoConsent, error)
 
 		GetClass(tx *gorm.DB, classId string, options store.SearchOptions) (store.Class, error)
 		GetUser(tx *gorm.DB, userId string, searchOptions store.SearchOptions) (store.User, error)
+
+		AddOutboxEvent(tx *gorm.DB, daycareId string, payload events.Payload) error
 	} `inject:""`
-	Storage storage.Storage `inject:""`
-	Logger  *log.Logger     `inject:""`
+	Storage storage.Storage   `inject:""`
+	Logger  *log.Logger       `inject:""`
+	Config  *shared.AppConfig `inject:""`
 }
 
 func (c *ChildService) AddChild(ctx context.Context, request ChildTransport) (store.Child, error) {
@@ -105,12 +141,24 @@ func (c *ChildService) AddChild(ctx context.Context, request ChildTransport) (st
 		return store.Child{}, errors.Wrap(err, "failed to add child")
 	}
 
-	uri, err := c.Storage.Get(ctx, *request.ImageUri)
+	childEvents := []events.Payload{events.ChildCreatedV1{ChildId: child.ChildId.String, ClassId: child.ClassId.String}}
+	if child.ClassId.String != "" {
+		childEvents = append(childEvents, events.ChildEnrolledV1{ChildId: child.ChildId.String, ClassId: child.ClassId.String})
+	}
+	for _, event := range childEvents {
+		if err := c.Store.AddOutboxEvent(tx, child.DaycareId.String, event); err != nil {
+			tx.Rollback()
+			return store.Child{}, errors.Wrap(err, "failed to add child")
+		}
+	}
+
+	uri, err := c.Storage.Get(ctx, *request.ImageUri, storage.USAGE_DOWNLOAD)
 	if err != nil {
 		tx.Rollback()
 		return store.Child{}, errors.Wrap(err, "failed to generate image uri")
 	}
-	child.ImageUri = store.DbNullString(&uri)
+	child.ImageUri = store.DbNullString(&uri.Url)
+	child.ImageUriExpires = uri.Expires
 
 	tx.Commit()
 	return child, nil
@@ -126,11 +174,12 @@ func (c *ChildService) GetChild(ctx context.Context, request ChildTransport) (st
 		return child, errors.Wrap(err, "failed to get child")
 	}
 
-	uri, err := c.Storage.Get(ctx, child.ImageUri.String)
+	uri, err := c.Storage.Get(ctx, child.ImageUri.String, storage.USAGE_DOWNLOAD)
 	if err != nil {
 		return store.Child{}, errors.Wrap(err, "failed to generate image uri")
 	}
-	child.ImageUri = store.DbNullString(&uri)
+	child.ImageUri = store.DbNullString(&uri.Url)
+	child.ImageUriExpires = uri.Expires
 
 	return child, nil
 }
@@ -162,12 +211,20 @@ func (c *ChildService) ListChildren(ctx context.Context) ([]store.Child, error)
 	}
 
 	for i := 0; i < len(children); i++ {
-		uri, err := c.Storage.Get(ctx, children[i].ImageUri.String)
+		thumbnailUri, err := c.Storage.GetThumbnail(ctx, children[i].ImageUri.String)
+		if err != nil {
+			return []store.Child{}, errors.Wrap(err, "failed to generate thumbnail uri")
+		}
+		children[i].ThumbnailUri = store.DbNullString(&thumbnailUri.Url)
+		children[i].ThumbnailUriExpires = thumbnailUri.Expires
+
+		uri, err := c.Storage.Get(ctx, children[i].ImageUri.String, storage.USAGE_DOWNLOAD)
 		if err != nil {
 			return []store.Child{}, errors.Wrap(err, "failed to generate image uri")
 		}
 		// When adding a child, the json response will contains a temporary uri, so the frontend can do whatever it wants with it
-		children[i].ImageUri = store.DbNullString(&uri)
+		children[i].ImageUri = store.DbNullString(&uri.Url)
+		children[i].ImageUriExpires = uri.Expires
 	}
 
 	return children, nil
@@ -205,11 +262,29 @@ func (c *ChildService) UpdateChild(ctx context.Context, request ChildTransport)
 		return store.Child{}, errors.Wrap(err, "failed to decode request")
 	}
 
-	err = c.Store.UpdateChild(nil, childToUpdate)
+	tx := c.Store.Tx()
+	if tx.Error != nil {
+		return store.Child{}, errors.Wrap(tx.Error, "failed to update child")
+	}
+
+	err = c.Store.UpdateChild(tx, childToUpdate)
 	if err != nil {
+		tx.Rollback()
 		return store.Child{}, errors.Wrap(err, "failed to update child")
 	}
 
+	if childToUpdate.ClassId.String != "" && childToUpdate.ClassId.String != child.ClassId.String {
+		if err := c.Store.AddOutboxEvent(tx, child.DaycareId.String, events.ChildEnrolledV1{
+			ChildId:         child.ChildId.String,
+			ClassId:         childToUpdate.ClassId.String,
+			PreviousClassId: child.ClassId.String,
+		}); err != nil {
+			tx.Rollback()
+			return store.Child{}, errors.Wrap(err, "failed to update child")
+		}
+	}
+	tx.Commit()
+
 	childToReturn, err := c.Store.GetChild(nil, childToUpdate.ChildId.String, store.SearchOptions{})
 	if err != nil {
 		return store.Child{}, err
@@ -218,15 +293,50 @@ func (c *ChildService) UpdateChild(ctx context.Context, request ChildTransport)
 	return childToReturn, nil
 }
 
+// UpdateChildImage replaces the image of a child with an uploaded file
+func (c *ChildService) UpdateChildImage(ctx context.Context, request ImageUploadTransport) (store.Child, error) {
+	if IsNilOrEmpty(request.Id) {
+		return store.Child{}, ErrEmptyChild
+	}
+
+	child, err := c.Store.GetChild(nil, *request.Id, claims.GetDefaultSearchOptions(ctx))
+	if err != nil {
+		return store.Child{}, errors.Wrap(err, "failed to update child")
+	}
+
+	imageUri, err := c.Storage.StoreFile(ctx, request.Image, request.ContentType, c.storageFolder(child.DaycareId.String))
+	if err != nil {
+		return store.Child{}, errors.Wrap(err, "failed to store image")
+	}
+
+	if err := c.Store.UpdateChild(nil, store.Child{ChildId: child.ChildId, ImageUri: store.DbNullString(&imageUri)}); err != nil {
+		return store.Child{}, errors.Wrap(err, "failed to update child")
+	}
+	// nothing refers to the previous image anymore
+	if child.ImageUri.String != "" && child.ImageUri.String != imageUri {
+		if err := c.Storage.Delete(ctx, child.ImageUri.String); err != nil {
+			c.Logger.Warn(ctx, "failed to delete previous child image", "imageUri", child.ImageUri.String, "err", err.Error())
+		}
+	}
+
+	childToReturn, err := c.Store.GetChild(nil, child.ChildId.String, store.SearchOptions{})
+	if err != nil {
+		return store.Child{}, err
+	}
+	c.setBucketUri(ctx, &childToReturn)
+	return childToReturn, nil
+}
+
 func (c *ChildService) setBucketUri(ctx context.Context, child *store.Child) {
 	if child.ImageUri.String == "" {
 		return
 	}
-	uri, err := c.Storage.Get(ctx, child.ImageUri.String)
+	uri, err := c.Storage.Get(ctx, child.ImageUri.String, storage.USAGE_DOWNLOAD)
 	if err != nil {
 		c.Logger.Warn(ctx, "failed to generate image uri", "imageUri", child.ImageUri, "err", err.Error())
 	}
-	child.ImageUri = store.DbNullString(&uri)
+	child.ImageUri = store.DbNullString(&uri.Url)
+	child.ImageUriExpires = uri.Expires
 }
 
 func (c *ChildService) AddPhoto(ctx context.Context, request PhotoRequestTransport) error {
@@ -244,6 +354,21 @@ func (c *ChildService) AddPhoto(ctx context.Context, request PhotoRequestTranspo
 		return ErrDifferentDaycare
 	}
 
+	// a group photo can only show children of the same daycare
+	for _, taggedChildId := range request.TaggedChildIds {
+		taggedChild, err := c.Store.GetChild(nil, taggedChildId, store.SearchOptions{})
+		if err != nil {
+			return errors.Wrap(err, "failed to tag child")
+		}
+		if taggedChild.DaycareId.String != child.DaycareId.String {
+			return ErrDifferentDaycare
+		}
+	}
+
+	if err := c.checkPhotoConsent(append([]string{child.ChildId.String}, request.TaggedChildIds...)); err != nil {
+		return err
+	}
+
 	if err := c.Store.AddChildPhoto(nil, photoTransportToStore(request)); err != nil {
 		return errors.Wrap(err, "failed to store photo")
 	}
@@ -261,16 +386,320 @@ func (c *ChildService) GetPhotosToApprove(ctx context.Context) ([]store.ChildPho
 	}
 
 	for i := 0; i < len(photos); i++ {
-		uri, err := c.Storage.Get(ctx, photos[i].ImageUri.String)
+		thumbnailUri, err := c.Storage.GetThumbnail(ctx, photos[i].ImageUri.String)
+		if err != nil {
+			return []store.ChildPhoto{}, errors.Wrap(err, "failed to generate thumbnail uri")
+		}
+		photos[i].ThumbnailUri = store.DbNullString(&thumbnailUri.Url)
+		photos[i].ThumbnailUriExpires = thumbnailUri.Expires
+
+		uri, err := c.Storage.Get(ctx, photos[i].ImageUri.String, storage.USAGE_DOWNLOAD)
+		if err != nil {
+			return []store.ChildPhoto{}, errors.Wrap(err, "failed to generate image uri")
+		}
+		photos[i].ImageUri = store.DbNullString(&uri.Url)
+		photos[i].ImageUriExpires = uri.Expires
+	}
+
+	return photos, nil
+}
+
+func (c *ChildService) ApprovePhoto(ctx context.Context, request PhotoRequestTransport) (store.ChildPhoto, error) {
+	photo, err := c.getPhotoToReview(ctx, request)
+	if err != nil {
+		return store.ChildPhoto{}, errors.Wrap(err, "failed to approve photo")
+	}
+
+	// the consent may have been withdrawn since the photo was published
+	if err := c.checkPhotoConsent(append([]string{photo.ChildId.String}, photo.TaggedChildIds...)); err != nil {
+		return store.ChildPhoto{}, errors.Wrap(err, "failed to approve photo")
+	}
+
+	tx := c.Store.Tx()
+	if tx.Error != nil {
+		return store.ChildPhoto{}, errors.Wrap(tx.Error, "failed to approve photo")
+	}
+
+	if err := c.Store.ApprovePhoto(tx, photo.PhotoId.String, claims.GetUserId(ctx)); err != nil {
+		tx.Rollback()
+		return store.ChildPhoto{}, errors.Wrap(err, "failed to approve photo")
+	}
+
+	child, err := c.Store.GetChild(tx, photo.ChildId.String, store.SearchOptions{})
+	if err != nil {
+		tx.Rollback()
+		return store.ChildPhoto{}, errors.Wrap(err, "failed to approve photo")
+	}
+	if err := c.Store.AddOutboxEvent(tx, child.DaycareId.String, events.PhotoApprovedV1{
+		PhotoId:        photo.PhotoId.String,
+		ChildId:        photo.ChildId.String,
+		PublishedBy:    photo.PublishedBy.String,
+		ApprovedBy:     claims.GetUserId(ctx),
+		TaggedChildIds: photo.TaggedChildIds,
+	}); err != nil {
+		tx.Rollback()
+		return store.ChildPhoto{}, errors.Wrap(err, "failed to approve photo")
+	}
+	tx.Commit()
+
+	photo, err = c.Store.GetPhoto(nil, photo.PhotoId.String)
+	if err != nil {
+		return store.ChildPhoto{}, errors.Wrap(err, "failed to approve photo")
+	}
+
+	uri, err := c.Storage.Get(ctx, photo.ImageUri.String, storage.USAGE_DOWNLOAD)
+	if err != nil {
+		return store.ChildPhoto{}, errors.Wrap(err, "failed to generate image uri")
+	}
+	photo.ImageUri = store.DbNullString(&uri.Url)
+	photo.ImageUriExpires = uri.Expires
+
+	return photo, nil
+}
+
+func (c *ChildService) RejectPhoto(ctx context.Context, request PhotoRequestTransport) (store.ChildPhoto, error) {
+	if IsNilOrEmpty(request.RejectionReason) {
+		return store.ChildPhoto{}, ErrEmptyReason
+	}
+
+	photo, err := c.getPhotoToReview(ctx, request)
+	if err != nil {
+		return store.ChildPhoto{}, errors.Wrap(err, "failed to reject photo")
+	}
+
+	if err := c.Store.RejectPhoto(nil, photo.PhotoId.String, claims.GetUserId(ctx), *request.RejectionReason); err != nil {
+		return store.ChildPhoto{}, errors.Wrap(err, "failed to reject photo")
+	}
+
+	photo, err = c.Store.GetPhoto(nil, photo.PhotoId.String)
+	if err != nil {
+		return store.ChildPhoto{}, errors.Wrap(err, "failed to reject photo")
+	}
+
+	// the row is kept to remember the decision, only the file goes away
+	if c.Config == nil || c.Config.RejectedPhotosPolicy == REJECTED_PHOTOS_DELETE {
+		if err := c.Storage.Delete(ctx, photo.ImageUri.String); err != nil {
+			c.Logger.Warn(ctx, "failed to delete rejected photo", "imageUri", photo.ImageUri.String, "err", err.Error())
+		}
+	}
+
+	return photo, nil
+}
+
+// ListChildPhotos returns the approved photos of a child, most recent first
+func (c *ChildService) ListChildPhotos(ctx context.Context, request PhotoSearchTransport) ([]store.ChildPhoto, error) {
+	if IsNilOrEmpty(request.ChildId) {
+		return []store.ChildPhoto{}, ErrEmptyChild
+	}
+
+	options := store.ChildPhotosSearchOptions{
+		Approved: true,
+		ChildId:  *request.ChildId,
+		Limit:    defaultPhotosLimit,
+	}
+	if !IsNilOrEmpty(request.Limit) {
+		limit, err := strconv.Atoi(*request.Limit)
+		if err != nil || limit <= 0 {
+			return []store.ChildPhoto{}, ErrInvalidPaging
+		}
+		if limit > maxPhotosLimit {
+			limit = maxPhotosLimit
+		}
+		options.Limit = limit
+	}
+	if !IsNilOrEmpty(request.Offset) {
+		offset, err := strconv.Atoi(*request.Offset)
+		if err != nil || offset < 0 {
+			return []store.ChildPhoto{}, ErrInvalidPaging
+		}
+		options.Offset = offset
+	}
+	if !IsNilOrEmpty(request.From) {
+		from, err := dateparse.ParseIn(*request.From, time.UTC)
+		if err != nil {
+			return []store.ChildPhoto{}, errors.Wrap(ErrInvalidDate, err.Error())
+		}
+		options.From = from
+	}
+	if !IsNilOrEmpty(request.To) {
+		to, err := dateparse.ParseIn(*request.To, time.UTC)
+		if err != nil {
+			return []store.ChildPhoto{}, errors.Wrap(ErrInvalidDate, err.Error())
+		}
+		// to is inclusive
+		options.To = to.AddDate(0, 0, 1)
+	}
+
+	// responsibles, teachers and office managers only see the children they are allowed to
+	searchOptions := claims.GetDefaultSearchOptions(ctx)
+	if _, err := c.Store.GetChild(nil, *request.ChildId, searchOptions); err != nil {
+		return []store.ChildPhoto{}, errors.Wrap(err, "failed to list photos")
+	}
+
+	photos, err := c.Store.ListPhotos(nil, options)
+	if err != nil {
+		return []store.ChildPhoto{}, errors.Wrap(err, "failed to list photos")
+	}
+	if err := c.filterTaggedChildren(photos, *request.ChildId, searchOptions); err != nil {
+		return []store.ChildPhoto{}, errors.Wrap(err, "failed to list photos")
+	}
+
+	for i := 0; i < len(photos); i++ {
+		thumbnailUri, err := c.Storage.GetThumbnail(ctx, photos[i].ImageUri.String)
+		if err != nil {
+			return []store.ChildPhoto{}, errors.Wrap(err, "failed to generate thumbnail uri")
+		}
+		photos[i].ThumbnailUri = store.DbNullString(&thumbnailUri.Url)
+		photos[i].ThumbnailUriExpires = thumbnailUri.Expires
+
+		uri, err := c.Storage.Get(ctx, photos[i].ImageUri.String, storage.USAGE_DOWNLOAD)
 		if err != nil {
 			return []store.ChildPhoto{}, errors.Wrap(err, "failed to generate image uri")
 		}
-		photos[i].ImageUri = store.DbNullString(&uri)
+		photos[i].ImageUri = store.DbNullString(&uri.Url)
+		photos[i].ImageUriExpires = uri.Expires
 	}
 
 	return photos, nil
 }
 
+// filterTaggedChildren hides the other families' children tagged on a group photo from responsibles and teachers. A
+// photo published for one of them is shown as a photo of the requested child
+func (c *ChildService) filterTaggedChildren(photos []store.ChildPhoto, childId string, searchOptions store.SearchOptions) error {
+	if searchOptions.ResponsibleId == "" && searchOptions.TeacherId == "" {
+		return nil
+	}
+	children, err := c.Store.ListChildren(nil, searchOptions)
+	if err != nil {
+		return err
+	}
+	visible := map[string]bool{}
+	for _, child := range children {
+		visible[child.ChildId.String] = true
+	}
+
+	for i := 0; i < len(photos); i++ {
+		if !visible[photos[i].ChildId.String] {
+			photos[i].ChildId = store.DbNullString(&childId)
+		}
+		taggedChildIds := []string{}
+		for _, childId := range photos[i].TaggedChildIds {
+			if visible[childId] {
+				taggedChildIds = append(taggedChildIds, childId)
+			}
+		}
+		photos[i].TaggedChildIds = taggedChildIds
+	}
+	return nil
+}
+
+// getPhotoToReview returns a photo still waiting for approval in a daycare visible by the requester
+func (c *ChildService) getPhotoToReview(ctx context.Context, request PhotoRequestTransport) (store.ChildPhoto, error) {
+	if IsNilOrEmpty(request.PhotoId) {
+		return store.ChildPhoto{}, ErrEmptyPhoto
+	}
+
+	photo, err := c.Store.GetPhoto(nil, *request.PhotoId)
+	if err != nil {
+		return store.ChildPhoto{}, err
+	}
+
+	// the reviewer must be allowed to see every child on the photo
+	for _, childId := range append([]string{photo.ChildId.String}, photo.TaggedChildIds...) {
+		if _, err := c.Store.GetChild(nil, childId, claims.GetDefaultSearchOptions(ctx)); err != nil {
+			if errors.Cause(err) == store.ErrChildNotFound {
+				return store.ChildPhoto{}, store.ErrPhotoNotFound
+			}
+			return store.ChildPhoto{}, err
+		}
+	}
+
+	if photo.Approved.Bool || photo.Rejected.Bool {
+		return store.ChildPhoto{}, ErrPhotoReviewed
+	}
+
+	return photo, nil
+}
+
+func (c *ChildService) GetPhotoConsent(ctx context.Context, request PhotoConsentTransport) (store.PhotoConsent, error) {
+	if IsNilOrEmpty(request.ChildId) {
+		return store.PhotoConsent{}, ErrEmptyChild
+	}
+
+	if _, err := c.Store.GetChild(nil, *request.ChildId, claims.GetDefaultSearchOptions(ctx)); err != nil {
+		return store.PhotoConsent{}, errors.Wrap(err, "failed to get photo consent")
+	}
+
+	consent, err := c.Store.GetPhotoConsent(nil, *request.ChildId)
+	if errors.Cause(err) == store.ErrPhotoConsentNotFound {
+		// as long as nobody answered, the child cannot be photographed
+		return store.PhotoConsent{
+			ChildId: store.DbNullString(request.ChildId),
+			Granted: sql.NullBool{Bool: false, Valid: true},
+		}, nil
+	}
+	if err != nil {
+		return store.PhotoConsent{}, errors.Wrap(err, "failed to get photo consent")
+	}
+
+	return consent, nil
+}
+
+func (c *ChildService) SetPhotoConsent(ctx context.Context, request PhotoConsentTransport) (store.PhotoConsent, error) {
+	if IsNilOrEmpty(request.ChildId) {
+		return store.PhotoConsent{}, ErrEmptyChild
+	}
+	if request.Granted == nil {
+		return store.PhotoConsent{}, ErrEmptyGranted
+	}
+
+	consent := store.PhotoConsent{
+		ChildId:   store.DbNullString(request.ChildId),
+		Granted:   store.DbNullBool(request.Granted),
+		GrantedAt: time.Now(),
+	}
+	// a refusal has no scope
+	if *request.Granted {
+		if request.Scope == nil || (*request.Scope != store.PHOTO_CONSENT_INTERNAL && *request.Scope != store.PHOTO_CONSENT_SHAREABLE) {
+			return store.PhotoConsent{}, ErrInvalidScope
+		}
+		consent.Scope = store.DbNullString(request.Scope)
+	}
+	if userId := claims.GetUserId(ctx); userId != "" {
+		consent.GrantedBy = store.DbNullString(&userId)
+	}
+
+	// responsibles can only decide for their own children
+	if _, err := c.Store.GetChild(nil, *request.ChildId, claims.GetDefaultSearchOptions(ctx)); err != nil {
+		return store.PhotoConsent{}, errors.Wrap(err, "failed to set photo consent")
+	}
+
+	consent, err := c.Store.SetPhotoConsent(nil, consent)
+	if err != nil {
+		return store.PhotoConsent{}, errors.Wrap(err, "failed to set photo consent")
+	}
+
+	return consent, nil
+}
+
+// checkPhotoConsent makes sure every child appearing on a photo can be photographed
+func (c *ChildService) checkPhotoConsent(childIds []string) error {
+	childId, err := store.ChildWithoutPhotoConsent(childIds, func(childId string) (store.PhotoConsent, error) {
+		consent, err := c.Store.GetPhotoConsent(nil, childId)
+		if err != nil && errors.Cause(err) != store.ErrPhotoConsentNotFound {
+			return store.PhotoConsent{}, err
+		}
+		return consent, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to get photo consent")
+	}
+	if childId != "" {
+		return ErrNoPhotoConsent
+	}
+	return nil
+}
+
 func transportToStore(request ChildTransport, strict bool) (store.Child, error) {
 	var birthDate, startDate time.Time
 	var err error
@@ -344,6 +773,7 @@ func photoTransportToStore(request PhotoRequestTransport) store.ChildPhoto {
 		PublicationDate: time.Now(),
 		ApprovedBy:      store.DbNullString(request.ApprovedBy),
 		PhotoId:         store.DbNullString(request.PhotoId),
+		TaggedChildIds:  request.TaggedChildIds,
 	}
 	return childPhoto
 }
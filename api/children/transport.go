@@ -3,10 +3,12 @@ package children
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 
 	"github.com/Vinubaba/SANTC-API/api/shared"
 	. "github.com/Vinubaba/SANTC-API/common/api"
+	"github.com/Vinubaba/SANTC-API/common/storage"
 	"github.com/Vinubaba/SANTC-API/common/store"
 
 	"github.com/go-kit/kit/endpoint"
@@ -19,6 +21,14 @@ var (
 	ErrBadRouting = errors.New("inconsistent mapping between route and handler (programmer error)")
 )
 
+type PhotoSearchTransport struct {
+	ChildId *string
+	From    *string
+	To      *string
+	Limit   *string
+	Offset  *string
+}
+
 type HandlerFactory struct {
 	Service Service `inject:""`
 }
@@ -68,6 +78,15 @@ func (h *HandlerFactory) Update(opts []kithttp.ServerOption) *kithttp.Server {
 	)
 }
 
+func (h *HandlerFactory) UpdateImage(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeUpdateImageEndpoint(h.Service),
+		decodeImageUploadRequest,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
 func (h *HandlerFactory) List(opts []kithttp.ServerOption) *kithttp.Server {
 	return kithttp.NewServer(
 		makeListEndpoint(h.Service),
@@ -86,6 +105,51 @@ func (h *HandlerFactory) AddPhoto(opts []kithttp.ServerOption) *kithttp.Server {
 	)
 }
 
+func (h *HandlerFactory) ApprovePhoto(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeApprovePhotoEndpoint(h.Service),
+		decodePhotoReviewRequest,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) RejectPhoto(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeRejectPhotoEndpoint(h.Service),
+		decodePhotoReviewRequest,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) ListPhotos(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeListPhotosEndpoint(h.Service),
+		decodePhotoSearchRequest,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) GetPhotoConsent(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeGetPhotoConsentEndpoint(h.Service),
+		decodePhotoConsentRequest,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) SetPhotoConsent(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeSetPhotoConsentEndpoint(h.Service),
+		decodePhotoConsentRequest,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
 func makeAddEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(ChildTransport)
@@ -164,6 +228,18 @@ func makeUpdateEndpoint(svc Service) endpoint.Endpoint {
 	}
 }
 
+func makeUpdateImageEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ImageUploadTransport)
+
+		child, err := svc.UpdateChildImage(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return storeToTransport(child), nil
+	}
+}
+
 func makeAddPhotoEndpoint(svc Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(PhotoRequestTransport)
@@ -176,6 +252,67 @@ func makeAddPhotoEndpoint(svc Service) endpoint.Endpoint {
 	}
 }
 
+func makeApprovePhotoEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(PhotoRequestTransport)
+		photo, err := svc.ApprovePhoto(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return photoStoreToTransport(photo), nil
+	}
+}
+
+func makeRejectPhotoEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(PhotoRequestTransport)
+		photo, err := svc.RejectPhoto(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return photoStoreToTransport(photo), nil
+	}
+}
+
+func makeListPhotosEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(PhotoSearchTransport)
+		photos, err := svc.ListChildPhotos(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		photosRet := []PhotoRequestTransport{}
+		for _, photo := range photos {
+			photosRet = append(photosRet, photoStoreToTransport(photo))
+		}
+
+		return photosRet, nil
+	}
+}
+
+func makeGetPhotoConsentEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(PhotoConsentTransport)
+		consent, err := svc.GetPhotoConsent(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return photoConsentStoreToTransport(consent), nil
+	}
+}
+
+func makeSetPhotoConsentEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(PhotoConsentTransport)
+		consent, err := svc.SetPhotoConsent(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return photoConsentStoreToTransport(consent), nil
+	}
+}
+
 func decodeChildTransport(_ context.Context, r *http.Request) (interface{}, error) {
 	var request ChildTransport
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -209,6 +346,20 @@ func decodeUpdateChildRequest(_ context.Context, r *http.Request) (interface{},
 	return request, nil
 }
 
+func decodeImageUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	// get id from url
+	vars := mux.Vars(r)
+	id, ok := vars["childId"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+	image, contentType, err := shared.DecodeImageUpload(r)
+	if err != nil {
+		return nil, err
+	}
+	return ImageUploadTransport{Id: &id, Image: image, ContentType: contentType}, nil
+}
+
 func decodePhotoRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	// get id from url
 	vars := mux.Vars(r)
@@ -225,6 +376,54 @@ func decodePhotoRequest(_ context.Context, r *http.Request) (interface{}, error)
 	return request, nil
 }
 
+func decodePhotoReviewRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	photoId, ok := vars["photoId"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+	// the payload is optional when approving, it only carries the rejection reason
+	var request PhotoRequestTransport
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		return nil, err
+	}
+	request.PhotoId = &photoId
+	return request, nil
+}
+
+func decodePhotoSearchRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	childId, ok := vars["childId"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+
+	query := r.URL.Query()
+	from, to, limit, offset := query.Get("from"), query.Get("to"), query.Get("limit"), query.Get("offset")
+	return PhotoSearchTransport{
+		ChildId: &childId,
+		From:    &from,
+		To:      &to,
+		Limit:   &limit,
+		Offset:  &offset,
+	}, nil
+}
+
+func decodePhotoConsentRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	childId, ok := vars["childId"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+	// there is no payload when reading the consent
+	var request PhotoConsentTransport
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		return nil, err
+	}
+	request.ChildId = &childId
+	return request, nil
+}
+
 func ignorePayload(_ context.Context, r *http.Request) (interface{}, error) {
 	return nil, nil
 }
@@ -233,9 +432,15 @@ func ignorePayload(_ context.Context, r *http.Request) (interface{}, error) {
 func EncodeError(_ context.Context, err error, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	switch errors.Cause(err) {
-	case ErrNoParent, store.ErrSetResponsible, ErrUpdateDaycare, store.ErrClassNotFound, ErrDifferentDaycare:
+	case ErrNoParent, store.ErrSetResponsible, ErrUpdateDaycare, store.ErrClassNotFound, ErrDifferentDaycare,
+		ErrEmptyPhoto, ErrEmptyReason, ErrPhotoReviewed, ErrInvalidPaging, ErrInvalidDate, ErrEmptyGranted, ErrInvalidScope,
+		storage.ErrUnsupportedFileFormat, storage.ErrMismatchingFileFormat, shared.ErrMissingImage:
 		w.WriteHeader(http.StatusBadRequest)
-	case store.ErrUserNotFound, store.ErrChildNotFound:
+	case storage.ErrFileTooLarge:
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	case ErrNoPhotoConsent:
+		w.WriteHeader(http.StatusForbidden)
+	case store.ErrUserNotFound, store.ErrChildNotFound, store.ErrPhotoNotFound:
 		w.WriteHeader(http.StatusNotFound)
 	default:
 		w.WriteHeader(http.StatusInternalServerError)
@@ -262,6 +467,13 @@ func storeToTransport(child store.Child) ChildTransport {
 		ResponsibleId: &child.ResponsibleId.String,
 		Relationship:  &child.Relationship.String,
 		AddressSameAs: &child.AddressSameAs.String,
+
+		ImageUriExpiresAt:     ExpiresAt(child.ImageUriExpires),
+		ThumbnailUriExpiresAt: ExpiresAt(child.ThumbnailUriExpires),
+	}
+	// only list responses come with a thumbnail
+	if child.ThumbnailUri.Valid {
+		ret.ThumbnailUri = &child.ThumbnailUri.String
 	}
 
 	for _, allergy := range child.Allergies {
@@ -308,6 +520,39 @@ func photoStoreToTransport(request store.ChildPhoto) PhotoRequestTransport {
 		PublicationDate: &publicationDate,
 		ApprovedBy:      &request.ApprovedBy.String,
 		PhotoId:         &request.PhotoId.String,
+		TaggedChildIds:  request.TaggedChildIds,
+
+		FilenameExpiresAt:     ExpiresAt(request.ImageUriExpires),
+		ThumbnailUriExpiresAt: ExpiresAt(request.ThumbnailUriExpires),
+	}
+	if request.ThumbnailUri.Valid {
+		childPhoto.ThumbnailUri = &request.ThumbnailUri.String
+	}
+	if childPhoto.TaggedChildIds == nil {
+		childPhoto.TaggedChildIds = []string{}
+	}
+	if request.Rejected.Bool {
+		childPhoto.Rejected = &request.Rejected.Bool
+		childPhoto.RejectedBy = &request.RejectedBy.String
+		childPhoto.RejectionReason = &request.RejectionReason.String
 	}
 	return childPhoto
 }
+
+func photoConsentStoreToTransport(consent store.PhotoConsent) PhotoConsentTransport {
+	ret := PhotoConsentTransport{
+		ChildId: &consent.ChildId.String,
+		Granted: &consent.Granted.Bool,
+	}
+	if consent.Scope.Valid {
+		ret.Scope = &consent.Scope.String
+	}
+	if consent.GrantedBy.Valid {
+		ret.GrantedBy = &consent.GrantedBy.String
+	}
+	if !consent.GrantedAt.IsZero() {
+		grantedAt := consent.GrantedAt.UTC().String()
+		ret.GrantedAt = &grantedAt
+	}
+	return ret
+}
@@ -2,11 +2,13 @@ package children_test
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"time"
 
 	"github.com/Vinubaba/SANTC-API/api/authentication"
 	. "github.com/Vinubaba/SANTC-API/api/children"
@@ -19,6 +21,7 @@ import (
 	. "github.com/Vinubaba/SANTC-API/common/api"
 	"github.com/Vinubaba/SANTC-API/common/log"
 	"github.com/Vinubaba/SANTC-API/common/roles"
+	"github.com/Vinubaba/SANTC-API/common/storage"
 	"github.com/Vinubaba/SANTC-API/common/storage/mocks"
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
@@ -39,6 +42,8 @@ var _ = Describe("Transport", func() {
 		concreteDb          *gorm.DB
 		mockStringGenerator *MockStringGenerator
 		mockStorage         = &mocks.MockGcs{}
+		mockStorageGet      *mock.Call
+		mockStorageThumb    *mock.Call
 		mockFirebaseClient  *MockClient
 
 		authenticator *authentication.Authenticator
@@ -113,7 +118,8 @@ var _ = Describe("Transport", func() {
 		mockStringGenerator.On("GenerateUuid").Return("generatedId3").Once()
 		mockStringGenerator.On("GenerateUuid").Return("generatedId4").Once()
 
-		mockStorage.On("Get", mock.Anything, mock.Anything).Return("gs://foo/"+mockImageUriName, nil)
+		mockStorageGet = mockStorage.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(storage.SignedUrl{Url: "gs://foo/" + mockImageUriName}, nil)
+		mockStorageThumb = mockStorage.On("GetThumbnail", mock.Anything, mock.Anything).Return(storage.SignedUrl{Url: "gs://foo/" + mockImageUriName}, nil)
 		mockStorage.On("Delete", mock.Anything, mock.Anything).Return(nil)
 
 		concreteStore = &store.Store{
@@ -125,9 +131,9 @@ var _ = Describe("Transport", func() {
 		mockFirebaseClient.On("DeleteUser", mock.Anything, mock.Anything).Return(nil)
 
 		userService := &users.UserService{
-			FirebaseClient: mockFirebaseClient,
-			Store:          concreteStore,
-			Storage:        mockStorage,
+			IdentityProvider: mockFirebaseClient,
+			Store:            concreteStore,
+			Storage:          mockStorage,
 		}
 		logger := log.NewLogger("teddycare")
 
@@ -161,8 +167,14 @@ var _ = Describe("Transport", func() {
 		router.Handle("/children/{childId}", authenticator.Roles(handlerFactory.Get(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADULT, roles.ROLE_ADMIN, roles.ROLE_TEACHER)).Methods(http.MethodGet)
 		router.Handle("/children/{childId}", authenticator.Roles(handlerFactory.Update(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADULT, roles.ROLE_ADMIN)).Methods(http.MethodPatch)
 		router.Handle("/children/{childId}", authenticator.Roles(handlerFactory.Delete(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN)).Methods(http.MethodDelete)
-		router.Handle("/children/{childId}/photos", authenticator.Roles(handlerFactory.AddPhoto(opts), roles.ROLE_SERVICE)).Methods(http.MethodPost)
+		router.Handle("/children/{childId}/image", authenticator.Roles(handlerFactory.UpdateImage(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADULT, roles.ROLE_ADMIN)).Methods(http.MethodPut)
+		router.Handle("/children/{childId}/photos", authenticator.Scope(handlerFactory.AddPhoto(opts), roles.SCOPE_PHOTOS_WRITE)).Methods(http.MethodPost)
+		router.Handle("/children/{childId}/photos", authenticator.Roles(handlerFactory.ListPhotos(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADULT, roles.ROLE_ADMIN, roles.ROLE_TEACHER)).Methods(http.MethodGet)
+		router.Handle("/children/{childId}/photo-consent", authenticator.Scope(handlerFactory.GetPhotoConsent(opts), roles.SCOPE_PHOTO_CONSENT_READ, roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADULT, roles.ROLE_ADMIN, roles.ROLE_TEACHER)).Methods(http.MethodGet)
+		router.Handle("/children/{childId}/photo-consent", authenticator.Roles(handlerFactory.SetPhotoConsent(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADULT, roles.ROLE_ADMIN)).Methods(http.MethodPut)
 		router.Handle("/photos-to-approve", authenticator.Roles(handlerFactory.GetPhotosToApprove(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN)).Methods(http.MethodGet)
+		router.Handle("/photos/{photoId}/approve", authenticator.Roles(handlerFactory.ApprovePhoto(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN)).Methods(http.MethodPost)
+		router.Handle("/photos/{photoId}/reject", authenticator.Roles(handlerFactory.RejectPhoto(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN)).Methods(http.MethodPost)
 		recorder = httptest.NewRecorder()
 
 		shared.SetDbInitialState()
@@ -204,6 +216,50 @@ var _ = Describe("Transport", func() {
 				BeforeEach(func() { claims[roles.ROLE_ADMIN] = true })
 				assertReturnedChildrenWithIds("childid-1", "childid-2", "childid-3", "childid-4")
 				assertHttpCode(http.StatusOK)
+				It("should sign the thumbnails", func() {
+					signed := []string{}
+					for _, call := range mockStorage.CallsForMethod("GetThumbnail") {
+						signed = append(signed, call.Arguments.String(1))
+					}
+					Expect(signed).To(ContainElement("gs://foo/bar.jpg"))
+
+					childrenTransport := []ChildTransport{}
+					json.Unmarshal(recorder.Body.Bytes(), &childrenTransport)
+					for _, child := range childrenTransport {
+						Expect(child.ThumbnailUri).NotTo(BeNil())
+					}
+				})
+			})
+
+			Context("When the urls are signed", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADMIN] = true
+					mockStorageGet.Return(storage.SignedUrl{
+						Url:     "gs://foo/" + mockImageUriName,
+						Expires: time.Date(2018, time.March, 1, 10, 0, 0, 0, time.UTC),
+					}, nil)
+					mockStorageThumb.Return(storage.SignedUrl{
+						Url:     "gs://foo/" + mockImageUriName,
+						Expires: time.Date(2018, time.March, 1, 10, 0, 0, 0, time.UTC),
+					}, nil)
+				})
+				It("should tell when they expire", func() {
+					childrenTransport := []ChildTransport{}
+					json.Unmarshal(recorder.Body.Bytes(), &childrenTransport)
+					Expect(childrenTransport).NotTo(BeEmpty())
+					for _, child := range childrenTransport {
+						Expect(*child.ImageUriExpiresAt).To(Equal("2018-03-01T10:00:00Z"))
+						Expect(*child.ThumbnailUriExpiresAt).To(Equal("2018-03-01T10:00:00Z"))
+					}
+				})
+				It("should sign the thumbnails and the images for their usage", func() {
+					usages := []storage.UrlUsage{}
+					for _, call := range mockStorage.CallsForMethod("Get") {
+						usages = append(usages, call.Arguments.Get(2).(storage.UrlUsage))
+					}
+					Expect(usages).To(ContainElement(storage.USAGE_DOWNLOAD))
+					Expect(mockStorage.CallsForMethod("GetThumbnail")).NotTo(BeEmpty())
+				})
 			})
 
 			Context("When user is an office manager", func() {
@@ -581,6 +637,102 @@ var _ = Describe("Transport", func() {
 
 		})
 
+		Describe("UPDATE IMAGE", func() {
+
+			BeforeEach(func() {
+				mockStorage.On("StoreFile", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(mockImageUriName, nil)
+				httpMethodToUse = http.MethodPut
+				httpEndpointToUse = "/children/childid-1/image"
+				var contentType string
+				httpBodyToUse, contentType = shared.NewMultipartImage("image", []byte("image content"))
+				headersToUse.Set("Content-Type", contentType)
+			})
+
+			Context("When user is an admin", func() {
+				BeforeEach(func() { claims[roles.ROLE_ADMIN] = true })
+				assertHttpCode(http.StatusOK)
+				mockStorage.AssertStoredFile("daycares/namek/children")
+				It("should respond with the new image", func() {
+					child := ChildTransport{}
+					json.Unmarshal(recorder.Body.Bytes(), &child)
+					Expect(*child.Id).To(Equal("childid-1"))
+					Expect(*child.ImageUri).To(Equal("gs://foo/bar.jpg"))
+				})
+				It("should stream the uploaded file to the storage", func() {
+					calls := mockStorage.CallsForMethod("StoreFile")
+					Expect(calls).To(HaveLen(1))
+				})
+				It("should delete the previous image", func() {
+					calls := mockStorage.CallsForMethod("Delete")
+					Expect(calls).To(HaveLen(1))
+					Expect(calls[0].Arguments.String(1)).To(Equal("gs://foo/bar.jpg"))
+				})
+			})
+
+			Context("When user is an office manager from another daycare", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_OFFICE_MANAGER] = true
+					claims["daycareId"] = "peyredragon"
+				})
+				assertJsonResponse(`{"error": "failed to update child: child not found"}`)
+				assertHttpCode(http.StatusNotFound)
+			})
+
+			Context("When user is a teacher", func() {
+				BeforeEach(func() { claims[roles.ROLE_TEACHER] = true })
+				assertReturnedNoPayload()
+				assertHttpCode(http.StatusUnauthorized)
+			})
+
+			Context("When the file is not sent in the image field", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADMIN] = true
+					var contentType string
+					httpBodyToUse, contentType = shared.NewMultipartImage("file", []byte("image content"))
+					headersToUse.Set("Content-Type", contentType)
+				})
+				assertJsonResponse(`{"error": "the request must be a multipart/form-data with an 'image' file"}`)
+				assertHttpCode(http.StatusBadRequest)
+			})
+
+			Context("When the image is too large", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADMIN] = true
+					mockStorage.Reset()
+					mockStorage.On("StoreFile", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return("", storage.ErrFileTooLarge)
+				})
+				assertJsonResponse(`{"error": "failed to store image: the image is too large"}`)
+				assertHttpCode(http.StatusRequestEntityTooLarge)
+			})
+
+			Context("When the file is not an image", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADMIN] = true
+					mockStorage.Reset()
+					mockStorage.On("StoreFile", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return("", storage.ErrUnsupportedFileFormat)
+				})
+				assertHttpCode(http.StatusBadRequest)
+			})
+
+			Context("When the declared type of the file is not its content", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADMIN] = true
+					var contentType string
+					httpBodyToUse, contentType = shared.NewMultipartImageOfType("image", "image/png", []byte("\xff\xd8\xff\xe0 jpeg content"))
+					headersToUse.Set("Content-Type", contentType)
+					mockStorage.Reset()
+					mockStorage.On("StoreFile", mock.Anything, mock.Anything, "image/png", mock.Anything).Return("", storage.ErrMismatchingFileFormat)
+				})
+				assertJsonResponse(`{"error": "failed to store image: the declared content type does not match the content of the file"}`)
+				assertHttpCode(http.StatusBadRequest)
+				It("should hand the declared type to the storage", func() {
+					calls := mockStorage.CallsForMethod("StoreFile")
+					Expect(calls).To(HaveLen(1))
+					Expect(calls[0].Arguments.String(2)).To(Equal("image/png"))
+				})
+			})
+		})
+
 		Describe("CREATE", func() {
 
 			var (
@@ -755,8 +907,11 @@ var _ = Describe("Transport", func() {
 				httpMethodToUse = http.MethodPost
 				httpEndpointToUse = "/children/childid-1/photos"
 				httpBodyToUse = `{"filename": "abcd-efgh.jpg", "childId": "childid-1", "publishedBy": "id6"}`
-				headersToUse.Set(roles.ROLE_REQUEST_HEADER, roles.ROLE_SERVICE)
-				claims = map[string]interface{}{}
+				claims = map[string]interface{}{
+					roles.ROLE_SERVICE: true,
+					"serviceName":      "event-manager",
+					"scopes":           []string{roles.SCOPE_PHOTOS_WRITE},
+				}
 			})
 			Context("Default", func() {
 				assertReturnedNoPayload()
@@ -779,6 +934,48 @@ var _ = Describe("Transport", func() {
 				assertHttpCode(http.StatusBadRequest)
 			})
 
+			Context("When a tagged child does not belong to the same daycare", func() {
+				BeforeEach(func() {
+					httpBodyToUse = `{"filename": "abcd-efgh.jpg", "publishedBy": "id6", "taggedChildIds": ["childid-2", "childid-3"]}`
+				})
+				assertJsonResponse(`{"error":"child does not belong to this daycare"}`)
+				assertHttpCode(http.StatusBadRequest)
+			})
+
+			Context("When a tagged child does not exist", func() {
+				BeforeEach(func() {
+					httpBodyToUse = `{"filename": "abcd-efgh.jpg", "publishedBy": "id6", "taggedChildIds": ["foobar"]}`
+				})
+				assertJsonResponse(`{"error":"failed to tag child: child not found"}`)
+				assertHttpCode(http.StatusNotFound)
+			})
+
+			Context("When a tagged child has no photo consent", func() {
+				BeforeEach(func() {
+					httpBodyToUse = `{"filename": "abcd-efgh.jpg", "publishedBy": "id6", "taggedChildIds": ["childid-2"]}`
+				})
+				assertJsonResponse(`{"error":"photo consent has not been granted for every child on the photo"}`)
+				assertHttpCode(http.StatusForbidden)
+			})
+
+			Context("When the child consent is internal only", func() {
+				BeforeEach(func() {
+					httpEndpointToUse = "/children/childid-3/photos"
+					httpBodyToUse = `{"filename": "abcd-efgh.jpg", "publishedBy": "id2"}`
+				})
+				assertReturnedNoPayload()
+				assertHttpCode(http.StatusCreated)
+			})
+
+			Context("When a child with an internal consent is on a group photo", func() {
+				BeforeEach(func() {
+					httpEndpointToUse = "/children/childid-3/photos"
+					httpBodyToUse = `{"filename": "abcd-efgh.jpg", "publishedBy": "id2", "taggedChildIds": ["childid-4"]}`
+				})
+				assertJsonResponse(`{"error":"photo consent has not been granted for every child on the photo"}`)
+				assertHttpCode(http.StatusForbidden)
+			})
+
 			Context("When the childId does not exist", func() {
 				BeforeEach(func() {
 					httpBodyToUse = `{"filename": "abcd-efgh.jpg", "senderId": "id6", "bucket": "photo-approvals"}`
@@ -793,12 +990,20 @@ var _ = Describe("Transport", func() {
 				BeforeEach(func() {
 					httpBodyToUse = `{"filename": "abcd-efgh.jpg", "senderId": "id6", "bucket": "photo-approvals"}`
 					httpEndpointToUse = "/children/foobar/photos"
-					headersToUse = http.Header{}
+					claims = map[string]interface{}{roles.ROLE_OFFICE_MANAGER: true}
 				})
 				assertReturnedNoPayload()
 				assertHttpCode(http.StatusUnauthorized)
 			})
 
+			Context("When the service was not given the scope", func() {
+				BeforeEach(func() {
+					claims["scopes"] = []string{roles.SCOPE_CHILDREN_READ}
+				})
+				assertReturnedNoPayload()
+				assertHttpCode(http.StatusForbidden)
+			})
+
 		})
 
 		Describe("LIST PHOTOS TO APPROVE", func() {
@@ -814,12 +1019,14 @@ var _ = Describe("Transport", func() {
 			Context("Default", func() {
 				assertJsonResponse(`[{
                 "filename": "gs://foo/bar.jpg",
+                "thumbnailUri": "gs://foo/bar.jpg",
                 "childId": "childid-1",
                 "publishedBy": "id9",
                 "photoId": "photoid-1",
                 "approvedBy": "",
                 "approved": false,
-                "publicationDate": "1992-10-13 00:00:00 +0000 UTC"
+                "publicationDate": "1992-10-13 00:00:00 +0000 UTC",
+                "taggedChildIds": ["childid-1"]
               }]`)
 				assertHttpCode(http.StatusOK)
 			})
@@ -834,6 +1041,360 @@ var _ = Describe("Transport", func() {
 
 		})
 
+		Describe("LIST CHILD PHOTOS", func() {
+
+			var (
+				jsonPhoto3 = `{
+					"filename": "gs://foo/bar.jpg",
+					"thumbnailUri": "gs://foo/bar.jpg",
+					"childId": "childid-3",
+					"publishedBy": "id4",
+					"photoId": "photoid-3",
+					"approvedBy": "id3",
+					"approved": true,
+					"publicationDate": "2018-05-16 00:00:00 +0000 UTC",
+					"taggedChildIds": ["childid-3", "childid-4"]
+				}`
+				jsonPhoto2 = `{
+					"filename": "gs://foo/bar.jpg",
+					"thumbnailUri": "gs://foo/bar.jpg",
+					"childId": "childid-3",
+					"publishedBy": "id4",
+					"photoId": "photoid-2",
+					"approvedBy": "id3",
+					"approved": true,
+					"publicationDate": "2018-05-14 00:00:00 +0000 UTC",
+					"taggedChildIds": ["childid-3"]
+				}`
+			)
+
+			BeforeEach(func() {
+				httpMethodToUse = http.MethodGet
+				httpEndpointToUse = "/children/childid-3/photos"
+				httpBodyToUse = ""
+				claims["daycareId"] = "peyredragon"
+			})
+
+			Context("When user is an adult responsible of the child", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADULT] = true
+					claims["userId"] = "id4"
+				})
+				It("should not show the other children tagged on the photos", func() {
+					Expect(recorder.Body.String()).To(MatchJSON("[" + strings.Replace(jsonPhoto3, `["childid-3", "childid-4"]`, `["childid-3"]`, 1) + "," + jsonPhoto2 + "]"))
+				})
+				assertHttpCode(http.StatusOK)
+			})
+
+			Context("When the child is tagged on a group photo", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADULT] = true
+					claims["userId"] = "id3"
+					httpEndpointToUse = "/children/childid-4/photos"
+				})
+				It("should only show their child on the photo", func() {
+					jsonPhoto := strings.Replace(jsonPhoto3, `["childid-3", "childid-4"]`, `["childid-4"]`, 1)
+					jsonPhoto = strings.Replace(jsonPhoto, `"childId": "childid-3"`, `"childId": "childid-4"`, 1)
+					Expect(recorder.Body.String()).To(MatchJSON("[" + jsonPhoto + "]"))
+					Expect(recorder.Body.String()).NotTo(ContainSubstring("childid-3"))
+				})
+				assertHttpCode(http.StatusOK)
+			})
+
+			Context("When paginating", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_OFFICE_MANAGER] = true
+					httpEndpointToUse = "/children/childid-3/photos?limit=1&offset=1"
+				})
+				assertJsonResponse("[" + jsonPhoto2 + "]")
+				assertHttpCode(http.StatusOK)
+			})
+
+			Context("When filtering by date", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_OFFICE_MANAGER] = true
+					httpEndpointToUse = "/children/childid-3/photos?from=2018-05-15&to=2018-05-16"
+				})
+				assertJsonResponse("[" + jsonPhoto3 + "]")
+				assertHttpCode(http.StatusOK)
+			})
+
+			Context("When the limit is invalid", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_OFFICE_MANAGER] = true
+					httpEndpointToUse = "/children/childid-3/photos?limit=-1"
+				})
+				assertJsonResponse(`{"error":"limit and offset must be positive integers"}`)
+				assertHttpCode(http.StatusBadRequest)
+			})
+
+			Context("When user is a random adult", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADULT] = true
+					claims["userId"] = "id5"
+				})
+				assertJsonResponse(`{"error":"failed to list photos: child not found"}`)
+				assertHttpCode(http.StatusNotFound)
+			})
+
+			Context("When user is an office manager of another daycare", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_OFFICE_MANAGER] = true
+					claims["daycareId"] = "namek"
+				})
+				assertJsonResponse(`{"error":"failed to list photos: child not found"}`)
+				assertHttpCode(http.StatusNotFound)
+			})
+		})
+
+		Describe("GET PHOTO CONSENT", func() {
+
+			BeforeEach(func() {
+				httpMethodToUse = http.MethodGet
+				httpEndpointToUse = "/children/childid-3/photo-consent"
+				httpBodyToUse = ""
+			})
+
+			Context("When user is an adult responsible of the child", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADULT] = true
+					claims["userId"] = "id4"
+				})
+				assertJsonResponse(`{
+					"childId": "childid-3",
+					"granted": true,
+					"scope": "internal",
+					"grantedBy": "id4",
+					"grantedAt": "2018-03-28 09:00:00 +0000 UTC"
+				}`)
+				assertHttpCode(http.StatusOK)
+			})
+
+			Context("When nobody answered yet", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADMIN] = true
+					httpEndpointToUse = "/children/childid-2/photo-consent"
+				})
+				assertJsonResponse(`{"childId": "childid-2", "granted": false, "scope": null, "grantedBy": null, "grantedAt": null}`)
+				assertHttpCode(http.StatusOK)
+			})
+
+			Context("When user is a random adult", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADULT] = true
+					claims["userId"] = "id5"
+				})
+				assertJsonResponse(`{"error":"failed to get photo consent: child not found"}`)
+				assertHttpCode(http.StatusNotFound)
+			})
+		})
+
+		Describe("SET PHOTO CONSENT", func() {
+
+			BeforeEach(func() {
+				httpMethodToUse = http.MethodPut
+				httpEndpointToUse = "/children/childid-3/photo-consent"
+				httpBodyToUse = `{"granted": true, "scope": "shareable"}`
+			})
+
+			Context("When user is an adult responsible of the child", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADULT] = true
+					claims["userId"] = "id4"
+				})
+				assertHttpCode(http.StatusOK)
+				It("should replace the consent", func() {
+					consent := PhotoConsentTransport{}
+					Expect(json.Unmarshal(recorder.Body.Bytes(), &consent)).To(Succeed())
+					Expect(*consent.ChildId).To(Equal("childid-3"))
+					Expect(*consent.Granted).To(BeTrue())
+					Expect(*consent.Scope).To(Equal("shareable"))
+					Expect(*consent.GrantedBy).To(Equal("id4"))
+					Expect(consent.GrantedAt).NotTo(BeNil())
+				})
+			})
+
+			Context("When the consent is denied", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_OFFICE_MANAGER] = true
+					claims["userId"] = "id2"
+					httpEndpointToUse = "/children/childid-4/photo-consent"
+					httpBodyToUse = `{"granted": false, "scope": "shareable"}`
+				})
+				assertHttpCode(http.StatusOK)
+				It("should drop the scope", func() {
+					consent, err := concreteStore.GetPhotoConsent(nil, "childid-4")
+					Expect(err).To(BeNil())
+					Expect(consent.Granted.Bool).To(BeFalse())
+					Expect(consent.Scope.Valid).To(BeFalse())
+					Expect(consent.GrantedBy.String).To(Equal("id2"))
+				})
+			})
+
+			Context("When the child had no consent yet", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADMIN] = true
+					claims["userId"] = "id6"
+					httpEndpointToUse = "/children/childid-2/photo-consent"
+					httpBodyToUse = `{"granted": true, "scope": "internal"}`
+				})
+				assertHttpCode(http.StatusOK)
+				It("should create the consent", func() {
+					consent, err := concreteStore.GetPhotoConsent(nil, "childid-2")
+					Expect(err).To(BeNil())
+					Expect(consent.Granted.Bool).To(BeTrue())
+					Expect(consent.Scope.String).To(Equal("internal"))
+				})
+			})
+
+			Context("When the scope is invalid", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADULT] = true
+					claims["userId"] = "id4"
+					httpBodyToUse = `{"granted": true, "scope": "everyone"}`
+				})
+				assertJsonResponse(`{"error":"scope must be one of internal, shareable"}`)
+				assertHttpCode(http.StatusBadRequest)
+			})
+
+			Context("When granted is missing", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADULT] = true
+					claims["userId"] = "id4"
+					httpBodyToUse = `{"scope": "internal"}`
+				})
+				assertJsonResponse(`{"error":"granted is mandatory"}`)
+				assertHttpCode(http.StatusBadRequest)
+			})
+
+			Context("When user is a random adult", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADULT] = true
+					claims["userId"] = "id5"
+				})
+				assertJsonResponse(`{"error":"failed to set photo consent: child not found"}`)
+				assertHttpCode(http.StatusNotFound)
+			})
+
+			Context("When user is a teacher", func() {
+				BeforeEach(func() { claims[roles.ROLE_TEACHER] = true })
+				assertReturnedNoPayload()
+				assertHttpCode(http.StatusUnauthorized)
+			})
+		})
+
+		Describe("APPROVE PHOTO", func() {
+
+			BeforeEach(func() {
+				httpMethodToUse = http.MethodPost
+				httpEndpointToUse = "/photos/photoid-1/approve"
+				httpBodyToUse = ""
+			})
+
+			Context("When user is an office manager of the same daycare", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_OFFICE_MANAGER] = true
+					claims["daycareId"] = "namek"
+					claims["userId"] = "id7"
+				})
+				assertJsonResponse(`{
+					"filename": "gs://foo/bar.jpg",
+					"childId": "childid-1",
+					"publishedBy": "id9",
+					"photoId": "photoid-1",
+					"approvedBy": "id7",
+					"approved": true,
+					"publicationDate": "1992-10-13 00:00:00 +0000 UTC",
+					"taggedChildIds": ["childid-1"]
+				}`)
+				assertHttpCode(http.StatusOK)
+			})
+
+			Context("When user is an office manager of another daycare", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_OFFICE_MANAGER] = true
+					claims["daycareId"] = "peyredragon"
+				})
+				assertJsonResponse(`{"error":"failed to approve photo: photo not found"}`)
+				assertHttpCode(http.StatusNotFound)
+			})
+
+			Context("When the photo does not exist", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADMIN] = true
+					httpEndpointToUse = "/photos/foo/approve"
+				})
+				assertJsonResponse(`{"error":"failed to approve photo: photo not found"}`)
+				assertHttpCode(http.StatusNotFound)
+			})
+
+			Context("When the consent has been withdrawn since the photo was published", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_OFFICE_MANAGER] = true
+					claims["daycareId"] = "namek"
+					claims["userId"] = "id7"
+					concreteStore.SetPhotoConsent(nil, store.PhotoConsent{
+						ChildId: sql.NullString{String: "childid-1", Valid: true},
+						Granted: sql.NullBool{Bool: false, Valid: true},
+					})
+				})
+				assertJsonResponse(`{"error":"failed to approve photo: photo consent has not been granted for every child on the photo"}`)
+				assertHttpCode(http.StatusForbidden)
+			})
+
+			Context("When user is a teacher", func() {
+				BeforeEach(func() { claims[roles.ROLE_TEACHER] = true })
+				assertReturnedNoPayload()
+				assertHttpCode(http.StatusUnauthorized)
+			})
+		})
+
+		Describe("REJECT PHOTO", func() {
+
+			BeforeEach(func() {
+				httpMethodToUse = http.MethodPost
+				httpEndpointToUse = "/photos/photoid-1/reject"
+				httpBodyToUse = `{"rejectionReason": "another child is visible"}`
+			})
+
+			Context("When user is an office manager of the same daycare", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_OFFICE_MANAGER] = true
+					claims["daycareId"] = "namek"
+					claims["userId"] = "id7"
+				})
+				assertJsonResponse(`{
+					"filename": "foo/bar.jpg",
+					"childId": "childid-1",
+					"publishedBy": "id9",
+					"photoId": "photoid-1",
+					"approvedBy": "",
+					"approved": false,
+					"publicationDate": "1992-10-13 00:00:00 +0000 UTC",
+					"taggedChildIds": ["childid-1"],
+					"rejected": true,
+					"rejectedBy": "id7",
+					"rejectionReason": "another child is visible"
+				}`)
+				assertHttpCode(http.StatusOK)
+				It("should delete the rejected file", func() {
+					calls := mockStorage.CallsForMethod("Delete")
+					Expect(calls).To(HaveLen(1))
+					Expect(calls[0].Arguments.String(1)).To(Equal("foo/bar.jpg"))
+				})
+			})
+
+			Context("When the reason is missing", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_OFFICE_MANAGER] = true
+					claims["daycareId"] = "namek"
+					httpBodyToUse = `{}`
+				})
+				assertJsonResponse(`{"error":"a reason is mandatory to reject a photo"}`)
+				assertHttpCode(http.StatusBadRequest)
+			})
+		})
+
 	})
 
 })
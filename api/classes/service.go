@@ -29,6 +29,7 @@ type Service interface {
 	DeleteClass(ctx context.Context, request ClassTransport) error
 	ListClasses(ctx context.Context) ([]store.Class, error)
 	UpdateClass(ctx context.Context, request ClassTransport) (store.Class, error)
+	UpdateClassImage(ctx context.Context, request ImageUploadTransport) (store.Class, error)
 }
 
 type ClassService struct {
@@ -91,11 +92,12 @@ func (c *ClassService) AddClass(ctx context.Context, request ClassTransport) (st
 		return store.Class{}, errors.Wrap(err, "failed to add class")
 	}
 
-	uri, err := c.Storage.Get(ctx, *request.ImageUri)
+	uri, err := c.Storage.Get(ctx, *request.ImageUri, storage.USAGE_DOWNLOAD)
 	if err != nil {
 		return store.Class{}, errors.Wrap(err, "failed to generate image uri")
 	}
-	class.ImageUri = store.DbNullString(&uri)
+	class.ImageUri = store.DbNullString(&uri.Url)
+	class.ImageUriExpires = uri.Expires
 
 	return class, nil
 }
@@ -136,11 +138,12 @@ func (c *ClassService) setBucketUri(ctx context.Context, class *store.Class) {
 	if class.ImageUri.String == "" {
 		return
 	}
-	uri, err := c.Storage.Get(ctx, class.ImageUri.String)
+	uri, err := c.Storage.Get(ctx, class.ImageUri.String, storage.USAGE_DOWNLOAD)
 	if err != nil {
 		c.Logger.Warn(ctx, "failed to generate image uri", "imageUri", class.ImageUri, "err", err.Error())
 	}
-	class.ImageUri = store.DbNullString(&uri)
+	class.ImageUri = store.DbNullString(&uri.Url)
+	class.ImageUriExpires = uri.Expires
 }
 
 func (c *ClassService) ListClasses(ctx context.Context) ([]store.Class, error) {
@@ -151,11 +154,19 @@ func (c *ClassService) ListClasses(ctx context.Context) ([]store.Class, error) {
 	}
 
 	for i := 0; i < len(classes); i++ {
-		uri, err := c.Storage.Get(ctx, classes[i].ImageUri.String)
+		thumbnailUri, err := c.Storage.GetThumbnail(ctx, classes[i].ImageUri.String)
+		if err != nil {
+			return []store.Class{}, errors.Wrap(err, "failed to generate thumbnail uri")
+		}
+		classes[i].ThumbnailUri = store.DbNullString(&thumbnailUri.Url)
+		classes[i].ThumbnailUriExpires = thumbnailUri.Expires
+
+		uri, err := c.Storage.Get(ctx, classes[i].ImageUri.String, storage.USAGE_DOWNLOAD)
 		if err != nil {
 			return []store.Class{}, errors.Wrap(err, "failed to generate image uri")
 		}
-		classes[i].ImageUri = store.DbNullString(&uri)
+		classes[i].ImageUri = store.DbNullString(&uri.Url)
+		classes[i].ImageUriExpires = uri.Expires
 	}
 
 	return classes, nil
@@ -197,6 +208,37 @@ func (c *ClassService) UpdateClass(ctx context.Context, request ClassTransport)
 	return class, nil
 }
 
+// UpdateClassImage replaces the image of a class with an uploaded file
+func (c *ClassService) UpdateClassImage(ctx context.Context, request ImageUploadTransport) (store.Class, error) {
+	if IsNilOrEmpty(request.Id) {
+		return store.Class{}, ErrEmptyClass
+	}
+
+	class, err := c.Store.GetClass(nil, *request.Id, claims.GetDefaultSearchOptions(ctx))
+	if err != nil {
+		return store.Class{}, errors.Wrap(err, "failed to update class")
+	}
+
+	imageUri, err := c.Storage.StoreFile(ctx, request.Image, request.ContentType, c.storageFolder(class.DaycareId.String))
+	if err != nil {
+		return store.Class{}, errors.Wrap(err, "failed to store image")
+	}
+
+	previousImageUri := class.ImageUri.String
+	class, err = c.Store.UpdateClass(nil, store.Class{ClassId: class.ClassId, ImageUri: store.DbNullString(&imageUri)})
+	if err != nil {
+		return class, errors.Wrap(err, "failed to update class")
+	}
+	// nothing refers to the previous image anymore
+	if previousImageUri != "" && previousImageUri != imageUri {
+		if err := c.Storage.Delete(ctx, previousImageUri); err != nil {
+			c.Logger.Warn(ctx, "failed to delete previous class image", "imageUri", previousImageUri, "err", err.Error())
+		}
+	}
+	c.setBucketUri(ctx, &class)
+	return class, nil
+}
+
 func (c *ClassService) getBucketUri(ctx context.Context, imgPath string) sql.NullString {
 	if imgPath == "" || strings.Contains(imgPath, "/") {
 		return sql.NullString{
@@ -204,11 +246,11 @@ func (c *ClassService) getBucketUri(ctx context.Context, imgPath string) sql.Nul
 			Valid:  false,
 		}
 	}
-	uri, err := c.Storage.Get(ctx, imgPath)
+	uri, err := c.Storage.Get(ctx, imgPath, storage.USAGE_DOWNLOAD)
 	if err != nil {
 		c.Logger.Warn(ctx, "failed to generate image uri", "imageUri", imgPath, "err", err.Error())
 	}
-	return store.DbNullString(&uri)
+	return store.DbNullString(&uri.Url)
 }
 
 // ServiceMiddleware is a chainable behavior modifier for classService.
@@ -6,6 +6,8 @@ import (
 	"net/http"
 
 	"github.com/Vinubaba/SANTC-API/api/shared"
+	. "github.com/Vinubaba/SANTC-API/common/api"
+	"github.com/Vinubaba/SANTC-API/common/storage"
 	"github.com/Vinubaba/SANTC-API/common/store"
 
 	"github.com/Vinubaba/SANTC-API/api/ageranges"
@@ -20,12 +22,17 @@ var (
 )
 
 type ClassTransport struct {
-	Id          *string                     `json:"id"`
-	DaycareId   *string                     `json:"daycareId"`
-	Name        *string                     `json:"name"`
-	Description *string                     `json:"description"`
-	ImageUri    *string                     `json:"imageUri"`
-	AgeRange    ageranges.AgeRangeTransport `json:"ageRange"`
+	Id           *string                     `json:"id"`
+	DaycareId    *string                     `json:"daycareId"`
+	Name         *string                     `json:"name"`
+	Description  *string                     `json:"description"`
+	ImageUri     *string                     `json:"imageUri"`
+	ThumbnailUri *string                     `json:"thumbnailUri,omitempty"`
+	AgeRange     ageranges.AgeRangeTransport `json:"ageRange"`
+
+	// When the signed urls of the image and of the thumbnail expire, RFC 3339
+	ImageUriExpiresAt     *string `json:"imageUriExpiresAt,omitempty"`
+	ThumbnailUriExpiresAt *string `json:"thumbnailUriExpiresAt,omitempty"`
 }
 
 type HandlerFactory struct {
@@ -68,6 +75,15 @@ func (h *HandlerFactory) Update(opts []kithttp.ServerOption) *kithttp.Server {
 	)
 }
 
+func (h *HandlerFactory) UpdateImage(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeUpdateImageEndpoint(h.Service),
+		decodeImageUploadRequest,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
 func (h *HandlerFactory) List(opts []kithttp.ServerOption) *kithttp.Server {
 	return kithttp.NewServer(
 		makeListEndpoint(h.Service),
@@ -138,6 +154,19 @@ func makeUpdateEndpoint(svc Service) endpoint.Endpoint {
 	}
 }
 
+func makeUpdateImageEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ImageUploadTransport)
+
+		class, err := svc.UpdateClassImage(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		return storeToTransport(class), nil
+	}
+}
+
 func decodeClassTransport(_ context.Context, r *http.Request) (interface{}, error) {
 	var request ClassTransport
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -171,6 +200,20 @@ func decodeUpdateClassRequest(_ context.Context, r *http.Request) (interface{},
 	return request, nil
 }
 
+func decodeImageUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	// get id from url
+	vars := mux.Vars(r)
+	id, ok := vars["classId"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+	image, contentType, err := shared.DecodeImageUpload(r)
+	if err != nil {
+		return nil, err
+	}
+	return ImageUploadTransport{Id: &id, Image: image, ContentType: contentType}, nil
+}
+
 func ignorePayload(_ context.Context, r *http.Request) (interface{}, error) {
 	return nil, nil
 }
@@ -181,8 +224,11 @@ func EncodeError(_ context.Context, err error, w http.ResponseWriter) {
 	switch errors.Cause(err) {
 	case store.ErrClassNotFound:
 		w.WriteHeader(http.StatusNotFound)
-	case store.ErrAgeRangeNotFound, ErrEmptyAgeRange, store.ErrClassNameAlreadyExists:
+	case store.ErrAgeRangeNotFound, ErrEmptyAgeRange, store.ErrClassNameAlreadyExists,
+		storage.ErrUnsupportedFileFormat, storage.ErrMismatchingFileFormat, shared.ErrMissingImage:
 		w.WriteHeader(http.StatusBadRequest)
+	case storage.ErrFileTooLarge:
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
 	default:
 		w.WriteHeader(http.StatusInternalServerError)
 	}
@@ -212,7 +258,7 @@ func transportToStore(request ClassTransport) store.Class {
 }
 
 func storeToTransport(class store.Class) ClassTransport {
-	return ClassTransport{
+	ret := ClassTransport{
 		Id:          &class.ClassId.String,
 		DaycareId:   &class.DaycareId.String,
 		ImageUri:    &class.ImageUri.String,
@@ -227,5 +273,13 @@ func storeToTransport(class store.Class) ClassTransport {
 			Max:       &class.AgeRange.Max.Int64,
 			MaxUnit:   &class.AgeRange.MaxUnit.String,
 		},
+
+		ImageUriExpiresAt:     ExpiresAt(class.ImageUriExpires),
+		ThumbnailUriExpiresAt: ExpiresAt(class.ThumbnailUriExpires),
+	}
+	// only list responses come with a thumbnail
+	if class.ThumbnailUri.Valid {
+		ret.ThumbnailUri = &class.ThumbnailUri.String
 	}
+	return ret
 }
@@ -13,6 +13,7 @@ import (
 	. "github.com/Vinubaba/SANTC-API/api/shared/mocks"
 	"github.com/Vinubaba/SANTC-API/api/users"
 	. "github.com/Vinubaba/SANTC-API/common/firebase/mocks"
+	"github.com/Vinubaba/SANTC-API/common/storage"
 	. "github.com/Vinubaba/SANTC-API/common/storage/mocks"
 	"github.com/Vinubaba/SANTC-API/common/store"
 
@@ -44,6 +45,7 @@ var _ = Describe("Transport", func() {
 
 		claims                                            map[string]interface{}
 		reqToUse                                          *http.Request
+		headersToUse                                      http.Header
 		httpMethodToUse, httpEndpointToUse, httpBodyToUse string
 
 		mockImageUriName = "bar.jpg"
@@ -109,7 +111,8 @@ var _ = Describe("Transport", func() {
 		mockStringGenerator.On("GenerateUuid").Return("aaa").Once()
 		mockStringGenerator.On("GenerateUuid").Return("bbb").Once()
 
-		mockStorage.On("Get", mock.Anything, mock.Anything).Return("gs://foo/"+mockImageUriName, nil)
+		mockStorage.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(storage.SignedUrl{Url: "gs://foo/" + mockImageUriName}, nil)
+		mockStorage.On("GetThumbnail", mock.Anything, mock.Anything).Return(storage.SignedUrl{Url: "gs://foo/" + mockImageUriName}, nil)
 		mockStorage.On("Delete", mock.Anything, mock.Anything).Return(nil)
 
 		concreteStore = &store.Store{
@@ -121,9 +124,9 @@ var _ = Describe("Transport", func() {
 		mockFirebaseClient.On("DeleteUser", mock.Anything, mock.Anything).Return(nil)
 
 		userService := &users.UserService{
-			FirebaseClient: mockFirebaseClient,
-			Store:          concreteStore,
-			Storage:        mockStorage,
+			IdentityProvider: mockFirebaseClient,
+			Store:            concreteStore,
+			Storage:          mockStorage,
 		}
 		logger := log.NewLogger("teddycare")
 
@@ -141,6 +144,7 @@ var _ = Describe("Transport", func() {
 		httpMethodToUse = ""
 		httpEndpointToUse = ""
 		httpBodyToUse = ""
+		headersToUse = http.Header{}
 
 		router = mux.NewRouter()
 		opts := []kithttp.ServerOption{
@@ -157,6 +161,7 @@ var _ = Describe("Transport", func() {
 		router.Handle("/classes/{classId}", authenticator.Roles(handlerFactory.Get(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADULT, roles.ROLE_ADMIN, roles.ROLE_TEACHER)).Methods(http.MethodGet)
 		router.Handle("/classes/{classId}", authenticator.Roles(handlerFactory.Update(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN)).Methods(http.MethodPatch)
 		router.Handle("/classes/{classId}", authenticator.Roles(handlerFactory.Delete(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN)).Methods(http.MethodDelete)
+		router.Handle("/classes/{classId}/image", authenticator.Roles(handlerFactory.UpdateImage(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN)).Methods(http.MethodPut)
 
 		recorder = httptest.NewRecorder()
 
@@ -182,6 +187,7 @@ var _ = Describe("Transport", func() {
 	JustBeforeEach(func() {
 		reqToUse, _ = http.NewRequest(httpMethodToUse, httpEndpointToUse, strings.NewReader(httpBodyToUse))
 		reqToUse = reqToUse.WithContext(context.WithValue(context.Background(), "claims", claims))
+		reqToUse.Header = headersToUse
 		router.ServeHTTP(recorder, reqToUse)
 	})
 
@@ -198,6 +204,13 @@ var _ = Describe("Transport", func() {
 				BeforeEach(func() { claims[roles.ROLE_ADMIN] = true })
 				assertReturnedClassesWithIds("classid-1", "classid-2")
 				assertHttpCode(http.StatusOK)
+				It("should sign the thumbnails", func() {
+					signed := []string{}
+					for _, call := range mockStorage.CallsForMethod("GetThumbnail") {
+						signed = append(signed, call.Arguments.String(1))
+					}
+					Expect(signed).To(ContainElement("gs://foo/bar.jpg"))
+				})
 			})
 
 			Context("When user is an office manager from peyredragon", func() {
@@ -380,6 +393,67 @@ var _ = Describe("Transport", func() {
 
 		})
 
+		Describe("UPDATE IMAGE", func() {
+
+			BeforeEach(func() {
+				mockStorage.On("StoreFile", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(mockImageUriName, nil)
+				httpMethodToUse = http.MethodPut
+				httpEndpointToUse = "/classes/classid-1/image"
+				var contentType string
+				httpBodyToUse, contentType = shared.NewMultipartImage("image", []byte("image content"))
+				headersToUse.Set("Content-Type", contentType)
+			})
+
+			Context("When user is an admin", func() {
+				BeforeEach(func() { claims[roles.ROLE_ADMIN] = true })
+				assertHttpCode(http.StatusOK)
+				mockStorage.AssertStoredFile("daycares/namek/classes")
+				It("should respond with the new image", func() {
+					class := ClassTransport{}
+					json.Unmarshal(recorder.Body.Bytes(), &class)
+					Expect(*class.Name).To(Equal("infant class"))
+					Expect(*class.ImageUri).To(Equal("gs://foo/bar.jpg"))
+				})
+			})
+
+			Context("When user is an office manager from another daycare", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_OFFICE_MANAGER] = true
+					claims["daycareId"] = "peyredragon"
+				})
+				assertJsonResponse(`{"error": "failed to update class: class not found"}`)
+				assertHttpCode(http.StatusNotFound)
+			})
+
+			Context("When the request is not multipart", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADMIN] = true
+					httpBodyToUse = `{}`
+					headersToUse.Set("Content-Type", "application/json")
+				})
+				assertJsonResponse(`{"error": "the request must be a multipart/form-data with an 'image' file"}`)
+				assertHttpCode(http.StatusBadRequest)
+			})
+
+			Context("When the declared type of the file is not its content", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADMIN] = true
+					var contentType string
+					httpBodyToUse, contentType = shared.NewMultipartImageOfType("image", "image/png", []byte("\xff\xd8\xff\xe0 jpeg content"))
+					headersToUse.Set("Content-Type", contentType)
+					mockStorage.Reset()
+					mockStorage.On("StoreFile", mock.Anything, mock.Anything, "image/png", mock.Anything).Return("", storage.ErrMismatchingFileFormat)
+				})
+				assertJsonResponse(`{"error": "failed to store image: the declared content type does not match the content of the file"}`)
+				assertHttpCode(http.StatusBadRequest)
+				It("should hand the declared type to the storage", func() {
+					calls := mockStorage.CallsForMethod("StoreFile")
+					Expect(calls).To(HaveLen(1))
+					Expect(calls[0].Arguments.String(2)).To(Equal("image/png"))
+				})
+			})
+		})
+
 		Describe("UPDATE", func() {
 
 			var (
@@ -0,0 +1,16 @@
+package dailyreports_test
+
+import (
+	"testing"
+
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestDailyReports(t *testing.T) {
+	RegisterFailHandler(Fail)
+	shared.InitDb()
+	defer shared.DeleteDb()
+	RunSpecs(t, "DailyReports Suite")
+}
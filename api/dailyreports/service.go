@@ -0,0 +1,241 @@
+package dailyreports
+
+import (
+	"context"
+	"time"
+
+	. "github.com/Vinubaba/SANTC-API/common/api"
+	"github.com/Vinubaba/SANTC-API/common/firebase/claims"
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/store"
+
+	"github.com/araddon/dateparse"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrEmptyChild        = errors.New("childId cannot be empty")
+	ErrEmptyEntry        = errors.New("entryId cannot be empty")
+	ErrInvalidType       = errors.New("type must be one of meal, nap, diaper, mood, activity")
+	ErrInvalidAmount     = errors.New("amount must be one of none, little, half, most, all")
+	ErrInvalidDiaper     = errors.New("diaper must be one of wet, dirty, dry, toilet")
+	ErrInvalidMood       = errors.New("mood must be one of happy, calm, tired, fussy, sad, sick")
+	ErrEmptyNapPeriod    = errors.New("start and end are mandatory for a nap")
+	ErrNapEndBeforeStart = errors.New("end cannot be before start")
+	ErrEmptyDescription  = errors.New("description is mandatory for an activity")
+	ErrInvalidDate       = errors.New("invalid date")
+	ErrInvalidTimezone   = errors.New("invalid timezone")
+)
+
+var (
+	amounts = []string{"none", "little", "half", "most", "all"}
+	diapers = []string{"wet", "dirty", "dry", "toilet"}
+	moods   = []string{"happy", "calm", "tired", "fussy", "sad", "sick"}
+)
+
+type Service interface {
+	AddEntry(ctx context.Context, request DailyReportEntryTransport) (store.DailyReportEntry, error)
+	DeleteEntry(ctx context.Context, request DailyReportEntryTransport) error
+	GetDailyReport(ctx context.Context, request DailyReportSearchTransport) (time.Time, []store.DailyReportEntry, error)
+}
+
+type DailyReportService struct {
+	Store interface {
+		AddDailyReportEntry(tx *gorm.DB, entry store.DailyReportEntry) (store.DailyReportEntry, error)
+		GetDailyReportEntry(tx *gorm.DB, entryId string) (store.DailyReportEntry, error)
+		ListDailyReportEntries(tx *gorm.DB, options store.DailyReportSearchOptions) ([]store.DailyReportEntry, error)
+		DeleteDailyReportEntry(tx *gorm.DB, entryId string) error
+
+		GetChild(tx *gorm.DB, childId string, options store.SearchOptions) (store.Child, error)
+	} `inject:""`
+	Logger *log.Logger `inject:""`
+}
+
+func (c *DailyReportService) AddEntry(ctx context.Context, request DailyReportEntryTransport) (store.DailyReportEntry, error) {
+	if IsNilOrEmpty(request.ChildId) {
+		return store.DailyReportEntry{}, ErrEmptyChild
+	}
+
+	entry, err := c.validateEntry(request)
+	if err != nil {
+		return store.DailyReportEntry{}, err
+	}
+
+	if _, err := c.Store.GetChild(nil, *request.ChildId, claims.GetDefaultSearchOptions(ctx)); err != nil {
+		return store.DailyReportEntry{}, errors.Wrap(err, "failed to add daily report entry")
+	}
+
+	entry.WrittenBy = store.DbNullString(nullIfEmpty(claims.GetUserId(ctx)))
+	entry, err = c.Store.AddDailyReportEntry(nil, entry)
+	if err != nil {
+		return store.DailyReportEntry{}, errors.Wrap(err, "failed to add daily report entry")
+	}
+
+	return entry, nil
+}
+
+func (c *DailyReportService) DeleteEntry(ctx context.Context, request DailyReportEntryTransport) error {
+	if IsNilOrEmpty(request.ChildId) {
+		return ErrEmptyChild
+	}
+	if IsNilOrEmpty(request.Id) {
+		return ErrEmptyEntry
+	}
+
+	if _, err := c.Store.GetChild(nil, *request.ChildId, claims.GetDefaultSearchOptions(ctx)); err != nil {
+		return errors.Wrap(err, "failed to delete daily report entry")
+	}
+
+	entry, err := c.Store.GetDailyReportEntry(nil, *request.Id)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete daily report entry")
+	}
+	if entry.ChildId.String != *request.ChildId {
+		return errors.Wrap(store.ErrDailyReportEntryNotFound, "failed to delete daily report entry")
+	}
+
+	if err := c.Store.DeleteDailyReportEntry(nil, *request.Id); err != nil {
+		return errors.Wrap(err, "failed to delete daily report entry")
+	}
+
+	return nil
+}
+
+func (c *DailyReportService) GetDailyReport(ctx context.Context, request DailyReportSearchTransport) (time.Time, []store.DailyReportEntry, error) {
+	if IsNilOrEmpty(request.ChildId) {
+		return time.Time{}, []store.DailyReportEntry{}, ErrEmptyChild
+	}
+
+	// The day goes from midnight to midnight in the timezone of the requester, UTC when none is given
+	location := time.UTC
+	if !IsNilOrEmpty(request.Timezone) {
+		loaded, err := time.LoadLocation(*request.Timezone)
+		if err != nil {
+			return time.Time{}, []store.DailyReportEntry{}, errors.Wrap(ErrInvalidTimezone, err.Error())
+		}
+		location = loaded
+	}
+
+	// When no date is given, the report of the current day is returned
+	date := time.Now().In(location)
+	if !IsNilOrEmpty(request.Date) {
+		parsed, err := dateparse.ParseIn(*request.Date, location)
+		if err != nil {
+			return time.Time{}, []store.DailyReportEntry{}, errors.Wrap(ErrInvalidDate, err.Error())
+		}
+		date = parsed.In(location)
+	}
+	date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, location)
+
+	if _, err := c.Store.GetChild(nil, *request.ChildId, claims.GetDefaultSearchOptions(ctx)); err != nil {
+		return time.Time{}, []store.DailyReportEntry{}, errors.Wrap(err, "failed to get daily report")
+	}
+
+	entries, err := c.Store.ListDailyReportEntries(nil, store.DailyReportSearchOptions{
+		ChildId: *request.ChildId,
+		From:    date,
+		To:      date.AddDate(0, 0, 1),
+	})
+	if err != nil {
+		return time.Time{}, []store.DailyReportEntry{}, errors.Wrap(err, "failed to get daily report")
+	}
+
+	return date, entries, nil
+}
+
+// validateEntry checks that the fields required by the entry type are present and only keeps those
+func (c *DailyReportService) validateEntry(request DailyReportEntryTransport) (store.DailyReportEntry, error) {
+	entry := store.DailyReportEntry{
+		ChildId:     store.DbNullString(request.ChildId),
+		Description: store.DbNullString(request.Description),
+	}
+
+	at, err := parseDateOrNow(request.At)
+	if err != nil {
+		return store.DailyReportEntry{}, err
+	}
+	entry.At = at
+
+	if request.Type == nil {
+		return store.DailyReportEntry{}, ErrInvalidType
+	}
+	entry.Type = store.DbNullString(request.Type)
+
+	switch *request.Type {
+	case store.DAILY_REPORT_MEAL:
+		if request.Amount == nil || !contains(amounts, *request.Amount) {
+			return store.DailyReportEntry{}, ErrInvalidAmount
+		}
+		entry.Amount = store.DbNullString(request.Amount)
+	case store.DAILY_REPORT_NAP:
+		if IsNilOrEmpty(request.Start) || IsNilOrEmpty(request.End) {
+			return store.DailyReportEntry{}, ErrEmptyNapPeriod
+		}
+		start, err := dateparse.ParseIn(*request.Start, time.UTC)
+		if err != nil {
+			return store.DailyReportEntry{}, errors.Wrap(ErrInvalidDate, err.Error())
+		}
+		end, err := dateparse.ParseIn(*request.End, time.UTC)
+		if err != nil {
+			return store.DailyReportEntry{}, errors.Wrap(ErrInvalidDate, err.Error())
+		}
+		if end.Before(start) {
+			return store.DailyReportEntry{}, ErrNapEndBeforeStart
+		}
+		entry.StartTime = pq.NullTime{Time: start, Valid: true}
+		entry.EndTime = pq.NullTime{Time: end, Valid: true}
+		// a nap belongs to the day it started
+		entry.At = start
+	case store.DAILY_REPORT_DIAPER:
+		if request.Diaper == nil || !contains(diapers, *request.Diaper) {
+			return store.DailyReportEntry{}, ErrInvalidDiaper
+		}
+		entry.Diaper = store.DbNullString(request.Diaper)
+	case store.DAILY_REPORT_MOOD:
+		if request.Mood == nil || !contains(moods, *request.Mood) {
+			return store.DailyReportEntry{}, ErrInvalidMood
+		}
+		entry.Mood = store.DbNullString(request.Mood)
+	case store.DAILY_REPORT_ACTIVITY:
+		if IsNilOrEmpty(request.Description) {
+			return store.DailyReportEntry{}, ErrEmptyDescription
+		}
+	default:
+		return store.DailyReportEntry{}, ErrInvalidType
+	}
+
+	return entry, nil
+}
+
+// When no date is given, the entry is considered to happen right now
+func parseDateOrNow(date *string) (time.Time, error) {
+	if IsNilOrEmpty(date) {
+		return time.Now().UTC(), nil
+	}
+	parsed, err := dateparse.ParseIn(*date, time.UTC)
+	if err != nil {
+		return time.Time{}, errors.Wrap(ErrInvalidDate, err.Error())
+	}
+	return parsed, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func nullIfEmpty(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// ServiceMiddleware is a chainable behavior modifier for dailyReportService.
+type ServiceMiddleware func(DailyReportService) DailyReportService
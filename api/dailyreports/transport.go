@@ -0,0 +1,218 @@
+package dailyreports
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	"github.com/Vinubaba/SANTC-API/common/store"
+
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrBadRouting = errors.New("inconsistent mapping between route and handler (programmer error)")
+)
+
+type DailyReportEntryTransport struct {
+	Id          *string `json:"id"`
+	ChildId     *string `json:"childId"`
+	Type        *string `json:"type"`
+	At          *string `json:"at"`
+	Amount      *string `json:"amount,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Start       *string `json:"start,omitempty"`
+	End         *string `json:"end,omitempty"`
+	Diaper      *string `json:"diaper,omitempty"`
+	Mood        *string `json:"mood,omitempty"`
+	WrittenBy   *string `json:"writtenBy"`
+}
+
+type DailyReportTransport struct {
+	ChildId *string                     `json:"childId"`
+	Date    *string                     `json:"date"`
+	Entries []DailyReportEntryTransport `json:"entries"`
+}
+
+type DailyReportSearchTransport struct {
+	ChildId *string
+	Date    *string
+	// IANA name, e.g America/New_York
+	Timezone *string
+}
+
+type HandlerFactory struct {
+	Service Service `inject:""`
+}
+
+func (h *HandlerFactory) AddEntry(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeAddEntryEndpoint(h.Service),
+		decodeDailyReportEntryTransport,
+		shared.EncodeResponse201,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) DeleteEntry(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeDeleteEntryEndpoint(h.Service),
+		decodeDailyReportEntryIdTransport,
+		shared.EncodeResponse204,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) Get(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeGetEndpoint(h.Service),
+		decodeDailyReportSearchTransport,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
+func makeAddEntryEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(DailyReportEntryTransport)
+		entry, err := svc.AddEntry(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return storeToTransport(entry), nil
+	}
+}
+
+func makeDeleteEntryEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(DailyReportEntryTransport)
+		return nil, svc.DeleteEntry(ctx, req)
+	}
+}
+
+func makeGetEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(DailyReportSearchTransport)
+		date, entries, err := svc.GetDailyReport(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		// midnight in the timezone of the report
+		dateStr := date.String()
+		report := DailyReportTransport{
+			ChildId: req.ChildId,
+			Date:    &dateStr,
+			Entries: []DailyReportEntryTransport{},
+		}
+		for _, entry := range entries {
+			report.Entries = append(report.Entries, storeToTransport(entry))
+		}
+
+		return report, nil
+	}
+}
+
+func decodeDailyReportEntryTransport(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	childId, ok := vars["childId"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+	// get informations from payload
+	var request DailyReportEntryTransport
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	request.ChildId = &childId
+	return request, nil
+}
+
+func decodeDailyReportEntryIdTransport(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	childId, ok := vars["childId"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+	entryId, ok := vars["entryId"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+	return DailyReportEntryTransport{
+		Id:      &entryId,
+		ChildId: &childId,
+	}, nil
+}
+
+func decodeDailyReportSearchTransport(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	childId, ok := vars["childId"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+
+	date := r.URL.Query().Get("date")
+	timezone := r.URL.Query().Get("tz")
+	return DailyReportSearchTransport{
+		ChildId:  &childId,
+		Date:     &date,
+		Timezone: &timezone,
+	}, nil
+}
+
+// encode errors from business-logic
+func EncodeError(_ context.Context, err error, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	switch errors.Cause(err) {
+	case ErrEmptyChild, ErrEmptyEntry, ErrInvalidType, ErrInvalidAmount, ErrInvalidDiaper, ErrInvalidMood,
+		ErrEmptyNapPeriod, ErrNapEndBeforeStart, ErrEmptyDescription, ErrInvalidDate, ErrInvalidTimezone:
+		w.WriteHeader(http.StatusBadRequest)
+	case store.ErrChildNotFound, store.ErrDailyReportEntryNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": err.Error(),
+	})
+}
+
+func storeToTransport(entry store.DailyReportEntry) DailyReportEntryTransport {
+	at := entry.At.UTC().String()
+	ret := DailyReportEntryTransport{
+		Id:        &entry.EntryId.String,
+		ChildId:   &entry.ChildId.String,
+		Type:      &entry.Type.String,
+		At:        &at,
+		WrittenBy: &entry.WrittenBy.String,
+	}
+	if entry.Amount.Valid {
+		ret.Amount = &entry.Amount.String
+	}
+	if entry.Description.Valid {
+		ret.Description = &entry.Description.String
+	}
+	if entry.StartTime.Valid {
+		ret.Start = formatTime(entry.StartTime.Time)
+	}
+	if entry.EndTime.Valid {
+		ret.End = formatTime(entry.EndTime.Time)
+	}
+	if entry.Diaper.Valid {
+		ret.Diaper = &entry.Diaper.String
+	}
+	if entry.Mood.Valid {
+		ret.Mood = &entry.Mood.String
+	}
+	return ret
+}
+
+func formatTime(t time.Time) *string {
+	formatted := t.UTC().String()
+	return &formatted
+}
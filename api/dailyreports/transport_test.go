@@ -0,0 +1,360 @@
+package dailyreports_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/Vinubaba/SANTC-API/api/authentication"
+	. "github.com/Vinubaba/SANTC-API/api/dailyreports"
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	. "github.com/Vinubaba/SANTC-API/api/shared/mocks"
+	"github.com/Vinubaba/SANTC-API/api/users"
+	"github.com/Vinubaba/SANTC-API/common/store"
+
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/roles"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Transport", func() {
+
+	var (
+		router   *mux.Router
+		recorder *httptest.ResponseRecorder
+
+		concreteStore       *store.Store
+		concreteDb          *gorm.DB
+		mockStringGenerator *MockStringGenerator
+
+		authenticator *authentication.Authenticator
+
+		claims                                            map[string]interface{}
+		reqToUse                                          *http.Request
+		httpMethodToUse, httpEndpointToUse, httpBodyToUse string
+	)
+
+	var (
+		assertHttpCode = func(code int) {
+			It(fmt.Sprintf("should respond with status code %d", code), func() {
+				Expect(recorder.Code).To(Equal(code))
+			})
+		}
+
+		assertReturnedNoPayload = func() {
+			It("should respond with no payload", func() {
+				Expect(recorder.Body.String()).To(Equal(""))
+			})
+		}
+
+		assertJsonResponse = func(response string) {
+			It("should respond with json response", func() {
+				Expect(recorder.Header().Get("Content-Type")).To(ContainSubstring("application/json"))
+				Expect(recorder.Body.String()).To(MatchJSON(response))
+			})
+		}
+	)
+
+	BeforeEach(func() {
+		concreteDb = shared.NewDbInstance(false)
+
+		mockStringGenerator = &MockStringGenerator{}
+		mockStringGenerator.On("GenerateUuid").Return("aaa").Once()
+		mockStringGenerator.On("GenerateUuid").Return("bbb").Once()
+
+		concreteStore = &store.Store{
+			Db:              concreteDb,
+			StringGenerator: mockStringGenerator,
+		}
+
+		userService := &users.UserService{
+			Store: concreteStore,
+		}
+		logger := log.NewLogger("teddycare")
+
+		authenticator = &authentication.Authenticator{
+			UserService: userService,
+			Logger:      logger,
+		}
+
+		dailyReportService := &DailyReportService{
+			Store:  concreteStore,
+			Logger: logger,
+		}
+
+		httpMethodToUse = ""
+		httpEndpointToUse = ""
+		httpBodyToUse = ""
+
+		router = mux.NewRouter()
+		opts := []kithttp.ServerOption{
+			kithttp.ServerErrorLogger(logger),
+			kithttp.ServerErrorEncoder(EncodeError),
+		}
+
+		handlerFactory := HandlerFactory{
+			Service: dailyReportService,
+		}
+
+		router.Handle("/children/{childId}/reports", authenticator.Roles(handlerFactory.Get(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADULT, roles.ROLE_ADMIN, roles.ROLE_TEACHER)).Methods(http.MethodGet)
+		router.Handle("/children/{childId}/reports", authenticator.Roles(handlerFactory.AddEntry(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN, roles.ROLE_TEACHER)).Methods(http.MethodPost)
+		router.Handle("/children/{childId}/reports/{entryId}", authenticator.Roles(handlerFactory.DeleteEntry(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN, roles.ROLE_TEACHER)).Methods(http.MethodDelete)
+
+		recorder = httptest.NewRecorder()
+
+		shared.SetDbInitialState()
+	})
+
+	AfterEach(func() {
+		concreteDb.Close()
+	})
+
+	BeforeEach(func() {
+		claims = map[string]interface{}{
+			"userId":                  "",
+			"daycareId":               "peyredragon",
+			roles.ROLE_TEACHER:        false,
+			roles.ROLE_OFFICE_MANAGER: false,
+			roles.ROLE_ADULT:          false,
+			roles.ROLE_ADMIN:          false,
+		}
+	})
+
+	JustBeforeEach(func() {
+		reqToUse, _ = http.NewRequest(httpMethodToUse, httpEndpointToUse, strings.NewReader(httpBodyToUse))
+		reqToUse = reqToUse.WithContext(context.WithValue(context.Background(), "claims", claims))
+		router.ServeHTTP(recorder, reqToUse)
+	})
+
+	Describe("GET", func() {
+
+		var (
+			expectedJsonReport = `{
+			  "childId": "childid-3",
+			  "date": "2018-05-14 00:00:00 +0000 UTC",
+			  "entries": [
+				{
+				  "id": "entryid-1",
+				  "childId": "childid-3",
+				  "type": "meal",
+				  "at": "2018-05-14 12:00:00 +0000 UTC",
+				  "amount": "most",
+				  "description": "pasta and carrots",
+				  "writtenBy": "id4"
+				},
+				{
+				  "id": "entryid-2",
+				  "childId": "childid-3",
+				  "type": "nap",
+				  "at": "2018-05-14 13:00:00 +0000 UTC",
+				  "start": "2018-05-14 13:00:00 +0000 UTC",
+				  "end": "2018-05-14 14:30:00 +0000 UTC",
+				  "writtenBy": "id4"
+				}
+			  ]
+			}`
+		)
+
+		BeforeEach(func() {
+			httpMethodToUse = http.MethodGet
+			httpEndpointToUse = "/children/childid-3/reports?date=2018-05-14"
+		})
+
+		Context("When user is an adult responsible of the child", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_ADULT] = true
+				claims["userId"] = "id4"
+			})
+			assertJsonResponse(expectedJsonReport)
+			assertHttpCode(http.StatusOK)
+		})
+
+		Context("When user is an office manager of the same daycare", func() {
+			BeforeEach(func() { claims[roles.ROLE_OFFICE_MANAGER] = true })
+			assertJsonResponse(expectedJsonReport)
+			assertHttpCode(http.StatusOK)
+		})
+
+		Context("When user is a random adult", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_ADULT] = true
+				claims["userId"] = "id5"
+			})
+			assertJsonResponse(`{"error":"failed to get daily report: child not found"}`)
+			assertHttpCode(http.StatusNotFound)
+		})
+
+		Context("When there is no entry that day", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpEndpointToUse = "/children/childid-3/reports?date=2018-05-16"
+			})
+			assertJsonResponse(`{"childId": "childid-3", "date": "2018-05-16 00:00:00 +0000 UTC", "entries": []}`)
+			assertHttpCode(http.StatusOK)
+		})
+
+		Context("When the day is asked in the timezone of the requester", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpEndpointToUse = "/children/childid-3/reports?date=2018-05-14&tz=America/New_York"
+			})
+			It("should include the entries until midnight in this timezone", func() {
+				report := DailyReportTransport{}
+				Expect(json.Unmarshal(recorder.Body.Bytes(), &report)).To(BeNil())
+				Expect(*report.Date).To(Equal("2018-05-14 00:00:00 -0400 EDT"))
+				ids := []string{}
+				for _, entry := range report.Entries {
+					ids = append(ids, *entry.Id)
+				}
+				Expect(ids).To(Equal([]string{"entryid-1", "entryid-2", "entryid-4"}))
+			})
+			assertHttpCode(http.StatusOK)
+		})
+
+		Context("When the timezone is invalid", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpEndpointToUse = "/children/childid-3/reports?date=2018-05-14&tz=Mars/Olympus"
+			})
+			assertHttpCode(http.StatusBadRequest)
+		})
+
+		Context("When the date is invalid", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpEndpointToUse = "/children/childid-3/reports?date=foo"
+			})
+			assertHttpCode(http.StatusBadRequest)
+		})
+
+		Context("When database is closed", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_ADMIN] = true
+				concreteDb.Close()
+			})
+			assertJsonResponse(`{"error":"failed to get daily report: sql: database is closed"}`)
+			assertHttpCode(http.StatusInternalServerError)
+		})
+	})
+
+	Describe("ADD ENTRY", func() {
+
+		BeforeEach(func() {
+			httpMethodToUse = http.MethodPost
+			httpEndpointToUse = "/children/childid-3/reports"
+			httpBodyToUse = `{"type": "meal", "at": "2018-05-16T12:00:00Z", "amount": "half", "description": "soup"}`
+		})
+
+		Context("When user is a teacher of the child", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_TEACHER] = true
+				claims["userId"] = "id4"
+			})
+			assertJsonResponse(`{
+				"id": "aaa",
+				"childId": "childid-3",
+				"type": "meal",
+				"at": "2018-05-16 12:00:00 +0000 UTC",
+				"amount": "half",
+				"description": "soup",
+				"writtenBy": "id4"
+			}`)
+			assertHttpCode(http.StatusCreated)
+		})
+
+		Context("When adding a nap", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_TEACHER] = true
+				claims["userId"] = "id4"
+				httpBodyToUse = `{"type": "nap", "start": "2018-05-16T13:00:00Z", "end": "2018-05-16T14:00:00Z"}`
+			})
+			assertJsonResponse(`{
+				"id": "aaa",
+				"childId": "childid-3",
+				"type": "nap",
+				"at": "2018-05-16 13:00:00 +0000 UTC",
+				"start": "2018-05-16 13:00:00 +0000 UTC",
+				"end": "2018-05-16 14:00:00 +0000 UTC",
+				"writtenBy": "id4"
+			}`)
+			assertHttpCode(http.StatusCreated)
+		})
+
+		Context("When the nap ends before it starts", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_TEACHER] = true
+				httpBodyToUse = `{"type": "nap", "start": "2018-05-16T13:00:00Z", "end": "2018-05-16T12:00:00Z"}`
+			})
+			assertJsonResponse(`{"error":"end cannot be before start"}`)
+			assertHttpCode(http.StatusBadRequest)
+		})
+
+		Context("When the type is unknown", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_TEACHER] = true
+				httpBodyToUse = `{"type": "bath"}`
+			})
+			assertJsonResponse(`{"error":"type must be one of meal, nap, diaper, mood, activity"}`)
+			assertHttpCode(http.StatusBadRequest)
+		})
+
+		Context("When the mood is unknown", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_TEACHER] = true
+				httpBodyToUse = `{"type": "mood", "mood": "angry"}`
+			})
+			assertJsonResponse(`{"error":"mood must be one of happy, calm, tired, fussy, sad, sick"}`)
+			assertHttpCode(http.StatusBadRequest)
+		})
+
+		Context("When user is a teacher of another daycare", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_TEACHER] = true
+				claims["userId"] = "id9"
+				claims["daycareId"] = "namek"
+			})
+			assertJsonResponse(`{"error":"failed to add daily report entry: child not found"}`)
+			assertHttpCode(http.StatusNotFound)
+		})
+
+		Context("When user is an adult", func() {
+			BeforeEach(func() { claims[roles.ROLE_ADULT] = true })
+			assertReturnedNoPayload()
+			assertHttpCode(http.StatusUnauthorized)
+		})
+	})
+
+	Describe("DELETE ENTRY", func() {
+
+		BeforeEach(func() {
+			httpMethodToUse = http.MethodDelete
+			httpEndpointToUse = "/children/childid-3/reports/entryid-1"
+		})
+
+		Context("When user is a teacher of the child", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_TEACHER] = true
+				claims["userId"] = "id4"
+			})
+			assertReturnedNoPayload()
+			assertHttpCode(http.StatusNoContent)
+		})
+
+		Context("When the entry belongs to another child", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpEndpointToUse = "/children/childid-4/reports/entryid-1"
+			})
+			assertJsonResponse(`{"error":"failed to delete daily report entry: daily report entry not found"}`)
+			assertHttpCode(http.StatusNotFound)
+		})
+	})
+})
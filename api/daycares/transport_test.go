@@ -114,8 +114,8 @@ var _ = Describe("Transport", func() {
 		mockFirebaseClient.On("DeleteUser", mock.Anything, mock.Anything).Return(nil)
 
 		userService := &users.UserService{
-			FirebaseClient: mockFirebaseClient,
-			Store:          concreteStore,
+			IdentityProvider: mockFirebaseClient,
+			Store:            concreteStore,
 		}
 		logger := log.NewLogger("teddycare")
 
@@ -0,0 +1,391 @@
+package invitations
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/api/authentication"
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	. "github.com/Vinubaba/SANTC-API/common/api"
+	"github.com/Vinubaba/SANTC-API/common/events"
+	"github.com/Vinubaba/SANTC-API/common/firebase/claims"
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/roles"
+	"github.com/Vinubaba/SANTC-API/common/store"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrEmptyInvitation        = errors.New("invitationId cannot be empty")
+	ErrEmptyToken             = errors.New("token cannot be empty")
+	ErrInvalidEmail           = errors.New("invalid email")
+	ErrEmptyRoles             = errors.New("at least one role is mandatory")
+	ErrForbiddenRole          = errors.New("cannot invite a user with this role")
+	ErrInviteDifferentDaycare = errors.New("cannot invite a user to another daycare")
+	ErrAlreadyInvited         = errors.New("a pending invitation already exists for this email")
+	ErrUserInAnotherDaycare   = errors.New("a user of another daycare already has this email")
+	ErrInvitationExpired      = errors.New("invitation expired or already accepted")
+	ErrInvitationDeleted      = errors.New("invitation deleted")
+	ErrEmailMismatch          = errors.New("the invitation was sent to another email")
+	ErrMissingIdentity        = errors.New("an authorization token or a password is mandatory")
+	ErrInvalidToken           = errors.New("invalid authorization token")
+)
+
+type Service interface {
+	CreateInvitation(ctx context.Context, request InvitationTransport) (store.Invitation, error)
+	ListInvitations(ctx context.Context) ([]store.Invitation, error)
+	DeleteInvitation(ctx context.Context, request InvitationTransport) error
+	AcceptInvitation(ctx context.Context, request AcceptTransport) (store.User, error)
+	IssueToken(ctx context.Context, request InvitationTransport) (string, error)
+}
+
+type InvitationService struct {
+	Store interface {
+		AddInvitation(tx *gorm.DB, invitation store.Invitation) (store.Invitation, error)
+		GetInvitation(tx *gorm.DB, invitationId string) (store.Invitation, error)
+		GetInvitationByTokenHash(tx *gorm.DB, tokenHash string) (store.Invitation, error)
+		ListInvitations(tx *gorm.DB, options store.InvitationSearchOptions) ([]store.Invitation, error)
+		AcceptInvitation(tx *gorm.DB, invitationId string) (bool, error)
+		RenewInvitationToken(tx *gorm.DB, invitationId, tokenHash string) (bool, error)
+		DeleteInvitation(tx *gorm.DB, invitationId string) error
+		DeletePendingConnexionRole(tx *gorm.DB, role store.PendingConnexionRole) error
+
+		GetDaycare(tx *gorm.DB, daycareId string, options store.SearchOptions) (store.Daycare, error)
+		GetUserByEmail(tx *gorm.DB, email string) (store.User, error)
+		AddUser(tx *gorm.DB, user store.User) (store.User, error)
+		AddRole(tx *gorm.DB, role store.Role) (store.Role, error)
+		GetClaimsVersion(tx *gorm.DB, userId string) (int, error)
+
+		AddOutboxEvent(tx *gorm.DB, daycareId string, payload events.Payload) error
+		Tx() *gorm.DB
+	} `inject:""`
+	IdentityProvider authentication.IdentityProvider `inject:"identityProvider"`
+	Config           *shared.AppConfig               `inject:""`
+	Logger           *log.Logger                     `inject:""`
+}
+
+// CreateInvitation records the invitation and the event asking for its email in the same transaction. Office
+// managers invite teachers and adults to their daycare, admins can also invite office managers. Its first token is
+// never given, the link of the email is issued by IssueToken
+func (c *InvitationService) CreateInvitation(ctx context.Context, request InvitationTransport) (store.Invitation, error) {
+	if IsNilOrEmpty(request.Email) || !strings.Contains(*request.Email, "@") {
+		return store.Invitation{}, ErrInvalidEmail
+	}
+	if len(request.Roles) == 0 {
+		return store.Invitation{}, ErrEmptyRoles
+	}
+	for _, role := range request.Roles {
+		if !c.canInvite(ctx, role) {
+			return store.Invitation{}, ErrForbiddenRole
+		}
+	}
+
+	daycareId := claims.GetDaycareId(ctx)
+	if !IsNilOrEmpty(request.DaycareId) {
+		if !claims.IsAdmin(ctx) && *request.DaycareId != daycareId {
+			return store.Invitation{}, ErrInviteDifferentDaycare
+		}
+		daycareId = *request.DaycareId
+	}
+	daycare, err := c.Store.GetDaycare(nil, daycareId, claims.GetDefaultSearchOptions(ctx))
+	if err != nil {
+		return store.Invitation{}, errors.Wrap(err, "failed to create invitation")
+	}
+
+	email := strings.ToLower(*request.Email)
+	user, err := c.Store.GetUserByEmail(nil, email)
+	if err == nil && user.DaycareId.String != daycareId {
+		return store.Invitation{}, ErrUserInAnotherDaycare
+	}
+	if err != nil && err != store.ErrUserNotFound {
+		return store.Invitation{}, errors.Wrap(err, "failed to create invitation")
+	}
+	existing, err := c.Store.ListInvitations(nil, store.InvitationSearchOptions{DaycareId: daycareId, Email: email, NotAccepted: true})
+	if err != nil {
+		return store.Invitation{}, errors.Wrap(err, "failed to create invitation")
+	}
+	for _, invitation := range existing {
+		if invitation.IsPending(time.Now()) {
+			return store.Invitation{}, ErrAlreadyInvited
+		}
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return store.Invitation{}, errors.Wrap(err, "failed to generate token")
+	}
+
+	tx := c.Store.Tx()
+	if tx.Error != nil {
+		return store.Invitation{}, errors.Wrap(tx.Error, "failed to create invitation")
+	}
+	defer tx.Rollback()
+
+	invitation, err := c.Store.AddInvitation(tx, store.Invitation{
+		TokenHash: hashToken(token),
+		Email:     email,
+		FirstName: stringOrEmpty(request.FirstName),
+		LastName:  stringOrEmpty(request.LastName),
+		DaycareId: daycareId,
+		InvitedBy: store.DbNullString(nullIfEmpty(claims.GetUserId(ctx))),
+		ExpiresAt: time.Now().Add(c.Config.InvitationLifetime),
+		Roles:     request.Roles,
+	})
+	if err != nil {
+		return store.Invitation{}, errors.Wrap(err, "failed to create invitation")
+	}
+
+	if err := c.Store.AddOutboxEvent(tx, daycareId, events.InvitationCreatedV1{
+		InvitationId: invitation.InvitationId,
+		Email:        invitation.Email,
+		FirstName:    invitation.FirstName,
+		LastName:     invitation.LastName,
+		DaycareName:  daycare.Name.String,
+		Roles:        invitation.Roles,
+		ExpiresAt:    invitation.ExpiresAt,
+	}); err != nil {
+		return store.Invitation{}, errors.Wrap(err, "failed to create invitation")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return store.Invitation{}, errors.Wrap(err, "failed to create invitation")
+	}
+	return invitation, nil
+}
+
+// ListInvitations returns the invitations of the daycare of the requester not accepted yet
+func (c *InvitationService) ListInvitations(ctx context.Context) ([]store.Invitation, error) {
+	invitations, err := c.Store.ListInvitations(nil, store.InvitationSearchOptions{
+		DaycareId:   claims.GetDaycareId(ctx),
+		NotAccepted: true,
+	})
+	if err != nil {
+		return []store.Invitation{}, errors.Wrap(err, "failed to list invitations")
+	}
+	return invitations, nil
+}
+
+// DeleteInvitation revokes the invitation, its token cannot be used anymore
+func (c *InvitationService) DeleteInvitation(ctx context.Context, request InvitationTransport) error {
+	if IsNilOrEmpty(request.Id) {
+		return ErrEmptyInvitation
+	}
+	invitation, err := c.Store.GetInvitation(nil, *request.Id)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete invitation")
+	}
+	if !claims.IsAdmin(ctx) && invitation.DaycareId != claims.GetDaycareId(ctx) {
+		return errors.Wrap(store.ErrInvitationNotFound, "failed to delete invitation")
+	}
+	if err := c.Store.DeleteInvitation(nil, invitation.InvitationId); err != nil {
+		return errors.Wrap(err, "failed to delete invitation")
+	}
+	return nil
+}
+
+// IssueToken replaces the token of a pending invitation and returns the link of its email. Only the service sending
+// the emails calls it, the token is given to nobody else
+func (c *InvitationService) IssueToken(ctx context.Context, request InvitationTransport) (string, error) {
+	if IsNilOrEmpty(request.Id) {
+		return "", ErrEmptyInvitation
+	}
+	_, err := c.Store.GetInvitation(nil, *request.Id)
+	if err == store.ErrInvitationNotFound {
+		return "", ErrInvitationDeleted
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "failed to issue invitation token")
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate token")
+	}
+	renewed, err := c.Store.RenewInvitationToken(nil, *request.Id, hashToken(token))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to issue invitation token")
+	}
+	if !renewed {
+		return "", ErrInvitationExpired
+	}
+	return c.acceptUrl(token), nil
+}
+
+// AcceptInvitation links the identity of the invited person to its user, created in the daycare of the invitation
+// when none has its email, and gives it the pending roles. The identity is the one of the authorization token; with
+// the local provider, a password creates it instead, in the transaction accepting the invitation
+func (c *InvitationService) AcceptInvitation(ctx context.Context, request AcceptTransport) (store.User, error) {
+	if IsNilOrEmpty(request.Token) {
+		return store.User{}, ErrEmptyToken
+	}
+	invitation, err := c.Store.GetInvitationByTokenHash(nil, hashToken(*request.Token))
+	if err != nil {
+		return store.User{}, errors.Wrap(err, "failed to accept invitation")
+	}
+	if !invitation.IsPending(time.Now()) {
+		return store.User{}, ErrInvitationExpired
+	}
+	// checked before creating an identity which could not be used
+	if user, err := c.Store.GetUserByEmail(nil, invitation.Email); err == nil && user.DaycareId.String != invitation.DaycareId {
+		return store.User{}, ErrUserInAnotherDaycare
+	}
+
+	identity, err := c.verifyIdentity(ctx, request, invitation.Email)
+	if err != nil {
+		return store.User{}, err
+	}
+
+	tx := c.Store.Tx()
+	if tx.Error != nil {
+		return store.User{}, errors.Wrap(tx.Error, "failed to accept invitation")
+	}
+	defer tx.Rollback()
+
+	accepted, err := c.Store.AcceptInvitation(tx, invitation.InvitationId)
+	if err != nil {
+		return store.User{}, errors.Wrap(err, "failed to accept invitation")
+	}
+	if !accepted {
+		return store.User{}, ErrInvitationExpired
+	}
+	// created once the invitation is accepted, a concurrent acceptance or a failure leaves no identity behind
+	if identity.Uid == "" {
+		if identity, err = c.createIdentity(ctx, tx, request, invitation.Email); err != nil {
+			return store.User{}, err
+		}
+	}
+
+	user, err := c.Store.GetUserByEmail(tx, invitation.Email)
+	switch {
+	case err == store.ErrUserNotFound:
+		user, err = c.Store.AddUser(tx, store.User{
+			Email:     store.DbNullString(&invitation.Email),
+			FirstName: store.DbNullString(&invitation.FirstName),
+			LastName:  store.DbNullString(&invitation.LastName),
+			DaycareId: store.DbNullString(&invitation.DaycareId),
+		})
+		if err != nil {
+			return store.User{}, errors.Wrap(err, "failed to create user")
+		}
+	case err != nil:
+		return store.User{}, errors.Wrap(err, "failed to accept invitation")
+	case user.DaycareId.String != invitation.DaycareId:
+		return store.User{}, ErrUserInAnotherDaycare
+	}
+
+	for _, role := range invitation.Roles {
+		if user.Is(role) {
+			continue
+		}
+		if _, err := c.Store.AddRole(tx, store.Role{UserId: user.UserId.String, Role: role}); err != nil {
+			return store.User{}, errors.Wrap(err, "failed to set user role")
+		}
+		user.Roles = append(user.Roles, store.Role{UserId: user.UserId.String, Role: role})
+	}
+	if err := c.Store.DeletePendingConnexionRole(tx, store.PendingConnexionRole{InvitationId: invitation.InvitationId}); err != nil {
+		return store.User{}, errors.Wrap(err, "failed to accept invitation")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return store.User{}, errors.Wrap(err, "failed to accept invitation")
+	}
+
+	// the roles are in the next tokens of the identity, the user is also looked up by email when they are missing
+	version, err := c.Store.GetClaimsVersion(nil, user.UserId.String)
+	if err != nil {
+		c.Logger.Warn(ctx, "failed to get claims version of accepted invitation", "invitationId", invitation.InvitationId, "err", err.Error())
+		return user, nil
+	}
+	if err := c.IdentityProvider.SetClaims(ctx, identity.Uid, authentication.UserClaims(user, version)); err != nil {
+		c.Logger.Warn(ctx, "failed to set claims of accepted invitation", "invitationId", invitation.InvitationId, "err", err.Error())
+	}
+	return user, nil
+}
+
+// identityCreator is the local provider, creating the identities of the invited persons from their password
+type identityCreator interface {
+	CreateIdentity(ctx context.Context, tx *gorm.DB, email, password string) (authentication.Identity, error)
+}
+
+// verifyIdentity returns the identity of the authorization token. Without token, it only checks that a local
+// identity can be created, the identity returned is empty
+func (c *InvitationService) verifyIdentity(ctx context.Context, request AcceptTransport, email string) (authentication.Identity, error) {
+	if request.IdToken != "" {
+		identity, err := c.IdentityProvider.VerifyToken(ctx, request.IdToken)
+		if err != nil {
+			return authentication.Identity{}, errors.Wrap(ErrInvalidToken, err.Error())
+		}
+		if !strings.EqualFold(identity.Email, email) {
+			return authentication.Identity{}, ErrEmailMismatch
+		}
+		return identity, nil
+	}
+
+	if _, ok := c.IdentityProvider.(identityCreator); !ok || IsNilOrEmpty(request.Password) {
+		return authentication.Identity{}, ErrMissingIdentity
+	}
+	return authentication.Identity{}, nil
+}
+
+// createIdentity creates the local identity for the email of the invitation in the transaction
+func (c *InvitationService) createIdentity(ctx context.Context, tx *gorm.DB, request AcceptTransport, email string) (authentication.Identity, error) {
+	creator, ok := c.IdentityProvider.(identityCreator)
+	if !ok || IsNilOrEmpty(request.Password) {
+		return authentication.Identity{}, ErrMissingIdentity
+	}
+	return creator.CreateIdentity(ctx, tx, email, *request.Password)
+}
+
+func (c *InvitationService) canInvite(ctx context.Context, role string) bool {
+	switch role {
+	case roles.ROLE_TEACHER, roles.ROLE_ADULT:
+		return claims.IsOfficeManager(ctx) || claims.IsAdmin(ctx)
+	case roles.ROLE_OFFICE_MANAGER:
+		return claims.IsAdmin(ctx)
+	default:
+		return false
+	}
+}
+
+func (c *InvitationService) acceptUrl(token string) string {
+	return c.Config.InvitationAcceptUrl + "?token=" + url.QueryEscape(token)
+}
+
+// newToken returns 256 random bits, url safe
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func stringOrEmpty(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func nullIfEmpty(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// ServiceMiddleware is a chainable behavior modifier for invitationService.
+type ServiceMiddleware func(InvitationService) InvitationService
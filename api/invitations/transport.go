@@ -0,0 +1,220 @@
+package invitations
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/api/authentication"
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	. "github.com/Vinubaba/SANTC-API/common/api"
+	"github.com/Vinubaba/SANTC-API/common/store"
+
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+type InvitationTransport struct {
+	Id        *string  `json:"id"`
+	Email     *string  `json:"email"`
+	FirstName *string  `json:"firstName"`
+	LastName  *string  `json:"lastName"`
+	DaycareId *string  `json:"daycareId"`
+	Roles     []string `json:"roles"`
+	InvitedBy *string  `json:"invitedBy"`
+	// RFC 3339
+	ExpiresAt *string `json:"expiresAt"`
+	CreatedAt *string `json:"createdAt"`
+}
+
+// AcceptTransport holds the token of the email. The identity accepting the invitation is the one of the
+// authorization header, or a new local identity with the password
+type AcceptTransport struct {
+	Token    *string `json:"token"`
+	Password *string `json:"password"`
+
+	IdToken string `json:"-"`
+}
+
+type HandlerFactory struct {
+	Service Service `inject:""`
+}
+
+func (h *HandlerFactory) Create(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeCreateEndpoint(h.Service),
+		decodeInvitationTransport,
+		shared.EncodeResponse201,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) List(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeListEndpoint(h.Service),
+		decodeInvitationIdTransport,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) Delete(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeDeleteEndpoint(h.Service),
+		decodeInvitationIdTransport,
+		shared.EncodeResponse204,
+		opts...,
+	)
+}
+
+// Accept is public, the invited person may have no user yet
+func (h *HandlerFactory) Accept(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeAcceptEndpoint(h.Service),
+		decodeAcceptTransport,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
+// IssueToken is called by the service sending the email of the invitation
+func (h *HandlerFactory) IssueToken(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeIssueTokenEndpoint(h.Service),
+		decodeInvitationIdTransport,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
+func makeCreateEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(InvitationTransport)
+		invitation, err := svc.CreateInvitation(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return storeToTransport(invitation), nil
+	}
+}
+
+func makeListEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		invitations, err := svc.ListInvitations(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		invitationsRet := []InvitationTransport{}
+		for _, invitation := range invitations {
+			invitationsRet = append(invitationsRet, storeToTransport(invitation))
+		}
+		return invitationsRet, nil
+	}
+}
+
+func makeDeleteEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(InvitationTransport)
+		return nil, svc.DeleteInvitation(ctx, req)
+	}
+}
+
+func makeAcceptEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(AcceptTransport)
+		user, err := svc.AcceptInvitation(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"userId":    user.UserId.String,
+			"email":     user.Email.String,
+			"daycareId": user.DaycareId.String,
+			"roles":     user.Roles.ToList(),
+		}, nil
+	}
+}
+
+func makeIssueTokenEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(InvitationTransport)
+		acceptUrl, err := svc.IssueToken(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return InvitationTokenTransport{AcceptUrl: &acceptUrl}, nil
+	}
+}
+
+func decodeInvitationTransport(_ context.Context, r *http.Request) (interface{}, error) {
+	var request InvitationTransport
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeInvitationIdTransport(_ context.Context, r *http.Request) (interface{}, error) {
+	request := InvitationTransport{}
+	if invitationId, ok := mux.Vars(r)["invitationId"]; ok {
+		request.Id = &invitationId
+	}
+	return request, nil
+}
+
+func decodeAcceptTransport(_ context.Context, r *http.Request) (interface{}, error) {
+	var request AcceptTransport
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	bearerToken := strings.Split(r.Header.Get("authorization"), " ")
+	if len(bearerToken) == 2 {
+		request.IdToken = bearerToken[1]
+	}
+	return request, nil
+}
+
+// encode errors from business-logic
+func EncodeError(_ context.Context, err error, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	switch errors.Cause(err) {
+	case ErrEmptyInvitation, ErrEmptyToken, ErrInvalidEmail, ErrEmptyRoles, ErrMissingIdentity, authentication.ErrPasswordTooShort:
+		w.WriteHeader(http.StatusBadRequest)
+	case ErrInvalidToken:
+		w.WriteHeader(http.StatusUnauthorized)
+	case ErrForbiddenRole, ErrInviteDifferentDaycare, ErrEmailMismatch:
+		w.WriteHeader(http.StatusForbidden)
+	case store.ErrInvitationNotFound, store.ErrDaycareNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case ErrAlreadyInvited, ErrUserInAnotherDaycare, store.ErrLocalIdentityAlreadyExists:
+		w.WriteHeader(http.StatusConflict)
+	case ErrInvitationExpired, ErrInvitationDeleted:
+		w.WriteHeader(http.StatusGone)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": err.Error(),
+	})
+}
+
+func storeToTransport(invitation store.Invitation) InvitationTransport {
+	expiresAt := invitation.ExpiresAt.UTC().Format(time.RFC3339)
+	createdAt := invitation.CreatedAt.UTC().Format(time.RFC3339)
+	return InvitationTransport{
+		Id:        &invitation.InvitationId,
+		Email:     &invitation.Email,
+		FirstName: &invitation.FirstName,
+		LastName:  &invitation.LastName,
+		DaycareId: &invitation.DaycareId,
+		Roles:     invitation.Roles,
+		InvitedBy: &invitation.InvitedBy.String,
+		ExpiresAt: &expiresAt,
+		CreatedAt: &createdAt,
+	}
+}
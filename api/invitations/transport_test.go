@@ -0,0 +1,502 @@
+package invitations_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/api/authentication"
+	. "github.com/Vinubaba/SANTC-API/api/invitations"
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	. "github.com/Vinubaba/SANTC-API/api/shared/mocks"
+	"github.com/Vinubaba/SANTC-API/api/users"
+	"github.com/Vinubaba/SANTC-API/common/api"
+	"github.com/Vinubaba/SANTC-API/common/events"
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/roles"
+	"github.com/Vinubaba/SANTC-API/common/store"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockIdentityProvider struct {
+	mock.Mock
+}
+
+func (m *MockIdentityProvider) VerifyToken(ctx context.Context, token string) (authentication.Identity, error) {
+	args := m.Called(token)
+	return args.Get(0).(authentication.Identity), args.Error(1)
+}
+
+func (m *MockIdentityProvider) SetClaims(ctx context.Context, uid string, claims map[string]interface{}) error {
+	args := m.Called(uid, claims)
+	return args.Error(0)
+}
+
+func (m *MockIdentityProvider) DeleteUserByEmail(ctx context.Context, email string) error {
+	args := m.Called(email)
+	return args.Error(0)
+}
+
+var _ = Describe("Transport", func() {
+
+	var (
+		router   *mux.Router
+		recorder *httptest.ResponseRecorder
+
+		concreteStore        *store.Store
+		concreteDb           *gorm.DB
+		mockStringGenerator  *MockStringGenerator
+		mockIdentityProvider *MockIdentityProvider
+
+		authenticator *authentication.Authenticator
+
+		claims                                            map[string]interface{}
+		reqToUse                                          *http.Request
+		authorizationToUse                                string
+		httpMethodToUse, httpEndpointToUse, httpBodyToUse string
+	)
+
+	var (
+		assertHttpCode = func(code int) {
+			It(fmt.Sprintf("should respond with status code %d", code), func() {
+				Expect(recorder.Code).To(Equal(code))
+			})
+		}
+
+		assertReturnedNoPayload = func() {
+			It("should respond with no payload", func() {
+				Expect(recorder.Body.String()).To(Equal(""))
+			})
+		}
+
+		assertJsonResponse = func(response string) {
+			It("should respond with json response", func() {
+				Expect(recorder.Header().Get("Content-Type")).To(ContainSubstring("application/json"))
+				Expect(recorder.Body.String()).To(MatchJSON(response))
+			})
+		}
+
+		countInvitationEvents = func(invitationId string) int {
+			var count int
+			Expect(concreteDb.Raw("SELECT count(*) FROM outbox WHERE type = 'invitationCreated' AND payload->>'invitationId' = ?", invitationId).Row().Scan(&count)).To(BeNil())
+			return count
+		}
+	)
+
+	BeforeEach(func() {
+		concreteDb = shared.NewDbInstance(false)
+
+		mockStringGenerator = &MockStringGenerator{}
+		mockStringGenerator.On("GenerateUuid").Return("aaa").Once()
+		mockStringGenerator.On("GenerateUuid").Return("bbb").Once()
+
+		mockIdentityProvider = &MockIdentityProvider{}
+		mockIdentityProvider.On("VerifyToken", "arya-id-token").Return(authentication.Identity{Uid: "arya-uid", Email: "Arya.Stark@got.com"}, nil)
+		mockIdentityProvider.On("VerifyToken", "sansa-id-token").Return(authentication.Identity{Uid: "sansa-uid", Email: "sansa.stark@got.com"}, nil)
+		mockIdentityProvider.On("SetClaims", mock.Anything, mock.Anything).Return(nil)
+
+		concreteStore = &store.Store{
+			Db:              concreteDb,
+			StringGenerator: mockStringGenerator,
+		}
+
+		userService := &users.UserService{
+			Store: concreteStore,
+		}
+		logger := log.NewLogger("teddycare")
+
+		authenticator = &authentication.Authenticator{
+			UserService: userService,
+			Logger:      logger,
+		}
+
+		invitationService := &InvitationService{
+			Store:            concreteStore,
+			IdentityProvider: mockIdentityProvider,
+			Config: &shared.AppConfig{
+				InvitationLifetime:  168 * time.Hour,
+				InvitationAcceptUrl: "https://teddycare.net/invitations/accept",
+			},
+			Logger: logger,
+		}
+
+		httpMethodToUse = ""
+		httpEndpointToUse = ""
+		httpBodyToUse = ""
+		authorizationToUse = ""
+
+		router = mux.NewRouter()
+		opts := []kithttp.ServerOption{
+			kithttp.ServerErrorLogger(logger),
+			kithttp.ServerErrorEncoder(EncodeError),
+		}
+
+		handlerFactory := HandlerFactory{
+			Service: invitationService,
+		}
+
+		router.Handle("/invitations", authenticator.Roles(handlerFactory.Create(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN)).Methods(http.MethodPost)
+		router.Handle("/invitations", authenticator.Roles(handlerFactory.List(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN)).Methods(http.MethodGet)
+		router.Handle("/invitations/accept", handlerFactory.Accept(opts)).Methods(http.MethodPost)
+		router.Handle("/invitations/{invitationId}", authenticator.Roles(handlerFactory.Delete(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN)).Methods(http.MethodDelete)
+		router.Handle("/invitations/{invitationId}/token", authenticator.Scope(handlerFactory.IssueToken(opts), roles.SCOPE_INVITATIONS_SEND)).Methods(http.MethodPost)
+
+		recorder = httptest.NewRecorder()
+
+		shared.SetDbInitialState()
+	})
+
+	AfterEach(func() {
+		concreteDb.Close()
+	})
+
+	BeforeEach(func() {
+		claims = map[string]interface{}{
+			"userId":                  "",
+			"daycareId":               "peyredragon",
+			roles.ROLE_TEACHER:        false,
+			roles.ROLE_OFFICE_MANAGER: false,
+			roles.ROLE_ADULT:          false,
+			roles.ROLE_ADMIN:          false,
+		}
+	})
+
+	JustBeforeEach(func() {
+		reqToUse, _ = http.NewRequest(httpMethodToUse, httpEndpointToUse, strings.NewReader(httpBodyToUse))
+		if authorizationToUse != "" {
+			reqToUse.Header.Set("Authorization", "Bearer "+authorizationToUse)
+		}
+		reqToUse = reqToUse.WithContext(context.WithValue(context.Background(), "claims", claims))
+		router.ServeHTTP(recorder, reqToUse)
+	})
+
+	Describe("CREATE", func() {
+
+		BeforeEach(func() {
+			httpMethodToUse = http.MethodPost
+			httpEndpointToUse = "/invitations"
+			httpBodyToUse = `{
+				"email": "Rickon.Stark@got.com",
+				"firstName": "Rickon",
+				"lastName": "Stark",
+				"roles": ["adult"]
+			}`
+		})
+
+		Context("When user is an office manager", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				claims["userId"] = "id2"
+			})
+			assertHttpCode(http.StatusCreated)
+			It("should return the invitation in the daycare of the requester", func() {
+				invitation := InvitationTransport{}
+				Expect(json.Unmarshal(recorder.Body.Bytes(), &invitation)).To(BeNil())
+				Expect(*invitation.Id).To(Equal("aaa"))
+				Expect(*invitation.Email).To(Equal("rickon.stark@got.com"))
+				Expect(*invitation.DaycareId).To(Equal("peyredragon"))
+				Expect(*invitation.InvitedBy).To(Equal("id2"))
+				Expect(invitation.Roles).To(Equal([]string{roles.ROLE_ADULT}))
+			})
+			It("should only keep the hash of the token", func() {
+				var tokenHash string
+				Expect(concreteDb.Raw("SELECT token_hash FROM invitations WHERE invitation_id = 'aaa'").Row().Scan(&tokenHash)).To(BeNil())
+				Expect(tokenHash).To(HaveLen(64))
+			})
+			It("should ask for the email to be sent", func() {
+				Expect(countInvitationEvents("aaa")).To(Equal(1))
+			})
+			It("should not publish the token", func() {
+				var payload string
+				Expect(concreteDb.Raw("SELECT payload::text FROM outbox WHERE type = 'invitationCreated' AND payload->>'invitationId' = 'aaa'").Row().Scan(&payload)).To(BeNil())
+				Expect(payload).NotTo(ContainSubstring("token"))
+			})
+		})
+
+		Context("When an office manager invites an office manager", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpBodyToUse = `{"email": "rickon.stark@got.com", "roles": ["officemanager"]}`
+			})
+			assertJsonResponse(`{"error":"cannot invite a user with this role"}`)
+			assertHttpCode(http.StatusForbidden)
+		})
+
+		Context("When an office manager invites to another daycare", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpBodyToUse = `{"email": "rickon.stark@got.com", "daycareId": "namek", "roles": ["teacher"]}`
+			})
+			assertJsonResponse(`{"error":"cannot invite a user to another daycare"}`)
+			assertHttpCode(http.StatusForbidden)
+		})
+
+		Context("When an admin invites an office manager to another daycare", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_ADMIN] = true
+				claims["userId"] = "id1"
+				httpBodyToUse = `{"email": "bulma.brief@dbz.com", "daycareId": "namek", "roles": ["officemanager"]}`
+			})
+			assertHttpCode(http.StatusCreated)
+			It("should invite to this daycare", func() {
+				invitation := InvitationTransport{}
+				Expect(json.Unmarshal(recorder.Body.Bytes(), &invitation)).To(BeNil())
+				Expect(*invitation.DaycareId).To(Equal("namek"))
+			})
+		})
+
+		Context("When a pending invitation exists for the email", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpBodyToUse = `{"email": "arya.stark@got.com", "roles": ["adult"]}`
+			})
+			assertJsonResponse(`{"error":"a pending invitation already exists for this email"}`)
+			assertHttpCode(http.StatusConflict)
+		})
+
+		Context("When a user of another daycare has the email", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpBodyToUse = `{"email": "sangoku@dbz.com", "roles": ["adult"]}`
+			})
+			assertJsonResponse(`{"error":"a user of another daycare already has this email"}`)
+			assertHttpCode(http.StatusConflict)
+		})
+
+		Context("When there is no role", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpBodyToUse = `{"email": "rickon.stark@got.com"}`
+			})
+			assertJsonResponse(`{"error":"at least one role is mandatory"}`)
+			assertHttpCode(http.StatusBadRequest)
+		})
+
+		Context("When user is a teacher", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_TEACHER] = true
+			})
+			assertHttpCode(http.StatusUnauthorized)
+		})
+	})
+
+	Describe("LIST", func() {
+
+		BeforeEach(func() {
+			httpMethodToUse = http.MethodGet
+			httpEndpointToUse = "/invitations"
+		})
+
+		Context("When user is an office manager", func() {
+			BeforeEach(func() { claims[roles.ROLE_OFFICE_MANAGER] = true })
+			assertJsonResponse(`[
+			  {
+				"id": "invitationid-1",
+				"email": "arya.stark@got.com",
+				"firstName": "Arya",
+				"lastName": "Stark",
+				"daycareId": "peyredragon",
+				"roles": ["teacher"],
+				"invitedBy": "id2",
+				"expiresAt": "2100-01-01T00:00:00Z",
+				"createdAt": "2018-03-28T09:00:00Z"
+			  }
+			]`)
+			assertHttpCode(http.StatusOK)
+		})
+	})
+
+	Describe("DELETE", func() {
+
+		BeforeEach(func() {
+			httpMethodToUse = http.MethodDelete
+			httpEndpointToUse = "/invitations/invitationid-1"
+		})
+
+		Context("When user is an office manager of the daycare", func() {
+			BeforeEach(func() { claims[roles.ROLE_OFFICE_MANAGER] = true })
+			assertReturnedNoPayload()
+			assertHttpCode(http.StatusNoContent)
+		})
+
+		Context("When user is an office manager of another daycare", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				claims["daycareId"] = "namek"
+			})
+			assertJsonResponse(`{"error":"failed to delete invitation: invitation not found"}`)
+			assertHttpCode(http.StatusNotFound)
+		})
+	})
+
+	Describe("ISSUE TOKEN", func() {
+
+		BeforeEach(func() {
+			httpMethodToUse = http.MethodPost
+			httpEndpointToUse = "/invitations/invitationid-1/token"
+			httpBodyToUse = ""
+			claims = map[string]interface{}{
+				roles.ROLE_SERVICE: true,
+				"serviceName":      "event-manager",
+				"scopes":           []string{roles.SCOPE_INVITATIONS_SEND},
+			}
+		})
+
+		Context("When the invitation is pending", func() {
+			assertHttpCode(http.StatusOK)
+			It("should return a link accepting the invitation", func() {
+				token := api.InvitationTokenTransport{}
+				Expect(json.Unmarshal(recorder.Body.Bytes(), &token)).To(BeNil())
+				Expect(*token.AcceptUrl).To(HavePrefix("https://teddycare.net/invitations/accept?token="))
+
+				acceptUrl, err := url.Parse(*token.AcceptUrl)
+				Expect(err).To(BeNil())
+				recorder = httptest.NewRecorder()
+				req, _ := http.NewRequest(http.MethodPost, "/invitations/accept", strings.NewReader(`{"token": "`+acceptUrl.Query().Get("token")+`"}`))
+				req.Header.Set("Authorization", "Bearer arya-id-token")
+				router.ServeHTTP(recorder, req)
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+			})
+			It("should stop the previous link", func() {
+				recorder = httptest.NewRecorder()
+				req, _ := http.NewRequest(http.MethodPost, "/invitations/accept", strings.NewReader(`{"token": "arya-token"}`))
+				req.Header.Set("Authorization", "Bearer arya-id-token")
+				router.ServeHTTP(recorder, req)
+				Expect(recorder.Code).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("When the invitation was already accepted", func() {
+			BeforeEach(func() { httpEndpointToUse = "/invitations/invitationid-2/token" })
+			assertJsonResponse(`{"error":"invitation expired or already accepted"}`)
+			assertHttpCode(http.StatusGone)
+		})
+
+		Context("When the invitation was deleted", func() {
+			BeforeEach(func() { httpEndpointToUse = "/invitations/foo/token" })
+			assertJsonResponse(`{"error":"invitation deleted"}`)
+			assertHttpCode(http.StatusGone)
+		})
+
+		Context("When the request does not come from a service", func() {
+			BeforeEach(func() { claims = map[string]interface{}{roles.ROLE_OFFICE_MANAGER: true} })
+			assertHttpCode(http.StatusUnauthorized)
+		})
+
+		Context("When the service was not given the scope", func() {
+			BeforeEach(func() { claims["scopes"] = []string{roles.SCOPE_CHILDREN_READ} })
+			assertHttpCode(http.StatusForbidden)
+		})
+	})
+
+	Describe("ACCEPT", func() {
+
+		BeforeEach(func() {
+			httpMethodToUse = http.MethodPost
+			httpEndpointToUse = "/invitations/accept"
+			httpBodyToUse = `{"token": "arya-token"}`
+			authorizationToUse = "arya-id-token"
+		})
+
+		Context("When the identity has the email of the invitation", func() {
+			assertJsonResponse(`{
+				"userId": "aaa",
+				"email": "arya.stark@got.com",
+				"daycareId": "peyredragon",
+				"roles": ["teacher"]
+			}`)
+			assertHttpCode(http.StatusOK)
+			It("should give the roles to the identity", func() {
+				mockIdentityProvider.AssertCalled(GinkgoT(), "SetClaims", "arya-uid", map[string]interface{}{
+					"userId":                  "aaa",
+					"daycareId":               "peyredragon",
+					"claimsVersion":           2,
+					roles.ROLE_TEACHER:        true,
+					roles.ROLE_OFFICE_MANAGER: false,
+					roles.ROLE_ADULT:          false,
+					roles.ROLE_ADMIN:          false,
+				})
+			})
+			It("should use the invitation once", func() {
+				recorder = httptest.NewRecorder()
+				req, _ := http.NewRequest(http.MethodPost, "/invitations/accept", strings.NewReader(`{"token": "arya-token"}`))
+				req.Header.Set("Authorization", "Bearer arya-id-token")
+				router.ServeHTTP(recorder, req)
+				Expect(recorder.Code).To(Equal(http.StatusGone))
+			})
+		})
+
+		Context("When the identity has another email", func() {
+			BeforeEach(func() { authorizationToUse = "sansa-id-token" })
+			assertJsonResponse(`{"error":"the invitation was sent to another email"}`)
+			assertHttpCode(http.StatusForbidden)
+		})
+
+		Context("When there is neither an authorization token nor a password", func() {
+			BeforeEach(func() { authorizationToUse = "" })
+			assertJsonResponse(`{"error":"an authorization token or a password is mandatory"}`)
+			assertHttpCode(http.StatusBadRequest)
+		})
+
+		Context("When the invitation was already accepted", func() {
+			BeforeEach(func() { httpBodyToUse = `{"token": "bran-token"}` })
+			assertJsonResponse(`{"error":"invitation expired or already accepted"}`)
+			assertHttpCode(http.StatusGone)
+		})
+
+		Context("When the invitation expired", func() {
+			BeforeEach(func() { httpBodyToUse = `{"token": "expired-token"}` })
+			assertJsonResponse(`{"error":"invitation expired or already accepted"}`)
+			assertHttpCode(http.StatusGone)
+		})
+
+		Context("When the token is unknown", func() {
+			BeforeEach(func() { httpBodyToUse = `{"token": "foo"}` })
+			assertJsonResponse(`{"error":"failed to accept invitation: invitation not found"}`)
+			assertHttpCode(http.StatusNotFound)
+		})
+	})
+
+	Describe("Outbox", func() {
+		It("should publish the events of overlapping transactions in the order of their commits", func() {
+			first := concreteDb.Begin()
+			defer first.Rollback()
+			Expect(concreteStore.AddOutboxEvent(first, "namek", events.InvitationCreatedV1{InvitationId: "first"})).To(BeNil())
+
+			secondCommitted := make(chan error, 1)
+			go func() {
+				second := concreteDb.Begin()
+				defer second.Rollback()
+				if err := concreteStore.AddOutboxEvent(second, "namek", events.InvitationCreatedV1{InvitationId: "second"}); err != nil {
+					secondCommitted <- err
+					return
+				}
+				secondCommitted <- second.Commit().Error
+			}()
+			Consistently(secondCommitted, "200ms").ShouldNot(Receive())
+
+			Expect(first.Commit().Error).To(BeNil())
+			Eventually(secondCommitted).Should(Receive(BeNil()))
+
+			published := []string{}
+			_, err := concreteStore.RelayOutboxEvents(10, func(envelope events.Envelope) error {
+				published = append(published, envelope.Id)
+				return nil
+			})
+			Expect(err).To(BeNil())
+			Expect(published).To(Equal([]string{"aaa", "bbb"}))
+		})
+	})
+})
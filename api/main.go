@@ -6,12 +6,17 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/Vinubaba/SANTC-API/api/ageranges"
+	"github.com/Vinubaba/SANTC-API/api/attendances"
 	"github.com/Vinubaba/SANTC-API/api/authentication"
 	"github.com/Vinubaba/SANTC-API/api/children"
 	"github.com/Vinubaba/SANTC-API/api/classes"
+	"github.com/Vinubaba/SANTC-API/api/dailyreports"
 	"github.com/Vinubaba/SANTC-API/api/daycares"
+	"github.com/Vinubaba/SANTC-API/api/invitations"
+	"github.com/Vinubaba/SANTC-API/api/pickups"
 	. "github.com/Vinubaba/SANTC-API/api/shared"
 	"github.com/Vinubaba/SANTC-API/api/users"
 	teddyFirebase "github.com/Vinubaba/SANTC-API/common/firebase"
@@ -25,6 +30,7 @@ import (
 	"firebase.google.com/go/auth"
 	"github.com/Vinubaba/SANTC-API/api/schedules"
 	"github.com/Vinubaba/SANTC-API/common/generator"
+	"github.com/Vinubaba/SANTC-API/common/jwt"
 	"github.com/facebookgo/inject"
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
@@ -42,48 +48,107 @@ var (
 	db              *gorm.DB
 	stringGenerator = &generator.StringGenerator{}
 
-	daycareService  = &daycares.DaycareService{}
-	childService    = &children.ChildService{}
-	userService     = &users.UserService{}
-	classService    = &classes.ClassService{}
-	ageRangeService = &ageranges.AgeRangeService{}
-	scheduleService = &schedules.ScheduleService{}
-
-	daycareHandlerFactory   = &daycares.HandlerFactory{}
-	userHandlerFactory      = &users.HandlerFactory{}
-	childrenHandlerFactory  = &children.HandlerFactory{}
-	classesHandlerFactory   = &classes.HandlerFactory{}
-	ageRangesHandlerFactory = &ageranges.HandlerFactory{}
-	schedulesHandlerFactory = &schedules.HandlerFactory{}
+	daycareService     = &daycares.DaycareService{}
+	childService       = &children.ChildService{}
+	userService        = &users.UserService{}
+	classService       = &classes.ClassService{}
+	ageRangeService    = &ageranges.AgeRangeService{}
+	scheduleService    = &schedules.ScheduleService{}
+	attendanceService  = &attendances.AttendanceService{}
+	pickupService      = &pickups.PickupService{}
+	dailyReportService = &dailyreports.DailyReportService{}
+	invitationService  = &invitations.InvitationService{}
+
+	daycareHandlerFactory      = &daycares.HandlerFactory{}
+	userHandlerFactory         = &users.HandlerFactory{}
+	childrenHandlerFactory     = &children.HandlerFactory{}
+	classesHandlerFactory      = &classes.HandlerFactory{}
+	ageRangesHandlerFactory    = &ageranges.HandlerFactory{}
+	schedulesHandlerFactory    = &schedules.HandlerFactory{}
+	attendancesHandlerFactory  = &attendances.HandlerFactory{}
+	pickupsHandlerFactory      = &pickups.HandlerFactory{}
+	dailyReportsHandlerFactory = &dailyreports.HandlerFactory{}
+	invitationsHandlerFactory  = &invitations.HandlerFactory{}
 
 	teddyFirebaseClient = &teddyFirebase.Client{}
 
-	dbStore    = &Store{}
-	gcsStorage *storage.GoogleStorage
+	dbStore = &Store{}
+	// localStorage is only set with the local backend
+	fileStorage  storage.Storage
+	localStorage *storage.LocalStorage
 
 	firebaseClient *auth.Client
 	authenticator  = &authentication.Authenticator{}
+	// identityProvider verifies the tokens, localAuthHandlers are only set with the local provider
+	identityProvider  authentication.IdentityProvider
+	localAuthHandlers *authentication.LocalAuthHandlers
+	serviceVerifier   = &authentication.ServiceVerifier{}
 )
 
 func init() {
 	checkErrAndExit(initAppConfiguration())
 	checkErrAndExit(initStorage())
 	checkErrAndExit(initPostgresConnection())
-	checkErrAndExit(initFirebase())
+	checkErrAndExit(initIdentityProvider())
+	initServiceVerifier()
 	checkErrAndExit(initApplicationGraph())
 	checkErrAndExit(initSwagger())
 }
 
 func initAppConfiguration() (err error) {
 	config, err = InitAppConfiguration()
+	if err != nil {
+		return
+	}
+	// a typo must not delete the files meant to be kept
+	switch config.RejectedPhotosPolicy {
+	case children.REJECTED_PHOTOS_DELETE, children.REJECTED_PHOTOS_KEEP:
+	default:
+		err = fmt.Errorf("unknown rejected photos policy %s", config.RejectedPhotosPolicy)
+	}
 	return
 }
 
 func initStorage() (err error) {
-	gcsStorage, err = storage.New(ctx, storage.Options{
-		BucketName:      config.BucketName,
-		CredentialsFile: config.BucketServiceAccount,
-	})
+	imageOptions := storage.ImageOptions{
+		MaxImageSize:          config.MaxImageSize,
+		ThumbnailSize:         config.ThumbnailSize,
+		ImageConverterCommand: config.ImageConverterCommand,
+		ImageConverterTimeout: config.ImageConverterTimeout,
+		MaxFileSize:           config.MaxFileSize,
+		MaxImagePixels:        config.MaxImagePixels,
+		DownloadUrlLifetime:   config.DownloadUrlLifetime,
+		ThumbnailUrlLifetime:  config.ThumbnailUrlLifetime,
+	}
+
+	switch config.StorageBackend {
+	case storage.BACKEND_GCS:
+		fileStorage, err = storage.New(ctx, storage.Options{
+			BucketName:      config.BucketName,
+			CredentialsFile: config.BucketServiceAccount,
+			ImageOptions:    imageOptions,
+		})
+	case storage.BACKEND_LOCAL:
+		localStorage, err = storage.NewLocal(storage.LocalOptions{
+			Path:         config.LocalStoragePath,
+			Url:          config.LocalStorageUrl,
+			Secret:       config.LocalStorageSecret,
+			ImageOptions: imageOptions,
+		})
+		fileStorage = localStorage
+	case storage.BACKEND_S3:
+		fileStorage, err = storage.NewS3(storage.S3Options{
+			Endpoint:     config.S3Endpoint,
+			Region:       config.S3Region,
+			BucketName:   config.BucketName,
+			AccessKey:    config.S3AccessKey,
+			SecretKey:    config.S3SecretKey,
+			PathStyle:    config.S3PathStyle,
+			ImageOptions: imageOptions,
+		})
+	default:
+		err = fmt.Errorf("unknown storage backend %s", config.StorageBackend)
+	}
 	return
 }
 
@@ -104,6 +169,46 @@ func initPostgresConnection() (err error) {
 	return
 }
 
+func initIdentityProvider() error {
+	switch config.AuthProvider {
+	case authentication.PROVIDER_FIREBASE:
+		identityProvider = &authentication.FirebaseProvider{}
+		return initFirebase()
+	case authentication.PROVIDER_LOCAL:
+		identityProvider = &authentication.LocalProvider{}
+		localAuthHandlers = &authentication.LocalAuthHandlers{}
+		return nil
+	case authentication.PROVIDER_OIDC:
+		return initOIDC()
+	default:
+		return fmt.Errorf("unknown auth provider %s", config.AuthProvider)
+	}
+}
+
+func initOIDC() error {
+	if config.OidcIssuer == "" {
+		return errors.New("no issuer configured for the oidc auth provider")
+	}
+	provider := &authentication.OIDCProvider{}
+	switch {
+	case config.OidcJwksFile != "":
+		provider.KeySet = jwt.NewFileKeySet(config.OidcJwksFile, config.OidcKeysMaxAge)
+	case config.OidcJwksUrl != "":
+		provider.KeySet = jwt.NewRemoteKeySet(&http.Client{Timeout: 10 * time.Second}, config.OidcJwksUrl, config.OidcKeysMaxAge)
+	default:
+		return errors.New("no jwks url nor file configured for the oidc auth provider")
+	}
+	identityProvider = provider
+	return nil
+}
+
+func initServiceVerifier() {
+	if config.ServiceKeysFile != "" {
+		// keys added to the file are picked up without restarting
+		serviceVerifier.KeySet = jwt.NewFileKeySet(config.ServiceKeysFile, 5*time.Minute)
+	}
+}
+
 func initFirebase() error {
 	opt := option.WithCredentialsFile(config.FirebaseServiceAccount)
 	config := &firebase.Config{ProjectID: config.GcpProjectID}
@@ -122,8 +227,7 @@ func initFirebase() error {
 }
 
 func initApplicationGraph() error {
-	g := inject.Graph{}
-	g.Provide(
+	objects := []*inject.Object{
 		&inject.Object{Value: config},
 		&inject.Object{Value: daycareService},
 		&inject.Object{Value: childService},
@@ -131,21 +235,43 @@ func initApplicationGraph() error {
 		&inject.Object{Value: classService},
 		&inject.Object{Value: ageRangeService},
 		&inject.Object{Value: scheduleService},
+		&inject.Object{Value: attendanceService},
+		&inject.Object{Value: pickupService},
+		&inject.Object{Value: dailyReportService},
+		&inject.Object{Value: invitationService},
 		&inject.Object{Value: userHandlerFactory},
 		&inject.Object{Value: daycareHandlerFactory},
 		&inject.Object{Value: childrenHandlerFactory},
 		&inject.Object{Value: classesHandlerFactory},
 		&inject.Object{Value: ageRangesHandlerFactory},
 		&inject.Object{Value: schedulesHandlerFactory},
+		&inject.Object{Value: attendancesHandlerFactory},
+		&inject.Object{Value: pickupsHandlerFactory},
+		&inject.Object{Value: dailyReportsHandlerFactory},
+		&inject.Object{Value: invitationsHandlerFactory},
 		&inject.Object{Value: db},
 		&inject.Object{Value: stringGenerator},
 		&inject.Object{Value: dbStore},
-		&inject.Object{Value: gcsStorage},
-		&inject.Object{Value: teddyFirebaseClient, Name: "teddyFirebaseClient"},
-		&inject.Object{Value: firebaseClient},
+		&inject.Object{Value: fileStorage},
+		&inject.Object{Value: identityProvider, Name: "identityProvider"},
+		&inject.Object{Value: serviceVerifier},
 		&inject.Object{Value: authenticator},
 		&inject.Object{Value: logger},
-	)
+	}
+	if firebaseClient != nil {
+		objects = append(objects,
+			&inject.Object{Value: teddyFirebaseClient, Name: "teddyFirebaseClient"},
+			&inject.Object{Value: firebaseClient},
+		)
+	}
+	if localAuthHandlers != nil {
+		objects = append(objects, &inject.Object{Value: localAuthHandlers})
+	}
+
+	g := inject.Graph{}
+	if err := g.Provide(objects...); err != nil {
+		return errors.Wrap(err, "failed to provide")
+	}
 	if err := g.Populate(); err != nil {
 		return errors.Wrap(err, "failed to populate")
 	}
@@ -209,6 +335,26 @@ func startHttpServer(ctx context.Context) {
 		kithttp.ServerErrorEncoder(schedules.EncodeError),
 	}
 
+	attendancesOpts := []kithttp.ServerOption{
+		kithttp.ServerErrorLogger(logger),
+		kithttp.ServerErrorEncoder(attendances.EncodeError),
+	}
+
+	pickupsOpts := []kithttp.ServerOption{
+		kithttp.ServerErrorLogger(logger),
+		kithttp.ServerErrorEncoder(pickups.EncodeError),
+	}
+
+	dailyReportsOpts := []kithttp.ServerOption{
+		kithttp.ServerErrorLogger(logger),
+		kithttp.ServerErrorEncoder(dailyreports.EncodeError),
+	}
+
+	invitationsOpts := []kithttp.ServerOption{
+		kithttp.ServerErrorLogger(logger),
+		kithttp.ServerErrorEncoder(invitations.EncodeError),
+	}
+
 	router := mux.NewRouter()
 
 	router.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
@@ -224,14 +370,32 @@ func startHttpServer(ctx context.Context) {
 		w.Write(swagger)
 	})
 
+	if localStorage != nil {
+		// urls are signed, files are served without authentication like the ones of a bucket
+		router.PathPrefix(localStorage.UrlPath()).Handler(localStorage).Methods(http.MethodGet, http.MethodHead)
+	}
+
+	if localAuthHandlers != nil {
+		router.HandleFunc("/auth/sign-in", localAuthHandlers.SignIn).Methods(http.MethodPost)
+		router.HandleFunc("/auth/refresh", localAuthHandlers.Refresh).Methods(http.MethodPost)
+		router.HandleFunc("/auth/sign-out", localAuthHandlers.SignOut).Methods(http.MethodPost)
+		router.HandleFunc("/auth/register", localAuthHandlers.Register).Methods(http.MethodPost)
+		router.HandleFunc("/auth/jwks.json", localAuthHandlers.KeySet).Methods(http.MethodGet)
+	}
+
 	if config.TestAuthMode {
-		router.HandleFunc("/auth/login", authentication.ServeTestAuth).Methods(http.MethodGet)
+		if localAuthHandlers != nil {
+			router.HandleFunc("/auth/login", authentication.ServeLocalTestAuth).Methods(http.MethodGet)
+		} else {
+			router.HandleFunc("/auth/login", authentication.ServeTestAuth).Methods(http.MethodGet)
+		}
 		router.HandleFunc("/auth/success", authentication.ServeTestAuthOnSuccess)
 	}
 
 	apiRouterV1 := router.PathPrefix("/api/v1").Subrouter()
 
 	apiRouterV1.Handle("/me", authenticator.Roles(userHandlerFactory.Me(userOpts), ROLE_ADMIN, ROLE_OFFICE_MANAGER, ROLE_ADULT, ROLE_TEACHER)).Methods(http.MethodGet)
+	apiRouterV1.Handle("/users/{id}/image", authenticator.Roles(userHandlerFactory.UpdateImage(userOpts), ROLE_ADMIN, ROLE_OFFICE_MANAGER, ROLE_ADULT, ROLE_TEACHER)).Methods(http.MethodPut)
 
 	apiRouterV1.Handle("/daycares", authenticator.Roles(daycareHandlerFactory.Add(daycareOpts), ROLE_ADMIN)).Methods(http.MethodPost)
 	apiRouterV1.Handle("/daycares", authenticator.Roles(daycareHandlerFactory.List(daycareOpts), ROLE_ADMIN)).Methods(http.MethodGet)
@@ -263,14 +427,31 @@ func startHttpServer(ctx context.Context) {
 
 	apiRouterV1.Handle("/children", authenticator.Roles(childrenHandlerFactory.Add(childrenOpts), ROLE_OFFICE_MANAGER, ROLE_ADULT, ROLE_ADMIN)).Methods(http.MethodPost)
 	apiRouterV1.Handle("/children", authenticator.Roles(childrenHandlerFactory.List(childrenOpts), ROLE_OFFICE_MANAGER, ROLE_ADULT, ROLE_ADMIN, ROLE_TEACHER)).Methods(http.MethodGet)
-	apiRouterV1.Handle("/children/{childId}", authenticator.Roles(childrenHandlerFactory.Get(childrenOpts), ROLE_OFFICE_MANAGER, ROLE_ADULT, ROLE_ADMIN, ROLE_TEACHER, ROLE_SERVICE)).Methods(http.MethodGet)
+	apiRouterV1.Handle("/children/{childId}", authenticator.Scope(childrenHandlerFactory.Get(childrenOpts), SCOPE_CHILDREN_READ, ROLE_OFFICE_MANAGER, ROLE_ADULT, ROLE_ADMIN, ROLE_TEACHER)).Methods(http.MethodGet)
 	apiRouterV1.Handle("/children/{childId}", authenticator.Roles(childrenHandlerFactory.Update(childrenOpts), ROLE_OFFICE_MANAGER, ROLE_ADULT, ROLE_ADMIN)).Methods(http.MethodPatch)
 	apiRouterV1.Handle("/children/{childId}", authenticator.Roles(childrenHandlerFactory.Delete(childrenOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodDelete)
-	apiRouterV1.Handle("/children/{childId}/photos", authenticator.Roles(childrenHandlerFactory.AddPhoto(childrenOpts), ROLE_SERVICE)).Methods(http.MethodPost)
+	apiRouterV1.Handle("/children/{childId}/image", authenticator.Roles(childrenHandlerFactory.UpdateImage(childrenOpts), ROLE_OFFICE_MANAGER, ROLE_ADULT, ROLE_ADMIN)).Methods(http.MethodPut)
+	apiRouterV1.Handle("/children/{childId}/photos", authenticator.Scope(childrenHandlerFactory.AddPhoto(childrenOpts), SCOPE_PHOTOS_WRITE)).Methods(http.MethodPost)
+	apiRouterV1.Handle("/children/{childId}/photos", authenticator.Roles(childrenHandlerFactory.ListPhotos(childrenOpts), ROLE_OFFICE_MANAGER, ROLE_ADULT, ROLE_ADMIN, ROLE_TEACHER)).Methods(http.MethodGet)
+	apiRouterV1.Handle("/children/{childId}/photo-consent", authenticator.Scope(childrenHandlerFactory.GetPhotoConsent(childrenOpts), SCOPE_PHOTO_CONSENT_READ, ROLE_OFFICE_MANAGER, ROLE_ADULT, ROLE_ADMIN, ROLE_TEACHER)).Methods(http.MethodGet)
+	apiRouterV1.Handle("/children/{childId}/photo-consent", authenticator.Roles(childrenHandlerFactory.SetPhotoConsent(childrenOpts), ROLE_OFFICE_MANAGER, ROLE_ADULT, ROLE_ADMIN)).Methods(http.MethodPut)
 	apiRouterV1.Handle("/children/{childId}/schedules", authenticator.Roles(schedulesHandlerFactory.Add(schedulesOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodPost)
 	apiRouterV1.Handle("/children/{childId}/schedules/{scheduleId}", authenticator.Roles(schedulesHandlerFactory.Get(schedulesOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodGet)
 	apiRouterV1.Handle("/children/{childId}/schedules/{scheduleId}", authenticator.Roles(schedulesHandlerFactory.Update(schedulesOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodPatch)
 	apiRouterV1.Handle("/children/{childId}/schedules/{scheduleId}", authenticator.Roles(schedulesHandlerFactory.Delete(schedulesOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodDelete)
+	apiRouterV1.Handle("/children/{childId}/attendance", authenticator.Roles(attendancesHandlerFactory.List(attendancesOpts), ROLE_OFFICE_MANAGER, ROLE_ADULT, ROLE_ADMIN, ROLE_TEACHER)).Methods(http.MethodGet)
+	apiRouterV1.Handle("/children/{childId}/attendance/check-in", authenticator.Roles(attendancesHandlerFactory.CheckIn(attendancesOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN, ROLE_TEACHER)).Methods(http.MethodPost)
+	apiRouterV1.Handle("/children/{childId}/attendance/check-out", authenticator.Roles(attendancesHandlerFactory.CheckOut(attendancesOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN, ROLE_TEACHER)).Methods(http.MethodPost)
+
+	apiRouterV1.Handle("/children/{childId}/authorized-pickups", authenticator.Roles(pickupsHandlerFactory.List(pickupsOpts), ROLE_OFFICE_MANAGER, ROLE_ADULT, ROLE_ADMIN, ROLE_TEACHER)).Methods(http.MethodGet)
+	apiRouterV1.Handle("/children/{childId}/authorized-pickups", authenticator.Roles(pickupsHandlerFactory.Add(pickupsOpts), ROLE_OFFICE_MANAGER, ROLE_ADULT, ROLE_ADMIN)).Methods(http.MethodPost)
+	apiRouterV1.Handle("/children/{childId}/authorized-pickups/{pickupId}", authenticator.Roles(pickupsHandlerFactory.Update(pickupsOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodPatch)
+	apiRouterV1.Handle("/children/{childId}/authorized-pickups/{pickupId}", authenticator.Roles(pickupsHandlerFactory.Delete(pickupsOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodDelete)
+	apiRouterV1.Handle("/children/{childId}/authorized-pickups/{pickupId}/approve", authenticator.Roles(pickupsHandlerFactory.Approve(pickupsOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodPost)
+
+	apiRouterV1.Handle("/children/{childId}/reports", authenticator.Roles(dailyReportsHandlerFactory.Get(dailyReportsOpts), ROLE_OFFICE_MANAGER, ROLE_ADULT, ROLE_ADMIN, ROLE_TEACHER)).Methods(http.MethodGet)
+	apiRouterV1.Handle("/children/{childId}/reports", authenticator.Roles(dailyReportsHandlerFactory.AddEntry(dailyReportsOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN, ROLE_TEACHER)).Methods(http.MethodPost)
+	apiRouterV1.Handle("/children/{childId}/reports/{entryId}", authenticator.Roles(dailyReportsHandlerFactory.DeleteEntry(dailyReportsOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN, ROLE_TEACHER)).Methods(http.MethodDelete)
 
 	apiRouterV1.Handle("/age-ranges", authenticator.Roles(ageRangesHandlerFactory.Add(ageRangesOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodPost)
 	apiRouterV1.Handle("/age-ranges", authenticator.Roles(ageRangesHandlerFactory.List(ageRangesOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodGet)
@@ -283,12 +464,27 @@ func startHttpServer(ctx context.Context) {
 	apiRouterV1.Handle("/classes/{classId}", authenticator.Roles(classesHandlerFactory.Get(classesOpts), ROLE_OFFICE_MANAGER, ROLE_ADULT, ROLE_ADMIN, ROLE_TEACHER)).Methods(http.MethodGet)
 	apiRouterV1.Handle("/classes/{classId}", authenticator.Roles(classesHandlerFactory.Update(classesOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodPatch)
 	apiRouterV1.Handle("/classes/{classId}", authenticator.Roles(classesHandlerFactory.Delete(classesOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodDelete)
+	apiRouterV1.Handle("/classes/{classId}/image", authenticator.Roles(classesHandlerFactory.UpdateImage(classesOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodPut)
 
 	apiRouterV1.Handle("/photos-to-approve", authenticator.Roles(childrenHandlerFactory.GetPhotosToApprove(childrenOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodGet)
+	apiRouterV1.Handle("/photos/{photoId}/approve", authenticator.Roles(childrenHandlerFactory.ApprovePhoto(childrenOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodPost)
+	apiRouterV1.Handle("/photos/{photoId}/reject", authenticator.Roles(childrenHandlerFactory.RejectPhoto(childrenOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodPost)
+
+	apiRouterV1.Handle("/invitations", authenticator.Roles(invitationsHandlerFactory.Create(invitationsOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodPost)
+	apiRouterV1.Handle("/invitations", authenticator.Roles(invitationsHandlerFactory.List(invitationsOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodGet)
+	apiRouterV1.Handle("/invitations/accept", invitationsHandlerFactory.Accept(invitationsOpts)).Methods(http.MethodPost)
+	apiRouterV1.Handle("/invitations/{invitationId}", authenticator.Roles(invitationsHandlerFactory.Delete(invitationsOpts), ROLE_OFFICE_MANAGER, ROLE_ADMIN)).Methods(http.MethodDelete)
+	apiRouterV1.Handle("/invitations/{invitationId}/token", authenticator.Scope(invitationsHandlerFactory.IssueToken(invitationsOpts), SCOPE_INVITATIONS_SEND)).Methods(http.MethodPost)
+
+	excludePath := []string{"/healthz", "/readyz", "/auth/login", "/auth/success", "/auth/sign-in", "/auth/refresh",
+		"/auth/sign-out", "/auth/register", "/auth/jwks.json", "/swagger.yaml", "/api/v1", "/api/v1/invitations/accept"}
+	if localStorage != nil {
+		excludePath = append(excludePath, localStorage.UrlPath())
+	}
 
 	checkErrAndExit(http.ListenAndServe("0.0.0.0:8080",
 		logger.RequestLoggerMiddleware(
-			authenticator.Firebase(router, []string{"/healthz", "/readyz", "/auth/login", "/auth/success", "/swagger.yaml", "/api/v1"}),
+			authenticator.Authenticate(router, excludePath),
 		),
 	))
 }
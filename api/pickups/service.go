@@ -0,0 +1,297 @@
+package pickups
+
+import (
+	"context"
+	"path"
+	"time"
+
+	. "github.com/Vinubaba/SANTC-API/common/api"
+	"github.com/Vinubaba/SANTC-API/common/firebase/claims"
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/storage"
+	"github.com/Vinubaba/SANTC-API/common/store"
+
+	"github.com/araddon/dateparse"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrEmptyChild            = errors.New("childId cannot be empty")
+	ErrEmptyPickup           = errors.New("pickupId cannot be empty")
+	ErrEmptyName             = errors.New("firstName and lastName are mandatory")
+	ErrEmptyPhone            = errors.New("phone is mandatory")
+	ErrEmptyRelationship     = errors.New("relationship is mandatory")
+	ErrInvalidDate           = errors.New("invalid date")
+	ErrInvalidValidityWindow = errors.New("validUntil cannot be before validFrom")
+)
+
+type Service interface {
+	AddAuthorizedPickup(ctx context.Context, request AuthorizedPickupTransport) (store.AuthorizedPickup, error)
+	UpdateAuthorizedPickup(ctx context.Context, request AuthorizedPickupTransport) (store.AuthorizedPickup, error)
+	ApproveAuthorizedPickup(ctx context.Context, request AuthorizedPickupTransport) (store.AuthorizedPickup, error)
+	DeleteAuthorizedPickup(ctx context.Context, request AuthorizedPickupTransport) error
+	ListAuthorizedPickups(ctx context.Context, request AuthorizedPickupTransport) ([]store.AuthorizedPickup, error)
+}
+
+type PickupService struct {
+	Store interface {
+		AddAuthorizedPickup(tx *gorm.DB, pickup store.AuthorizedPickup) (store.AuthorizedPickup, error)
+		GetAuthorizedPickup(tx *gorm.DB, pickupId string) (store.AuthorizedPickup, error)
+		ListAuthorizedPickups(tx *gorm.DB, options store.AuthorizedPickupSearchOptions) ([]store.AuthorizedPickup, error)
+		UpdateAuthorizedPickup(tx *gorm.DB, pickup store.AuthorizedPickup) (store.AuthorizedPickup, error)
+		ApproveAuthorizedPickup(tx *gorm.DB, pickupId, approvedBy string) error
+		DeleteAuthorizedPickup(tx *gorm.DB, pickupId string) error
+
+		GetChild(tx *gorm.DB, childId string, options store.SearchOptions) (store.Child, error)
+	} `inject:""`
+	Storage storage.Storage `inject:""`
+	Logger  *log.Logger     `inject:""`
+}
+
+func (c *PickupService) AddAuthorizedPickup(ctx context.Context, request AuthorizedPickupTransport) (store.AuthorizedPickup, error) {
+	if IsNilOrEmpty(request.ChildId) {
+		return store.AuthorizedPickup{}, ErrEmptyChild
+	}
+	if IsNilOrEmpty(request.FirstName) || IsNilOrEmpty(request.LastName) {
+		return store.AuthorizedPickup{}, ErrEmptyName
+	}
+	if IsNilOrEmpty(request.Phone) {
+		return store.AuthorizedPickup{}, ErrEmptyPhone
+	}
+	if IsNilOrEmpty(request.Relationship) {
+		return store.AuthorizedPickup{}, ErrEmptyRelationship
+	}
+
+	child, err := c.Store.GetChild(nil, *request.ChildId, claims.GetDefaultSearchOptions(ctx))
+	if err != nil {
+		return store.AuthorizedPickup{}, errors.Wrap(err, "failed to add authorized pickup")
+	}
+
+	pickupToCreate, err := transportToStore(request)
+	if err != nil {
+		return store.AuthorizedPickup{}, err
+	}
+
+	// Adults can only propose someone, an office manager has to approve the proposal
+	userId := claims.GetUserId(ctx)
+	pickupToCreate.ProposedBy = store.DbNullString(nullIfEmpty(userId))
+	if claims.IsOfficeManager(ctx) || claims.IsAdmin(ctx) {
+		pickupToCreate.Approved = store.DbNullBool(newBool(true))
+		pickupToCreate.ApprovedBy = store.DbNullString(nullIfEmpty(userId))
+	} else {
+		pickupToCreate.Approved = store.DbNullBool(newBool(false))
+		pickupToCreate.ApprovedBy = store.DbNullString(nil)
+	}
+
+	if !IsNilOrEmpty(request.ImageUri) {
+		imageUri, err := c.Storage.Store(ctx, *request.ImageUri, c.storageFolder(child.DaycareId.String))
+		if err != nil {
+			return store.AuthorizedPickup{}, errors.Wrap(err, "failed to store image")
+		}
+		pickupToCreate.ImageUri = store.DbNullString(&imageUri)
+	}
+
+	pickup, err := c.Store.AddAuthorizedPickup(nil, pickupToCreate)
+	if err != nil {
+		return store.AuthorizedPickup{}, errors.Wrap(err, "failed to add authorized pickup")
+	}
+
+	c.setBucketUri(ctx, &pickup)
+	return pickup, nil
+}
+
+func (c *PickupService) UpdateAuthorizedPickup(ctx context.Context, request AuthorizedPickupTransport) (store.AuthorizedPickup, error) {
+	existingPickup, child, err := c.getChildPickup(ctx, request)
+	if err != nil {
+		return store.AuthorizedPickup{}, errors.Wrap(err, "failed to update authorized pickup")
+	}
+
+	pickupToUpdate, err := transportToStore(request)
+	if err != nil {
+		return store.AuthorizedPickup{}, err
+	}
+	// approval has its own endpoint and a pickup cannot be moved to another child
+	pickupToUpdate.ChildId = existingPickup.ChildId
+	pickupToUpdate.Approved = store.DbNullBool(nil)
+	pickupToUpdate.ApprovedBy = store.DbNullString(nil)
+	pickupToUpdate.ProposedBy = store.DbNullString(nil)
+
+	validFrom, validUntil := existingPickup.ValidFrom, existingPickup.ValidUntil
+	if pickupToUpdate.ValidFrom.Valid {
+		validFrom = pickupToUpdate.ValidFrom
+	}
+	if pickupToUpdate.ValidUntil.Valid {
+		validUntil = pickupToUpdate.ValidUntil
+	}
+	if validFrom.Valid && validUntil.Valid && validUntil.Time.Before(validFrom.Time) {
+		return store.AuthorizedPickup{}, ErrInvalidValidityWindow
+	}
+
+	if !IsNilOrEmpty(request.ImageUri) {
+		imageUri, err := c.Storage.Store(ctx, *request.ImageUri, c.storageFolder(child.DaycareId.String))
+		if err != nil {
+			return store.AuthorizedPickup{}, errors.Wrap(err, "failed to store image")
+		}
+		pickupToUpdate.ImageUri = store.DbNullString(&imageUri)
+	}
+
+	pickup, err := c.Store.UpdateAuthorizedPickup(nil, pickupToUpdate)
+	if err != nil {
+		return store.AuthorizedPickup{}, errors.Wrap(err, "failed to update authorized pickup")
+	}
+
+	if pickupToUpdate.ImageUri.Valid && existingPickup.ImageUri.String != "" {
+		if err := c.Storage.Delete(ctx, existingPickup.ImageUri.String); err != nil {
+			c.Logger.Warn(ctx, "failed to delete previous pickup image", "imageUri", existingPickup.ImageUri.String, "err", err.Error())
+		}
+	}
+
+	c.setBucketUri(ctx, &pickup)
+	return pickup, nil
+}
+
+func (c *PickupService) ApproveAuthorizedPickup(ctx context.Context, request AuthorizedPickupTransport) (store.AuthorizedPickup, error) {
+	pickup, _, err := c.getChildPickup(ctx, request)
+	if err != nil {
+		return store.AuthorizedPickup{}, errors.Wrap(err, "failed to approve authorized pickup")
+	}
+
+	if err := c.Store.ApproveAuthorizedPickup(nil, pickup.PickupId.String, claims.GetUserId(ctx)); err != nil {
+		return store.AuthorizedPickup{}, errors.Wrap(err, "failed to approve authorized pickup")
+	}
+
+	pickup, err = c.Store.GetAuthorizedPickup(nil, pickup.PickupId.String)
+	if err != nil {
+		return store.AuthorizedPickup{}, errors.Wrap(err, "failed to approve authorized pickup")
+	}
+
+	c.setBucketUri(ctx, &pickup)
+	return pickup, nil
+}
+
+func (c *PickupService) DeleteAuthorizedPickup(ctx context.Context, request AuthorizedPickupTransport) error {
+	pickup, _, err := c.getChildPickup(ctx, request)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete authorized pickup")
+	}
+
+	if err := c.Store.DeleteAuthorizedPickup(nil, pickup.PickupId.String); err != nil {
+		return errors.Wrap(err, "failed to delete authorized pickup")
+	}
+
+	if pickup.ImageUri.String != "" {
+		if err := c.Storage.Delete(ctx, pickup.ImageUri.String); err != nil {
+			c.Logger.Warn(ctx, "failed to delete pickup image", "imageUri", pickup.ImageUri.String, "err", err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (c *PickupService) ListAuthorizedPickups(ctx context.Context, request AuthorizedPickupTransport) ([]store.AuthorizedPickup, error) {
+	if IsNilOrEmpty(request.ChildId) {
+		return []store.AuthorizedPickup{}, ErrEmptyChild
+	}
+
+	if _, err := c.Store.GetChild(nil, *request.ChildId, claims.GetDefaultSearchOptions(ctx)); err != nil {
+		return []store.AuthorizedPickup{}, errors.Wrap(err, "failed to list authorized pickups")
+	}
+
+	pickups, err := c.Store.ListAuthorizedPickups(nil, store.AuthorizedPickupSearchOptions{ChildId: *request.ChildId})
+	if err != nil {
+		return []store.AuthorizedPickup{}, errors.Wrap(err, "failed to list authorized pickups")
+	}
+
+	for i := range pickups {
+		c.setBucketUri(ctx, &pickups[i])
+	}
+	return pickups, nil
+}
+
+// getChildPickup ensures the requester can see the child and that the pickup belongs to this child
+func (c *PickupService) getChildPickup(ctx context.Context, request AuthorizedPickupTransport) (store.AuthorizedPickup, store.Child, error) {
+	if IsNilOrEmpty(request.ChildId) {
+		return store.AuthorizedPickup{}, store.Child{}, ErrEmptyChild
+	}
+	if IsNilOrEmpty(request.Id) {
+		return store.AuthorizedPickup{}, store.Child{}, ErrEmptyPickup
+	}
+
+	child, err := c.Store.GetChild(nil, *request.ChildId, claims.GetDefaultSearchOptions(ctx))
+	if err != nil {
+		return store.AuthorizedPickup{}, store.Child{}, err
+	}
+
+	pickup, err := c.Store.GetAuthorizedPickup(nil, *request.Id)
+	if err != nil {
+		return store.AuthorizedPickup{}, store.Child{}, err
+	}
+	if pickup.ChildId.String != child.ChildId.String {
+		return store.AuthorizedPickup{}, store.Child{}, store.ErrAuthorizedPickupNotFound
+	}
+
+	return pickup, child, nil
+}
+
+func (c *PickupService) storageFolder(daycareId string) string {
+	return path.Join("daycares", daycareId, "pickups")
+}
+
+func (c *PickupService) setBucketUri(ctx context.Context, pickup *store.AuthorizedPickup) {
+	if pickup.ImageUri.String == "" {
+		return
+	}
+	uri, err := c.Storage.Get(ctx, pickup.ImageUri.String, storage.USAGE_DOWNLOAD)
+	if err != nil {
+		c.Logger.Warn(ctx, "failed to generate image uri", "imageUri", pickup.ImageUri.String, "err", err.Error())
+	}
+	pickup.ImageUri = store.DbNullString(&uri.Url)
+	pickup.ImageUriExpires = uri.Expires
+}
+
+func transportToStore(request AuthorizedPickupTransport) (store.AuthorizedPickup, error) {
+	pickup := store.AuthorizedPickup{
+		PickupId:     store.DbNullString(request.Id),
+		ChildId:      store.DbNullString(request.ChildId),
+		FirstName:    store.DbNullString(request.FirstName),
+		LastName:     store.DbNullString(request.LastName),
+		Phone:        store.DbNullString(request.Phone),
+		Relationship: store.DbNullString(request.Relationship),
+	}
+
+	if !IsNilOrEmpty(request.ValidFrom) {
+		validFrom, err := dateparse.ParseIn(*request.ValidFrom, time.UTC)
+		if err != nil {
+			return store.AuthorizedPickup{}, errors.Wrap(ErrInvalidDate, err.Error())
+		}
+		pickup.ValidFrom = pq.NullTime{Time: validFrom, Valid: true}
+	}
+	if !IsNilOrEmpty(request.ValidUntil) {
+		validUntil, err := dateparse.ParseIn(*request.ValidUntil, time.UTC)
+		if err != nil {
+			return store.AuthorizedPickup{}, errors.Wrap(ErrInvalidDate, err.Error())
+		}
+		pickup.ValidUntil = pq.NullTime{Time: validUntil, Valid: true}
+	}
+	if pickup.ValidFrom.Valid && pickup.ValidUntil.Valid && pickup.ValidUntil.Time.Before(pickup.ValidFrom.Time) {
+		return store.AuthorizedPickup{}, ErrInvalidValidityWindow
+	}
+
+	return pickup, nil
+}
+
+func nullIfEmpty(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+func newBool(value bool) *bool {
+	return &value
+}
+
+// ServiceMiddleware is a chainable behavior modifier for pickupService.
+type ServiceMiddleware func(PickupService) PickupService
@@ -0,0 +1,222 @@
+package pickups
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	"github.com/Vinubaba/SANTC-API/common/api"
+	"github.com/Vinubaba/SANTC-API/common/storage"
+	"github.com/Vinubaba/SANTC-API/common/store"
+
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrBadRouting = errors.New("inconsistent mapping between route and handler (programmer error)")
+)
+
+type AuthorizedPickupTransport struct {
+	Id           *string `json:"id"`
+	ChildId      *string `json:"childId"`
+	FirstName    *string `json:"firstName"`
+	LastName     *string `json:"lastName"`
+	Phone        *string `json:"phone"`
+	Relationship *string `json:"relationship"`
+	ImageUri     *string `json:"imageUri"`
+	ValidFrom    *string `json:"validFrom"`
+	ValidUntil   *string `json:"validUntil"`
+	Approved     *bool   `json:"approved"`
+	ProposedBy   *string `json:"proposedBy"`
+	ApprovedBy   *string `json:"approvedBy"`
+
+	// When the signed url of the image expires, RFC 3339
+	ImageUriExpiresAt *string `json:"imageUriExpiresAt,omitempty"`
+}
+
+type HandlerFactory struct {
+	Service Service `inject:""`
+}
+
+func (h *HandlerFactory) Add(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeAddEndpoint(h.Service),
+		decodeAuthorizedPickupTransport,
+		shared.EncodeResponse201,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) Update(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeUpdateEndpoint(h.Service),
+		decodeAuthorizedPickupTransport,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) Approve(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeApproveEndpoint(h.Service),
+		decodeAuthorizedPickupIdTransport,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) Delete(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeDeleteEndpoint(h.Service),
+		decodeAuthorizedPickupIdTransport,
+		shared.EncodeResponse204,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) List(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeListEndpoint(h.Service),
+		decodeAuthorizedPickupIdTransport,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
+func makeAddEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(AuthorizedPickupTransport)
+		pickup, err := svc.AddAuthorizedPickup(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return storeToTransport(pickup), nil
+	}
+}
+
+func makeUpdateEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(AuthorizedPickupTransport)
+		pickup, err := svc.UpdateAuthorizedPickup(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return storeToTransport(pickup), nil
+	}
+}
+
+func makeApproveEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(AuthorizedPickupTransport)
+		pickup, err := svc.ApproveAuthorizedPickup(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return storeToTransport(pickup), nil
+	}
+}
+
+func makeDeleteEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(AuthorizedPickupTransport)
+		return nil, svc.DeleteAuthorizedPickup(ctx, req)
+	}
+}
+
+func makeListEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(AuthorizedPickupTransport)
+		pickups, err := svc.ListAuthorizedPickups(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		pickupsRet := []AuthorizedPickupTransport{}
+		for _, pickup := range pickups {
+			pickupsRet = append(pickupsRet, storeToTransport(pickup))
+		}
+
+		return pickupsRet, nil
+	}
+}
+
+func decodeAuthorizedPickupTransport(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	childId, ok := vars["childId"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+	// get informations from payload
+	var request AuthorizedPickupTransport
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+	request.ChildId = &childId
+	// pickupId is only present in the route when updating
+	if pickupId, ok := vars["pickupId"]; ok {
+		request.Id = &pickupId
+	}
+	return request, nil
+}
+
+func decodeAuthorizedPickupIdTransport(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	childId, ok := vars["childId"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+	request := AuthorizedPickupTransport{
+		ChildId: &childId,
+	}
+	if pickupId, ok := vars["pickupId"]; ok {
+		request.Id = &pickupId
+	}
+	return request, nil
+}
+
+// encode errors from business-logic
+func EncodeError(_ context.Context, err error, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	switch errors.Cause(err) {
+	case ErrEmptyChild, ErrEmptyPickup, ErrEmptyName, ErrEmptyPhone, ErrEmptyRelationship, ErrInvalidDate, ErrInvalidValidityWindow,
+		storage.ErrUnsupportedFileFormat, storage.ErrMismatchingFileFormat:
+		w.WriteHeader(http.StatusBadRequest)
+	case store.ErrChildNotFound, store.ErrAuthorizedPickupNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": err.Error(),
+	})
+}
+
+func storeToTransport(pickup store.AuthorizedPickup) AuthorizedPickupTransport {
+	ret := AuthorizedPickupTransport{
+		Id:           &pickup.PickupId.String,
+		ChildId:      &pickup.ChildId.String,
+		FirstName:    &pickup.FirstName.String,
+		LastName:     &pickup.LastName.String,
+		Phone:        &pickup.Phone.String,
+		Relationship: &pickup.Relationship.String,
+		ImageUri:     &pickup.ImageUri.String,
+		Approved:     &pickup.Approved.Bool,
+		ProposedBy:   &pickup.ProposedBy.String,
+		ApprovedBy:   &pickup.ApprovedBy.String,
+
+		ImageUriExpiresAt: api.ExpiresAt(pickup.ImageUriExpires),
+	}
+	if pickup.ValidFrom.Valid {
+		validFrom := pickup.ValidFrom.Time.UTC().String()
+		ret.ValidFrom = &validFrom
+	}
+	if pickup.ValidUntil.Valid {
+		validUntil := pickup.ValidUntil.Time.UTC().String()
+		ret.ValidUntil = &validUntil
+	}
+	return ret
+}
@@ -0,0 +1,414 @@
+package pickups_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/Vinubaba/SANTC-API/api/authentication"
+	. "github.com/Vinubaba/SANTC-API/api/pickups"
+	"github.com/Vinubaba/SANTC-API/api/shared"
+	. "github.com/Vinubaba/SANTC-API/api/shared/mocks"
+	"github.com/Vinubaba/SANTC-API/api/users"
+	"github.com/Vinubaba/SANTC-API/common/storage"
+	"github.com/Vinubaba/SANTC-API/common/storage/mocks"
+	"github.com/Vinubaba/SANTC-API/common/store"
+
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/roles"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+)
+
+var _ = Describe("Transport", func() {
+
+	var (
+		router   *mux.Router
+		recorder *httptest.ResponseRecorder
+
+		concreteStore       *store.Store
+		concreteDb          *gorm.DB
+		mockStringGenerator *MockStringGenerator
+		mockStorage         = &mocks.MockGcs{}
+
+		authenticator *authentication.Authenticator
+
+		claims                                            map[string]interface{}
+		reqToUse                                          *http.Request
+		httpMethodToUse, httpEndpointToUse, httpBodyToUse string
+	)
+
+	var (
+		assertHttpCode = func(code int) {
+			It(fmt.Sprintf("should respond with status code %d", code), func() {
+				Expect(recorder.Code).To(Equal(code))
+			})
+		}
+
+		assertReturnedNoPayload = func() {
+			It("should respond with no payload", func() {
+				Expect(recorder.Body.String()).To(Equal(""))
+			})
+		}
+
+		assertJsonResponse = func(response string) {
+			It("should respond with json response", func() {
+				Expect(recorder.Header().Get("Content-Type")).To(ContainSubstring("application/json"))
+				Expect(recorder.Body.String()).To(MatchJSON(response))
+			})
+		}
+	)
+
+	BeforeEach(func() {
+		concreteDb = shared.NewDbInstance(false)
+
+		mockStringGenerator = &MockStringGenerator{}
+		mockStringGenerator.On("GenerateUuid").Return("aaa").Once()
+		mockStringGenerator.On("GenerateUuid").Return("bbb").Once()
+
+		mockStorage.On("Store", mock.Anything, mock.Anything, mock.Anything).Return("pickups/new.jpg", nil)
+		mockStorage.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(storage.SignedUrl{Url: "gs://foo/bar.jpg"}, nil)
+		mockStorage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+		concreteStore = &store.Store{
+			Db:              concreteDb,
+			StringGenerator: mockStringGenerator,
+		}
+
+		userService := &users.UserService{
+			Store: concreteStore,
+		}
+		logger := log.NewLogger("teddycare")
+
+		authenticator = &authentication.Authenticator{
+			UserService: userService,
+			Logger:      logger,
+		}
+
+		pickupService := &PickupService{
+			Store:   concreteStore,
+			Storage: mockStorage,
+			Logger:  logger,
+		}
+
+		httpMethodToUse = ""
+		httpEndpointToUse = ""
+		httpBodyToUse = ""
+
+		router = mux.NewRouter()
+		opts := []kithttp.ServerOption{
+			kithttp.ServerErrorLogger(logger),
+			kithttp.ServerErrorEncoder(EncodeError),
+		}
+
+		handlerFactory := HandlerFactory{
+			Service: pickupService,
+		}
+
+		router.Handle("/children/{childId}/authorized-pickups", authenticator.Roles(handlerFactory.List(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADULT, roles.ROLE_ADMIN, roles.ROLE_TEACHER)).Methods(http.MethodGet)
+		router.Handle("/children/{childId}/authorized-pickups", authenticator.Roles(handlerFactory.Add(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADULT, roles.ROLE_ADMIN)).Methods(http.MethodPost)
+		router.Handle("/children/{childId}/authorized-pickups/{pickupId}", authenticator.Roles(handlerFactory.Update(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN)).Methods(http.MethodPatch)
+		router.Handle("/children/{childId}/authorized-pickups/{pickupId}", authenticator.Roles(handlerFactory.Delete(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN)).Methods(http.MethodDelete)
+		router.Handle("/children/{childId}/authorized-pickups/{pickupId}/approve", authenticator.Roles(handlerFactory.Approve(opts), roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADMIN)).Methods(http.MethodPost)
+
+		recorder = httptest.NewRecorder()
+
+		shared.SetDbInitialState()
+	})
+
+	AfterEach(func() {
+		concreteDb.Close()
+		mockStorage.Reset()
+	})
+
+	BeforeEach(func() {
+		claims = map[string]interface{}{
+			"userId":                  "",
+			"daycareId":               "peyredragon",
+			roles.ROLE_TEACHER:        false,
+			roles.ROLE_OFFICE_MANAGER: false,
+			roles.ROLE_ADULT:          false,
+			roles.ROLE_ADMIN:          false,
+		}
+	})
+
+	JustBeforeEach(func() {
+		reqToUse, _ = http.NewRequest(httpMethodToUse, httpEndpointToUse, strings.NewReader(httpBodyToUse))
+		reqToUse = reqToUse.WithContext(context.WithValue(context.Background(), "claims", claims))
+		router.ServeHTTP(recorder, reqToUse)
+	})
+
+	Describe("LIST", func() {
+
+		var (
+			expectedJsonPickups = `[
+			  {
+				"id": "pickupid-1",
+				"childId": "childid-4",
+				"firstName": "Cersei",
+				"lastName": "Lannister",
+				"phone": "+3365651",
+				"relationship": "mother",
+				"imageUri": "gs://foo/bar.jpg",
+				"validFrom": null,
+				"validUntil": null,
+				"approved": true,
+				"proposedBy": "id3",
+				"approvedBy": "id3"
+			  },
+			  {
+				"id": "pickupid-2",
+				"childId": "childid-4",
+				"firstName": "Jaime",
+				"lastName": "Lannister",
+				"phone": "+3365651",
+				"relationship": "uncle",
+				"imageUri": "",
+				"validFrom": "2018-05-01 00:00:00 +0000 UTC",
+				"validUntil": "2018-05-31 00:00:00 +0000 UTC",
+				"approved": false,
+				"proposedBy": "id3",
+				"approvedBy": ""
+			  }
+			]`
+		)
+
+		BeforeEach(func() {
+			httpMethodToUse = http.MethodGet
+			httpEndpointToUse = "/children/childid-4/authorized-pickups"
+		})
+
+		Context("When user is an office manager of the same daycare", func() {
+			BeforeEach(func() { claims[roles.ROLE_OFFICE_MANAGER] = true })
+			assertJsonResponse(expectedJsonPickups)
+			assertHttpCode(http.StatusOK)
+		})
+
+		Context("When user is a teacher of the child", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_TEACHER] = true
+				claims["userId"] = "id4"
+			})
+			assertJsonResponse(expectedJsonPickups)
+			assertHttpCode(http.StatusOK)
+		})
+
+		Context("When user is an office manager of another daycare", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				claims["daycareId"] = "namek"
+			})
+			assertJsonResponse(`{"error":"failed to list authorized pickups: child not found"}`)
+			assertHttpCode(http.StatusNotFound)
+		})
+
+		Context("When user is a random adult", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_ADULT] = true
+				claims["userId"] = "id5"
+			})
+			assertJsonResponse(`{"error":"failed to list authorized pickups: child not found"}`)
+			assertHttpCode(http.StatusNotFound)
+		})
+	})
+
+	Describe("ADD", func() {
+
+		BeforeEach(func() {
+			httpMethodToUse = http.MethodPost
+			httpEndpointToUse = "/children/childid-4/authorized-pickups"
+			httpBodyToUse = `{
+				"firstName": "Sandor",
+				"lastName": "Clegane",
+				"phone": "+3365652",
+				"relationship": "bodyguard",
+				"imageUri": "b64image",
+				"validUntil": "2018-12-31"
+			}`
+		})
+
+		Context("When user is an office manager of the same daycare", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				claims["userId"] = "id2"
+			})
+			assertJsonResponse(`{
+				"id": "aaa",
+				"childId": "childid-4",
+				"firstName": "Sandor",
+				"lastName": "Clegane",
+				"phone": "+3365652",
+				"relationship": "bodyguard",
+				"imageUri": "gs://foo/bar.jpg",
+				"validFrom": null,
+				"validUntil": "2018-12-31 00:00:00 +0000 UTC",
+				"approved": true,
+				"proposedBy": "id2",
+				"approvedBy": "id2"
+			}`)
+			assertHttpCode(http.StatusCreated)
+			mockStorage.AssertStoredImage("daycares/peyredragon/pickups")
+		})
+
+		Context("When user is an adult responsible of the child", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_ADULT] = true
+				claims["userId"] = "id3"
+			})
+			assertJsonResponse(`{
+				"id": "aaa",
+				"childId": "childid-4",
+				"firstName": "Sandor",
+				"lastName": "Clegane",
+				"phone": "+3365652",
+				"relationship": "bodyguard",
+				"imageUri": "gs://foo/bar.jpg",
+				"validFrom": null,
+				"validUntil": "2018-12-31 00:00:00 +0000 UTC",
+				"approved": false,
+				"proposedBy": "id3",
+				"approvedBy": ""
+			}`)
+			assertHttpCode(http.StatusCreated)
+		})
+
+		Context("When user is a teacher", func() {
+			BeforeEach(func() { claims[roles.ROLE_TEACHER] = true })
+			assertReturnedNoPayload()
+			assertHttpCode(http.StatusUnauthorized)
+		})
+
+		Context("When the phone is missing", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpBodyToUse = `{"firstName": "Sandor", "lastName": "Clegane", "relationship": "bodyguard"}`
+			})
+			assertJsonResponse(`{"error":"phone is mandatory"}`)
+			assertHttpCode(http.StatusBadRequest)
+		})
+
+		Context("When the validity window is inverted", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpBodyToUse = `{"firstName": "Sandor", "lastName": "Clegane", "phone": "+3365652", "relationship": "bodyguard", "validFrom": "2018-12-31", "validUntil": "2018-01-01"}`
+			})
+			assertJsonResponse(`{"error":"validUntil cannot be before validFrom"}`)
+			assertHttpCode(http.StatusBadRequest)
+		})
+	})
+
+	Describe("UPDATE", func() {
+
+		BeforeEach(func() {
+			httpMethodToUse = http.MethodPatch
+			httpEndpointToUse = "/children/childid-4/authorized-pickups/pickupid-2"
+			httpBodyToUse = `{"phone": "+3365653"}`
+		})
+
+		Context("When user is an office manager of the same daycare", func() {
+			BeforeEach(func() { claims[roles.ROLE_OFFICE_MANAGER] = true })
+			assertJsonResponse(`{
+				"id": "pickupid-2",
+				"childId": "childid-4",
+				"firstName": "Jaime",
+				"lastName": "Lannister",
+				"phone": "+3365653",
+				"relationship": "uncle",
+				"imageUri": "",
+				"validFrom": "2018-05-01 00:00:00 +0000 UTC",
+				"validUntil": "2018-05-31 00:00:00 +0000 UTC",
+				"approved": false,
+				"proposedBy": "id3",
+				"approvedBy": ""
+			}`)
+			assertHttpCode(http.StatusOK)
+		})
+
+		Context("When the pickup belongs to another child", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpEndpointToUse = "/children/childid-3/authorized-pickups/pickupid-2"
+			})
+			assertJsonResponse(`{"error":"failed to update authorized pickup: authorized pickup not found"}`)
+			assertHttpCode(http.StatusNotFound)
+		})
+
+		Context("When user is an adult", func() {
+			BeforeEach(func() { claims[roles.ROLE_ADULT] = true })
+			assertReturnedNoPayload()
+			assertHttpCode(http.StatusUnauthorized)
+		})
+	})
+
+	Describe("APPROVE", func() {
+
+		BeforeEach(func() {
+			httpMethodToUse = http.MethodPost
+			httpEndpointToUse = "/children/childid-4/authorized-pickups/pickupid-2/approve"
+		})
+
+		Context("When user is an office manager of the same daycare", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				claims["userId"] = "id2"
+			})
+			assertJsonResponse(`{
+				"id": "pickupid-2",
+				"childId": "childid-4",
+				"firstName": "Jaime",
+				"lastName": "Lannister",
+				"phone": "+3365651",
+				"relationship": "uncle",
+				"imageUri": "",
+				"validFrom": "2018-05-01 00:00:00 +0000 UTC",
+				"validUntil": "2018-05-31 00:00:00 +0000 UTC",
+				"approved": true,
+				"proposedBy": "id3",
+				"approvedBy": "id2"
+			}`)
+			assertHttpCode(http.StatusOK)
+		})
+
+		Context("When the pickup does not exist", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				httpEndpointToUse = "/children/childid-4/authorized-pickups/unknown/approve"
+			})
+			assertJsonResponse(`{"error":"failed to approve authorized pickup: authorized pickup not found"}`)
+			assertHttpCode(http.StatusNotFound)
+		})
+	})
+
+	Describe("DELETE", func() {
+
+		BeforeEach(func() {
+			httpMethodToUse = http.MethodDelete
+			httpEndpointToUse = "/children/childid-4/authorized-pickups/pickupid-1"
+		})
+
+		Context("When user is an office manager of the same daycare", func() {
+			BeforeEach(func() { claims[roles.ROLE_OFFICE_MANAGER] = true })
+			assertReturnedNoPayload()
+			assertHttpCode(http.StatusNoContent)
+			It("should delete the pickup image", func() {
+				Expect(mockStorage.CallsForMethod("Delete")).To(HaveLen(1))
+			})
+		})
+
+		Context("When user is an office manager of another daycare", func() {
+			BeforeEach(func() {
+				claims[roles.ROLE_OFFICE_MANAGER] = true
+				claims["daycareId"] = "namek"
+			})
+			assertJsonResponse(`{"error":"failed to delete authorized pickup: child not found"}`)
+			assertHttpCode(http.StatusNotFound)
+		})
+	})
+})
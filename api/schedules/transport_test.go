@@ -84,8 +84,8 @@ var _ = Describe("Transport", func() {
 		}
 
 		userService := &users.UserService{
-			FirebaseClient: mockFirebaseClient,
-			Store:          concreteStore,
+			IdentityProvider: mockFirebaseClient,
+			Store:            concreteStore,
 		}
 		logger := log.NewLogger("teddycare")
 
@@ -2,6 +2,7 @@ package shared
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
@@ -16,13 +17,83 @@ type AppConfig struct {
 	PgDbName               string `split_words:"true" default:"teddycare"`
 	SqlMigrationsSourceDir string `split_words:"true" default:"C:\\Users\\arthur\\gocode\\src\\github.com\\Vinubaba\\SANTC-API\\api\\sql"`
 	GcpProjectID           string `split_words:"true" default:"teddy-care"`
-	LocalStoragePath       string `split_words:"true"`
+
+	// What happens to the file of a rejected photo: "delete" removes it from the bucket, "keep" leaves it for auditing
+	RejectedPhotosPolicy string `split_words:"true" default:"delete"`
+
+	// Where the images are kept: "gcs", "local" or "s3". Local files are served by the api under LocalStorageUrl
+	StorageBackend     string `split_words:"true" default:"gcs"`
+	LocalStoragePath   string `split_words:"true"`
+	LocalStorageUrl    string `split_words:"true" default:"http://localhost:8080/files"`
+	LocalStorageSecret string `split_words:"true"`
+	// S3 compatible service, e.g http://localhost:9000 for MinIO. The files go to BucketName
+	S3Endpoint  string `split_words:"true"`
+	S3Region    string `split_words:"true" default:"us-east-1"`
+	S3AccessKey string `split_words:"true"`
+	S3SecretKey string `split_words:"true"`
+	// MinIO expects the bucket in the path of the urls rather than in the host name
+	S3PathStyle bool `split_words:"true" default:"false"`
 
 	BucketName           string `split_words:"true" default:"teddycare"`
 	BucketServiceAccount string `split_words:"true" default:"C:\\Users\\arthur\\code\\kubernetes-configuration\\bucket-sa.json"`
+	// Longest side, in pixels, of the stored images and of their thumbnails
+	MaxImageSize  int `split_words:"true" default:"2048"`
+	ThumbnailSize int `split_words:"true" default:"320"`
+	// Program converting webp and heic uploads to jpeg, see the Dockerfile
+	ImageConverterCommand string        `split_words:"true" default:"convert"`
+	ImageConverterTimeout time.Duration `split_words:"true" default:"30s"`
+	// Biggest uploaded image, in bytes, whether it is sent as a file or as a data uri
+	MaxFileSize int64 `split_words:"true" default:"10485760"`
+	// Most pixels, width times height, of an uploaded image. A small file can declare a huge image
+	MaxImagePixels int `split_words:"true" default:"50000000"`
+	// How long the signed urls of the responses work: full size images are opened once, thumbnails fill the lists
+	DownloadUrlLifetime  time.Duration `split_words:"true" default:"3m"`
+	ThumbnailUrlLifetime time.Duration `split_words:"true" default:"1h"`
 
 	FirebaseServiceAccount string `split_words:"true" default:"C:\\Users\\arthur\\code\\kubernetes-configuration\\firebase-sa.json"`
 
+	// Who verifies the bearer tokens: firebase, oidc for the issuer below, or local to sign them with keys kept in
+	// the database
+	AuthProvider string `split_words:"true" default:"firebase"`
+	// iss and aud of the tokens of the local provider
+	LocalAuthIssuer               string        `split_words:"true" default:"teddycare"`
+	LocalAuthTokenLifetime        time.Duration `split_words:"true" default:"1h"`
+	LocalAuthRefreshTokenLifetime time.Duration `split_words:"true" default:"720h"`
+	// A new key signs the tokens after that, the previous ones verify the tokens they signed until they expire
+	LocalAuthKeyRotation time.Duration `split_words:"true" default:"720h"`
+	// Anyone knowing the email of a user can create its identity, for development and CI only
+	LocalAuthRegistration bool `split_words:"true" default:"false"`
+
+	// OpenID Connect issuer and the audience of its tokens, the keys are read from the file for offline use
+	OidcIssuer     string        `split_words:"true"`
+	OidcAudience   string        `split_words:"true"`
+	OidcJwksUrl    string        `split_words:"true"`
+	OidcJwksFile   string        `split_words:"true"`
+	OidcKeysMaxAge time.Duration `split_words:"true" default:"1h"`
+	// Claims of the tokens holding the user, the userId and daycareId being the ids of the api
+	OidcEmailClaim     string `split_words:"true" default:"email"`
+	OidcUserIdClaim    string `split_words:"true"`
+	OidcDaycareIdClaim string `split_words:"true"`
+	OidcRolesClaim     string `split_words:"true" default:"roles"`
+	// Values of the roles claim to the roles of the api, e.g "staff:teacher,parents:adult". When empty the claim
+	// holds the roles of the api
+	OidcRoleMapping map[string]string `split_words:"true"`
+	// How long the claims of the users looked up by email are kept
+	OidcClaimsCacheLifetime time.Duration `split_words:"true" default:"5m"`
+
+	// Public keys of the services calling the api, a JWKS whose key ids are the names of the services. Without it
+	// no service is trusted
+	ServiceKeysFile      string `split_words:"true"`
+	ServiceTokenAudience string `split_words:"true" default:"teddycare-api"`
+	// Routes each service may call, its scopes space separated, see common/roles
+	ServiceScopes map[string]string `split_words:"true" default:"event-manager:children.read photos.write photo-consent.read invitations.send"`
+
+	// An invitation can be accepted until then, with the link of its email: the page of the application at
+	// InvitationAcceptUrl gets the token in its query and posts it to /api/v1/invitations/accept. The link is only
+	// issued to the service sending the email
+	InvitationLifetime  time.Duration `split_words:"true" default:"168h"`
+	InvitationAcceptUrl string        `split_words:"true" default:"http://localhost:3000/invitations/accept"`
+
 	TestAuthMode     bool `split_words:"true" default:"true"`
 	StartupMigration bool `split_words:"true" default:"false"`
 
@@ -0,0 +1,37 @@
+package shared
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+const IMAGE_FORM_FIELD = "image"
+
+var (
+	ErrMissingImage = errors.New("the request must be a multipart/form-data with an 'image' file")
+)
+
+// DecodeImageUpload returns the image file of a multipart/form-data request and its declared content type. The file is
+// read from the request body rather than buffered on disk by the multipart parser, it must be read before the handler
+// returns. The storage reads it in memory, at most MaxFileSize bytes
+func DecodeImageUpload(r *http.Request) (io.Reader, string, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", ErrMissingImage
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, "", ErrMissingImage
+		}
+		if err != nil {
+			return nil, "", errors.Wrap(err, "failed to read multipart request")
+		}
+		if part.FormName() == IMAGE_FORM_FIELD {
+			return part, part.Header.Get("Content-Type"), nil
+		}
+	}
+}
@@ -1,12 +1,17 @@
 package shared
 
 import (
+	"bytes"
 	"fmt"
 	"log"
+	"mime/multipart"
+	"net/textproto"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/jinzhu/gorm"
@@ -30,33 +35,39 @@ func InitDb() {
 		log.Fatal("failed to grant privileges:" + string(out))
 	}
 
-	visit := func(files *[]string) filepath.WalkFunc {
-		return func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				log.Fatal(err)
-			}
-			*files = append(*files, path)
-			return nil
+	root := getSqlDirPath(true)
+
+	for _, file := range upMigrations(root) {
+		// psql exits with 0 on sql errors unless told to stop on the first one
+		out, err := exec.Command("psql", "-U", "postgres", "-h", "localhost", "-d", "test_teddycare", "-v", "ON_ERROR_STOP=1", "-a", "-f", file).CombinedOutput()
+		if err != nil {
+			log.Print(string(out))
+			log.Fatal(file + ": " + err.Error())
 		}
 	}
+}
 
-	var files []string
-
-	root := getSqlDirPath(true)
-
-	err := filepath.Walk(root, visit(&files))
+// upMigrations returns the up files of the migrations in the order of their version, as migrate applies them:
+// 10_foo.up.sql comes after 9_bar.up.sql
+func upMigrations(root string) []string {
+	files, err := filepath.Glob(filepath.Join(root, "*.up.sql"))
 	if err != nil {
 		panic(err)
 	}
+
+	versions := map[string]int{}
 	for _, file := range files {
-		if strings.Contains(file, "up") {
-			out, err := exec.Command("psql", "-U", "postgres", "-h", "localhost", "-d", "test_teddycare", "-a", "-f", fmt.Sprintf("%s", file)).Output()
-			if err != nil {
-				log.Print(string(out))
-				log.Fatal(err.Error())
-			}
+		prefix := strings.SplitN(filepath.Base(file), "_", 2)[0]
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			log.Fatal("migration without version: " + file)
 		}
+		versions[file] = version
 	}
+	sort.Slice(files, func(i, j int) bool {
+		return versions[files[i]] < versions[files[j]]
+	})
+	return files
 }
 
 func getSqlDirPath(verbose bool) string {
@@ -76,7 +87,8 @@ func getSqlDirPath(verbose bool) string {
 
 func SetDbInitialState() {
 	root := getSqlDirPath(false)
-	out, err := exec.Command("psql", "-U", "postgres", "-h", "localhost", "-d", "test_teddycare", "-a", "-f", path.Join(root, "test_initial_state.sql")).Output()
+	// a fixture that does not load, e.g. an invitation referencing a missing daycare, must fail the suite
+	out, err := exec.Command("psql", "-U", "postgres", "-h", "localhost", "-d", "test_teddycare", "-v", "ON_ERROR_STOP=1", "-a", "-f", path.Join(root, "test_initial_state.sql")).CombinedOutput()
 	if err != nil {
 		log.Print(string(out))
 		log.Fatal(err.Error())
@@ -105,3 +117,26 @@ func NewDbInstance(verbose bool, logger ...interface {
 	}
 	return db
 }
+
+// NewMultipartImage builds the body of an image upload and its content type
+func NewMultipartImage(field string, content []byte) (body string, contentType string) {
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+	part, _ := writer.CreateFormFile(field, "image.jpg")
+	part.Write(content)
+	writer.Close()
+	return buf.String(), writer.FormDataContentType()
+}
+
+// NewMultipartImageOfType builds the body of an image upload whose file declares the given content type
+func NewMultipartImageOfType(field string, fileContentType string, content []byte) (body string, contentType string) {
+	buf := &bytes.Buffer{}
+	writer := multipart.NewWriter(buf)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="image.png"`, field))
+	header.Set("Content-Type", fileContentType)
+	part, _ := writer.CreatePart(header)
+	part.Write(content)
+	writer.Close()
+	return buf.String(), writer.FormDataContentType()
+}
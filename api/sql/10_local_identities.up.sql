@@ -0,0 +1,22 @@
+-- accounts of the local identity provider, the users of the api are matched to them by email
+CREATE TABLE IF NOT EXISTS local_identities (
+  uid varchar NOT NULL PRIMARY KEY,
+  email varchar NOT NULL UNIQUE,
+  password_hash varchar NOT NULL,
+  claims jsonb NOT NULL default '{}',
+  created_at timestamp with time zone NOT NULL default now()
+);
+-- only the hash of the refresh tokens is kept, a token is revoked once used
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  token_hash varchar NOT NULL PRIMARY KEY,
+  uid varchar REFERENCES local_identities (uid) ON DELETE CASCADE NOT NULL,
+  expires_at timestamp with time zone NOT NULL,
+  revoked boolean NOT NULL default false
+);
+CREATE INDEX IF NOT EXISTS refresh_tokens_uid_idx ON refresh_tokens (uid);
+-- the newest key signs the tokens, the previous ones verify the tokens they signed until they expire
+CREATE TABLE IF NOT EXISTS signing_keys (
+  key_id varchar NOT NULL PRIMARY KEY,
+  private_key text NOT NULL,
+  created_at timestamp with time zone NOT NULL default now()
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS pending_connexion_roles;
+DROP TABLE IF EXISTS invitations;
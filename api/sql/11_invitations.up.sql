@@ -0,0 +1,22 @@
+-- only the hash of the token sent by email is kept, an invitation is accepted once
+CREATE TABLE IF NOT EXISTS invitations (
+  invitation_id varchar NOT NULL PRIMARY KEY,
+  token_hash varchar NOT NULL UNIQUE,
+  email varchar NOT NULL,
+  first_name varchar NOT NULL default '',
+  last_name varchar NOT NULL default '',
+  daycare_id varchar REFERENCES daycares (daycare_id) ON DELETE CASCADE NOT NULL,
+  invited_by varchar REFERENCES users (user_id) ON DELETE SET NULL,
+  expires_at timestamp with time zone NOT NULL,
+  accepted_at timestamp with time zone,
+  created_at timestamp with time zone NOT NULL default now()
+);
+CREATE INDEX IF NOT EXISTS invitations_daycare_id_idx ON invitations (daycare_id);
+-- roles given to the invited user when the invitation is accepted
+CREATE TABLE IF NOT EXISTS pending_connexion_roles (
+  invitation_id varchar REFERENCES invitations (invitation_id) ON DELETE CASCADE NOT NULL,
+  email varchar NOT NULL,
+  role varchar NOT NULL,
+  PRIMARY KEY (invitation_id, role)
+);
+CREATE INDEX IF NOT EXISTS pending_connexion_roles_email_idx ON pending_connexion_roles (email);
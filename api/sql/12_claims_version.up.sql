@@ -0,0 +1,2 @@
+-- bumped when the roles or the daycare of a user change, the claims of an older version are refused
+ALTER TABLE users ADD COLUMN IF NOT EXISTS claims_version integer NOT NULL default 1;
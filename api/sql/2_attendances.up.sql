@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS attendances (
+  attendance_id varchar UNIQUE NOT NULL PRIMARY KEY,
+  child_id varchar REFERENCES children (child_id) ON DELETE CASCADE NOT NULL,
+  check_in timestamp with time zone NOT NULL,
+  check_out timestamp with time zone,
+  dropped_by varchar NOT NULL, -- name of the person who dropped off the child
+  picked_up_by varchar, -- name of the person who picked up the child
+  checked_in_by varchar REFERENCES users (user_id) ON DELETE SET NULL,
+  checked_out_by varchar REFERENCES users (user_id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS attendances_child_id_idx ON attendances (child_id, check_in);
+
+-- a child has at most one open attendance, even with concurrent check-ins
+CREATE UNIQUE INDEX IF NOT EXISTS attendances_open_child_id_key ON attendances (child_id) WHERE check_out IS NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE attendances DROP COLUMN IF EXISTS unauthorized_pickup;
+ALTER TABLE attendances DROP COLUMN IF EXISTS pickup_id;
+DROP TABLE IF EXISTS authorized_pickups;
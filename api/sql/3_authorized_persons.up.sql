@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS authorized_pickups (
+  pickup_id varchar UNIQUE NOT NULL PRIMARY KEY,
+  child_id varchar REFERENCES children (child_id) ON DELETE CASCADE NOT NULL,
+  first_name varchar NOT NULL,
+  last_name varchar NOT NULL,
+  phone varchar NOT NULL,
+  relationship varchar NOT NULL, --aunt, nanny, neighbour...
+  image_uri varchar,
+  valid_from date,
+  valid_until date,
+  approved boolean NOT NULL default false,
+  proposed_by varchar REFERENCES users (user_id) ON DELETE SET NULL,
+  approved_by varchar REFERENCES users (user_id) ON DELETE SET NULL
+);
+
+ALTER TABLE attendances ADD COLUMN IF NOT EXISTS pickup_id varchar REFERENCES authorized_pickups (pickup_id) ON DELETE SET NULL;
+ALTER TABLE attendances ADD COLUMN IF NOT EXISTS unauthorized_pickup boolean NOT NULL default false;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS daily_report_entries (
+  entry_id varchar UNIQUE NOT NULL PRIMARY KEY,
+  child_id varchar REFERENCES children (child_id) ON DELETE CASCADE NOT NULL,
+  type varchar NOT NULL, -- meal, nap, diaper, mood, activity
+  at timestamp with time zone NOT NULL,
+  amount varchar, -- meal only: none, little, half, most, all
+  description varchar, -- what was eaten, what the child did...
+  start_time timestamp with time zone, -- nap only
+  end_time timestamp with time zone, -- nap only
+  diaper varchar, -- diaper only: wet, dirty, dry, toilet
+  mood varchar, -- mood only: happy, calm, tired, fussy, sad, sick
+  written_by varchar REFERENCES users (user_id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS daily_report_entries_child_id_idx ON daily_report_entries (child_id, at);
@@ -0,0 +1,3 @@
+ALTER TABLE child_photos DROP COLUMN IF EXISTS rejection_reason;
+ALTER TABLE child_photos DROP COLUMN IF EXISTS rejected_by;
+ALTER TABLE child_photos DROP COLUMN IF EXISTS rejected;
@@ -0,0 +1,3 @@
+ALTER TABLE child_photos ADD COLUMN IF NOT EXISTS rejected boolean NOT NULL default false;
+ALTER TABLE child_photos ADD COLUMN IF NOT EXISTS rejected_by varchar REFERENCES users (user_id) ON DELETE SET NULL;
+ALTER TABLE child_photos ADD COLUMN IF NOT EXISTS rejection_reason varchar;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS child_photo_tags;
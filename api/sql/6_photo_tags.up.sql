@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS child_photo_tags (
+  photo_id varchar REFERENCES child_photos (photo_id) ON DELETE CASCADE NOT NULL,
+  child_id varchar REFERENCES children (child_id) ON DELETE CASCADE NOT NULL,
+  PRIMARY KEY (photo_id, child_id)
+);
+
+CREATE INDEX IF NOT EXISTS child_photo_tags_child_id_idx ON child_photo_tags (child_id);
+
+-- photos published before tagging only show the child they were published for
+INSERT INTO child_photo_tags (photo_id, child_id)
+  SELECT photo_id, child_id FROM child_photos WHERE child_id IS NOT NULL
+  ON CONFLICT DO NOTHING;
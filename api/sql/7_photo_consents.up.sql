@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS photo_consents (
+  child_id varchar REFERENCES children (child_id) ON DELETE CASCADE NOT NULL PRIMARY KEY,
+  granted boolean NOT NULL default false,
+  scope varchar, --internal, shareable
+  granted_by varchar REFERENCES users (user_id) ON DELETE SET NULL,
+  granted_at timestamp with time zone NOT NULL default now()
+);
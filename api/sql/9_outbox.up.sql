@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS outbox (
+  event_id varchar NOT NULL PRIMARY KEY,
+  -- the order in which the events are published
+  sequence bigserial NOT NULL UNIQUE,
+  type varchar NOT NULL,
+  version integer NOT NULL,
+  daycare_id varchar NOT NULL default '',
+  payload jsonb NOT NULL,
+  occurred_at timestamp with time zone NOT NULL default now(),
+  published_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (sequence) WHERE published_at IS NULL;
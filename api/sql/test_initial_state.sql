@@ -9,6 +9,13 @@ TRUNCATE TABLE "daycares" CASCADE;
 TRUNCATE TABLE "teacher_classes" CASCADE;
 TRUNCATE TABLE "schedules" CASCADE;
 TRUNCATE TABLE "child_photos" CASCADE;
+TRUNCATE TABLE "attendances" CASCADE;
+TRUNCATE TABLE "authorized_pickups" CASCADE;
+TRUNCATE TABLE "daily_report_entries" CASCADE;
+TRUNCATE TABLE "photo_consents" CASCADE;
+TRUNCATE TABLE "invitations" CASCADE;
+TRUNCATE TABLE "pending_connexion_roles" CASCADE;
+TRUNCATE TABLE "outbox" CASCADE;
 
 INSERT INTO daycares ("daycare_id", "name", "address_1", "address_2", "city", "state", "zip") VALUES ('peyredragon', 'peyredragon', 'peyredragon', 'peyredragon', 'peyredragon', 'peyredragon', 'peyredragon');
 INSERT INTO "users" ("user_id","email","first_name","last_name","gender","phone","address_1","address_2","city","state","zip","image_uri","daycare_id","work_address_1","work_address_2","work_city","work_state","work_zip","work_phone") VALUES ('id1','elaria.sand@got.com','Elaria','Sand','M','+3365651','address','floor','Peyredragon','WESTEROS','31400','http://image.com','peyredragon','work_address_1','work_address_2','work_city','work_state','work_zip','work_phone');
@@ -59,3 +66,31 @@ UPDATE users SET schedule_id = 'scheduleid-1' WHERE user_id = 'id9';
 UPDATE children SET schedule_id = 'scheduleid-1' WHERE child_id = 'childid-1';
 
 INSERT INTO "child_photos" ("photo_id","child_id","published_by","approved_by","image_uri","approved","publication_date") VALUES ('photoid-1','childid-1','id9',NULL,'foo/bar.jpg',false,'1992-10-13T15:13:00Z');
+INSERT INTO "child_photos" ("photo_id","child_id","published_by","approved_by","image_uri","approved","publication_date") VALUES ('photoid-2','childid-3','id4','id3','foo/arya-1.jpg',true,'2018-05-14T10:00:00Z');
+INSERT INTO "child_photos" ("photo_id","child_id","published_by","approved_by","image_uri","approved","publication_date") VALUES ('photoid-3','childid-3','id4','id3','foo/arya-2.jpg',true,'2018-05-16T10:00:00Z');
+INSERT INTO "child_photo_tags" ("photo_id","child_id") VALUES ('photoid-1','childid-1');
+INSERT INTO "child_photo_tags" ("photo_id","child_id") VALUES ('photoid-2','childid-3');
+INSERT INTO "child_photo_tags" ("photo_id","child_id") VALUES ('photoid-3','childid-3');
+INSERT INTO "child_photo_tags" ("photo_id","child_id") VALUES ('photoid-3','childid-4');
+
+INSERT INTO "attendances" ("attendance_id","child_id","check_in","check_out","dropped_by","picked_up_by","checked_in_by","checked_out_by") VALUES ('attendanceid-1','childid-3','2018-05-14T08:30:00Z','2018-05-14T17:45:00Z','Caitlyn Stark','Caitlyn Stark','id4','id4');
+INSERT INTO "attendances" ("attendance_id","child_id","check_in","check_out","dropped_by","picked_up_by","checked_in_by","checked_out_by") VALUES ('attendanceid-2','childid-4','2018-05-15T08:30:00Z',NULL,'Tyrion Lannister',NULL,'id4',NULL);
+
+INSERT INTO "authorized_pickups" ("pickup_id","child_id","first_name","last_name","phone","relationship","image_uri","valid_from","valid_until","approved","proposed_by","approved_by") VALUES ('pickupid-1','childid-4','Cersei','Lannister','+3365651','mother','pickups/cersei.jpg',NULL,NULL,true,'id3','id3');
+INSERT INTO "authorized_pickups" ("pickup_id","child_id","first_name","last_name","phone","relationship","image_uri","valid_from","valid_until","approved","proposed_by","approved_by") VALUES ('pickupid-2','childid-4','Jaime','Lannister','+3365651','uncle',NULL,'2018-05-01','2018-05-31',false,'id3',NULL);
+
+INSERT INTO "daily_report_entries" ("entry_id","child_id","type","at","amount","description","start_time","end_time","diaper","mood","written_by") VALUES ('entryid-1','childid-3','meal','2018-05-14T12:00:00Z','most','pasta and carrots',NULL,NULL,NULL,NULL,'id4');
+INSERT INTO "daily_report_entries" ("entry_id","child_id","type","at","amount","description","start_time","end_time","diaper","mood","written_by") VALUES ('entryid-2','childid-3','nap','2018-05-14T13:00:00Z',NULL,NULL,'2018-05-14T13:00:00Z','2018-05-14T14:30:00Z',NULL,NULL,'id4');
+INSERT INTO "daily_report_entries" ("entry_id","child_id","type","at","amount","description","start_time","end_time","diaper","mood","written_by") VALUES ('entryid-3','childid-3','mood','2018-05-15T09:00:00Z',NULL,NULL,NULL,NULL,NULL,'happy','id4');
+INSERT INTO "daily_report_entries" ("entry_id","child_id","type","at","amount","description","start_time","end_time","diaper","mood","written_by") VALUES ('entryid-4','childid-3','diaper','2018-05-15T02:30:00Z',NULL,NULL,NULL,NULL,'wet',NULL,'id4');
+
+INSERT INTO "photo_consents" ("child_id","granted","scope","granted_by","granted_at") VALUES ('childid-1',true,'shareable','id6','2018-03-28T09:00:00Z');
+INSERT INTO "photo_consents" ("child_id","granted","scope","granted_by","granted_at") VALUES ('childid-3',true,'internal','id4','2018-03-28T09:00:00Z');
+INSERT INTO "photo_consents" ("child_id","granted","scope","granted_by","granted_at") VALUES ('childid-4',true,'shareable','id3','2018-03-28T09:00:00Z');
+
+-- the tokens are arya-token, bran-token (accepted) and expired-token
+INSERT INTO "invitations" ("invitation_id","token_hash","email","first_name","last_name","daycare_id","invited_by","expires_at","accepted_at","created_at") VALUES ('invitationid-1','319c99ab0e73e4b89596888b1daef1184bdc2ccee4ea5c6710fe04b5e2fb60ea','arya.stark@got.com','Arya','Stark','peyredragon','id2','2100-01-01T00:00:00Z',NULL,'2018-03-28T09:00:00Z');
+INSERT INTO "invitations" ("invitation_id","token_hash","email","first_name","last_name","daycare_id","invited_by","expires_at","accepted_at","created_at") VALUES ('invitationid-2','332af4bafe977daa9ed7a1711aab93902b896698473ba48984d14c8a74dca13d','bran.stark@got.com','Bran','Stark','peyredragon','id2','2100-01-01T00:00:00Z','2018-03-29T09:00:00Z','2018-03-28T09:00:00Z');
+INSERT INTO "invitations" ("invitation_id","token_hash","email","first_name","last_name","daycare_id","invited_by","expires_at","accepted_at","created_at") VALUES ('invitationid-3','b52b3ef2233858ce1156d85f235cf2c41eddfa8ca1eedc924398b9af1db303cb','bulma@dbz.com','Bulma','Brief','namek','id7','2018-04-01T00:00:00Z',NULL,'2018-03-28T09:00:00Z');
+INSERT INTO "pending_connexion_roles" ("invitation_id","email","role") VALUES ('invitationid-1','arya.stark@got.com','teacher');
+INSERT INTO "pending_connexion_roles" ("invitation_id","email","role") VALUES ('invitationid-3','bulma@dbz.com','adult');
@@ -6,8 +6,10 @@ import (
 
 	"github.com/Vinubaba/SANTC-API/api/shared"
 	. "github.com/Vinubaba/SANTC-API/common/api"
+	"github.com/Vinubaba/SANTC-API/common/events"
 	"github.com/Vinubaba/SANTC-API/common/firebase/claims"
 	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/roles"
 	"github.com/Vinubaba/SANTC-API/common/storage"
 	"github.com/Vinubaba/SANTC-API/common/store"
 
@@ -19,12 +21,14 @@ var (
 	ErrInvalidEmail           = errors.New("invalid email")
 	ErrInvalidPasswordFormat  = errors.New("password must be at least 6 characters long")
 	ErrCreateDifferentDaycare = errors.New("cannot create user for another daycare")
+	ErrForbiddenImageUpdate   = errors.New("cannot update the image of this user")
 )
 
 type Service interface {
 	AddUserByRoles(ctx context.Context, request UserTransport, roles ...string) (store.User, error)
 	GetUserByRoles(ctx context.Context, request UserTransport, roles ...string) (store.User, error)
 	UpdateUserByRoles(ctx context.Context, request UserTransport, roles ...string) (store.User, error)
+	UpdateUserImage(ctx context.Context, request ImageUploadTransport) (store.User, error)
 	DeleteUserByRoles(ctx context.Context, request UserTransport, roles ...string) error
 	ListUsersByRole(ctx context.Context, roleConstraint string) ([]store.User, error)
 
@@ -49,10 +53,12 @@ type UserService struct {
 
 		AddRole(tx *gorm.DB, role store.Role) (store.Role, error)
 		Tx() *gorm.DB
+
+		AddOutboxEvent(tx *gorm.DB, daycareId string, payload events.Payload) error
 	} `inject:""`
-	FirebaseClient interface {
+	IdentityProvider interface {
 		DeleteUserByEmail(ctx context.Context, email string) error
-	} `inject:"teddyFirebaseClient"`
+	} `inject:"identityProvider"`
 	Storage storage.Storage   `inject:""`
 	Config  *shared.AppConfig `inject:""`
 	Logger  *log.Logger       `inject:""`
@@ -155,16 +161,75 @@ func (c *UserService) UpdateUserByRoles(ctx context.Context, request UserTranspo
 	return user, nil
 }
 
+// UpdateUserImage replaces the image of a user with an uploaded file. Everyone can change their own image, office
+// managers can also change the ones of the teachers and adults of their daycare
+func (c *UserService) UpdateUserImage(ctx context.Context, request ImageUploadTransport) (store.User, error) {
+	user, err := c.Store.GetUser(nil, *request.Id, claims.GetDefaultSearchOptions(ctx))
+	if err != nil {
+		return store.User{}, errors.Wrap(err, "failed to update user")
+	}
+
+	if !c.canUpdateImage(ctx, user) {
+		return store.User{}, ErrForbiddenImageUpdate
+	}
+
+	imageUri, err := c.Storage.StoreFile(ctx, request.Image, request.ContentType, c.storageFolder(user.DaycareId.String))
+	if err != nil {
+		return store.User{}, errors.Wrap(err, "failed to store image")
+	}
+
+	previousImageUri := user.ImageUri.String
+	user, err = c.Store.UpdateUser(nil, store.User{UserId: user.UserId, ImageUri: store.DbNullString(&imageUri)})
+	if err != nil {
+		return store.User{}, err
+	}
+	// nothing refers to the previous image anymore
+	if previousImageUri != "" && previousImageUri != imageUri {
+		if err := c.Storage.Delete(ctx, previousImageUri); err != nil {
+			c.Logger.Warn(ctx, "failed to delete previous user image", "imageUri", previousImageUri, "err", err.Error())
+		}
+	}
+
+	c.setBucketUri(ctx, &user)
+	return user, nil
+}
+
+func (c *UserService) canUpdateImage(ctx context.Context, user store.User) bool {
+	switch {
+	case claims.IsAdmin(ctx), claims.GetUserId(ctx) == user.UserId.String:
+		return true
+	case claims.IsOfficeManager(ctx):
+		return !user.Is(roles.ROLE_OFFICE_MANAGER) && !user.Is(roles.ROLE_ADMIN)
+	default:
+		return false
+	}
+}
+
 func (c *UserService) setBucketUri(ctx context.Context, user *store.User) {
 	if user.ImageUri.String == "" {
 		return
 	}
-	uri, err := c.Storage.Get(ctx, user.ImageUri.String)
+	uri, err := c.Storage.Get(ctx, user.ImageUri.String, storage.USAGE_DOWNLOAD)
 	if err != nil {
 		c.Logger.Warn(ctx, "failed to generate image uri", "err", err.Error())
 		user.ImageUri.Scan("")
 	}
-	user.ImageUri.Scan(uri)
+	user.ImageUri.Scan(uri.Url)
+	user.ImageUriExpires = uri.Expires
+}
+
+// setThumbnailUri must be called before setBucketUri, which replaces the image path by its url
+func (c *UserService) setThumbnailUri(ctx context.Context, user *store.User) {
+	if user.ImageUri.String == "" {
+		return
+	}
+	uri, err := c.Storage.GetThumbnail(ctx, user.ImageUri.String)
+	if err != nil {
+		c.Logger.Warn(ctx, "failed to generate thumbnail uri", "err", err.Error())
+		return
+	}
+	user.ThumbnailUri.Scan(uri.Url)
+	user.ThumbnailUriExpires = uri.Expires
 }
 
 func (c *UserService) GetUserByRoles(ctx context.Context, request UserTransport, roles ...string) (store.User, error) {
@@ -210,13 +275,28 @@ func (c *UserService) DeleteUserByRoles(ctx context.Context, request UserTranspo
 		}
 	}
 
-	if err := c.FirebaseClient.DeleteUserByEmail(ctx, user.Email.String); err != nil {
-		c.Logger.Warn(ctx, "failed to delete user from firebase")
+	if err := c.IdentityProvider.DeleteUserByEmail(ctx, user.Email.String); err != nil {
+		c.Logger.Warn(ctx, "failed to delete user from the identity provider")
 	}
 
-	if err := c.Store.DeleteUser(nil, *request.Id); err != nil {
+	tx := c.Store.Tx()
+	if tx.Error != nil {
+		return errors.Wrap(tx.Error, "failed to delete user")
+	}
+
+	if err := c.Store.DeleteUser(tx, *request.Id); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "failed to delete user")
+	}
+
+	if err := c.Store.AddOutboxEvent(tx, user.DaycareId.String, events.UserDeletedV1{
+		UserId: user.UserId.String,
+		Roles:  user.Roles.ToList(),
+	}); err != nil {
+		tx.Rollback()
 		return errors.Wrap(err, "failed to delete user")
 	}
+	tx.Commit()
 
 	if err := c.Storage.Delete(ctx, user.ImageUri.String); err != nil {
 		c.Logger.Warn(ctx, "failed to delete user image", "imageUri", user.ImageUri.String, "err", err.Error())
@@ -244,6 +324,7 @@ func (c *UserService) ListUsersByRole(ctx context.Context, roleConstraint string
 	}
 
 	for i := range users {
+		c.setThumbnailUri(ctx, &users[i])
 		c.setBucketUri(ctx, &users[i])
 	}
 	return users, nil
@@ -8,9 +8,11 @@ import (
 	"net/http"
 
 	"github.com/Vinubaba/SANTC-API/api/shared"
+	. "github.com/Vinubaba/SANTC-API/common/api"
 
 	"github.com/Vinubaba/SANTC-API/common/firebase/claims"
 	"github.com/Vinubaba/SANTC-API/common/roles"
+	"github.com/Vinubaba/SANTC-API/common/storage"
 	"github.com/Vinubaba/SANTC-API/common/store"
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/pkg/errors"
@@ -34,6 +36,7 @@ type UserTransport struct {
 	State         *string  `json:"state"`
 	Zip           *string  `json:"zip"`
 	ImageUri      *string  `json:"imageUri"`
+	ThumbnailUri  *string  `json:"thumbnailUri,omitempty"`
 	Roles         []string `json:"roles"`
 	DaycareId     *string  `json:"daycareId"`
 	WorkAddress_1 *string  `json:"workAddress_1"`
@@ -42,6 +45,10 @@ type UserTransport struct {
 	WorkState     *string  `json:"workState"`
 	WorkZip       *string  `json:"workZip"`
 	WorkPhone     *string  `json:"workPhone"`
+
+	// When the signed urls of the image and of the thumbnail expire, RFC 3339
+	ImageUriExpiresAt     *string `json:"imageUriExpiresAt,omitempty"`
+	ThumbnailUriExpiresAt *string `json:"thumbnailUriExpiresAt,omitempty"`
 }
 
 type TeacherClassTransport struct {
@@ -111,6 +118,17 @@ func (h *HandlerFactory) UpdateAdult(opts []kithttp.ServerOption) *kithttp.Serve
 	)
 }
 
+// USERS
+
+func (h *HandlerFactory) UpdateImage(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeUpdateImageEndpoint(h.Service),
+		decodeImageUploadRequest,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
 // OFFICE MANAGERS
 
 func (h *HandlerFactory) ListOfficeManager(opts []kithttp.ServerOption) *kithttp.Server {
@@ -231,6 +249,19 @@ func makeUpdateEndpoint(svc Service, role string) endpoint.Endpoint {
 	}
 }
 
+func makeUpdateImageEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ImageUploadTransport)
+
+		user, err := svc.UpdateUserImage(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		return dbToTransport(user), nil
+	}
+}
+
 func makeDeleteEndpoint(svc Service, role string) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(UserTransport)
@@ -320,6 +351,20 @@ func decodeUpdateUserRequest(_ context.Context, r *http.Request) (interface{}, e
 	return request, nil
 }
 
+func decodeImageUploadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	// get id from url
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+	image, contentType, err := shared.DecodeImageUpload(r)
+	if err != nil {
+		return nil, err
+	}
+	return ImageUploadTransport{Id: &id, Image: image, ContentType: contentType}, nil
+}
+
 func decodeGetOrDeleteRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	vars := mux.Vars(r)
 	id, ok := vars["id"]
@@ -352,10 +397,13 @@ func EncodeError(_ context.Context, err error, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
 	switch errors.Cause(err).Error() {
-	case ErrCreateDifferentDaycare.Error():
+	case ErrCreateDifferentDaycare.Error(), ErrForbiddenImageUpdate.Error():
 		w.WriteHeader(http.StatusForbidden)
-	case ErrInvalidPasswordFormat.Error(), ErrInvalidEmail.Error():
+	case ErrInvalidPasswordFormat.Error(), ErrInvalidEmail.Error(),
+		storage.ErrUnsupportedFileFormat.Error(), storage.ErrMismatchingFileFormat.Error(), shared.ErrMissingImage.Error():
 		w.WriteHeader(http.StatusBadRequest)
+	case storage.ErrFileTooLarge.Error():
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
 	case store.ErrUserNotFound.Error(), store.ErrClassNotFound.Error():
 		w.WriteHeader(http.StatusNotFound)
 	default:
@@ -367,7 +415,7 @@ func EncodeError(_ context.Context, err error, w http.ResponseWriter) {
 }
 
 func dbToTransport(user store.User) UserTransport {
-	return UserTransport{
+	ret := UserTransport{
 		Id:            &user.UserId.String,
 		ScheduleId:    &user.ScheduleId.String,
 		FirstName:     &user.FirstName.String,
@@ -389,5 +437,13 @@ func dbToTransport(user store.User) UserTransport {
 		WorkState:     &user.WorkState.String,
 		WorkZip:       &user.WorkZip.String,
 		WorkPhone:     &user.WorkPhone.String,
+
+		ImageUriExpiresAt:     ExpiresAt(user.ImageUriExpires),
+		ThumbnailUriExpiresAt: ExpiresAt(user.ThumbnailUriExpires),
+	}
+	// only list responses come with a thumbnail
+	if user.ThumbnailUri.Valid {
+		ret.ThumbnailUri = &user.ThumbnailUri.String
 	}
+	return ret
 }
@@ -15,6 +15,7 @@ import (
 	. "github.com/Vinubaba/SANTC-API/common/firebase/mocks"
 	"github.com/Vinubaba/SANTC-API/common/log"
 	"github.com/Vinubaba/SANTC-API/common/roles"
+	"github.com/Vinubaba/SANTC-API/common/storage"
 	"github.com/Vinubaba/SANTC-API/common/storage/mocks"
 	"github.com/Vinubaba/SANTC-API/common/store"
 
@@ -44,6 +45,7 @@ var _ = Describe("Transport", func() {
 
 		claims                                            map[string]interface{}
 		reqToUse                                          *http.Request
+		headersToUse                                      http.Header
 		httpMethodToUse, httpEndpointToUse, httpBodyToUse string
 
 		mockImageUriName = "bar.jpg"
@@ -110,7 +112,8 @@ var _ = Describe("Transport", func() {
 		mockStringGenerator = &MockStringGenerator{}
 		mockStringGenerator.On("GenerateUuid").Return("aaa").Once()
 
-		mockStorage.On("Get", mock.Anything, mock.Anything).Return("gs://foo/"+mockImageUriName, nil)
+		mockStorage.On("Get", mock.Anything, mock.Anything, mock.Anything).Return(storage.SignedUrl{Url: "gs://foo/" + mockImageUriName}, nil)
+		mockStorage.On("GetThumbnail", mock.Anything, mock.Anything).Return(storage.SignedUrl{Url: "gs://foo/" + mockImageUriName}, nil)
 		mockStorage.On("Delete", mock.Anything, mock.Anything).Return(nil)
 
 		mockFirebaseClient = &MockClient{}
@@ -128,11 +131,11 @@ var _ = Describe("Transport", func() {
 		}
 
 		userService := &UserService{
-			FirebaseClient: mockFirebaseClient,
-			Store:          concreteStore,
-			Storage:        mockStorage,
-			Logger:         logger,
-			Config:         config,
+			IdentityProvider: mockFirebaseClient,
+			Store:            concreteStore,
+			Storage:          mockStorage,
+			Logger:           logger,
+			Config:           config,
 		}
 
 		authenticator = &authentication.Authenticator{
@@ -143,6 +146,7 @@ var _ = Describe("Transport", func() {
 		httpMethodToUse = ""
 		httpEndpointToUse = ""
 		httpBodyToUse = ""
+		headersToUse = http.Header{}
 
 		router = mux.NewRouter()
 
@@ -155,6 +159,8 @@ var _ = Describe("Transport", func() {
 			Service: userService,
 		}
 
+		router.Handle("/users/{id}/image", authenticator.Roles(handlerFactory.UpdateImage(opts), roles.ROLE_ADMIN, roles.ROLE_OFFICE_MANAGER, roles.ROLE_ADULT, roles.ROLE_TEACHER)).Methods(http.MethodPut)
+
 		router.Handle("/office-managers", authenticator.Roles(handlerFactory.ListOfficeManager(opts), roles.ROLE_ADMIN)).Methods(http.MethodGet)
 		router.Handle("/office-managers/{id}", authenticator.Roles(handlerFactory.GetOfficeManager(opts), roles.ROLE_ADMIN)).Methods(http.MethodGet)
 		router.Handle("/office-managers/{id}", authenticator.Roles(handlerFactory.DeleteOfficeManager(opts), roles.ROLE_ADMIN)).Methods(http.MethodDelete)
@@ -195,9 +201,114 @@ var _ = Describe("Transport", func() {
 	JustBeforeEach(func() {
 		reqToUse, _ = http.NewRequest(httpMethodToUse, httpEndpointToUse, strings.NewReader(httpBodyToUse))
 		reqToUse = reqToUse.WithContext(context.WithValue(context.Background(), "claims", claims))
+		reqToUse.Header = headersToUse
 		router.ServeHTTP(recorder, reqToUse)
 	})
 
+	Describe("USERS", func() {
+
+		Describe("UPDATE IMAGE", func() {
+
+			BeforeEach(func() {
+				mockStorage.On("StoreFile", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(mockImageUriName, nil)
+				httpMethodToUse = http.MethodPut
+				httpEndpointToUse = "/users/id5/image"
+				var contentType string
+				httpBodyToUse, contentType = shared.NewMultipartImage("image", []byte("image content"))
+				headersToUse.Set("Content-Type", contentType)
+			})
+
+			Context("When user is an admin", func() {
+				BeforeEach(func() { claims[roles.ROLE_ADMIN] = true })
+				assertReturnedSingleUser(`{"scheduleId": "","daycareId":"peyredragon","id":"id5","firstName":"Sansa","lastName":"Stark","gender":"F","email":"sansa.stark@got.com","phone":"+3365651","address_1":"address","address_2":"floor","city":"Peyredragon","state":"WESTEROS","zip":"31400","imageUri":"gs://foo/bar.jpg","roles":["adult"],"workAddress_1": "work_address_1","workAddress_2": "work_address_2","workCity": "work_city","workState": "work_state","workZip": "work_zip","workPhone": "work_phone"}`)
+				assertHttpCode(http.StatusOK)
+				mockStorage.AssertStoredFile("daycares/peyredragon/users")
+			})
+
+			Context("When user is an office manager", func() {
+				BeforeEach(func() { claims[roles.ROLE_OFFICE_MANAGER] = true })
+				assertReturnedSingleUser(`{"scheduleId": "","daycareId":"peyredragon","id":"id5","firstName":"Sansa","lastName":"Stark","gender":"F","email":"sansa.stark@got.com","phone":"+3365651","address_1":"address","address_2":"floor","city":"Peyredragon","state":"WESTEROS","zip":"31400","imageUri":"gs://foo/bar.jpg","roles":["adult"],"workAddress_1": "work_address_1","workAddress_2": "work_address_2","workCity": "work_city","workState": "work_state","workZip": "work_zip","workPhone": "work_phone"}`)
+				assertHttpCode(http.StatusOK)
+				mockStorage.AssertStoredFile("daycares/peyredragon/users")
+			})
+
+			Context("When user is an office manager and updates another office manager", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_OFFICE_MANAGER] = true
+					claims["userId"] = "id2"
+					httpEndpointToUse = "/users/id3/image"
+				})
+				assertJsonResponse(`{"error":"cannot update the image of this user"}`)
+				assertHttpCode(http.StatusForbidden)
+			})
+
+			Context("When user is an adult and updates its own image", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADULT] = true
+					claims["userId"] = "id5"
+				})
+				assertReturnedSingleUser(`{"scheduleId": "","daycareId":"peyredragon","id":"id5","firstName":"Sansa","lastName":"Stark","gender":"F","email":"sansa.stark@got.com","phone":"+3365651","address_1":"address","address_2":"floor","city":"Peyredragon","state":"WESTEROS","zip":"31400","imageUri":"gs://foo/bar.jpg","roles":["adult"],"workAddress_1": "work_address_1","workAddress_2": "work_address_2","workCity": "work_city","workState": "work_state","workZip": "work_zip","workPhone": "work_phone"}`)
+				assertHttpCode(http.StatusOK)
+			})
+
+			Context("When user is a teacher and updates another user", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_TEACHER] = true
+					claims["userId"] = "id4"
+				})
+				assertJsonResponse(`{"error":"cannot update the image of this user"}`)
+				assertHttpCode(http.StatusForbidden)
+			})
+
+			Context("When user is an office manager from another daycare", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_OFFICE_MANAGER] = true
+					claims["daycareId"] = "namek"
+				})
+				assertJsonResponse(`{"error":"failed to update user: user not found"}`)
+				assertHttpCode(http.StatusNotFound)
+			})
+
+			Context("When the request has no image", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADMIN] = true
+					httpBodyToUse = `{"imageUri": "data:image/jpeg;base64,/9j/"}`
+					headersToUse.Set("Content-Type", "application/json")
+				})
+				assertJsonResponse(`{"error":"the request must be a multipart/form-data with an 'image' file"}`)
+				assertHttpCode(http.StatusBadRequest)
+			})
+
+			Context("When the image is too large", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADMIN] = true
+					mockStorage.Reset()
+					mockStorage.On("StoreFile", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return("", storage.ErrFileTooLarge)
+				})
+				assertJsonResponse(`{"error":"failed to store image: the image is too large"}`)
+				assertHttpCode(http.StatusRequestEntityTooLarge)
+			})
+
+			Context("When the declared type of the file is not its content", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADMIN] = true
+					var contentType string
+					httpBodyToUse, contentType = shared.NewMultipartImageOfType("image", "image/png", []byte("\xff\xd8\xff\xe0 jpeg content"))
+					headersToUse.Set("Content-Type", contentType)
+					mockStorage.Reset()
+					mockStorage.On("StoreFile", mock.Anything, mock.Anything, "image/png", mock.Anything).Return("", storage.ErrMismatchingFileFormat)
+				})
+				assertJsonResponse(`{"error": "failed to store image: the declared content type does not match the content of the file"}`)
+				assertHttpCode(http.StatusBadRequest)
+				It("should hand the declared type to the storage", func() {
+					calls := mockStorage.CallsForMethod("StoreFile")
+					Expect(calls).To(HaveLen(1))
+					Expect(calls[0].Arguments.String(2)).To(Equal("image/png"))
+				})
+			})
+		})
+	})
+
 	Describe("ADULTS", func() {
 
 		Describe("LIST", func() {
@@ -211,6 +322,13 @@ var _ = Describe("Transport", func() {
 				BeforeEach(func() { claims[roles.ROLE_ADMIN] = true })
 				assertReturnedUsersWithIds("id5", "id10")
 				assertHttpCode(http.StatusOK)
+				It("should return the thumbnails", func() {
+					usersTransport := []UserTransport{}
+					json.Unmarshal(recorder.Body.Bytes(), &usersTransport)
+					for _, user := range usersTransport {
+						Expect(user.ThumbnailUri).NotTo(BeNil())
+					}
+				})
 			})
 
 			Context("When user is an office manager", func() {
@@ -397,6 +515,28 @@ var _ = Describe("Transport", func() {
 				assertHttpCode(http.StatusNotFound)
 			})
 
+			Context("When the daycare of the user does not change", func() {
+				BeforeEach(func() { claims[roles.ROLE_ADMIN] = true })
+				It("should keep the claims of the user valid", func() {
+					var version int
+					Expect(concreteDb.Raw("SELECT claims_version FROM users WHERE user_id = 'id5'").Row().Scan(&version)).To(BeNil())
+					Expect(version).To(Equal(1))
+				})
+			})
+
+			Context("When an admin moves the user to another daycare", func() {
+				BeforeEach(func() {
+					claims[roles.ROLE_ADMIN] = true
+					httpBodyToUse = `{"daycareId": "namek", "imageUri": ""}`
+				})
+				assertHttpCode(http.StatusOK)
+				It("should outdate the claims of the user", func() {
+					var version int
+					Expect(concreteDb.Raw("SELECT claims_version FROM users WHERE user_id = 'id5'").Row().Scan(&version)).To(BeNil())
+					Expect(version).To(Equal(2))
+				})
+			})
+
 		})
 
 		Describe("CREATE", func() {
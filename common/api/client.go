@@ -8,12 +8,12 @@ import (
 	"net/http"
 	"net/url"
 
-	"github.com/Vinubaba/SANTC-API/common/roles"
 	"github.com/pkg/errors"
 )
 
 var (
 	ErrServerBadRequest       = errors.New("server responded with bad request")
+	ErrServerGone             = errors.New("server responded the resource is gone")
 	ErrServerError            = errors.New("server responded server error")
 	ErrServerUnexpectedStatus = errors.New("server responded with unexpected status")
 )
@@ -21,16 +21,22 @@ var (
 type Client interface {
 	AddImageApprovalRequest(ctx context.Context, approval PhotoRequestTransport) error
 	GetChild(ctx context.Context, childId string) (ChildTransport, error)
+	GetPhotoConsent(ctx context.Context, childId string) (PhotoConsentTransport, error)
+	Me(ctx context.Context, token string) (MeTransport, error)
+	IssueInvitationToken(ctx context.Context, invitationId string) (InvitationTokenTransport, error)
 }
 
 type DefaultClient struct {
 	protocol, hostname string
+	// nil sends the requests without authorization
+	credentials *ServiceCredentials
 }
 
-func NewDefaultClient(protocol, hostname string) (Client, error) {
+func NewDefaultClient(protocol, hostname string, credentials *ServiceCredentials) (Client, error) {
 	return &DefaultClient{
-		protocol: protocol,
-		hostname: hostname,
+		protocol:    protocol,
+		hostname:    hostname,
+		credentials: credentials,
 	}, nil
 }
 
@@ -50,7 +56,7 @@ func (c *DefaultClient) AddImageApprovalRequest(ctx context.Context, approval Ph
 		return errors.Wrap(err, "failed to build request")
 	}
 
-	_, err = c.performRequest(ctx, AsService(req))
+	_, err = c.performRequest(ctx, req)
 	if err != nil {
 		return errors.Wrap(err, "failed to perform request")
 	}
@@ -65,7 +71,7 @@ func (c *DefaultClient) GetChild(ctx context.Context, childId string) (ChildTran
 		return childTransport, errors.Wrap(err, "failed to build request")
 	}
 
-	resp, err := c.performRequest(ctx, AsService(req))
+	resp, err := c.performRequest(ctx, req)
 	if err != nil {
 		return childTransport, errors.Wrap(err, "failed to perform request")
 	}
@@ -75,8 +81,76 @@ func (c *DefaultClient) GetChild(ctx context.Context, childId string) (ChildTran
 	return childTransport, nil
 }
 
+func (c *DefaultClient) GetPhotoConsent(ctx context.Context, childId string) (PhotoConsentTransport, error) {
+	consentTransport := PhotoConsentTransport{}
+	requestUrl := url.URL{Scheme: c.protocol, Host: c.hostname, Path: "/api/v1/children/" + childId + "/photo-consent"}
+	req, err := http.NewRequest(http.MethodGet, requestUrl.String(), nil)
+	if err != nil {
+		return consentTransport, errors.Wrap(err, "failed to build request")
+	}
+
+	resp, err := c.performRequest(ctx, req)
+	if err != nil {
+		return consentTransport, errors.Wrap(err, "failed to perform request")
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&consentTransport); err != nil {
+		return consentTransport, errors.Wrap(err, "failed to decode json response")
+	}
+	return consentTransport, nil
+}
+
+// Me returns the user a token was issued for, the api verifies it with its identity provider
+func (c *DefaultClient) Me(ctx context.Context, token string) (MeTransport, error) {
+	userTransport := MeTransport{}
+	requestUrl := url.URL{Scheme: c.protocol, Host: c.hostname, Path: "/api/v1/me"}
+	req, err := http.NewRequest(http.MethodGet, requestUrl.String(), nil)
+	if err != nil {
+		return userTransport, errors.Wrap(err, "failed to build request")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.performRequest(ctx, req)
+	if err != nil {
+		return userTransport, errors.Wrap(err, "failed to perform request")
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&userTransport); err != nil {
+		return userTransport, errors.Wrap(err, "failed to decode json response")
+	}
+	return userTransport, nil
+}
+
+// IssueInvitationToken returns a new link of a pending invitation, the links issued before stop working
+func (c *DefaultClient) IssueInvitationToken(ctx context.Context, invitationId string) (InvitationTokenTransport, error) {
+	tokenTransport := InvitationTokenTransport{}
+	requestUrl := url.URL{Scheme: c.protocol, Host: c.hostname, Path: "/api/v1/invitations/" + invitationId + "/token"}
+	req, err := http.NewRequest(http.MethodPost, requestUrl.String(), nil)
+	if err != nil {
+		return tokenTransport, errors.Wrap(err, "failed to build request")
+	}
+
+	resp, err := c.performRequest(ctx, req)
+	if err != nil {
+		return tokenTransport, errors.Wrap(err, "failed to perform request")
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&tokenTransport); err != nil {
+		return tokenTransport, errors.Wrap(err, "failed to decode json response")
+	}
+	return tokenTransport, nil
+}
+
+// performRequest authorizes the request with the credentials of the service, unless it is already
 func (c *DefaultClient) performRequest(ctx context.Context, r *http.Request) (*http.Response, error) {
 	r = r.WithContext(ctx)
+	if c.credentials != nil && r.Header.Get("Authorization") == "" {
+		token, err := c.credentials.Token()
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
 	resp, err := http.DefaultClient.Do(r)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to execute the http request")
@@ -85,6 +159,8 @@ func (c *DefaultClient) performRequest(ctx context.Context, r *http.Request) (*h
 	switch {
 	case resp.StatusCode >= 200 && resp.StatusCode < 300:
 		return resp, nil
+	case resp.StatusCode == http.StatusGone:
+		err = ErrServerGone
 	case resp.StatusCode >= 400 && resp.StatusCode < 500:
 		err = ErrServerBadRequest
 	case resp.StatusCode >= 500:
@@ -97,8 +173,3 @@ func (c *DefaultClient) performRequest(ctx context.Context, r *http.Request) (*h
 	b, _ := ioutil.ReadAll(resp.Body)
 	return nil, errors.Wrapf(err, "server responded with status code %v, body: %s", resp.StatusCode, b)
 }
-
-func AsService(r *http.Request) *http.Request {
-	r.Header.Set(roles.ROLE_REQUEST_HEADER, roles.ROLE_SERVICE)
-	return r
-}
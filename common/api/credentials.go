@@ -0,0 +1,90 @@
+package api
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/common/jwt"
+
+	"github.com/pkg/errors"
+)
+
+// ServiceCredentials signs the tokens a service authenticates to the api with. The key id is the name of the
+// service, the api verifies the tokens with the public key it was given for that name
+type ServiceCredentials struct {
+	Service  string
+	Audience string
+	Key      *rsa.PrivateKey
+	Lifetime time.Duration
+
+	token     string
+	expiresAt time.Time
+	mutex     sync.Mutex
+}
+
+// LoadServiceCredentials reads a PEM encoded RSA private key, PKCS #1 or PKCS #8
+func LoadServiceCredentials(service, audience, keyFile string) (*ServiceCredentials, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read service key")
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf("no PEM block in %s", keyFile)
+	}
+
+	var key *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		var parsed interface{}
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if rsaKey, ok := parsed.(*rsa.PrivateKey); ok {
+			key = rsaKey
+		} else if err == nil {
+			err = errors.New("not a RSA key")
+		}
+	default:
+		err = errors.Errorf("unexpected PEM block %s", block.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid service key")
+	}
+
+	return &ServiceCredentials{
+		Service:  service,
+		Audience: audience,
+		Key:      key,
+		Lifetime: 5 * time.Minute,
+	}, nil
+}
+
+// Token returns the token of the previous requests until it is about to expire
+func (c *ServiceCredentials) Token() (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if c.token != "" && now.Add(time.Minute).Before(c.expiresAt) {
+		return c.token, nil
+	}
+	expiresAt := now.Add(c.Lifetime)
+	token, err := jwt.Sign(jwt.Claims{
+		"iss": c.Service,
+		"sub": c.Service,
+		"aud": c.Audience,
+		"iat": now.Unix(),
+		"exp": expiresAt.Unix(),
+	}, c.Service, c.Key)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign service token")
+	}
+	c.token = token
+	c.expiresAt = expiresAt
+	return token, nil
+}
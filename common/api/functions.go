@@ -1,8 +1,19 @@
 package api
 
+import "time"
+
 func IsNilOrEmpty(value *string) bool {
 	if value == nil {
 		return true
 	}
 	return *value == ""
 }
+
+// ExpiresAt tells clients when a signed url must be refreshed, nil without url
+func ExpiresAt(expires time.Time) *string {
+	if expires.IsZero() {
+		return nil
+	}
+	ret := expires.UTC().Format(time.RFC3339)
+	return &ret
+}
@@ -1,13 +1,54 @@
 package api
 
+import "io"
+
 type PhotoRequestTransport struct {
 	Filename        *string `json:"filename"`
+	ThumbnailUri    *string `json:"thumbnailUri,omitempty"`
 	ChildId         *string `json:"childId"`
 	PublishedBy     *string `json:"publishedBy"`
 	PhotoId         *string `json:"photoId"`
 	ApprovedBy      *string `json:"approvedBy"`
 	Approved        *bool   `json:"approved"`
 	PublicationDate *string `json:"publicationDate"`
+	// Every child appearing on the photo. When publishing, childId does not need to be repeated
+	TaggedChildIds  []string `json:"taggedChildIds"`
+	Rejected        *bool    `json:"rejected,omitempty"`
+	RejectedBy      *string  `json:"rejectedBy,omitempty"`
+	RejectionReason *string  `json:"rejectionReason,omitempty"`
+
+	// When the signed urls of the photo and of its thumbnail expire, RFC 3339
+	FilenameExpiresAt     *string `json:"filenameExpiresAt,omitempty"`
+	ThumbnailUriExpiresAt *string `json:"thumbnailUriExpiresAt,omitempty"`
+}
+
+type PhotoConsentTransport struct {
+	ChildId   *string `json:"childId"`
+	Granted   *bool   `json:"granted"`
+	Scope     *string `json:"scope"` // internal or shareable
+	GrantedBy *string `json:"grantedBy"`
+	GrantedAt *string `json:"grantedAt"`
+}
+
+// MeTransport is the part of the user returned by /me the other services read
+type MeTransport struct {
+	Id        *string  `json:"id"`
+	Email     *string  `json:"email"`
+	DaycareId *string  `json:"daycareId"`
+	Roles     []string `json:"roles"`
+}
+
+// InvitationTokenTransport is the link of the email of an invitation, holding its token
+type InvitationTokenTransport struct {
+	AcceptUrl *string `json:"acceptUrl"`
+}
+
+// ImageUploadTransport is an image sent as a multipart/form-data file instead of a base64 data uri
+type ImageUploadTransport struct {
+	Id    *string
+	Image io.Reader
+	// declared content type of the image, the storage checks it against the content
+	ContentType string
 }
 
 type ChildTransport struct {
@@ -20,6 +61,7 @@ type ChildTransport struct {
 	BirthDate           *string                       `json:"birthDate"` // dd/mm/yyyy
 	Gender              *string                       `json:"gender"`
 	ImageUri            *string                       `json:"imageUri"`
+	ThumbnailUri        *string                       `json:"thumbnailUri,omitempty"`
 	StartDate           *string                       `json:"startDate"` // dd/mm/yyyy
 	Notes               *string                       `json:"notes"`
 	Allergies           []AllergyTransport            `json:"allergies"`
@@ -27,6 +69,10 @@ type ChildTransport struct {
 	Relationship        *string                       `json:"relationship"`
 	SpecialInstructions []SpecialInstructionTransport `json:"specialInstructions"`
 	Schedule            ScheduleTransport             `json:"schedule"`
+
+	// When the signed urls of the image and of the thumbnail expire, RFC 3339
+	ImageUriExpiresAt     *string `json:"imageUriExpiresAt,omitempty"`
+	ThumbnailUriExpiresAt *string `json:"thumbnailUriExpiresAt,omitempty"`
 }
 
 type AllergyTransport struct {
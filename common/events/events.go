@@ -0,0 +1,193 @@
+// Package events describes the domain events the api publishes through the outbox. A payload never changes once
+// published: a breaking change makes a new version of the event, consumers may receive both for a while
+package events
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	TYPE_CHILD_CREATED  = "childCreated"
+	TYPE_CHILD_ENROLLED = "childEnrolled"
+	TYPE_PHOTO_APPROVED = "photoApproved"
+	TYPE_USER_DELETED   = "userDeleted"
+
+	TYPE_INVITATION_CREATED = "invitationCreated"
+)
+
+// Attributes of the published messages, consumers can tell the domain events apart without decoding them
+const (
+	ATTRIBUTE_TYPE    = "type"
+	ATTRIBUTE_VERSION = "version"
+)
+
+// Payload is the content of an event, each version of each type has its own struct
+type Payload interface {
+	EventType() string
+	EventVersion() int
+}
+
+// Envelope is what is published, the payload is decoded according to the type and the version
+type Envelope struct {
+	Id         string          `json:"id"`
+	Type       string          `json:"type"`
+	Version    int             `json:"version"`
+	OccurredAt time.Time       `json:"occurredAt"`
+	DaycareId  string          `json:"daycareId"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+func NewEnvelope(id, daycareId string, occurredAt time.Time, payload Payload) (Envelope, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{
+		Id:         id,
+		Type:       payload.EventType(),
+		Version:    payload.EventVersion(),
+		OccurredAt: occurredAt,
+		DaycareId:  daycareId,
+		Payload:    data,
+	}, nil
+}
+
+type ChildCreatedV1 struct {
+	ChildId string `json:"childId"`
+	// Empty when the child is not in a class yet
+	ClassId string `json:"classId"`
+}
+
+func (ChildCreatedV1) EventType() string {
+	return TYPE_CHILD_CREATED
+}
+
+func (ChildCreatedV1) EventVersion() int {
+	return 1
+}
+
+// ChildEnrolledV1 is emitted when a child joins a class, at its creation or later
+type ChildEnrolledV1 struct {
+	ChildId string `json:"childId"`
+	ClassId string `json:"classId"`
+	// Empty when the child was in no class
+	PreviousClassId string `json:"previousClassId"`
+}
+
+func (ChildEnrolledV1) EventType() string {
+	return TYPE_CHILD_ENROLLED
+}
+
+func (ChildEnrolledV1) EventVersion() int {
+	return 1
+}
+
+type PhotoApprovedV1 struct {
+	PhotoId     string `json:"photoId"`
+	ChildId     string `json:"childId"`
+	PublishedBy string `json:"publishedBy"`
+	ApprovedBy  string `json:"approvedBy"`
+	// Other children appearing on the photo
+	TaggedChildIds []string `json:"taggedChildIds"`
+}
+
+func (PhotoApprovedV1) EventType() string {
+	return TYPE_PHOTO_APPROVED
+}
+
+func (PhotoApprovedV1) EventVersion() int {
+	return 1
+}
+
+type UserDeletedV1 struct {
+	UserId string   `json:"userId"`
+	Roles  []string `json:"roles"`
+}
+
+func (UserDeletedV1) EventType() string {
+	return TYPE_USER_DELETED
+}
+
+func (UserDeletedV1) EventVersion() int {
+	return 1
+}
+
+// InvitationCreatedV1 asks for the email of an invitation to be sent. It holds no token, the link of the email is
+// issued by the api to the service sending it
+type InvitationCreatedV1 struct {
+	InvitationId string    `json:"invitationId"`
+	Email        string    `json:"email"`
+	FirstName    string    `json:"firstName"`
+	LastName     string    `json:"lastName"`
+	DaycareName  string    `json:"daycareName"`
+	Roles        []string  `json:"roles"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+func (InvitationCreatedV1) EventType() string {
+	return TYPE_INVITATION_CREATED
+}
+
+func (InvitationCreatedV1) EventVersion() int {
+	return 1
+}
+
+// schemas of the payloads, keyed by type then version, they describe the structs above for the consumers
+var schemas = map[string]map[int]string{
+	TYPE_CHILD_CREATED: {1: `{
+		"type": "object",
+		"required": ["childId"],
+		"properties": {
+			"childId": {"type": "string", "minLength": 1},
+			"classId": {"type": "string"}
+		}
+	}`},
+	TYPE_CHILD_ENROLLED: {1: `{
+		"type": "object",
+		"required": ["childId", "classId"],
+		"properties": {
+			"childId": {"type": "string", "minLength": 1},
+			"classId": {"type": "string", "minLength": 1},
+			"previousClassId": {"type": "string"}
+		}
+	}`},
+	TYPE_PHOTO_APPROVED: {1: `{
+		"type": "object",
+		"required": ["photoId", "childId"],
+		"properties": {
+			"photoId": {"type": "string", "minLength": 1},
+			"childId": {"type": "string", "minLength": 1},
+			"publishedBy": {"type": "string"},
+			"approvedBy": {"type": "string"},
+			"taggedChildIds": {"type": ["array", "null"], "items": {"type": "string"}}
+		}
+	}`},
+	TYPE_USER_DELETED: {1: `{
+		"type": "object",
+		"required": ["userId"],
+		"properties": {
+			"userId": {"type": "string", "minLength": 1},
+			"roles": {"type": ["array", "null"], "items": {"type": "string"}}
+		}
+	}`},
+	TYPE_INVITATION_CREATED: {1: `{
+		"type": "object",
+		"required": ["invitationId", "email", "expiresAt"],
+		"properties": {
+			"invitationId": {"type": "string", "minLength": 1},
+			"email": {"type": "string", "minLength": 1},
+			"firstName": {"type": "string"},
+			"lastName": {"type": "string"},
+			"daycareName": {"type": "string"},
+			"roles": {"type": ["array", "null"], "items": {"type": "string"}},
+			"expiresAt": {"type": "string", "minLength": 1}
+		}
+	}`},
+}
+
+// PayloadSchema returns the JSON schema of a version of an event published by the api
+func PayloadSchema(eventType string, version int) (string, bool) {
+	schema, ok := schemas[eventType][version]
+	return schema, ok
+}
@@ -0,0 +1,205 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is the subset of JSON schema the payloads need: type, properties, required, additionalProperties, items,
+// enum, minLength and maxLength. Other keywords, like $schema or description, are ignored
+type Schema struct {
+	Type                 schemaTypes        `json:"type"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []interface{}      `json:"enum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+}
+
+// ValidationError lists every part of a document not matching its schema
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "invalid document: " + strings.Join(e, ", ")
+}
+
+// schemaTypes is either a single type or a list of types
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("type must be a string or a list of strings")
+	}
+	*t = list
+	return nil
+}
+
+var knownTypes = map[string]bool{
+	"null":    true,
+	"boolean": true,
+	"integer": true,
+	"number":  true,
+	"string":  true,
+	"array":   true,
+	"object":  true,
+}
+
+func ParseSchema(schema string) (*Schema, error) {
+	s := &Schema{}
+	if err := json.Unmarshal([]byte(schema), s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	if err := s.check(); err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	return s, nil
+}
+
+func MustParseSchema(schema string) *Schema {
+	s, err := ParseSchema(schema)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (s *Schema) check() error {
+	for _, t := range s.Type {
+		if !knownTypes[t] {
+			return fmt.Errorf("unknown type %s", t)
+		}
+	}
+	for name, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("property %s has no schema", name)
+		}
+		if err := property.check(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.check()
+	}
+	return nil
+}
+
+// Validate returns a ValidationError when the document does not match the schema
+func (s *Schema) Validate(document []byte) error {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("invalid json: %v", err)
+	}
+	if errs := s.validate("$", value); len(errs) > 0 {
+		return ValidationError(errs)
+	}
+	return nil
+}
+
+func (s *Schema) validate(path string, value interface{}) []string {
+	if len(s.Type) > 0 && !s.hasType(value) {
+		return []string{fmt.Sprintf("%s must be of type %s", path, strings.Join(s.Type, " or "))}
+	}
+
+	errs := []string{}
+	if len(s.Enum) > 0 && !inEnum(value, s.Enum) {
+		errs = append(errs, fmt.Sprintf("%s is not one of the allowed values", path))
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			errs = append(errs, fmt.Sprintf("%s must be at least %d characters long", path, *s.MinLength))
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			errs = append(errs, fmt.Sprintf("%s must be at most %d characters long", path, *s.MaxLength))
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				errs = append(errs, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				errs = append(errs, fmt.Sprintf("%s.%s is required", path, name))
+			}
+		}
+		names := []string{}
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					errs = append(errs, fmt.Sprintf("%s.%s is not allowed", path, name))
+				}
+				continue
+			}
+			errs = append(errs, property.validate(path+"."+name, v[name])...)
+		}
+	}
+	return errs
+}
+
+func (s *Schema) hasType(value interface{}) bool {
+	actual := typeOf(value)
+	for _, t := range s.Type {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// inEnum compares the json encodings, numbers of the document and of the schema are not decoded the same way
+func inEnum(value interface{}, enum []interface{}) bool {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range enum {
+		encodedAllowed, err := json.Marshal(allowed)
+		if err == nil && bytes.Equal(encoded, encodedAllowed) {
+			return true
+		}
+	}
+	return false
+}
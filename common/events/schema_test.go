@@ -0,0 +1,72 @@
+package events_test
+
+import (
+	"encoding/json"
+
+	. "github.com/Vinubaba/SANTC-API/common/events"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Schema", func() {
+
+	var schema = MustParseSchema(`{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type": "object",
+		"required": ["childId", "image"],
+		"additionalProperties": false,
+		"properties": {
+			"childId": {"type": "string", "minLength": 1},
+			"image": {"type": "string"},
+			"scope": {"enum": ["internal", "shareable"]},
+			"count": {"type": "integer"},
+			"taggedChildIds": {"type": ["array", "null"], "items": {"type": "string"}}
+		}
+	}`)
+
+	It("should accept a matching document", func() {
+		Expect(schema.Validate([]byte(`{"childId": "aaa", "image": "data", "scope": "internal", "count": 2, "taggedChildIds": ["bbb"]}`))).To(BeNil())
+		Expect(schema.Validate([]byte(`{"childId": "aaa", "image": "data", "taggedChildIds": null}`))).To(BeNil())
+	})
+
+	It("should list every mismatch", func() {
+		err := schema.Validate([]byte(`{"childId": "", "count": 1.5, "scope": "public", "taggedChildIds": [1], "other": true}`))
+		Expect(err).To(Equal(ValidationError{
+			"$.image is required",
+			"$.childId must be at least 1 characters long",
+			"$.count must be of type integer",
+			"$.other is not allowed",
+			"$.scope is not one of the allowed values",
+			"$.taggedChildIds[0] must be of type string",
+		}))
+	})
+
+	It("should reject a document of another type", func() {
+		Expect(schema.Validate([]byte(`["aaa"]`))).To(Equal(ValidationError{"$ must be of type object"}))
+	})
+
+	It("should reject invalid json", func() {
+		Expect(schema.Validate([]byte(`{`))).NotTo(BeNil())
+	})
+
+	It("should reject unknown types", func() {
+		_, err := ParseSchema(`{"type": "uuid"}`)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("should describe the payloads of the api", func() {
+		for _, payload := range []Payload{
+			ChildCreatedV1{ChildId: "aaa"},
+			ChildEnrolledV1{ChildId: "aaa", ClassId: "blue"},
+			PhotoApprovedV1{PhotoId: "ppp", ChildId: "aaa"},
+			UserDeletedV1{UserId: "uuu"},
+		} {
+			schema, ok := PayloadSchema(payload.EventType(), payload.EventVersion())
+			Expect(ok).To(BeTrue())
+			data, err := json.Marshal(payload)
+			Expect(err).To(BeNil())
+			Expect(MustParseSchema(schema).Validate(data)).To(BeNil())
+		}
+	})
+})
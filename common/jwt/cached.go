@@ -0,0 +1,110 @@
+package jwt
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CachedKeySet verifies the tokens with keys loaded from a file or from the jwks_uri of an issuer. The keys are
+// loaded again once they are older than MaxAge, or when a token has an unknown kid since the issuer may have
+// rotated its keys
+type CachedKeySet struct {
+	Load   func() (KeySet, error)
+	MaxAge time.Duration
+	// least time between two loads, tokens of unknown kids do not make the issuer called for each of them
+	MinRefreshInterval time.Duration
+
+	keySet      KeySet
+	loadedAt    time.Time
+	attemptedAt time.Time
+	mutex       sync.Mutex
+}
+
+func NewFileKeySet(path string, maxAge time.Duration) *CachedKeySet {
+	return &CachedKeySet{
+		Load: func() (KeySet, error) {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return KeySet{}, errors.Wrap(err, "failed to read key set")
+			}
+			return parseKeySet(data)
+		},
+		MaxAge:             maxAge,
+		MinRefreshInterval: time.Minute,
+	}
+}
+
+func NewRemoteKeySet(client *http.Client, url string, maxAge time.Duration) *CachedKeySet {
+	return &CachedKeySet{
+		Load: func() (KeySet, error) {
+			resp, err := client.Get(url)
+			if err != nil {
+				return KeySet{}, errors.Wrap(err, "failed to fetch key set")
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return KeySet{}, errors.Errorf("failed to fetch key set: %s returned %d", url, resp.StatusCode)
+			}
+			data, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return KeySet{}, errors.Wrap(err, "failed to fetch key set")
+			}
+			return parseKeySet(data)
+		},
+		MaxAge:             maxAge,
+		MinRefreshInterval: time.Minute,
+	}
+}
+
+// Key is a KeyFunc
+func (c *CachedKeySet) Key(keyId string) (*rsa.PublicKey, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.loadedAt.IsZero() || (time.Since(c.loadedAt) > c.MaxAge && c.canLoad()) {
+		if err := c.load(); err != nil {
+			return nil, err
+		}
+	}
+	key, err := c.keySet.Key(keyId)
+	if err == ErrUnknownKey && c.canLoad() {
+		if err := c.load(); err != nil {
+			return nil, err
+		}
+		return c.keySet.Key(keyId)
+	}
+	return key, err
+}
+
+func (c *CachedKeySet) canLoad() bool {
+	return time.Since(c.attemptedAt) > c.MinRefreshInterval
+}
+
+func (c *CachedKeySet) load() error {
+	c.attemptedAt = time.Now()
+	keySet, err := c.Load()
+	if err != nil {
+		// the keys already loaded keep verifying the tokens while the issuer is unavailable
+		if !c.loadedAt.IsZero() {
+			return nil
+		}
+		return err
+	}
+	c.keySet = keySet
+	c.loadedAt = time.Now()
+	return nil
+}
+
+func parseKeySet(data []byte) (KeySet, error) {
+	keySet := KeySet{}
+	if err := json.Unmarshal(data, &keySet); err != nil {
+		return KeySet{}, errors.Wrap(err, "invalid key set")
+	}
+	return keySet, nil
+}
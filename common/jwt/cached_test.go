@@ -0,0 +1,102 @@
+package jwt_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/Vinubaba/SANTC-API/common/jwt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CachedKeySet", func() {
+
+	var (
+		key       *rsa.PrivateKey
+		published KeySet
+		requests  int
+		server    *httptest.Server
+	)
+
+	BeforeEach(func() {
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).To(BeNil())
+		published = KeySet{Keys: []JSONWebKey{NewJSONWebKey("key-1", &key.PublicKey)}}
+		requests = 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			json.NewEncoder(w).Encode(published)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should load the keys once", func() {
+		keySet := NewRemoteKeySet(http.DefaultClient, server.URL, time.Hour)
+
+		for i := 0; i < 3; i++ {
+			publicKey, err := keySet.Key("key-1")
+			Expect(err).To(BeNil())
+			Expect(publicKey.N).To(Equal(key.PublicKey.N))
+		}
+		Expect(requests).To(Equal(1))
+	})
+
+	It("should load the keys again for an unknown kid", func() {
+		keySet := NewRemoteKeySet(http.DefaultClient, server.URL, time.Hour)
+		keySet.MinRefreshInterval = 0
+		_, err := keySet.Key("key-1")
+		Expect(err).To(BeNil())
+
+		published.Keys = append(published.Keys, NewJSONWebKey("key-2", &key.PublicKey))
+		_, err = keySet.Key("key-2")
+		Expect(err).To(BeNil())
+		Expect(requests).To(Equal(2))
+	})
+
+	It("should not load the keys again for each unknown kid", func() {
+		keySet := NewRemoteKeySet(http.DefaultClient, server.URL, time.Hour)
+
+		for i := 0; i < 3; i++ {
+			_, err := keySet.Key("key-2")
+			Expect(err).To(Equal(ErrUnknownKey))
+		}
+		Expect(requests).To(Equal(1))
+	})
+
+	It("should keep the keys loaded when the issuer is unavailable", func() {
+		keySet := NewRemoteKeySet(http.DefaultClient, server.URL, 0)
+		keySet.MinRefreshInterval = 0
+		_, err := keySet.Key("key-1")
+		Expect(err).To(BeNil())
+
+		server.Close()
+		_, err = keySet.Key("key-1")
+		Expect(err).To(BeNil())
+	})
+
+	It("should load the keys of a file", func() {
+		dir, err := ioutil.TempDir("", "jwks")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		data, err := json.Marshal(published)
+		Expect(err).To(BeNil())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "jwks.json"), data, 0600)).To(BeNil())
+
+		keySet := NewFileKeySet(filepath.Join(dir, "jwks.json"), time.Hour)
+		publicKey, err := keySet.Key("key-1")
+		Expect(err).To(BeNil())
+		Expect(publicKey.E).To(Equal(key.PublicKey.E))
+	})
+})
@@ -0,0 +1,62 @@
+package jwt
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// JSONWebKey is the public part of a RSA key, as published by the jwks_uri of the issuers
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyId     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+type KeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+func NewJSONWebKey(keyId string, key *rsa.PublicKey) JSONWebKey {
+	return JSONWebKey{
+		KeyType:   "RSA",
+		KeyId:     keyId,
+		Use:       "sig",
+		Algorithm: ALGORITHM_RS256,
+		Modulus:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func (k JSONWebKey) PublicKey() (*rsa.PublicKey, error) {
+	if k.KeyType != "RSA" {
+		return nil, errors.Errorf("key %s is not a RSA key", k.KeyId)
+	}
+	modulus, err := base64.RawURLEncoding.DecodeString(k.Modulus)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid modulus for key %s", k.KeyId)
+	}
+	exponent, err := base64.RawURLEncoding.DecodeString(k.Exponent)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid exponent for key %s", k.KeyId)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(modulus),
+		E: int(new(big.Int).SetBytes(exponent).Int64()),
+	}, nil
+}
+
+// Key returns the public key having the kid, a key set of a single key also verifies the tokens without kid
+func (s KeySet) Key(keyId string) (*rsa.PublicKey, error) {
+	for _, key := range s.Keys {
+		if key.KeyId == keyId || (keyId == "" && len(s.Keys) == 1) {
+			return key.PublicKey()
+		}
+	}
+	return nil, ErrUnknownKey
+}
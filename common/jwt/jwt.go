@@ -0,0 +1,156 @@
+// Package jwt signs and verifies the RS256 json web tokens of the local identity provider, of the OpenID Connect
+// providers and of the services
+package jwt
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const ALGORITHM_RS256 = "RS256"
+
+var (
+	ErrMalformedToken       = errors.New("malformed token")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrInvalidSignature     = errors.New("invalid token signature")
+	ErrExpiredToken         = errors.New("token is expired")
+	ErrTokenNotValidYet     = errors.New("token is not valid yet")
+	ErrUnknownKey           = errors.New("unknown signing key")
+)
+
+// clocks of the issuers are allowed that much drift
+const leeway = time.Minute
+
+type Header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyId     string `json:"kid,omitempty"`
+}
+
+// Claims are the content of a token, numbers are decoded as float64 like any json
+type Claims map[string]interface{}
+
+func (c Claims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// Audiences returns the aud claim, which is either a string or a list of strings
+func (c Claims) Audiences() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		audiences := []string{}
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+		return audiences
+	}
+	return nil
+}
+
+// Time reads the NumericDate claims, e.g exp
+func (c Claims) Time(name string) (time.Time, bool) {
+	value, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(value), 0), true
+}
+
+// KeyFunc returns the public key a token was signed with, given the kid of its header
+type KeyFunc func(keyId string) (*rsa.PublicKey, error)
+
+// Sign returns a RS256 token of the claims, kid tells the verifiers which of their keys to use
+func Sign(claims Claims, keyId string, key *rsa.PrivateKey) (string, error) {
+	header, err := encodeSegment(Header{Algorithm: ALGORITHM_RS256, Type: "JWT", KeyId: keyId})
+	if err != nil {
+		return "", err
+	}
+	payload, err := encodeSegment(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := header + "." + payload
+	hash := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign token")
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks the signature of the token with the key returned by keyFunc and its exp and nbf claims. A token
+// without exp would never expire, it is malformed. The issuer and the audience are left to the caller
+func Verify(token string, keyFunc KeyFunc) (Claims, error) {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	header := Header{}
+	if err := decodeSegment(segments[0], &header); err != nil {
+		return nil, ErrMalformedToken
+	}
+	if header.Algorithm != ALGORITHM_RS256 {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	key, err := keyFunc(header.KeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	hash := sha256.Sum256([]byte(segments[0] + "." + segments[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	claims := Claims{}
+	if err := decodeSegment(segments[1], &claims); err != nil {
+		return nil, ErrMalformedToken
+	}
+	now := time.Now()
+	exp, ok := claims.Time("exp")
+	if !ok {
+		return nil, ErrMalformedToken
+	}
+	if now.After(exp.Add(leeway)) {
+		return nil, ErrExpiredToken
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Add(leeway).Before(nbf) {
+		return nil, ErrTokenNotValidYet
+	}
+	return claims, nil
+}
+
+func encodeSegment(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeSegment(segment string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
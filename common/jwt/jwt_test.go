@@ -0,0 +1,104 @@
+package jwt_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"strings"
+	"time"
+
+	. "github.com/Vinubaba/SANTC-API/common/jwt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Jwt", func() {
+
+	var (
+		key      *rsa.PrivateKey
+		otherKey *rsa.PrivateKey
+		keySet   KeySet
+	)
+
+	BeforeEach(func() {
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).To(BeNil())
+		otherKey, err = rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).To(BeNil())
+		keySet = KeySet{Keys: []JSONWebKey{NewJSONWebKey("key-1", &key.PublicKey), NewJSONWebKey("key-2", &otherKey.PublicKey)}}
+	})
+
+	It("should verify the tokens it signed", func() {
+		token, err := Sign(Claims{"sub": "goku", "aud": "teddycare", "exp": time.Now().Add(time.Hour).Unix()}, "key-1", key)
+		Expect(err).To(BeNil())
+
+		claims, err := Verify(token, keySet.Key)
+		Expect(err).To(BeNil())
+		Expect(claims.String("sub")).To(Equal("goku"))
+		Expect(claims.Audiences()).To(Equal([]string{"teddycare"}))
+	})
+
+	It("should refuse the tokens signed by another key", func() {
+		token, err := Sign(Claims{"sub": "goku"}, "key-1", otherKey)
+		Expect(err).To(BeNil())
+
+		_, err = Verify(token, keySet.Key)
+		Expect(err).To(Equal(ErrInvalidSignature))
+	})
+
+	It("should refuse the tokens of unknown keys", func() {
+		token, err := Sign(Claims{"sub": "goku"}, "key-3", key)
+		Expect(err).To(BeNil())
+
+		_, err = Verify(token, keySet.Key)
+		Expect(err).To(Equal(ErrUnknownKey))
+	})
+
+	It("should refuse the expired tokens", func() {
+		token, err := Sign(Claims{"sub": "goku", "exp": time.Now().Add(-time.Hour).Unix()}, "key-1", key)
+		Expect(err).To(BeNil())
+
+		_, err = Verify(token, keySet.Key)
+		Expect(err).To(Equal(ErrExpiredToken))
+	})
+
+	It("should refuse the tokens without expiration", func() {
+		token, err := Sign(Claims{"sub": "goku"}, "key-1", key)
+		Expect(err).To(BeNil())
+
+		_, err = Verify(token, keySet.Key)
+		Expect(err).To(Equal(ErrMalformedToken))
+	})
+
+	It("should refuse the tokens not valid yet", func() {
+		token, err := Sign(Claims{"sub": "goku", "nbf": time.Now().Add(time.Hour).Unix(), "exp": time.Now().Add(2 * time.Hour).Unix()}, "key-1", key)
+		Expect(err).To(BeNil())
+
+		_, err = Verify(token, keySet.Key)
+		Expect(err).To(Equal(ErrTokenNotValidYet))
+	})
+
+	It("should refuse the unsigned tokens", func() {
+		token, err := Sign(Claims{"sub": "goku"}, "key-1", key)
+		Expect(err).To(BeNil())
+		segments := strings.Split(token, ".")
+		unsigned := "eyJhbGciOiJub25lIiwidHlwIjoiSldUIn0." + segments[1] + "."
+
+		_, err = Verify(unsigned, keySet.Key)
+		Expect(err).To(Equal(ErrUnsupportedAlgorithm))
+	})
+
+	It("should read the keys it published", func() {
+		data, err := json.Marshal(keySet)
+		Expect(err).To(BeNil())
+		published := KeySet{}
+		Expect(json.Unmarshal(data, &published)).To(BeNil())
+
+		publicKey, err := published.Key("key-2")
+		Expect(err).To(BeNil())
+		Expect(publicKey.N).To(Equal(otherKey.PublicKey.N))
+		Expect(publicKey.E).To(Equal(otherKey.PublicKey.E))
+	})
+})
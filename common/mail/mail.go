@@ -0,0 +1,152 @@
+// Package mail sends the emails of teddycare, e.g the invitations. In development the SMTP server can be a sink
+// such as MailHog keeping the emails instead of delivering them
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/common/log"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	BACKEND_SMTP = "smtp"
+	BACKEND_LOG  = "log"
+)
+
+var (
+	ErrInvalidAddress = errors.New("invalid email address")
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Sender interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// SMTPSender delivers the emails to an SMTP server. The connection is upgraded with STARTTLS when the server offers
+// it, Username and Password are only sent over TLS or to localhost
+type SMTPSender struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s *SMTPSender) Send(ctx context.Context, message Message) error {
+	from, err := parseAddress(s.From)
+	if err != nil {
+		return errors.Wrap(err, "invalid sender")
+	}
+	to, err := parseAddress(message.To)
+	if err != nil {
+		return err
+	}
+	data, err := s.format(from, to, message)
+	if err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return errors.Wrap(err, "invalid smtp address")
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to the smtp server")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "failed to connect to the smtp server")
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return errors.Wrap(err, "failed to start tls")
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return errors.Wrap(err, "failed to authenticate to the smtp server")
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return errors.Wrap(err, "smtp server refused the sender")
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return errors.Wrap(err, "smtp server refused the recipient")
+	}
+	w, err := client.Data()
+	if err != nil {
+		return errors.Wrap(err, "failed to send email")
+	}
+	if _, err := w.Write(data); err != nil {
+		return errors.Wrap(err, "failed to send email")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "failed to send email")
+	}
+	return client.Quit()
+}
+
+func (s *SMTPSender) format(from, to *netmail.Address, message Message) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	w := quotedprintable.NewWriter(buf)
+	if _, err := w.Write([]byte(message.Body)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// LogSender only logs the emails, links included, for running without SMTP server
+type LogSender struct {
+	Logger *log.Logger `inject:""`
+}
+
+func (s *LogSender) Send(ctx context.Context, message Message) error {
+	if _, err := parseAddress(message.To); err != nil {
+		return err
+	}
+	s.Logger.Info(ctx, "email not sent, mail backend is log", "to", message.To, "subject", message.Subject, "body", message.Body)
+	return nil
+}
+
+// parseAddress also refuses the line breaks which would add headers to the email
+func parseAddress(address string) (*netmail.Address, error) {
+	parsed, err := netmail.ParseAddress(address)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidAddress, err.Error())
+	}
+	return parsed, nil
+}
@@ -0,0 +1,114 @@
+package mail_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+
+	. "github.com/Vinubaba/SANTC-API/common/mail"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+// fakeSmtpServer accepts one email and hands its envelope and its data to received
+func fakeSmtpServer(received chan<- []string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		lines := []string{}
+		reply("220 localhost")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(line, "MAIL"), strings.HasPrefix(line, "RCPT"):
+				lines = append(lines, line)
+				reply("250 OK")
+			case line == "DATA":
+				reply("354 go ahead")
+				for {
+					data, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					data = strings.TrimRight(data, "\r\n")
+					if data == "." {
+						break
+					}
+					lines = append(lines, data)
+				}
+				reply("250 OK")
+			case line == "QUIT":
+				reply("221 bye")
+				received <- lines
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return listener
+}
+
+var _ = Describe("Mail", func() {
+
+	var (
+		received chan []string
+		listener net.Listener
+		sender   *SMTPSender
+		message  Message
+	)
+
+	BeforeEach(func() {
+		received = make(chan []string, 1)
+		listener = fakeSmtpServer(received)
+		sender = &SMTPSender{
+			Addr: listener.Addr().String(),
+			From: "TeddyCare <noreply@teddycare.com>",
+		}
+		message = Message{
+			To:      "arya.stark@got.com",
+			Subject: "Invitation à TeddyCare",
+			Body:    "Hello Arya",
+		}
+	})
+
+	AfterEach(func() {
+		listener.Close()
+	})
+
+	It("should deliver the email to the smtp server", func() {
+		Expect(sender.Send(context.Background(), message)).To(BeNil())
+
+		var lines []string
+		Eventually(received).Should(Receive(&lines))
+		Expect(lines).To(ContainElement("MAIL FROM:<noreply@teddycare.com>"))
+		Expect(lines).To(ContainElement("RCPT TO:<arya.stark@got.com>"))
+		Expect(lines).To(ContainElement(`From: "TeddyCare" <noreply@teddycare.com>`))
+		Expect(lines).To(ContainElement("To: <arya.stark@got.com>"))
+		Expect(lines).To(ContainElement("Subject: =?utf-8?q?Invitation_=C3=A0_TeddyCare?="))
+		Expect(lines).To(ContainElement("Hello Arya"))
+	})
+
+	It("should refuse a recipient adding headers", func() {
+		message.To = "arya.stark@got.com\r\nBcc: everyone@got.com"
+		Expect(errors.Cause(sender.Send(context.Background(), message))).To(Equal(ErrInvalidAddress))
+	})
+})
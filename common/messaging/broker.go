@@ -0,0 +1,24 @@
+package messaging
+
+import "context"
+
+const (
+	BROKER_PUBSUB   = "pubsub"
+	BROKER_POSTGRES = "postgres"
+	BROKER_MEMORY   = "memory"
+)
+
+type Publisher interface {
+	Publish(ctx context.Context, message Message) error
+}
+
+// Subscriber calls back for every message until the context is done. Messages are delivered again until they are
+// acked
+type Subscriber interface {
+	Subscribe(ctx context.Context, callback SubscribeCallbackFunc) error
+}
+
+type Broker interface {
+	Publisher
+	Subscriber
+}
@@ -2,7 +2,7 @@ package messaging
 
 import (
 	"context"
-	"fmt"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/pkg/errors"
@@ -15,6 +15,7 @@ type Client struct {
 	topic              *pubsub.Topic
 	subscription       *pubsub.Subscription
 	projectID          string
+	maxNackDelay       time.Duration
 }
 
 type ClientOptions struct {
@@ -22,13 +23,16 @@ type ClientOptions struct {
 	Topic          string
 	Subscription   string
 	CredentialPath string
+	// Longest delay of NackAfter. A message waiting to be nacked is held by the client, it counts as outstanding and
+	// a restart loses the delay, keep it well below the ack deadline. 0 nacks right away
+	MaxNackDelay time.Duration
 }
 
 type SubscribeCallbackFunc func(ctx context.Context, msg Message)
 
 func New(config ClientOptions) (*Client, error) {
 	var err error
-	client := &Client{}
+	client := &Client{maxNackDelay: config.MaxNackDelay}
 	client.googlePubSubClient, err = pubsub.NewClient(context.Background(), config.ProjectID, option.WithCredentialsFile(config.CredentialPath))
 	if err != nil {
 		return nil, err
@@ -81,6 +85,18 @@ func (s *Client) newMessageFromPubSubMessage(pubSubMsg *pubsub.Message) (msg Mes
 		pubSubMsg.Nack()
 		return nil
 	})
+	msg.RegisterNackAfter(func(delay time.Duration) error {
+		// Pub/Sub has no delayed nack, the client holds the message and extends its ack deadline until it is nacked
+		if delay > s.maxNackDelay {
+			delay = s.maxNackDelay
+		}
+		if delay <= 0 {
+			pubSubMsg.Nack()
+			return nil
+		}
+		time.AfterFunc(delay, pubSubMsg.Nack)
+		return nil
+	})
 	if msg.Attributes == nil {
 		msg.Attributes = make(map[string]string)
 	}
@@ -95,7 +111,7 @@ func (s *Client) Publish(ctx context.Context, message Message) (err error) {
 	msg := s.newPubSubMessageFromMessage(ctx, message)
 
 	if _, err = s.topic.Publish(ctx, msg).Get(ctx); err != nil {
-		err = errors.Wrapf(err, fmt.Sprintf("failed to publish in Google Pub/Sub topic %s", s.topic))
+		err = errors.Wrapf(err, "failed to publish in Google Pub/Sub topic %s", s.topic)
 	}
 
 	return err
@@ -0,0 +1,84 @@
+package messaging
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+// MemoryBroker delivers the messages published in the process to one of its subscribers. Nacked messages are
+// delivered again. Nothing survives a restart, it is meant for tests and for running the event-manager alone
+type MemoryBroker struct {
+	messages chan Message
+}
+
+// NewMemoryBroker returns a broker holding up to capacity pending messages, Publish blocks when it is full
+func NewMemoryBroker(capacity int) *MemoryBroker {
+	return &MemoryBroker{
+		messages: make(chan Message, capacity),
+	}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, message Message) error {
+	if message.ID == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+		message.ID = id.String()
+	}
+	message.PublishTime = time.Now()
+	attributes := map[string]string{}
+	for key, value := range message.Attributes {
+		attributes[key] = value
+	}
+	message.Attributes = attributes
+
+	return b.enqueue(ctx, message)
+}
+
+func (b *MemoryBroker) enqueue(ctx context.Context, message Message) error {
+	select {
+	case b.messages <- message:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, callback SubscribeCallbackFunc) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case message := <-b.messages:
+			message.DeliveryAttempt++
+			callback(ctx, b.deliverable(message))
+		}
+	}
+}
+
+// deliverable registers the acknowledgements of a message, only the first one counts
+func (b *MemoryBroker) deliverable(message Message) Message {
+	delivered := message
+	once := &sync.Once{}
+	delivered.RegisterAck(func() error {
+		once.Do(func() {})
+		return nil
+	})
+	delivered.RegisterNack(func() error {
+		return delivered.NackAfter(0)
+	})
+	delivered.RegisterNackAfter(func(delay time.Duration) error {
+		once.Do(func() {
+			// the subscriber may be the one reading the channel, it must not wait for itself
+			time.AfterFunc(delay, func() {
+				b.enqueue(context.Background(), message)
+			})
+		})
+		return nil
+	})
+	return delivered
+}
@@ -0,0 +1,119 @@
+package messaging_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/Vinubaba/SANTC-API/common/messaging"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MemoryBroker", func() {
+
+	var (
+		broker   *MemoryBroker
+		ctx      context.Context
+		cancel   context.CancelFunc
+		received chan Message
+		nack     bool
+	)
+
+	var subscribe = func() {
+		go broker.Subscribe(ctx, func(ctx context.Context, msg Message) {
+			if nack {
+				nack = false
+				msg.Nack()
+			} else {
+				msg.Ack()
+			}
+			received <- msg
+		})
+	}
+
+	BeforeEach(func() {
+		broker = NewMemoryBroker(10)
+		ctx, cancel = context.WithCancel(context.Background())
+		received = make(chan Message, 10)
+		nack = false
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("should deliver the published messages", func() {
+		subscribe()
+		Expect(broker.Publish(ctx, Message{Data: []byte("hello"), Attributes: map[string]string{"type": "greeting"}})).To(BeNil())
+
+		var msg Message
+		Eventually(received).Should(Receive(&msg))
+		Expect(string(msg.Data)).To(Equal("hello"))
+		Expect(msg.Attributes).To(Equal(map[string]string{"type": "greeting"}))
+		Expect(msg.ID).NotTo(BeEmpty())
+		Expect(msg.PublishTime.IsZero()).To(BeFalse())
+	})
+
+	It("should keep the messages published before the subscription", func() {
+		Expect(broker.Publish(ctx, Message{ID: "1"})).To(BeNil())
+		subscribe()
+
+		var msg Message
+		Eventually(received).Should(Receive(&msg))
+		Expect(msg.ID).To(Equal("1"))
+	})
+
+	It("should deliver a nacked message again", func() {
+		nack = true
+		subscribe()
+		Expect(broker.Publish(ctx, Message{ID: "1"})).To(BeNil())
+
+		var first, second Message
+		Eventually(received).Should(Receive(&first))
+		Eventually(received).Should(Receive(&second))
+		Expect(second.ID).To(Equal("1"))
+		Consistently(received).ShouldNot(Receive())
+	})
+
+	It("should count the deliveries", func() {
+		nack = true
+		subscribe()
+		Expect(broker.Publish(ctx, Message{ID: "1"})).To(BeNil())
+
+		var first, second Message
+		Eventually(received).Should(Receive(&first))
+		Eventually(received).Should(Receive(&second))
+		Expect(first.DeliveryAttempt).To(Equal(1))
+		Expect(second.DeliveryAttempt).To(Equal(2))
+	})
+
+	It("should wait before delivering again a message nacked with a delay", func() {
+		go broker.Subscribe(ctx, func(ctx context.Context, msg Message) {
+			if msg.DeliveryAttempt == 1 {
+				msg.NackAfter(200 * time.Millisecond)
+			}
+			received <- msg
+		})
+		Expect(broker.Publish(ctx, Message{ID: "1"})).To(BeNil())
+
+		Eventually(received).Should(Receive())
+		Consistently(received, "100ms").ShouldNot(Receive())
+		Eventually(received).Should(Receive())
+	})
+
+	It("should return when the context is done", func() {
+		done := make(chan error)
+		go func() {
+			done <- broker.Subscribe(ctx, func(ctx context.Context, msg Message) {})
+		}()
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("should not block a publisher when the context is done", func() {
+		broker = NewMemoryBroker(0)
+		cancel()
+		Expect(broker.Publish(ctx, Message{})).To(Equal(context.Canceled))
+	})
+})
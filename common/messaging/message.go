@@ -1,6 +1,14 @@
 package messaging
 
-import "time"
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrDeliveryExpired is returned when acking, delaying or extending a message delivered again after its deadline, the
+// new delivery handles it
+var ErrDeliveryExpired = errors.New("the message was delivered again after its ack deadline")
 
 type Message struct {
 	ID             string
@@ -9,6 +17,11 @@ type Message struct {
 	PublishTime    time.Time
 	registeredAck  func() error
 	registeredNack func() error
+
+	// How many times the message was delivered, this one included. 0 when the broker does not count, like Pub/Sub
+	DeliveryAttempt     int
+	registeredNackAfter func(delay time.Duration) error
+	registeredExtend    func(deadline time.Duration) error
 }
 
 func (m Message) Ack() error {
@@ -19,12 +32,30 @@ func (m Message) Ack() error {
 }
 
 func (m Message) Nack() error {
-	if m.registeredAck != nil {
+	if m.registeredNack != nil {
 		return m.registeredNack()
 	}
 	return nil
 }
 
+// NackAfter asks for the message to be delivered again once the delay is over. Without support from the broker it
+// is delivered again right away
+func (m Message) NackAfter(delay time.Duration) error {
+	if m.registeredNackAfter != nil {
+		return m.registeredNackAfter(delay)
+	}
+	return m.Nack()
+}
+
+// ExtendDeadline keeps the message from being delivered again for deadline more. Brokers extending it on their own,
+// like Pub/Sub, do not need it
+func (m Message) ExtendDeadline(deadline time.Duration) error {
+	if m.registeredExtend != nil {
+		return m.registeredExtend(deadline)
+	}
+	return nil
+}
+
 func (m *Message) RegisterAck(f func() error) {
 	m.registeredAck = f
 }
@@ -32,3 +63,11 @@ func (m *Message) RegisterAck(f func() error) {
 func (m *Message) RegisterNack(f func() error) {
 	m.registeredNack = f
 }
+
+func (m *Message) RegisterNackAfter(f func(delay time.Duration) error) {
+	m.registeredNackAfter = f
+}
+
+func (m *Message) RegisterExtendDeadline(f func(deadline time.Duration) error) {
+	m.registeredExtend = f
+}
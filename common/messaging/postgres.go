@@ -0,0 +1,180 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// channel notified when a message is published, its payload is the topic
+const postgresChannel = "messages"
+
+type PostgresOptions struct {
+	Db *gorm.DB
+	// Connection string of the LISTEN connection waking the subscribers up, they only poll without it
+	ConnectString string
+	Topic         string
+	// How often subscribers look for messages when nothing woke them up. Defaults to 5s
+	PollInterval time.Duration
+	// A message neither acked nor nacked is delivered again after that. Defaults to 20s, like the subscription
+	AckDeadline time.Duration
+}
+
+// PostgresBroker keeps the messages in the messages table until they are acked, see event-manager/sql/5_messages.up.sql.
+// Subscribers of a topic share its messages, each one is delivered to one of them at a time
+type PostgresBroker struct {
+	db            *gorm.DB
+	connectString string
+	topic         string
+	pollInterval  time.Duration
+	ackDeadline   time.Duration
+}
+
+func NewPostgresBroker(options PostgresOptions) (*PostgresBroker, error) {
+	if options.Db == nil {
+		return nil, errors.New("a database is mandatory")
+	}
+	if options.Topic == "" {
+		return nil, errors.New("a topic is mandatory")
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = 5 * time.Second
+	}
+	if options.AckDeadline <= 0 {
+		options.AckDeadline = 20 * time.Second
+	}
+	return &PostgresBroker{
+		db:            options.Db,
+		connectString: options.ConnectString,
+		topic:         options.Topic,
+		pollInterval:  options.PollInterval,
+		ackDeadline:   options.AckDeadline,
+	}, nil
+}
+
+func (b *PostgresBroker) Publish(ctx context.Context, message Message) error {
+	if message.ID == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+		message.ID = id.String()
+	}
+	if message.Attributes == nil {
+		message.Attributes = map[string]string{}
+	}
+	attributes, err := json.Marshal(message.Attributes)
+	if err != nil {
+		return err
+	}
+
+	if err := b.db.Exec("INSERT INTO messages (message_id, topic, data, attributes) VALUES (?, ?, ?, ?)",
+		message.ID, b.topic, message.Data, string(attributes)).Error; err != nil {
+		return errors.Wrapf(err, "failed to publish in topic %s", b.topic)
+	}
+	if err := b.db.Exec("SELECT pg_notify(?, ?)", postgresChannel, b.topic).Error; err != nil {
+		return errors.Wrap(err, "failed to notify the subscribers")
+	}
+	return nil
+}
+
+func (b *PostgresBroker) Subscribe(ctx context.Context, callback SubscribeCallbackFunc) error {
+	var notifications <-chan *pq.Notification
+	if b.connectString != "" {
+		listener := pq.NewListener(b.connectString, time.Second, time.Minute, nil)
+		defer listener.Close()
+		if err := listener.Listen(postgresChannel); err != nil {
+			return errors.Wrap(err, "failed to listen to the notifications")
+		}
+		notifications = listener.Notify
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		message, found, err := b.claim()
+		if err != nil {
+			return err
+		}
+		if found {
+			callback(ctx, message)
+			continue
+		}
+
+		// a nil notification means the listener reconnected, some may have been missed
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-notifications:
+		case <-time.After(b.pollInterval):
+		}
+	}
+}
+
+// claim hides the oldest deliverable message of the topic from the other subscribers until the ack deadline
+func (b *PostgresBroker) claim() (Message, bool, error) {
+	message := Message{}
+	rows, err := b.db.Raw(`UPDATE messages SET
+		deliver_after = now() + ? * interval '1 millisecond',
+		delivery_attempts = delivery_attempts + 1
+		WHERE message_id = (
+			SELECT message_id FROM messages
+			WHERE topic = ? AND deliver_after <= now()
+			ORDER BY publish_time
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING message_id, data, attributes, publish_time, delivery_attempts`, b.ackDeadline/time.Millisecond, b.topic).Rows()
+	if err != nil {
+		return message, false, errors.Wrapf(err, "failed to pull messages from topic %s", b.topic)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return message, false, rows.Err()
+	}
+
+	var attributes []byte
+	if err := rows.Scan(&message.ID, &message.Data, &attributes, &message.PublishTime, &message.DeliveryAttempt); err != nil {
+		return message, false, err
+	}
+	if err := json.Unmarshal(attributes, &message.Attributes); err != nil {
+		return message, false, errors.Wrapf(err, "invalid attributes for message %s", message.ID)
+	}
+
+	// once the deadline passed, the message may be claimed again: only this delivery acks or delays it
+	id, attempt := message.ID, message.DeliveryAttempt
+	message.RegisterAck(func() error {
+		return b.execDelivery(b.db.Exec("DELETE FROM messages WHERE message_id = ? AND delivery_attempts = ?", id, attempt))
+	})
+	message.RegisterNack(func() error {
+		return b.execDelivery(b.db.Exec("UPDATE messages SET deliver_after = now() WHERE message_id = ? AND delivery_attempts = ?", id, attempt))
+	})
+	message.RegisterNackAfter(func(delay time.Duration) error {
+		return b.execDelivery(b.db.Exec("UPDATE messages SET deliver_after = now() + ? * interval '1 millisecond' WHERE message_id = ? AND delivery_attempts = ?",
+			delay/time.Millisecond, id, attempt))
+	})
+	message.RegisterExtendDeadline(func(deadline time.Duration) error {
+		return b.execDelivery(b.db.Exec("UPDATE messages SET deliver_after = now() + ? * interval '1 millisecond' WHERE message_id = ? AND delivery_attempts = ?",
+			deadline/time.Millisecond, id, attempt))
+	})
+	return message, true, nil
+}
+
+func (b *PostgresBroker) execDelivery(res *gorm.DB) error {
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrDeliveryExpired
+	}
+	return nil
+}
@@ -6,6 +6,6 @@ const (
 	ROLE_ADULT          = "adult"
 	ROLE_OFFICE_MANAGER = "officemanager"
 
-	ROLE_SERVICE        = "service"
-	ROLE_REQUEST_HEADER = "X-Role"
+	// only given to the services authenticated by their token, see the scopes
+	ROLE_SERVICE = "service"
 )
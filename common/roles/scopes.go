@@ -0,0 +1,9 @@
+package roles
+
+// Scopes given to the service identities, a service only calls the routes of its scopes
+const (
+	SCOPE_CHILDREN_READ      = "children.read"
+	SCOPE_PHOTOS_WRITE       = "photos.write"
+	SCOPE_PHOTO_CONSENT_READ = "photo-consent.read"
+	SCOPE_INVITATIONS_SEND   = "invitations.send"
+)
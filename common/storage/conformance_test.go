@@ -0,0 +1,330 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/Vinubaba/SANTC-API/api/shared/mocks"
+	. "github.com/Vinubaba/SANTC-API/common/storage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+// storageFixture is a backend ready to be tested, with the client downloading the files of the urls it signs
+type storageFixture struct {
+	storage Storage
+	client  *http.Client
+	close   func()
+}
+
+// itBehavesLikeAStorage are the specs every backend must pass
+func itBehavesLikeAStorage(newFixture func(options ImageOptions, stringGenerator *MockStringGenerator) storageFixture) {
+
+	var (
+		fixture             storageFixture
+		mockStringGenerator *MockStringGenerator
+		ctx                 = context.Background()
+		maxFileSize         = int64(1 << 16)
+	)
+
+	var dataUri = func(mimeType string, encode func(buf *bytes.Buffer, img image.Image)) string {
+		buf := &bytes.Buffer{}
+		encode(buf, image.NewRGBA(image.Rect(0, 0, 300, 150)))
+		return "data:" + mimeType + ";base64," + b64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+	var jpegUri = dataUri(MIME_JPEG, func(buf *bytes.Buffer, img image.Image) { jpeg.Encode(buf, img, nil) })
+	var pngUri = dataUri(MIME_PNG, func(buf *bytes.Buffer, img image.Image) { png.Encode(buf, img) })
+
+	var download = func(fileName string) (*http.Response, []byte) {
+		signedUrl, err := fixture.storage.Get(ctx, fileName, USAGE_DOWNLOAD)
+		Expect(err).To(BeNil())
+		resp, err := fixture.client.Get(signedUrl.Url)
+		Expect(err).To(BeNil())
+		defer resp.Body.Close()
+		content, _ := ioutil.ReadAll(resp.Body)
+		return resp, content
+	}
+
+	var imageSize = func(content []byte) image.Point {
+		config, _, err := image.DecodeConfig(bytes.NewReader(content))
+		Expect(err).To(BeNil())
+		return image.Pt(config.Width, config.Height)
+	}
+
+	BeforeEach(func() {
+		mockStringGenerator = &MockStringGenerator{}
+		mockStringGenerator.On("GenerateUuid").Return("aze3215fe-513df")
+		fixture = newFixture(ImageOptions{
+			MaxImageSize:         100,
+			ThumbnailSize:        20,
+			MaxFileSize:          maxFileSize,
+			DownloadUrlLifetime:  4 * time.Minute,
+			ThumbnailUrlLifetime: time.Hour,
+		}, mockStringGenerator)
+	})
+
+	AfterEach(func() {
+		fixture.close()
+	})
+
+	Context("Store", func() {
+		It("should store the processed image in the folder", func() {
+			fileName, err := fixture.storage.Store(ctx, jpegUri, "daycares/namek/children")
+			Expect(err).To(BeNil())
+			Expect(fileName).To(Equal("daycares/namek/children/aze3215fe-513df.jpg"))
+
+			resp, content := download(fileName)
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(imageSize(content)).To(Equal(image.Pt(100, 50)))
+		})
+
+		It("should store the thumbnail next to the image", func() {
+			fileName, err := fixture.storage.Store(ctx, jpegUri, "daycares/namek/children")
+			Expect(err).To(BeNil())
+
+			resp, content := download(ThumbnailName(fileName))
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(imageSize(content)).To(Equal(image.Pt(20, 10)))
+		})
+
+		It("should keep the format of a png", func() {
+			fileName, err := fixture.storage.Store(ctx, pngUri, "")
+			Expect(err).To(BeNil())
+			Expect(fileName).To(Equal("aze3215fe-513df.png"))
+
+			resp, _ := download(fileName)
+			Expect(resp.Header.Get("Content-Type")).To(Equal(MIME_PNG))
+		})
+
+		It("should not store anything without image", func() {
+			Expect(fixture.storage.Store(ctx, "", "daycares/namek/children")).To(Equal(""))
+		})
+
+		It("should refuse what is not an image", func() {
+			_, err := fixture.storage.Store(ctx, "data:text/plain;base64,Zm9v", "")
+			Expect(errors.Cause(err)).To(Equal(ErrUnsupportedFileFormat))
+		})
+	})
+
+	Context("StoreFile", func() {
+		It("should store the image in the folder", func() {
+			content, _ := b64.StdEncoding.DecodeString(jpegUri[len("data:image/jpeg;base64,"):])
+			fileName, err := fixture.storage.StoreFile(ctx, bytes.NewReader(content), "image/jpeg", "daycares/namek/classes")
+			Expect(err).To(BeNil())
+			Expect(fileName).To(Equal("daycares/namek/classes/aze3215fe-513df.jpg"))
+
+			resp, _ := download(fileName)
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("should refuse a file too large", func() {
+			_, err := fixture.storage.StoreFile(ctx, bytes.NewReader(make([]byte, maxFileSize+1)), "image/jpeg", "")
+			Expect(err).To(Equal(ErrFileTooLarge))
+		})
+
+		It("should refuse a file whose declared type is not its content", func() {
+			content, _ := b64.StdEncoding.DecodeString(jpegUri[len("data:image/jpeg;base64,"):])
+			_, err := fixture.storage.StoreFile(ctx, bytes.NewReader(content), "image/png", "")
+			Expect(errors.Cause(err)).To(Equal(ErrMismatchingFileFormat))
+		})
+
+		It("should sniff a file of unknown type", func() {
+			content, _ := b64.StdEncoding.DecodeString(jpegUri[len("data:image/jpeg;base64,"):])
+			fileName, err := fixture.storage.StoreFile(ctx, bytes.NewReader(content), "application/octet-stream", "")
+			Expect(err).To(BeNil())
+			Expect(fileName).To(Equal("aze3215fe-513df.jpg"))
+		})
+	})
+
+	Context("Get", func() {
+		It("should return an empty url without file", func() {
+			Expect(fixture.storage.Get(ctx, "", USAGE_DOWNLOAD)).To(Equal(SignedUrl{}))
+		})
+
+		It("should sign the urls for the lifetime of their usage", func() {
+			download, err := fixture.storage.Get(ctx, "aze3215fe-513df.jpg", USAGE_DOWNLOAD)
+			Expect(err).To(BeNil())
+			// the url expires one lifetime after the start of the current expiry bucket
+			Expect(download.Expires).To(BeTemporally("~", time.Now().Add(4*time.Minute), time.Minute))
+			Expect(download.Expires).To(BeTemporally(">", time.Now().Add(3*time.Minute)))
+
+			thumbnail, err := fixture.storage.Get(ctx, "aze3215fe-513df_thumb.jpg", USAGE_THUMBNAIL)
+			Expect(err).To(BeNil())
+			Expect(thumbnail.Expires).To(BeTemporally("~", time.Now().Add(time.Hour), 15*time.Minute))
+			Expect(thumbnail.Expires).To(BeTemporally(">", time.Now().Add(45*time.Minute)))
+		})
+
+		It("should sign the thumbnail of an image for the lifetime of thumbnails", func() {
+			fileName, err := fixture.storage.Store(ctx, jpegUri, "daycares/namek/children")
+			Expect(err).To(BeNil())
+
+			thumbnail, err := fixture.storage.GetThumbnail(ctx, fileName)
+			Expect(err).To(BeNil())
+			Expect(thumbnail.Expires).To(BeTemporally(">", time.Now().Add(45*time.Minute)))
+			resp, err := fixture.client.Get(thumbnail.Url)
+			Expect(err).To(BeNil())
+			defer resp.Body.Close()
+			content, _ := ioutil.ReadAll(resp.Body)
+			Expect(imageSize(content)).To(Equal(image.Pt(20, 10)))
+		})
+
+		It("should return the same url during an expiry bucket", func() {
+			first, err := fixture.storage.Get(ctx, "aze3215fe-513df.jpg", USAGE_THUMBNAIL)
+			Expect(err).To(BeNil())
+			Expect(fixture.storage.Get(ctx, "aze3215fe-513df.jpg", USAGE_THUMBNAIL)).To(Equal(first))
+		})
+	})
+
+	Context("List", func() {
+		It("should list the files of a folder with their variants", func() {
+			childImage, err := fixture.storage.Store(ctx, jpegUri, "daycares/namek/children")
+			Expect(err).To(BeNil())
+			_, err = fixture.storage.Store(ctx, jpegUri, "daycares/peyredragon/children")
+			Expect(err).To(BeNil())
+
+			files, err := fixture.storage.List(ctx, "daycares/namek/")
+			Expect(err).To(BeNil())
+			names := []string{}
+			for _, file := range files {
+				names = append(names, file.Name)
+				Expect(file.Updated).To(BeTemporally("~", time.Now(), time.Minute))
+			}
+			Expect(names).To(ConsistOf(childImage, ThumbnailName(childImage)))
+		})
+
+		It("should return nothing when nothing was stored", func() {
+			Expect(fixture.storage.List(ctx, "daycares/namek/")).To(BeEmpty())
+		})
+	})
+
+	Context("Delete", func() {
+		It("should delete the image and its thumbnail", func() {
+			fileName, err := fixture.storage.Store(ctx, jpegUri, "daycares/namek/children")
+			Expect(err).To(BeNil())
+
+			Expect(fixture.storage.Delete(ctx, fileName)).To(BeNil())
+			resp, _ := download(fileName)
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			resp, _ = download(ThumbnailName(fileName))
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+
+		It("should do nothing without file", func() {
+			Expect(fixture.storage.Delete(ctx, "")).To(BeNil())
+		})
+	})
+}
+
+var _ = Describe("Conformance", func() {
+
+	ctx := context.Background()
+
+	Describe("GoogleStorage", func() {
+		itBehavesLikeAStorage(func(options ImageOptions, stringGenerator *MockStringGenerator) storageFixture {
+			key, err := rsa.GenerateKey(rand.Reader, 1024)
+			Expect(err).To(BeNil())
+			server := newFakeGcs("teddycare", &key.PublicKey)
+
+			credentials, err := ioutil.TempFile("", "bucket-sa")
+			Expect(err).To(BeNil())
+			json.NewEncoder(credentials).Encode(map[string]string{
+				"type":         "service_account",
+				"client_email": "bucket@teddycare.iam.gserviceaccount.com",
+				"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+			})
+			credentials.Close()
+
+			googleStorage, err := New(ctx, Options{
+				CredentialsFile: credentials.Name(),
+				BucketName:      "teddycare",
+				Endpoint:        server.URL + "/storage/v1/",
+				ImageOptions:    options,
+			})
+			Expect(err).To(BeNil())
+			googleStorage.StringGenerator = stringGenerator
+
+			serverUrl, _ := url.Parse(server.URL)
+			return storageFixture{
+				storage: googleStorage,
+				// signed urls point to storage.googleapis.com
+				client: &http.Client{Transport: redirectTransport{target: serverUrl}},
+				close: func() {
+					server.Close()
+					os.Remove(credentials.Name())
+				},
+			}
+		})
+	})
+
+	Describe("LocalStorage", func() {
+		itBehavesLikeAStorage(func(options ImageOptions, stringGenerator *MockStringGenerator) storageFixture {
+			root, err := ioutil.TempDir("", "local-storage")
+			Expect(err).To(BeNil())
+
+			var localStorage *LocalStorage
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				localStorage.ServeHTTP(w, r)
+			}))
+
+			localStorage, err = NewLocal(LocalOptions{
+				Path:         filepath.Join(root, "files"),
+				Url:          server.URL + "/files",
+				Secret:       "secret",
+				ImageOptions: options,
+			})
+			Expect(err).To(BeNil())
+			localStorage.StringGenerator = stringGenerator
+
+			return storageFixture{
+				storage: localStorage,
+				client:  http.DefaultClient,
+				close: func() {
+					server.Close()
+					os.RemoveAll(root)
+				},
+			}
+		})
+	})
+
+	Describe("S3Storage", func() {
+		itBehavesLikeAStorage(func(options ImageOptions, stringGenerator *MockStringGenerator) storageFixture {
+			server := newFakeS3("teddycare")
+
+			s3Storage, err := NewS3(S3Options{
+				Endpoint:     server.URL,
+				Region:       "us-east-1",
+				BucketName:   "teddycare",
+				AccessKey:    "minio",
+				SecretKey:    "minio123",
+				PathStyle:    true,
+				ImageOptions: options,
+			})
+			Expect(err).To(BeNil())
+			s3Storage.StringGenerator = stringGenerator
+
+			return storageFixture{
+				storage: s3Storage,
+				client:  http.DefaultClient,
+				close:   server.Close,
+			}
+		})
+	})
+})
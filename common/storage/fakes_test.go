@@ -0,0 +1,221 @@
+package storage_test
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type fakeObject struct {
+	name        string
+	contentType string
+	content     []byte
+	updated     time.Time
+}
+
+// fakeBucket keeps the objects of the fake servers in memory
+type fakeBucket struct {
+	mutex   sync.Mutex
+	objects map[string]fakeObject
+}
+
+func newFakeBucket() *fakeBucket {
+	return &fakeBucket{objects: map[string]fakeObject{}}
+}
+
+func (b *fakeBucket) put(name string, object fakeObject) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	object.name = name
+	object.updated = time.Now()
+	b.objects[name] = object
+}
+
+func (b *fakeBucket) list(prefix string) []fakeObject {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	objects := []fakeObject{}
+	for name, object := range b.objects {
+		if strings.HasPrefix(name, prefix) {
+			objects = append(objects, object)
+		}
+	}
+	return objects
+}
+
+func (b *fakeBucket) delete(name string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	_, ok := b.objects[name]
+	delete(b.objects, name)
+	return ok
+}
+
+func (b *fakeBucket) serve(w http.ResponseWriter, name string) {
+	b.mutex.Lock()
+	object, ok := b.objects[name]
+	b.mutex.Unlock()
+	if !ok {
+		http.NotFound(w, nil)
+		return
+	}
+	w.Header().Set("Content-Type", object.contentType)
+	w.Write(object.content)
+}
+
+// newFakeGcs serves the few calls of the JSON api made by GoogleStorage under /storage/v1/, and the urls it signs
+// with the private key of publicKey
+func newFakeGcs(bucket string, publicKey *rsa.PublicKey) *httptest.Server {
+	objects := newFakeBucket()
+	apiPath := "/storage/v1/b/" + bucket + "/o"
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == apiPath:
+			// multipart upload: the metadata of the object, then its content
+			_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			parts := multipart.NewReader(r.Body, params["boundary"])
+			metadata := struct {
+				Name        string `json:"name"`
+				ContentType string `json:"contentType"`
+			}{}
+			part, err := parts.NextPart()
+			if err == nil {
+				err = json.NewDecoder(part).Decode(&metadata)
+			}
+			if err == nil {
+				part, err = parts.NextPart()
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			content, _ := ioutil.ReadAll(part)
+			objects.put(metadata.Name, fakeObject{contentType: part.Header.Get("Content-Type"), content: content})
+			json.NewEncoder(w).Encode(map[string]string{"bucket": bucket, "name": metadata.Name})
+
+		case r.Method == http.MethodGet && r.URL.Path == apiPath:
+			items := []map[string]string{}
+			for _, object := range objects.list(r.URL.Query().Get("prefix")) {
+				items = append(items, map[string]string{
+					"bucket":  bucket,
+					"name":    object.name,
+					"updated": object.updated.UTC().Format(time.RFC3339Nano),
+				})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"kind": "storage#objects", "items": items})
+
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, apiPath+"/"):
+			if !objects.delete(strings.TrimPrefix(r.URL.Path, apiPath+"/")) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":{"code":404,"message":"Not Found"}}`))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/"+bucket+"/"):
+			expires := r.URL.Query().Get("Expires")
+			signature, _ := base64.StdEncoding.DecodeString(r.URL.Query().Get("Signature"))
+			signed := sha256.Sum256([]byte("GET\n\n\n" + expires + "\n" + r.URL.Path))
+			if rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, signed[:], signature) != nil || isPast(expires) {
+				http.Error(w, "invalid signature", http.StatusForbidden)
+				return
+			}
+			objects.serve(w, strings.TrimPrefix(r.URL.Path, "/"+bucket+"/"))
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// newFakeS3 serves the path style urls of an S3 bucket. Signatures are only checked to be there, the way they are
+// computed is tested against the examples of AWS
+func newFakeS3(bucket string) *httptest.Server {
+	objects := newFakeBucket()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/"+bucket+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/"+bucket+"/")
+
+		if r.Method == http.MethodGet && r.URL.Query().Get("list-type") != "2" {
+			query := r.URL.Query()
+			date, err := time.Parse("20060102T150405Z", query.Get("X-Amz-Date"))
+			lifetime, _ := strconv.Atoi(query.Get("X-Amz-Expires"))
+			if err != nil || query.Get("X-Amz-Signature") == "" || time.Now().After(date.Add(time.Duration(lifetime)*time.Second)) {
+				http.Error(w, "invalid signature", http.StatusForbidden)
+				return
+			}
+			objects.serve(w, name)
+			return
+		}
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=") {
+			http.Error(w, "missing authorization", http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			// a single page, as if there were less than 1000 objects
+			result := "<ListBucketResult><IsTruncated>false</IsTruncated>"
+			for _, object := range objects.list(r.URL.Query().Get("prefix")) {
+				result += fmt.Sprintf("<Contents><Key>%s</Key><LastModified>%s</LastModified></Contents>",
+					object.name, object.updated.UTC().Format("2006-01-02T15:04:05.000Z"))
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			w.Write([]byte(result + "</ListBucketResult>"))
+		case http.MethodPut:
+			content, _ := ioutil.ReadAll(r.Body)
+			hash := sha256.Sum256(content)
+			if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(hash[:]) {
+				http.Error(w, "invalid content hash", http.StatusBadRequest)
+				return
+			}
+			objects.put(name, fakeObject{contentType: r.Header.Get("Content-Type"), content: content})
+		case http.MethodDelete:
+			objects.delete(name)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, fmt.Sprintf("unexpected %s", r.Method), http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+// redirectTransport sends every request to the given server, whatever the host of their url
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	redirected := *r
+	u := *r.URL
+	u.Scheme, u.Host = t.target.Scheme, t.target.Host
+	redirected.URL = &u
+	return http.DefaultTransport.RoundTrip(&redirected)
+}
+
+func isPast(unix string) bool {
+	seconds, err := strconv.ParseInt(unix, 10, 64)
+	return err != nil || time.Now().Unix() > seconds
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	b64 "encoding/base64"
+	"math"
+	"mime"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	MIME_JPEG = "image/jpeg"
+	MIME_PNG  = "image/png"
+	MIME_WEBP = "image/webp"
+	MIME_HEIC = "image/heic"
+	// the type of a file whose type is unknown
+	MIME_OCTET_STREAM = "application/octet-stream"
+
+	DefaultConverterTimeout = 30 * time.Second
+
+	// past the memory and map limits ImageMagick uses the disk, past the disk limit it fails
+	converterMemoryLimit = "256MiB"
+	converterMapLimit    = "512MiB"
+	converterDiskLimit   = "1GiB"
+)
+
+var (
+	ErrMismatchingFileFormat = errors.New("the declared content type does not match the content of the file")
+)
+
+var (
+	extensions = map[string]string{
+		MIME_JPEG: ".jpg",
+		MIME_PNG:  ".png",
+		MIME_WEBP: ".webp",
+		MIME_HEIC: ".heic",
+	}
+	mimeTypes = map[string]string{
+		".jpg":  MIME_JPEG,
+		".png":  MIME_PNG,
+		".webp": MIME_WEBP,
+		".heic": MIME_HEIC,
+	}
+	// other names clients use for the same formats
+	mimeTypeAliases = map[string]string{
+		"image/jpg":   MIME_JPEG,
+		"image/pjpeg": MIME_JPEG,
+		"image/heif":  MIME_HEIC,
+	}
+	// brands of the HEIF container holding HEVC images, as found in the ftyp box
+	heicBrands = []string{"heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1"}
+)
+
+// DecodeDataUri returns the content of a base64 data uri (e.g data:image/png;base64,iVBORw0KGgo...) and its type.
+// The type is the one sniffed from the content, it must match the declared one
+func DecodeDataUri(dataUri string) ([]byte, string, error) {
+	if !strings.HasPrefix(dataUri, "data:") {
+		return nil, "", ErrUnsupportedFileFormat
+	}
+	separator := strings.Index(dataUri, ";base64,")
+	if separator < 0 {
+		return nil, "", ErrUnsupportedFileFormat
+	}
+	declared := normalizeMimeType(dataUri[len("data:"):separator])
+	if _, ok := extensions[declared]; !ok {
+		return nil, "", ErrUnsupportedFileFormat
+	}
+
+	decoded, err := b64.StdEncoding.DecodeString(dataUri[separator+len(";base64,"):])
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to decode base64 image")
+	}
+
+	sniffed := SniffImageType(decoded)
+	if sniffed == "" {
+		return nil, "", ErrUnsupportedFileFormat
+	}
+	if sniffed != declared {
+		return nil, "", errors.Wrapf(ErrMismatchingFileFormat, "declared %s but got %s", declared, sniffed)
+	}
+	return decoded, sniffed, nil
+}
+
+// checkDeclaredType refuses a file whose declared type is not the one sniffed from its content. A file of unknown
+// type, as sent by clients which don't look at it, is only sniffed
+func checkDeclaredType(contentType string, content []byte) error {
+	declared := contentType
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		declared = mediaType
+	}
+	declared = normalizeMimeType(declared)
+	if declared == "" || declared == MIME_OCTET_STREAM {
+		return nil
+	}
+	sniffed := SniffImageType(content)
+	if sniffed == "" {
+		return ErrUnsupportedFileFormat
+	}
+	if sniffed != declared {
+		return errors.Wrapf(ErrMismatchingFileFormat, "declared %s but got %s", declared, sniffed)
+	}
+	return nil
+}
+
+// SniffImageType detects the type of an image from its first bytes, empty when it is not a supported image
+func SniffImageType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return MIME_JPEG
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return MIME_PNG
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return MIME_WEBP
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		brand := string(data[8:12])
+		for _, heicBrand := range heicBrands {
+			if brand == heicBrand {
+				return MIME_HEIC
+			}
+		}
+	}
+	return ""
+}
+
+// Extension returns the extension of the files of the given type
+func Extension(mimeType string) string {
+	return extensions[normalizeMimeType(mimeType)]
+}
+
+func normalizeMimeType(mimeType string) string {
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+	if alias, ok := mimeTypeAliases[mimeType]; ok {
+		return alias
+	}
+	return mimeType
+}
+
+// ImageConverter turns the formats the standard library cannot decode (webp, heic) into jpeg
+type ImageConverter interface {
+	ToJpeg(ctx context.Context, data []byte, mimeType string) ([]byte, error)
+}
+
+// CommandConverter pipes the image through ImageMagick, the uploads are untrusted so its resources are limited:
+// convert -limit memory 256MiB ... heic:- jpeg:-
+type CommandConverter struct {
+	Command string
+	// Most pixels of the converted image. DefaultMaxImagePixels is used when 0
+	MaxPixels int
+	// How long a conversion may run. DefaultConverterTimeout is used when 0
+	Timeout time.Duration
+}
+
+func (c CommandConverter) ToJpeg(ctx context.Context, data []byte, mimeType string) ([]byte, error) {
+	if c.Command == "" {
+		return nil, errors.Wrap(ErrUnsupportedFileFormat, "no converter configured for "+mimeType)
+	}
+	maxPixels, timeout := c.MaxPixels, c.Timeout
+	if maxPixels <= 0 {
+		maxPixels = DefaultMaxImagePixels
+	}
+	if timeout <= 0 {
+		timeout = DefaultConverterTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// ImageMagick guesses the input format from this prefix
+	format := strings.TrimPrefix(normalizeMimeType(mimeType), "image/")
+	cmd := exec.CommandContext(ctx, c.Command,
+		"-limit", "memory", converterMemoryLimit,
+		"-limit", "map", converterMapLimit,
+		"-limit", "disk", converterDiskLimit,
+		"-limit", "area", strconv.Itoa(maxPixels),
+		"-limit", "time", strconv.Itoa(int(math.Ceil(timeout.Seconds()))),
+		format+":-", "jpeg:-")
+	cmd.Stdin = bytes.NewReader(data)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.Error); ok {
+			// the program is not installed
+			return nil, errors.Wrap(ErrUnsupportedFileFormat, err.Error())
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errors.Wrapf(ErrUnsupportedFileFormat, "conversion of %s image timed out", mimeType)
+		}
+		return nil, errors.Wrapf(err, "failed to convert %s image: %s", mimeType, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
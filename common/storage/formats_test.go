@@ -0,0 +1,149 @@
+package storage_test
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/Vinubaba/SANTC-API/common/storage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Formats", func() {
+
+	var (
+		jpegData = []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10}
+		pngData  = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+		webpData = []byte("RIFF\x24\x00\x00\x00WEBPVP8 ")
+		heicData = []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00")
+	)
+
+	var dataUri = func(mimeType string, data []byte) string {
+		return "data:" + mimeType + ";base64," + b64.StdEncoding.EncodeToString(data)
+	}
+
+	Describe("SniffImageType", func() {
+		It("should recognize the supported formats", func() {
+			Expect(SniffImageType(jpegData)).To(Equal(MIME_JPEG))
+			Expect(SniffImageType(pngData)).To(Equal(MIME_PNG))
+			Expect(SniffImageType(webpData)).To(Equal(MIME_WEBP))
+			Expect(SniffImageType(heicData)).To(Equal(MIME_HEIC))
+		})
+		It("should not recognize anything else", func() {
+			Expect(SniffImageType([]byte("GIF89a"))).To(Equal(""))
+			Expect(SniffImageType([]byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00"))).To(Equal(""))
+			Expect(SniffImageType(nil)).To(Equal(""))
+		})
+	})
+
+	Describe("DecodeDataUri", func() {
+
+		var (
+			uri           string
+			decoded       []byte
+			mimeType      string
+			returnedError error
+		)
+
+		JustBeforeEach(func() {
+			decoded, mimeType, returnedError = DecodeDataUri(uri)
+		})
+
+		Context("Default", func() {
+			BeforeEach(func() {
+				uri = dataUri("image/png", pngData)
+			})
+			It("should return the content and its type", func() {
+				Expect(returnedError).To(BeNil())
+				Expect(decoded).To(Equal(pngData))
+				Expect(mimeType).To(Equal(MIME_PNG))
+			})
+		})
+
+		Context("When the type has an alias", func() {
+			BeforeEach(func() {
+				uri = dataUri("image/heif", heicData)
+			})
+			It("should return the canonical type", func() {
+				Expect(returnedError).To(BeNil())
+				Expect(mimeType).To(Equal(MIME_HEIC))
+			})
+		})
+
+		Context("When the declared type does not match the content", func() {
+			BeforeEach(func() {
+				uri = dataUri("image/jpeg", pngData)
+			})
+			It("should return ErrMismatchingFileFormat", func() {
+				Expect(errors.Cause(returnedError)).To(Equal(ErrMismatchingFileFormat))
+			})
+		})
+
+		Context("When the type is not supported", func() {
+			BeforeEach(func() {
+				uri = dataUri("image/gif", []byte("GIF89a"))
+			})
+			It("should return ErrUnsupportedFileFormat", func() {
+				Expect(errors.Cause(returnedError)).To(Equal(ErrUnsupportedFileFormat))
+			})
+		})
+
+		Context("When it is not a data uri", func() {
+			BeforeEach(func() {
+				uri = b64.StdEncoding.EncodeToString(jpegData)
+			})
+			It("should return ErrUnsupportedFileFormat", func() {
+				Expect(errors.Cause(returnedError)).To(Equal(ErrUnsupportedFileFormat))
+			})
+		})
+	})
+
+	Describe("CommandConverter", func() {
+
+		var (
+			dir       string
+			converter CommandConverter
+		)
+
+		// stands for ImageMagick, the script is given the arguments of the command
+		var script = func(body string) string {
+			name := filepath.Join(dir, "convert")
+			Expect(ioutil.WriteFile(name, []byte("#!/bin/sh\n"+body+"\n"), 0700)).To(BeNil())
+			return name
+		}
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "converter")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should limit the resources of the conversion", func() {
+			converter = CommandConverter{Command: script(`echo "$@"`), MaxPixels: 1000, Timeout: time.Minute}
+			out, err := converter.ToJpeg(context.Background(), webpData, MIME_WEBP)
+			Expect(err).To(BeNil())
+			Expect(string(out)).To(ContainSubstring("-limit memory 256MiB"))
+			Expect(string(out)).To(ContainSubstring("-limit area 1000"))
+			Expect(string(out)).To(ContainSubstring("-limit time 60"))
+			Expect(string(out)).To(HaveSuffix("webp:- jpeg:-\n"))
+		})
+
+		It("should stop a conversion running too long", func() {
+			converter = CommandConverter{Command: script("exec sleep 10"), Timeout: 100 * time.Millisecond}
+			start := time.Now()
+			_, err := converter.ToJpeg(context.Background(), webpData, MIME_WEBP)
+			Expect(errors.Cause(err)).To(Equal(ErrUnsupportedFileFormat))
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		})
+	})
+})
@@ -2,31 +2,40 @@ package storage
 
 import (
 	"context"
-	b64 "encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
-	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
 type Options struct {
 	CredentialsFile string
 	BucketName      string
+	// Url of the JSON api of an emulator, e.g http://localhost:4443/storage/v1/. Requests to an emulator are not
+	// authenticated, urls are still signed with the key of CredentialsFile
+	Endpoint string
+	ImageOptions
 }
 
 func New(ctx context.Context, options Options) (*GoogleStorage, error) {
-	client, err := storage.NewClient(ctx, option.WithCredentialsFile(options.CredentialsFile))
+	clientOptions := []option.ClientOption{option.WithCredentialsFile(options.CredentialsFile)}
+	if options.Endpoint != "" {
+		clientOptions = []option.ClientOption{option.WithEndpoint(options.Endpoint), option.WithoutAuthentication()}
+	}
+	client, err := storage.NewClient(ctx, clientOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %v", err)
 	}
 	gs := &GoogleStorage{
-		client: client,
-		bucket: options.BucketName,
+		imageStore: newImageStore(options.ImageOptions),
+		client:     client,
+		bucket:     options.BucketName,
 	}
 
 	b, err := ioutil.ReadFile(options.CredentialsFile)
@@ -41,6 +50,7 @@ func New(ctx context.Context, options Options) (*GoogleStorage, error) {
 }
 
 type GoogleStorage struct {
+	imageStore
 	client                *storage.Client
 	bucket                string
 	serviceAccountDetails serviceAccountDetails
@@ -53,53 +63,58 @@ func (s *GoogleStorage) Store(ctx context.Context, b64image string, folder strin
 	if b64image == "" {
 		return "", nil
 	}
-	encoded, err := s.validate64EncodedPhoto(b64image)
+	decoded, err := s.decode(b64image)
 	if err != nil {
 		return "", err
 	}
+	return s.store(ctx, s.StringGenerator.GenerateUuid(), decoded, folder, s.writer(ctx))
+}
 
-	decoded, err := b64.StdEncoding.DecodeString(encoded)
+func (s *GoogleStorage) StoreFile(ctx context.Context, file io.Reader, contentType string, folder string) (string, error) {
+	content, err := s.read(file, contentType)
 	if err != nil {
 		return "", err
 	}
+	return s.store(ctx, s.StringGenerator.GenerateUuid(), content, folder, s.writer(ctx))
+}
 
-	fileName := s.StringGenerator.GenerateUuid() + ".jpg"
-	if folder != "" {
-		fileName = folder + "/" + fileName
-	}
-	w := s.client.Bucket(s.bucket).Object(fileName).NewWriter(ctx)
+func (s *GoogleStorage) writer(ctx context.Context) func(fileName, contentType string, content []byte) error {
+	return func(fileName, contentType string, content []byte) error {
+		w := s.client.Bucket(s.bucket).Object(fileName).NewWriter(ctx)
+		w.ContentType = contentType
 
-	if _, err = w.Write(decoded); err != nil {
-		return "", err
+		if _, err := w.Write(content); err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
 	}
+}
 
-	return fileName, w.Close()
+// returns signedUrls
+func (s *GoogleStorage) Get(ctx context.Context, fileName string, usage UrlUsage) (SignedUrl, error) {
+	return s.signedUrl(fileName, usage, s.signUrl)
 }
 
-func (s *GoogleStorage) validate64EncodedPhoto(photo string) (encoded string, err error) {
-	if strings.HasPrefix(photo, "data:image/jpeg;base64,") {
-		encoded = strings.TrimPrefix(photo, "data:image/jpeg;base64,")
-	} else {
-		err = ErrUnsupportedFileFormat
-	}
-	return
+func (s *GoogleStorage) GetThumbnail(ctx context.Context, fileName string) (SignedUrl, error) {
+	return s.thumbnailUrl(ctx, fileName, s.exists, s.signUrl)
 }
 
-// returns signedUrls
-func (s *GoogleStorage) Get(ctx context.Context, fileName string) (string, error) {
-	if fileName == "" {
-		return "", nil
+func (s *GoogleStorage) exists(ctx context.Context, fileName string) (bool, error) {
+	_, err := s.client.Bucket(s.bucket).Object(fileName).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return false, nil
 	}
-	url, err := storage.SignedURL(s.bucket, fileName, &storage.SignedURLOptions{
+	return err == nil, err
+}
+
+func (s *GoogleStorage) signUrl(fileName string, expires time.Time) (string, error) {
+	return storage.SignedURL(s.bucket, fileName, &storage.SignedURLOptions{
 		GoogleAccessID: s.serviceAccountDetails.ClientEmail,
 		PrivateKey:     []byte(s.serviceAccountDetails.PrivateKey),
 		Method:         http.MethodGet,
-		Expires:        time.Now().Add(time.Second * 180),
+		Expires:        expires,
 	})
-	if err != nil {
-		return "", err
-	}
-	return url, nil
 }
 
 func (s *GoogleStorage) Delete(ctx context.Context, fileName string) error {
@@ -107,5 +122,29 @@ func (s *GoogleStorage) Delete(ctx context.Context, fileName string) error {
 		return nil
 	}
 
+	s.thumbnails.forget(ThumbnailName(fileName))
+	for _, variant := range s.variantNames(fileName) {
+		err := s.client.Bucket(s.bucket).Object(variant).Delete(ctx)
+		if err != nil && err != storage.ErrObjectNotExist {
+			return err
+		}
+	}
+
 	return s.client.Bucket(s.bucket).Object(fileName).Delete(ctx)
 }
+
+func (s *GoogleStorage) List(ctx context.Context, prefix string) ([]StoredFile, error) {
+	files := []StoredFile{}
+	it := s.client.Bucket(s.bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, StoredFile{Name: attrs.Name, Updated: attrs.Updated})
+	}
+	return files, nil
+}
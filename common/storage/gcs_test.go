@@ -1,13 +1,14 @@
 package storage_test
 
 import (
+	"bytes"
 	"context"
 	b64 "encoding/base64"
+	"image/jpeg"
 	"io/ioutil"
 	"net/http"
 	"os"
 
-	"github.com/Vinubaba/SANTC-API/api/shared"
 	. "github.com/Vinubaba/SANTC-API/api/shared/mocks"
 
 	. "github.com/Vinubaba/SANTC-API/common/storage"
@@ -19,7 +20,6 @@ var _ = Describe("Gcs", func() {
 
 	var (
 		storage             *GoogleStorage
-		config              *shared.AppConfig
 		mockStringGenerator *MockStringGenerator
 		ctx                 = context.Background()
 	)
@@ -30,10 +30,6 @@ var _ = Describe("Gcs", func() {
 		if bucketSa == "" {
 			bucketSa = `C:\Users\arthur\gocode\src\github.com\Vinubaba\deployment\bucket-sa.json`
 		}
-		config = &shared.AppConfig{
-			BucketServiceAccount: bucketSa,
-			BucketName:           "teddycare",
-		}
 		var err error
 		storage, err = New(ctx, Options{
 			CredentialsFile: bucketSa,
@@ -51,7 +47,7 @@ var _ = Describe("Gcs", func() {
 			image                             []byte
 			encodedImage                      string
 			storeError, getError, deleteError error
-			uri                               string
+			signedUrl                         SignedUrl
 			fileName                          string
 			getResponse                       *http.Response
 		)
@@ -65,8 +61,8 @@ var _ = Describe("Gcs", func() {
 			fileName, storeError = storage.Store(ctx, "data:image/jpeg;base64,"+encodedImage, "")
 
 			// Then get
-			uri, getError = storage.Get(ctx, fileName)
-			getResponse, _ = http.Get(uri)
+			signedUrl, getError = storage.Get(ctx, fileName, USAGE_DOWNLOAD)
+			getResponse, _ = http.Get(signedUrl.Url)
 			// Finally delete
 			deleteError = storage.Delete(ctx, fileName)
 		})
@@ -78,8 +74,13 @@ var _ = Describe("Gcs", func() {
 			Expect(fileName).To(Equal("image1.jpg"))
 
 			// Get
+			// what is stored is the processed image, not the uploaded file
 			b, _ := ioutil.ReadAll(getResponse.Body)
-			Expect(b).To(Equal(image))
+			Expect(b).NotTo(Equal(image))
+			config, err := jpeg.DecodeConfig(bytes.NewReader(b))
+			Expect(err).To(BeNil())
+			Expect(config.Width).To(BeNumerically("<=", DefaultMaxImageSize))
+			Expect(config.Height).To(BeNumerically("<=", DefaultMaxImageSize))
 			Expect(getError).To(BeNil())
 			Expect(getResponse.StatusCode).To(Equal(http.StatusOK))
 
@@ -0,0 +1,357 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	THUMBNAIL = "thumb"
+
+	DefaultMaxImageSize   = 2048
+	DefaultThumbnailSize  = 320
+	DefaultMaxFileSize    = 10 << 20
+	DefaultMaxImagePixels = 50000000
+
+	jpegQuality = 85
+
+	exifOrientationTag = 0x0112
+)
+
+type ImageVariant struct {
+	Name string
+	// Longest side of the variant, in pixels
+	MaxSize int
+}
+
+// ImageProcessor prepares an uploaded image before it is stored: the image is re-encoded, which drops every
+// metadata (e.g the GPS position added by phones), rotated according to its EXIF orientation and scaled down.
+// Png images stay png, the formats the standard library cannot encode (webp, heic) are stored as jpeg
+type ImageProcessor struct {
+	// Longest side of the stored original, in pixels. 0 keeps the original resolution
+	MaxSize int
+	// Most pixels of an image accepted for decoding, a small file can declare a huge image
+	MaxPixels int
+	Variants  []ImageVariant
+	Converter ImageConverter
+}
+
+type ProcessedImage struct {
+	// Type of the original and of the variants
+	MimeType string
+	Original []byte
+	Variants map[string][]byte
+}
+
+func NewImageProcessor(maxSize, thumbnailSize, maxPixels int) ImageProcessor {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxImageSize
+	}
+	if thumbnailSize <= 0 {
+		thumbnailSize = DefaultThumbnailSize
+	}
+	if maxPixels <= 0 {
+		maxPixels = DefaultMaxImagePixels
+	}
+	return ImageProcessor{
+		MaxSize:   maxSize,
+		MaxPixels: maxPixels,
+		Variants:  []ImageVariant{{Name: THUMBNAIL, MaxSize: thumbnailSize}},
+	}
+}
+
+func (p ImageProcessor) Process(ctx context.Context, raw []byte) (ProcessedImage, error) {
+	switch mimeType := SniffImageType(raw); mimeType {
+	case MIME_JPEG:
+		return p.processJpeg(raw)
+	case MIME_PNG:
+		return p.processPng(raw)
+	case MIME_WEBP, MIME_HEIC:
+		if p.Converter == nil {
+			return ProcessedImage{}, errors.Wrap(ErrUnsupportedFileFormat, "no converter configured for "+mimeType)
+		}
+		converted, err := p.Converter.ToJpeg(ctx, raw, mimeType)
+		if err != nil {
+			return ProcessedImage{}, err
+		}
+		return p.processJpeg(converted)
+	default:
+		return ProcessedImage{}, ErrUnsupportedFileFormat
+	}
+}
+
+func (p ImageProcessor) processJpeg(raw []byte) (ProcessedImage, error) {
+	if err := p.checkPixels(raw, jpeg.DecodeConfig); err != nil {
+		return ProcessedImage{}, err
+	}
+	img, err := jpeg.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return ProcessedImage{}, errors.Wrap(ErrUnsupportedFileFormat, err.Error())
+	}
+	orientation := exifOrientation(raw)
+
+	// scaling first keeps the rotation cheap, the longest side does not depend on the orientation
+	return p.encodeAll(MIME_JPEG, func(maxSize int) ([]byte, error) {
+		return encodeJpeg(orient(resize(img, maxSize), orientation))
+	})
+}
+
+// processPng drops the ancillary chunks (text, exif...) by re-encoding, png have no orientation to apply
+func (p ImageProcessor) processPng(raw []byte) (ProcessedImage, error) {
+	if err := p.checkPixels(raw, png.DecodeConfig); err != nil {
+		return ProcessedImage{}, err
+	}
+	img, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return ProcessedImage{}, errors.Wrap(ErrUnsupportedFileFormat, err.Error())
+	}
+
+	return p.encodeAll(MIME_PNG, func(maxSize int) ([]byte, error) {
+		return encodePng(resize(img, maxSize))
+	})
+}
+
+// checkPixels reads the dimensions declared by the header, the decoders allocate every pixel before reading them
+func (p ImageProcessor) checkPixels(raw []byte, decodeConfig func(r io.Reader) (image.Config, error)) error {
+	config, err := decodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return errors.Wrap(ErrUnsupportedFileFormat, err.Error())
+	}
+	if p.MaxPixels > 0 && int64(config.Width)*int64(config.Height) > int64(p.MaxPixels) {
+		return errors.Wrap(ErrFileTooLarge, fmt.Sprintf("%dx%d pixels", config.Width, config.Height))
+	}
+	return nil
+}
+
+// encodeAll encodes the original and every variant at their own size
+func (p ImageProcessor) encodeAll(mimeType string, encode func(maxSize int) ([]byte, error)) (ProcessedImage, error) {
+	original, err := encode(p.MaxSize)
+	if err != nil {
+		return ProcessedImage{}, err
+	}
+
+	processed := ProcessedImage{
+		MimeType: mimeType,
+		Original: original,
+		Variants: map[string][]byte{},
+	}
+	for _, variant := range p.Variants {
+		encoded, err := encode(variant.MaxSize)
+		if err != nil {
+			return ProcessedImage{}, err
+		}
+		processed.Variants[variant.Name] = encoded
+	}
+	return processed, nil
+}
+
+// VariantName returns where a variant of a stored file lives, e.g daycares/foo/bar_thumb.jpg
+func VariantName(fileName, variant string) string {
+	if fileName == "" {
+		return ""
+	}
+	ext := path.Ext(fileName)
+	return strings.TrimSuffix(fileName, ext) + "_" + variant + ext
+}
+
+func ThumbnailName(fileName string) string {
+	return VariantName(fileName, THUMBNAIL)
+}
+
+func encodeJpeg(img image.Image) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, errors.Wrap(err, "failed to encode image")
+	}
+	return buf.Bytes(), nil
+}
+
+func encodePng(img image.Image) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		return nil, errors.Wrap(err, "failed to encode image")
+	}
+	return buf.Bytes(), nil
+}
+
+// resize scales the image down so that its longest side is at most maxSize, each pixel being the average of the
+// pixels it replaces. Images are never scaled up
+func resize(img image.Image, maxSize int) image.Image {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if maxSize <= 0 || (w <= maxSize && h <= maxSize) {
+		return img
+	}
+
+	dw, dh := maxSize, h*maxSize/w
+	if h > w {
+		dw, dh = w*maxSize/h, maxSize
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	src := toRGBA(img)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		sy0, sy1 := y*h/dh, (y+1)*h/dh
+		if sy1 <= sy0 {
+			sy1 = sy0 + 1
+		}
+		for x := 0; x < dw; x++ {
+			sx0, sx1 := x*w/dw, (x+1)*w/dw
+			if sx1 <= sx0 {
+				sx1 = sx0 + 1
+			}
+
+			var r, g, b, a, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				i := src.PixOffset(sx0, sy)
+				for sx := sx0; sx < sx1; sx++ {
+					r += uint32(src.Pix[i])
+					g += uint32(src.Pix[i+1])
+					b += uint32(src.Pix[i+2])
+					a += uint32(src.Pix[i+3])
+					n++
+					i += 4
+				}
+			}
+
+			o := dst.PixOffset(x, y)
+			dst.Pix[o] = uint8(r / n)
+			dst.Pix[o+1] = uint8(g / n)
+			dst.Pix[o+2] = uint8(b / n)
+			dst.Pix[o+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// orient applies an EXIF orientation (1 to 8) so that the image is displayed upright without its metadata
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	src := toRGBA(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		// 5 to 8 swap width and height
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // upside down and mirrored
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // needs a clockwise rotation
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // needs a counter clockwise rotation
+				sx, sy = w-1-y, x
+			}
+			o, i := dst.PixOffset(x, y), src.PixOffset(sx, sy)
+			copy(dst.Pix[o:o+4], src.Pix[i:i+4])
+		}
+	}
+	return dst
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == image.ZP {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
+
+// exifOrientation looks for the orientation tag in the EXIF segment of a jpeg, 1 (upright) when there is none
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	offset := 2
+	for offset+4 <= len(data) {
+		if data[offset] != 0xFF {
+			return 1
+		}
+		marker := data[offset+1]
+		// metadata segments are all before the start of the image data
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		if length < 2 || offset+2+length > len(data) {
+			return 1
+		}
+		segment := data[offset+4 : offset+2+length]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+		offset += 2 + length
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
@@ -0,0 +1,257 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+
+	. "github.com/Vinubaba/SANTC-API/common/storage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+type fakeConverter struct {
+	jpeg     []byte
+	mimeType string
+}
+
+func (c *fakeConverter) ToJpeg(ctx context.Context, data []byte, mimeType string) ([]byte, error) {
+	c.mimeType = mimeType
+	return c.jpeg, nil
+}
+
+var _ = Describe("Images", func() {
+
+	var (
+		processor     ImageProcessor
+		raw           []byte
+		processed     ProcessedImage
+		returnedError error
+	)
+
+	// left half is red, right half is blue
+	var newJpeg = func(width, height int) []byte {
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				if x < width/2 {
+					img.Set(x, y, color.RGBA{R: 255, A: 255})
+				} else {
+					img.Set(x, y, color.RGBA{B: 255, A: 255})
+				}
+			}
+		}
+		buf := &bytes.Buffer{}
+		jpeg.Encode(buf, img, &jpeg.Options{Quality: 100})
+		return buf.Bytes()
+	}
+
+	// adds an EXIF segment right after the start of image marker, like a phone camera would
+	var withExifOrientation = func(data []byte, orientation byte) []byte {
+		tiff := []byte{
+			'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // big endian header, IFD0 at offset 8
+			0x00, 0x01, // 1 entry
+			0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, orientation, 0x00, 0x00, // orientation, SHORT
+			0x00, 0x00, 0x00, 0x00, // no next IFD
+		}
+		segment := append([]byte("Exif\x00\x00"), tiff...)
+		length := len(segment) + 2
+
+		ret := []byte{0xFF, 0xD8, 0xFF, 0xE1, byte(length >> 8), byte(length)}
+		ret = append(ret, segment...)
+		return append(ret, data[2:]...)
+	}
+
+	var newPng = func(width, height int) []byte {
+		buf := &bytes.Buffer{}
+		png.Encode(buf, image.NewRGBA(image.Rect(0, 0, width, height)))
+		return buf.Bytes()
+	}
+
+	// patches the dimensions of the header, the pixels stay the ones of a tiny image
+	var withDeclaredSize = func(data []byte, width, height int) []byte {
+		patched := append([]byte{}, data...)
+		if bytes.HasPrefix(patched, []byte("\x89PNG")) {
+			// IHDR is the first chunk, its crc covers the type and the data
+			binary.BigEndian.PutUint32(patched[16:], uint32(width))
+			binary.BigEndian.PutUint32(patched[20:], uint32(height))
+			binary.BigEndian.PutUint32(patched[29:], crc32.ChecksumIEEE(patched[12:29]))
+			return patched
+		}
+		sof := bytes.Index(patched, []byte{0xFF, 0xC0})
+		binary.BigEndian.PutUint16(patched[sof+5:], uint16(height))
+		binary.BigEndian.PutUint16(patched[sof+7:], uint16(width))
+		return patched
+	}
+
+	var decode = func(data []byte) image.Image {
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		Expect(err).To(BeNil())
+		return img
+	}
+
+	var isRed = func(c color.Color) bool {
+		r, _, b, _ := c.RGBA()
+		return r>>8 > 200 && b>>8 < 60
+	}
+
+	BeforeEach(func() {
+		processor = NewImageProcessor(100, 20, 0)
+		raw = newJpeg(80, 40)
+	})
+
+	JustBeforeEach(func() {
+		processed, returnedError = processor.Process(context.Background(), raw)
+	})
+
+	Context("Default", func() {
+		It("should not return an error", func() {
+			Expect(returnedError).To(BeNil())
+		})
+		It("should be a jpeg", func() {
+			Expect(processed.MimeType).To(Equal(MIME_JPEG))
+		})
+		It("should keep a small original as is", func() {
+			Expect(decode(processed.Original).Bounds().Size()).To(Equal(image.Pt(80, 40)))
+		})
+		It("should generate a thumbnail", func() {
+			Expect(processed.Variants).To(HaveKey(THUMBNAIL))
+			Expect(decode(processed.Variants[THUMBNAIL]).Bounds().Size()).To(Equal(image.Pt(20, 10)))
+		})
+	})
+
+	Context("When the image is bigger than the max size", func() {
+		BeforeEach(func() {
+			raw = newJpeg(300, 150)
+		})
+		It("should scale it down keeping the ratio", func() {
+			Expect(decode(processed.Original).Bounds().Size()).To(Equal(image.Pt(100, 50)))
+		})
+	})
+
+	Context("When the image has an EXIF orientation", func() {
+		BeforeEach(func() {
+			// the camera was rotated, the image must be turned clockwise to be displayed
+			raw = withExifOrientation(newJpeg(80, 40), 6)
+		})
+		It("should rotate the image", func() {
+			img := decode(processed.Original)
+			Expect(img.Bounds().Size()).To(Equal(image.Pt(40, 80)))
+			// the left half is now on top
+			Expect(isRed(img.At(20, 10))).To(BeTrue())
+			Expect(isRed(img.At(20, 70))).To(BeFalse())
+		})
+		It("should rotate the thumbnail", func() {
+			Expect(decode(processed.Variants[THUMBNAIL]).Bounds().Size()).To(Equal(image.Pt(10, 20)))
+		})
+		It("should strip the metadata", func() {
+			Expect(bytes.Contains(processed.Original, []byte("Exif"))).To(BeFalse())
+			Expect(bytes.Contains(processed.Variants[THUMBNAIL], []byte("Exif"))).To(BeFalse())
+		})
+	})
+
+	Context("When the image is a png", func() {
+		BeforeEach(func() {
+			raw = newPng(300, 150)
+		})
+		It("should stay a png", func() {
+			Expect(returnedError).To(BeNil())
+			Expect(processed.MimeType).To(Equal(MIME_PNG))
+			img, err := png.Decode(bytes.NewReader(processed.Original))
+			Expect(err).To(BeNil())
+			Expect(img.Bounds().Size()).To(Equal(image.Pt(100, 50)))
+		})
+		It("should generate a png thumbnail", func() {
+			img, err := png.Decode(bytes.NewReader(processed.Variants[THUMBNAIL]))
+			Expect(err).To(BeNil())
+			Expect(img.Bounds().Size()).To(Equal(image.Pt(20, 10)))
+		})
+	})
+
+	Context("When the image is a webp", func() {
+		var converter *fakeConverter
+		BeforeEach(func() {
+			converter = &fakeConverter{jpeg: newJpeg(300, 150)}
+			processor.Converter = converter
+			raw = []byte("RIFF\x00\x00\x00\x00WEBPVP8 ")
+		})
+		It("should be converted to jpeg", func() {
+			Expect(returnedError).To(BeNil())
+			Expect(converter.mimeType).To(Equal(MIME_WEBP))
+			Expect(processed.MimeType).To(Equal(MIME_JPEG))
+			Expect(decode(processed.Original).Bounds().Size()).To(Equal(image.Pt(100, 50)))
+		})
+	})
+
+	Context("When the image is a heic and there is no converter", func() {
+		BeforeEach(func() {
+			raw = []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00")
+		})
+		It("should return ErrUnsupportedFileFormat", func() {
+			Expect(errors.Cause(returnedError)).To(Equal(ErrUnsupportedFileFormat))
+		})
+	})
+
+	Context("When a small jpeg declares huge dimensions", func() {
+		BeforeEach(func() {
+			raw = withDeclaredSize(newJpeg(8, 8), 50000, 50000)
+		})
+		It("should refuse it before decoding", func() {
+			Expect(len(raw)).To(BeNumerically("<", 10<<10))
+			Expect(errors.Cause(returnedError)).To(Equal(ErrFileTooLarge))
+		})
+	})
+
+	Context("When a small png declares huge dimensions", func() {
+		BeforeEach(func() {
+			raw = withDeclaredSize(newPng(8, 8), 50000, 50000)
+		})
+		It("should refuse it before decoding", func() {
+			Expect(errors.Cause(returnedError)).To(Equal(ErrFileTooLarge))
+		})
+	})
+
+	Context("When the converter returns a huge image", func() {
+		BeforeEach(func() {
+			processor.Converter = &fakeConverter{jpeg: withDeclaredSize(newJpeg(8, 8), 60000, 60000)}
+			raw = []byte("RIFF\x00\x00\x00\x00WEBPVP8 ")
+		})
+		It("should refuse it before decoding", func() {
+			Expect(errors.Cause(returnedError)).To(Equal(ErrFileTooLarge))
+		})
+	})
+
+	Context("When the image has more pixels than allowed", func() {
+		BeforeEach(func() {
+			processor.MaxPixels = 80*40 - 1
+		})
+		It("should refuse it", func() {
+			Expect(errors.Cause(returnedError)).To(Equal(ErrFileTooLarge))
+		})
+	})
+
+	Context("When the file is not an image", func() {
+		BeforeEach(func() {
+			raw = []byte("not an image")
+		})
+		It("should return ErrUnsupportedFileFormat", func() {
+			Expect(errors.Cause(returnedError)).To(Equal(ErrUnsupportedFileFormat))
+		})
+	})
+
+	Describe("ThumbnailName", func() {
+		It("should be stored next to the original", func() {
+			Expect(ThumbnailName("daycares/peyredragon/children/aaa.jpg")).To(Equal("daycares/peyredragon/children/aaa_thumb.jpg"))
+		})
+		It("should be empty without image", func() {
+			Expect(ThumbnailName("")).To(Equal(""))
+		})
+	})
+})
@@ -2,74 +2,254 @@ package storage
 
 import (
 	"context"
-	b64 "encoding/base64"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"path"
-
-	"github.com/Vinubaba/SANTC-API/api/shared"
-)
-
-const (
-	jpegMimetype = "image/jpeg"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
-	ErrUnsupportedFileFormat = errors.New("for now, only jpeg is supported. the image must have the following pattern: 'data:image/jpeg;base64,[big 64encoded image string]'")
+	ErrInvalidSignature = errors.New("invalid or expired signature")
 )
 
-type Storage interface {
-	Store(ctx context.Context, b64image string, folder string) (string, error)
-	Get(ctx context.Context, filename string) (string, error)
-	Delete(ctx context.Context, filename string) error
+type LocalOptions struct {
+	// Directory holding the files, it must be shared by the api and the event-manager
+	Path string
+	// Where the api serves the files, e.g http://localhost:8080/files
+	Url string
+	// Key signing the urls, it must be the same in every process
+	Secret string
+	ImageOptions
 }
 
+func NewLocal(options LocalOptions) (*LocalStorage, error) {
+	if options.Path == "" {
+		return nil, errors.New("a path is mandatory to store files locally")
+	}
+	if options.Secret == "" {
+		return nil, errors.New("a secret is mandatory to sign urls")
+	}
+	baseUrl, err := url.Parse(options.Url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %v", err)
+	}
+	baseUrl.Path = strings.TrimSuffix(baseUrl.Path, "/")
+
+	if err := os.MkdirAll(options.Path, 0755); err != nil {
+		return nil, err
+	}
+
+	return &LocalStorage{
+		imageStore: newImageStore(options.ImageOptions),
+		root:       options.Path,
+		url:        baseUrl,
+		secret:     []byte(options.Secret),
+	}, nil
+}
+
+// LocalStorage keeps the files on the filesystem, for development or a single host deployment. Like with a bucket,
+// files are downloaded through signed urls that expire, see ServeHTTP
 type LocalStorage struct {
-	Config          *shared.AppConfig `inject:""`
+	imageStore
+	root            string
+	url             *url.URL
+	secret          []byte
 	StringGenerator interface {
 		GenerateUuid() string
 	} `inject:""`
 }
 
-func (s *LocalStorage) Store(ctx context.Context, encodedImage, mimeType string, folder string) (string, error) {
-	if mimeType != jpegMimetype {
-		return "", ErrUnsupportedFileFormat
+func (s *LocalStorage) Store(ctx context.Context, b64image string, folder string) (string, error) {
+	if b64image == "" {
+		return "", nil
+	}
+	decoded, err := s.decode(b64image)
+	if err != nil {
+		return "", err
 	}
+	return s.store(ctx, s.StringGenerator.GenerateUuid(), decoded, folder, s.write)
+}
 
-	decoded, err := b64.StdEncoding.DecodeString(encodedImage)
+func (s *LocalStorage) StoreFile(ctx context.Context, file io.Reader, contentType string, folder string) (string, error) {
+	content, err := s.read(file, contentType)
 	if err != nil {
 		return "", err
 	}
+	return s.store(ctx, s.StringGenerator.GenerateUuid(), content, folder, s.write)
+}
 
-	id := s.StringGenerator.GenerateUuid()
-	var fileName string
-	if folder != "" {
-		fileName = path.Clean(s.Config.LocalStoragePath + "/" + folder + "/" + id + ".jpg")
-	} else {
-		fileName = path.Clean(s.Config.LocalStoragePath + "/" + id + ".jpg")
+// write goes through a temporary file so that a file being written is never served
+func (s *LocalStorage) write(fileName, contentType string, content []byte) error {
+	filePath := s.filePath(fileName)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
 	}
 
-	file, err := os.Create(fileName)
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), ".upload")
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer file.Close()
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// returns signedUrls
+func (s *LocalStorage) Get(ctx context.Context, fileName string, usage UrlUsage) (SignedUrl, error) {
+	return s.signedUrl(fileName, usage, s.signUrl)
+}
+
+func (s *LocalStorage) GetThumbnail(ctx context.Context, fileName string) (SignedUrl, error) {
+	return s.thumbnailUrl(ctx, fileName, s.exists, s.signUrl)
+}
+
+func (s *LocalStorage) exists(ctx context.Context, fileName string) (bool, error) {
+	_, err := os.Stat(s.filePath(fileName))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *LocalStorage) signUrl(fileName string, expires time.Time) (string, error) {
+	expiresAt := strconv.FormatInt(expires.Unix(), 10)
 
-	_, err = file.Write(decoded)
+	signedUrl := *s.url
+	signedUrl.Path = s.url.Path + "/" + fileName
+	signedUrl.RawQuery = url.Values{
+		"expires":   {expiresAt},
+		"signature": {s.signature(fileName, expiresAt)},
+	}.Encode()
+	return signedUrl.String(), nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, fileName string) error {
+	if fileName == "" {
+		return nil
+	}
+
+	s.thumbnails.forget(ThumbnailName(fileName))
+	for _, variant := range s.variantNames(fileName) {
+		if err := os.Remove(s.filePath(variant)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Remove(s.filePath(fileName))
+}
+
+func (s *LocalStorage) List(ctx context.Context, prefix string) ([]StoredFile, error) {
+	files := []StoredFile{}
+
+	// only the directory of the prefix is walked through
+	start := s.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = s.filePath(prefix[:i])
+	}
+	err := filepath.Walk(start, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if filePath == start && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		// temporary files are being written, see write
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".upload") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, filePath)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			files = append(files, StoredFile{Name: name, Updated: info.ModTime()})
+		}
+		return nil
+	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	file.Sync()
+	return files, nil
+}
 
-	return fileName, nil
+// UrlPath is where the handler must be mounted, e.g /files
+func (s *LocalStorage) UrlPath() string {
+	return s.url.Path + "/"
 }
 
-func (s *LocalStorage) Get(ctx context.Context, filePath string) (string, error) {
-	file, err := ioutil.ReadFile(filePath)
+// ServeHTTP serves the files of the urls returned by Get, the path of the request being the name of the file
+func (s *LocalStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// cleaning a rooted path removes every ..
+	fileName := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(r.URL.Path, s.url.Path)), "/")
+	expires := r.URL.Query().Get("expires")
+
+	if err := s.verify(fileName, expires, r.URL.Query().Get("signature")); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	file, err := os.Open(s.filePath(fileName))
 	if err != nil {
-		return "", err
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		http.NotFound(w, r)
+		return
 	}
 
-	return b64.StdEncoding.EncodeToString(file), nil
+	if contentType, ok := mimeTypes[path.Ext(fileName)]; ok {
+		w.Header().Set("Content-Type", contentType)
+	}
+	// the file can be kept as long as the url is valid
+	expiresAt, _ := strconv.ParseInt(expires, 10, 64)
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.FormatInt(expiresAt-time.Now().Unix(), 10))
+	http.ServeContent(w, r, fileName, stat.ModTime(), file)
+}
+
+func (s *LocalStorage) verify(fileName, expires, signature string) error {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return ErrInvalidSignature
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, s.mac(fileName, expires)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (s *LocalStorage) signature(fileName, expires string) string {
+	return hex.EncodeToString(s.mac(fileName, expires))
+}
+
+func (s *LocalStorage) mac(fileName, expires string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(fileName + "\n" + expires))
+	return mac.Sum(nil)
+}
+
+func (s *LocalStorage) filePath(fileName string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+fileName)))
 }
@@ -1,88 +1,197 @@
 package storage_test
 
 import (
+	"bytes"
 	"context"
 	b64 "encoding/base64"
+	"image"
+	"image/jpeg"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
-	"github.com/Vinubaba/SANTC-API/api/shared"
 	. "github.com/Vinubaba/SANTC-API/api/shared/mocks"
 	. "github.com/Vinubaba/SANTC-API/common/storage"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
 )
 
 var _ = Describe("LocalFilesystem", func() {
 
 	var (
-		storage             LocalStorage
-		config              *shared.AppConfig
+		storage             *LocalStorage
+		root                string
 		mockStringGenerator *MockStringGenerator
 		ctx                 = context.Background()
+		dataUri             string
 	)
 
+	var serve = func(rawUrl string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, rawUrl, nil)
+		storage.ServeHTTP(recorder, req)
+		return recorder
+	}
+
 	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "local-storage")
+		Expect(err).To(BeNil())
+
 		mockStringGenerator = &MockStringGenerator{}
-		config = &shared.AppConfig{
-			LocalStoragePath: "test_data",
-		}
-
-		storage = LocalStorage{
-			StringGenerator: mockStringGenerator,
-			Config:          config,
-		}
+		mockStringGenerator.On("GenerateUuid").Return("aze3215fe-513df")
+
+		storage, err = NewLocal(LocalOptions{
+			Path:   root,
+			Url:    "http://localhost:8080/files",
+			Secret: "secret",
+		})
+		Expect(err).To(BeNil())
+		storage.StringGenerator = mockStringGenerator
+
+		buf := &bytes.Buffer{}
+		jpeg.Encode(buf, image.NewRGBA(image.Rect(0, 0, 40, 20)), nil)
+		dataUri = "data:image/jpeg;base64," + b64.StdEncoding.EncodeToString(buf.Bytes())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
 	})
 
 	Context("Store", func() {
 
 		var (
-			encodedImage  string
 			returnedError error
-			uri           string
+			fileName      string
 		)
 
-		BeforeEach(func() {
-			mockStringGenerator.On("GenerateUuid").Return("aze3215fe-513df")
+		JustBeforeEach(func() {
+			fileName, returnedError = storage.Store(ctx, dataUri, "daycares/namek/children")
+		})
+
+		It("should not return an error", func() {
+			Expect(returnedError).To(BeNil())
 		})
 
-		AfterEach(func() {
-			os.Remove("test_data/aze3215fe-513df.jpg")
+		It("should return the name of the file", func() {
+			Expect(fileName).To(Equal("daycares/namek/children/aze3215fe-513df.jpg"))
 		})
 
-		JustBeforeEach(func() {
-			image, _ := ioutil.ReadFile("test_data/DSCF6458.JPG")
-			encodedImage = b64.RawStdEncoding.EncodeToString(image)
-			uri, returnedError = storage.Store(ctx, encodedImage, "image/jpeg", "")
+		It("should write the image and its thumbnail", func() {
+			Expect(filepath.Join(root, "daycares/namek/children/aze3215fe-513df.jpg")).To(BeAnExistingFile())
+			Expect(filepath.Join(root, "daycares/namek/children/aze3215fe-513df_thumb.jpg")).To(BeAnExistingFile())
 		})
 
-		It("should not return an error", func() {
-			Expect(returnedError).To(BeNil())
+		Context("When the image is not a data uri", func() {
+			BeforeEach(func() {
+				dataUri = "foo"
+			})
+			It("should return ErrUnsupportedFileFormat", func() {
+				Expect(errors.Cause(returnedError)).To(Equal(ErrUnsupportedFileFormat))
+			})
 		})
+	})
 
-		It("should return the right uri", func() {
-			Expect(uri).To(Equal("test_data/aze3215fe-513df.jpg"))
+	Context("StoreFile", func() {
+		It("should write the uploaded image", func() {
+			content, _ := b64.StdEncoding.DecodeString(dataUri[len("data:image/jpeg;base64,"):])
+			fileName, err := storage.StoreFile(ctx, bytes.NewReader(content), "image/jpeg", "")
+			Expect(err).To(BeNil())
+			Expect(fileName).To(Equal("aze3215fe-513df.jpg"))
+			Expect(filepath.Join(root, "aze3215fe-513df.jpg")).To(BeAnExistingFile())
 		})
 	})
 
-	Context("Store", func() {
+	Context("Get", func() {
 
 		var (
-			encodedFile   string
-			returnedError error
+			signedUrl string
+			fileName  string
 		)
 
-		JustBeforeEach(func() {
-			encodedFile, returnedError = storage.Get(ctx, "test_data/DSCF6458.JPG")
+		BeforeEach(func() {
+			var err error
+			fileName, err = storage.Store(ctx, dataUri, "daycares/namek/children")
+			Expect(err).To(BeNil())
+			uri, err := storage.Get(ctx, fileName, USAGE_DOWNLOAD)
+			Expect(err).To(BeNil())
+			signedUrl = uri.Url
 		})
 
-		It("should not return an error", func() {
-			Expect(returnedError).To(BeNil())
+		It("should return a signed url", func() {
+			parsed, err := url.Parse(signedUrl)
+			Expect(err).To(BeNil())
+			Expect(parsed.Host).To(Equal("localhost:8080"))
+			Expect(parsed.Path).To(Equal("/files/daycares/namek/children/aze3215fe-513df.jpg"))
+			Expect(parsed.Query().Get("signature")).NotTo(BeEmpty())
+			Expect(parsed.Query().Get("expires")).NotTo(BeEmpty())
+		})
+
+		It("should serve the file of a signed url", func() {
+			recorder := serve(signedUrl)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Header().Get("Content-Type")).To(Equal("image/jpeg"))
+			stored, _ := ioutil.ReadFile(filepath.Join(root, fileName))
+			Expect(recorder.Body.Bytes()).To(Equal(stored))
+		})
+
+		It("should refuse a url signed for another file", func() {
+			parsed, _ := url.Parse(signedUrl)
+			parsed.Path = "/files/daycares/namek/children/aze3215fe-513df_thumb.jpg"
+			Expect(serve(parsed.String()).Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("should refuse an expired url", func() {
+			parsed, _ := url.Parse(signedUrl)
+			query := parsed.Query()
+			query.Set("expires", strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+			parsed.RawQuery = query.Encode()
+			Expect(serve(parsed.String()).Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("should not serve files outside of its directory", func() {
+			Expect(serve("http://localhost:8080/files/../../etc/passwd?expires=1&signature=00").Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("should return an empty url without file", func() {
+			Expect(storage.Get(ctx, "", USAGE_DOWNLOAD)).To(Equal(SignedUrl{}))
+		})
+	})
+
+	Context("GetThumbnail", func() {
+		It("should sign the image itself when it was stored without thumbnail", func() {
+			// images stored before thumbnails were generated
+			Expect(os.MkdirAll(filepath.Join(root, "daycares/namek/children"), 0755)).To(BeNil())
+			Expect(ioutil.WriteFile(filepath.Join(root, "daycares/namek/children/old.jpg"), []byte("old image"), 0644)).To(BeNil())
+
+			thumbnail, err := storage.GetThumbnail(ctx, "daycares/namek/children/old.jpg")
+			Expect(err).To(BeNil())
+			parsed, err := url.Parse(thumbnail.Url)
+			Expect(err).To(BeNil())
+			Expect(parsed.Path).To(Equal("/files/daycares/namek/children/old.jpg"))
+			Expect(serve(thumbnail.Url).Body.String()).To(Equal("old image"))
+		})
+
+		It("should return an empty url without file", func() {
+			Expect(storage.GetThumbnail(ctx, "")).To(Equal(SignedUrl{}))
 		})
+	})
+
+	Context("Delete", func() {
+		It("should delete the image and its thumbnail", func() {
+			fileName, err := storage.Store(ctx, dataUri, "")
+			Expect(err).To(BeNil())
 
-		It("should return the right encodedFile", func() {
-			Expect(len(encodedFile)).To(Equal(2001288))
+			Expect(storage.Delete(ctx, fileName)).To(BeNil())
+			Expect(filepath.Join(root, fileName)).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(root, ThumbnailName(fileName))).NotTo(BeAnExistingFile())
 		})
 	})
 
@@ -2,6 +2,9 @@ package mocks
 
 import (
 	"context"
+	"io"
+
+	"github.com/Vinubaba/SANTC-API/common/storage"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -17,16 +20,31 @@ func (m *MockGcs) Store(ctx context.Context, b64image string, folder string) (st
 	return args.Get(0).(string), args.Error(1)
 }
 
-func (m *MockGcs) Get(ctx context.Context, filename string) (string, error) {
-	args := m.Called(ctx, filename)
+func (m *MockGcs) StoreFile(ctx context.Context, file io.Reader, contentType string, folder string) (string, error) {
+	args := m.Called(ctx, file, contentType, folder)
 	return args.Get(0).(string), args.Error(1)
 }
 
+func (m *MockGcs) Get(ctx context.Context, filename string, usage storage.UrlUsage) (storage.SignedUrl, error) {
+	args := m.Called(ctx, filename, usage)
+	return args.Get(0).(storage.SignedUrl), args.Error(1)
+}
+
+func (m *MockGcs) GetThumbnail(ctx context.Context, filename string) (storage.SignedUrl, error) {
+	args := m.Called(ctx, filename)
+	return args.Get(0).(storage.SignedUrl), args.Error(1)
+}
+
 func (m *MockGcs) Delete(ctx context.Context, filename string) error {
 	args := m.Called(ctx, filename)
 	return args.Error(0)
 }
 
+func (m *MockGcs) List(ctx context.Context, prefix string) ([]storage.StoredFile, error) {
+	args := m.Called(ctx, prefix)
+	return args.Get(0).([]storage.StoredFile), args.Error(1)
+}
+
 func (m *MockGcs) CallsForMethod(method string) []mock.Call {
 	var calls []mock.Call
 	for _, call := range m.Calls {
@@ -46,6 +64,15 @@ func (m *MockGcs) AssertStoredImage(path string) {
 	})
 }
 
+func (m *MockGcs) AssertStoredFile(path string) {
+	It("should store the file to the right folder", func() {
+		calls := m.CallsForMethod("StoreFile")
+		Expect(calls).To(HaveLen(1))
+		args := calls[0].Arguments
+		Expect(args.String(3)).To(Equal(path))
+	})
+}
+
 func (m *MockGcs) Reset() {
 	m.Mock = mock.Mock{}
 }
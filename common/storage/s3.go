@@ -0,0 +1,371 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3Service         = "s3"
+	s3DateFormat      = "20060102T150405Z"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+type S3Options struct {
+	// e.g http://localhost:9000 for MinIO. Defaults to the AWS endpoint of the region
+	Endpoint   string
+	Region     string
+	BucketName string
+	AccessKey  string
+	SecretKey  string
+	// Puts the bucket in the path of the urls rather than in the host name, as MinIO expects by default
+	PathStyle bool
+	ImageOptions
+}
+
+func NewS3(options S3Options) (*S3Storage, error) {
+	if options.BucketName == "" {
+		return nil, errors.New("a bucket is mandatory")
+	}
+	if options.AccessKey == "" || options.SecretKey == "" {
+		return nil, errors.New("an access key and a secret key are mandatory")
+	}
+	if options.Region == "" {
+		options.Region = "us-east-1"
+	}
+	if options.Endpoint == "" {
+		options.Endpoint = "https://s3." + options.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(options.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %v", err)
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/")
+
+	return &S3Storage{
+		imageStore: newImageStore(options.ImageOptions),
+		client:     &http.Client{Timeout: time.Minute},
+		endpoint:   endpoint,
+		bucket:     options.BucketName,
+		pathStyle:  options.PathStyle,
+		signer: s3Signer{
+			accessKey: options.AccessKey,
+			secretKey: options.SecretKey,
+			region:    options.Region,
+		},
+	}, nil
+}
+
+// S3Storage keeps the files in a bucket of any S3 compatible service (AWS, MinIO...). Like with GCS, files are
+// downloaded through presigned urls
+type S3Storage struct {
+	imageStore
+	client          *http.Client
+	endpoint        *url.URL
+	bucket          string
+	pathStyle       bool
+	signer          s3Signer
+	StringGenerator interface {
+		GenerateUuid() string
+	} `inject:""`
+}
+
+func (s *S3Storage) Store(ctx context.Context, b64image string, folder string) (string, error) {
+	if b64image == "" {
+		return "", nil
+	}
+	decoded, err := s.decode(b64image)
+	if err != nil {
+		return "", err
+	}
+	return s.store(ctx, s.StringGenerator.GenerateUuid(), decoded, folder, s.writer(ctx))
+}
+
+func (s *S3Storage) StoreFile(ctx context.Context, file io.Reader, contentType string, folder string) (string, error) {
+	content, err := s.read(file, contentType)
+	if err != nil {
+		return "", err
+	}
+	return s.store(ctx, s.StringGenerator.GenerateUuid(), content, folder, s.writer(ctx))
+}
+
+func (s *S3Storage) writer(ctx context.Context) func(fileName, contentType string, content []byte) error {
+	return func(fileName, contentType string, content []byte) error {
+		req, err := http.NewRequest(http.MethodPut, s.objectUrl(fileName).String(), bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType)
+		return s.do(ctx, req, content, nil)
+	}
+}
+
+// returns signedUrls
+func (s *S3Storage) Get(ctx context.Context, fileName string, usage UrlUsage) (SignedUrl, error) {
+	return s.signedUrl(fileName, usage, s.signUrl)
+}
+
+func (s *S3Storage) GetThumbnail(ctx context.Context, fileName string) (SignedUrl, error) {
+	return s.thumbnailUrl(ctx, fileName, s.exists, s.signUrl)
+}
+
+// exists asks for the metadata of the file, S3 answers 404 when it is missing
+func (s *S3Storage) exists(ctx context.Context, fileName string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, s.objectUrl(fileName).String(), nil)
+	if err != nil {
+		return false, err
+	}
+	s.signer.sign(req, nil, time.Now())
+
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode/100 != 2:
+		return false, fmt.Errorf("failed to %s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return true, nil
+}
+
+func (s *S3Storage) signUrl(fileName string, expires time.Time) (string, error) {
+	// presigned urls are valid for a number of seconds after their signature
+	now := time.Now().Truncate(time.Second)
+	u := s.objectUrl(fileName)
+	s.signer.presign(u, http.MethodGet, expires.Sub(now), now)
+	return u.String(), nil
+}
+
+// Delete succeeds whether the files exist or not, S3 does not tell
+func (s *S3Storage) Delete(ctx context.Context, fileName string) error {
+	if fileName == "" {
+		return nil
+	}
+
+	s.thumbnails.forget(ThumbnailName(fileName))
+	for _, name := range append(s.variantNames(fileName), fileName) {
+		req, err := http.NewRequest(http.MethodDelete, s.objectUrl(name).String(), nil)
+		if err != nil {
+			return err
+		}
+		if err := s.do(ctx, req, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]StoredFile, error) {
+	files := []StoredFile{}
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		u := s.objectUrl("")
+		u.RawQuery = s3CanonicalQuery(query)
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		result := s3ListResult{}
+		if err := s.do(ctx, req, nil, &result); err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			files = append(files, StoredFile{Name: object.Key, Updated: object.LastModified})
+		}
+		// results come by pages of 1000 objects
+		if !result.IsTruncated {
+			return files, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// do sends a signed request, the xml response is decoded in response when it is not nil
+func (s *S3Storage) do(ctx context.Context, req *http.Request, payload []byte, response interface{}) error {
+	s.signer.sign(req, payload, time.Now())
+
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to %s %s: %s %s", req.Method, req.URL.Path, resp.Status, body)
+	}
+	if response != nil {
+		return xml.NewDecoder(resp.Body).Decode(response)
+	}
+	return nil
+}
+
+func (s *S3Storage) objectUrl(fileName string) *url.URL {
+	u := *s.endpoint
+	key := "/" + strings.TrimPrefix(fileName, "/")
+	if s.pathStyle {
+		key = "/" + s.bucket + key
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	// the path is escaped the way it is signed
+	u.Path = s.endpoint.Path + key
+	u.RawPath = s3Escape(s.endpoint.Path, true) + s3Escape(key, true)
+	return &u
+}
+
+// s3Signer implements the version 4 of the AWS signature, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+type s3Signer struct {
+	accessKey string
+	secretKey string
+	region    string
+}
+
+// sign adds the Authorization header to a request sent to the api
+func (s s3Signer) sign(req *http.Request, payload []byte, now time.Time) {
+	payloadHash := sha256.Sum256(payload)
+	req.Header.Set("X-Amz-Date", now.UTC().Format(s3DateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	signedHeaders, canonicalHeaders := s.canonicalHeaders(headers)
+
+	signature := s.signature(now, req.Method, req.URL.EscapedPath(), req.URL.Query(),
+		canonicalHeaders, signedHeaders, hex.EncodeToString(payloadHash[:]))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.accessKey, s.scope(now), signedHeaders, signature))
+}
+
+// presign adds to the url the query parameters allowing anyone to use it until it expires
+func (s s3Signer) presign(u *url.URL, method string, lifetime time.Duration, now time.Time) {
+	signedHeaders, canonicalHeaders := s.canonicalHeaders(map[string]string{"host": u.Host})
+
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", s3Algorithm)
+	query.Set("X-Amz-Credential", s.accessKey+"/"+s.scope(now))
+	query.Set("X-Amz-Date", now.UTC().Format(s3DateFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(lifetime.Seconds())))
+	query.Set("X-Amz-SignedHeaders", signedHeaders)
+
+	signature := s.signature(now, method, u.EscapedPath(), query, canonicalHeaders, signedHeaders, s3UnsignedPayload)
+	query.Set("X-Amz-Signature", signature)
+	u.RawQuery = s3CanonicalQuery(query)
+}
+
+func (s s3Signer) signature(now time.Time, method, path string, query url.Values,
+	canonicalHeaders, signedHeaders, payloadHash string) string {
+	canonicalRequest := strings.Join([]string{
+		method,
+		path,
+		s3CanonicalQuery(query),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		now.UTC().Format(s3DateFormat),
+		s.scope(now),
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := s3Hmac([]byte("AWS4"+s.secretKey), now.UTC().Format("20060102"))
+	for _, part := range []string{s.region, s3Service, "aws4_request"} {
+		key = s3Hmac(key, part)
+	}
+	return hex.EncodeToString(s3Hmac(key, stringToSign))
+}
+
+func (s s3Signer) scope(now time.Time) string {
+	return now.UTC().Format("20060102") + "/" + s.region + "/" + s3Service + "/aws4_request"
+}
+
+// canonicalHeaders returns the sorted names of the headers and their canonical form, ending with a new line
+func (s s3Signer) canonicalHeaders(headers map[string]string) (string, string) {
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonical := ""
+	for _, name := range names {
+		canonical += name + ":" + headers[name] + "\n"
+	}
+	return strings.Join(names, ";"), canonical
+}
+
+func s3Hmac(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := []string{}
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	params := []string{}
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			params = append(params, s3Escape(key, false)+"="+s3Escape(value, false))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// s3Escape percent-encodes every byte but the unreserved characters of RFC 3986, and the slashes of a path
+func s3Escape(value string, path bool) string {
+	buf := &bytes.Buffer{}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && path:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
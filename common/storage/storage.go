@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	BACKEND_GCS   = "gcs"
+	BACKEND_LOCAL = "local"
+	BACKEND_S3    = "s3"
+)
+
+type UrlUsage string
+
+const (
+	// full size images, opened one at a time
+	USAGE_DOWNLOAD UrlUsage = "download"
+	// thumbnails of the lists, displayed again and again
+	USAGE_THUMBNAIL UrlUsage = "thumbnail"
+
+	DefaultDownloadUrlLifetime  = 3 * time.Minute
+	DefaultThumbnailUrlLifetime = time.Hour
+
+	// the lifetime of the urls is cut in that many expiry buckets, see signedUrl
+	urlBuckets = 4
+)
+
+var (
+	ErrFileTooLarge          = errors.New("the image is too large")
+	ErrUnsupportedFileFormat = errors.New("only jpeg, png, webp and heic images are supported. the image must have the following pattern: 'data:image/[jpeg|png|webp|heic];base64,[big 64encoded image string]'")
+)
+
+type Storage interface {
+	Store(ctx context.Context, b64image string, folder string) (string, error)
+	// StoreFile stores an image sent as a file rather than as a data uri, e.g a multipart upload. contentType is the
+	// declared type of the file, it must match its content unless it is empty or application/octet-stream
+	StoreFile(ctx context.Context, file io.Reader, contentType string, folder string) (string, error)
+	// Get returns a signed url of the file, its lifetime depends on what it is used for
+	Get(ctx context.Context, filename string, usage UrlUsage) (SignedUrl, error)
+	// GetThumbnail returns a signed url of the thumbnail of an image, or of the image itself when it was stored
+	// before thumbnails were generated
+	GetThumbnail(ctx context.Context, filename string) (SignedUrl, error)
+	Delete(ctx context.Context, filename string) error
+	// List returns the files whose name starts with prefix, variants included
+	List(ctx context.Context, prefix string) ([]StoredFile, error)
+}
+
+// StoredFile is a file as listed by Storage.List
+type StoredFile struct {
+	Name    string
+	Updated time.Time
+}
+
+// SignedUrl downloads a file until it expires
+type SignedUrl struct {
+	Url     string
+	Expires time.Time
+}
+
+// ImageOptions are shared by every backend
+type ImageOptions struct {
+	// Longest side of stored images and of their thumbnails, in pixels. Defaults are used when 0
+	MaxImageSize  int
+	ThumbnailSize int
+	// Program turning webp and heic images into jpeg, e.g ImageMagick's convert, and how long it may run
+	ImageConverterCommand string
+	ImageConverterTimeout time.Duration
+	// Biggest image accepted, in bytes, before it is processed. DefaultMaxFileSize is used when 0
+	MaxFileSize int64
+	// Most pixels of an image accepted, width times height. DefaultMaxImagePixels is used when 0
+	MaxImagePixels int
+	// Lifetime of the signed urls of each usage. Defaults are used when 0
+	DownloadUrlLifetime  time.Duration
+	ThumbnailUrlLifetime time.Duration
+}
+
+// imageStore is what the backends have in common, they only differ in the way files are written
+type imageStore struct {
+	processor   ImageProcessor
+	maxFileSize int64
+	lifetimes   map[UrlUsage]time.Duration
+	urls        *urlCache
+	thumbnails  *thumbnailCache
+}
+
+func newImageStore(options ImageOptions) imageStore {
+	s := imageStore{
+		processor:   NewImageProcessor(options.MaxImageSize, options.ThumbnailSize, options.MaxImagePixels),
+		maxFileSize: options.MaxFileSize,
+		lifetimes: map[UrlUsage]time.Duration{
+			USAGE_DOWNLOAD:  options.DownloadUrlLifetime,
+			USAGE_THUMBNAIL: options.ThumbnailUrlLifetime,
+		},
+		urls:       newUrlCache(),
+		thumbnails: newThumbnailCache(),
+	}
+	if s.maxFileSize <= 0 {
+		s.maxFileSize = DefaultMaxFileSize
+	}
+	if s.lifetimes[USAGE_DOWNLOAD] <= 0 {
+		s.lifetimes[USAGE_DOWNLOAD] = DefaultDownloadUrlLifetime
+	}
+	if s.lifetimes[USAGE_THUMBNAIL] <= 0 {
+		s.lifetimes[USAGE_THUMBNAIL] = DefaultThumbnailUrlLifetime
+	}
+	s.processor.Converter = CommandConverter{
+		Command:   options.ImageConverterCommand,
+		MaxPixels: options.MaxImagePixels,
+		Timeout:   options.ImageConverterTimeout,
+	}
+	return s
+}
+
+func (s imageStore) decode(b64image string) ([]byte, error) {
+	decoded, _, err := DecodeDataUri(b64image)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decoded)) > s.maxFileSize {
+		return nil, ErrFileTooLarge
+	}
+	return decoded, nil
+}
+
+func (s imageStore) read(file io.Reader, contentType string) ([]byte, error) {
+	// reading one more byte than allowed tells a file at the limit from a bigger one
+	content, err := ioutil.ReadAll(io.LimitReader(file, s.maxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > s.maxFileSize {
+		return nil, ErrFileTooLarge
+	}
+	if err := checkDeclaredType(contentType, content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// store processes the image then writes its variants and the original, whose name is returned
+func (s imageStore) store(ctx context.Context, id string, content []byte, folder string,
+	write func(fileName, contentType string, content []byte) error) (string, error) {
+	processed, err := s.processor.Process(ctx, content)
+	if err != nil {
+		return "", err
+	}
+
+	// webp and heic images are converted, the extension is the one of the stored format
+	fileName := id + Extension(processed.MimeType)
+	if folder != "" {
+		fileName = folder + "/" + fileName
+	}
+
+	for variant, content := range processed.Variants {
+		if err := write(VariantName(fileName, variant), processed.MimeType, content); err != nil {
+			return "", err
+		}
+	}
+	if err := write(fileName, processed.MimeType, processed.Original); err != nil {
+		return "", err
+	}
+
+	return fileName, nil
+}
+
+// variantNames returns where the variants of a stored file live. Images stored before thumbnails were generated
+// have no variant
+func (s imageStore) variantNames(fileName string) []string {
+	names := []string{}
+	for _, variant := range s.processor.Variants {
+		names = append(names, VariantName(fileName, variant.Name))
+	}
+	return names
+}
+
+// thumbnailUrl returns the url of the thumbnail of the file, or of the file when it has no thumbnail: images stored
+// before thumbnails were generated have none. Whether a file has a thumbnail is only asked once to the backend
+func (s imageStore) thumbnailUrl(ctx context.Context, fileName string, exists func(ctx context.Context, fileName string) (bool, error),
+	sign func(fileName string, expires time.Time) (string, error)) (SignedUrl, error) {
+	if fileName == "" {
+		return SignedUrl{}, nil
+	}
+	thumbnail := ThumbnailName(fileName)
+	found, ok := s.thumbnails.get(thumbnail)
+	if !ok {
+		var err error
+		if found, err = exists(ctx, thumbnail); err != nil {
+			return SignedUrl{}, err
+		}
+		s.thumbnails.put(thumbnail, found)
+	}
+	if !found {
+		thumbnail = fileName
+	}
+	return s.signedUrl(thumbnail, USAGE_THUMBNAIL, sign)
+}
+
+// signedUrl returns the url of the file for the given usage. Rather than expiring one lifetime after each call, urls
+// expire one lifetime after the start of the current expiry bucket, a fraction of the lifetime: every call of the
+// bucket gets the same url, signed once then cached. A url is still valid for at least 3/4 of its lifetime
+func (s imageStore) signedUrl(fileName string, usage UrlUsage, sign func(fileName string, expires time.Time) (string, error)) (SignedUrl, error) {
+	if fileName == "" {
+		return SignedUrl{}, nil
+	}
+	lifetime, ok := s.lifetimes[usage]
+	if !ok {
+		lifetime = s.lifetimes[USAGE_DOWNLOAD]
+	}
+
+	now := time.Now()
+	bucket := lifetime / urlBuckets
+	bucketStart := now.Truncate(bucket)
+	expires := bucketStart.Add(lifetime)
+
+	key := urlCacheKey{fileName: fileName, expires: expires.Unix()}
+	if url, ok := s.urls.get(key); ok {
+		return SignedUrl{Url: url, Expires: expires}, nil
+	}
+	url, err := sign(fileName, expires)
+	if err != nil {
+		return SignedUrl{}, err
+	}
+	// once the next bucket starts, nobody asks for this url anymore
+	s.urls.put(key, url, bucketStart.Add(bucket), now)
+	return SignedUrl{Url: url, Expires: expires}, nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+const urlCacheSweepInterval = time.Minute
+
+type urlCacheKey struct {
+	fileName string
+	expires  int64
+}
+
+type cachedUrl struct {
+	url     string
+	staleAt time.Time
+}
+
+// urlCache keeps the signed urls of the current expiry buckets, it is shared by every request
+type urlCache struct {
+	mutex     sync.Mutex
+	urls      map[urlCacheKey]cachedUrl
+	nextSweep time.Time
+}
+
+func newUrlCache() *urlCache {
+	return &urlCache{urls: map[urlCacheKey]cachedUrl{}}
+}
+
+func (c *urlCache) get(key urlCacheKey) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, ok := c.urls[key]
+	return cached.url, ok
+}
+
+// put caches the url until staleAt, the urls gone stale are removed from time to time
+func (c *urlCache) put(key urlCacheKey, url string, staleAt, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.urls[key] = cachedUrl{url: url, staleAt: staleAt}
+
+	if now.Before(c.nextSweep) {
+		return
+	}
+	for key, cached := range c.urls {
+		if !now.Before(cached.staleAt) {
+			delete(c.urls, key)
+		}
+	}
+	c.nextSweep = now.Add(urlCacheSweepInterval)
+}
+
+// thumbnailCache remembers which images have a thumbnail. A thumbnail is written before its image and removed with
+// it, so the answer does not change while the image exists
+type thumbnailCache struct {
+	mutex  sync.Mutex
+	exists map[string]bool
+}
+
+func newThumbnailCache() *thumbnailCache {
+	return &thumbnailCache{exists: map[string]bool{}}
+}
+
+func (c *thumbnailCache) get(thumbnail string) (exists bool, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	exists, ok = c.exists[thumbnail]
+	return
+}
+
+func (c *thumbnailCache) put(thumbnail string, exists bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.exists[thumbnail] = exists
+}
+
+func (c *thumbnailCache) forget(thumbnail string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.exists, thumbnail)
+}
@@ -0,0 +1,165 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrChildAlreadyCheckedIn = errors.New("child is already checked in")
+	ErrChildNotCheckedIn     = errors.New("child is not checked in")
+)
+
+type Attendance struct {
+	AttendanceId sql.NullString
+	ChildId      sql.NullString
+	CheckIn      time.Time
+	CheckOut     pq.NullTime
+	DroppedBy    sql.NullString
+	PickedUpBy   sql.NullString
+	CheckedInBy  sql.NullString
+	CheckedOutBy sql.NullString
+	// Set when the child was picked up by someone from the authorized pickup list
+	PickupId sql.NullString
+	// Set when an office manager forced the check out of a child picked up by someone who was not authorized
+	UnauthorizedPickup sql.NullBool
+}
+
+type AttendanceSearchOptions struct {
+	ChildId string
+	From    time.Time
+	To      time.Time
+}
+
+func (s *Store) CheckIn(tx *gorm.DB, attendance Attendance) (Attendance, error) {
+	db := s.dbOrTx(tx)
+
+	if _, err := s.getOpenAttendance(db, attendance.ChildId.String); err == nil {
+		return Attendance{}, ErrChildAlreadyCheckedIn
+	} else if err != ErrChildNotCheckedIn {
+		return Attendance{}, err
+	}
+
+	attendance.AttendanceId = s.newId()
+	attendance.CheckOut = pq.NullTime{}
+	attendance.UnauthorizedPickup = sql.NullBool{Bool: false, Valid: true}
+	if err := db.Create(&attendance).Error; err != nil {
+		// a concurrent check in was inserted since the check above
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint \"attendances_open_child_id_key\"") {
+			return Attendance{}, ErrChildAlreadyCheckedIn
+		}
+		return Attendance{}, err
+	}
+
+	return attendance, nil
+}
+
+func (s *Store) CheckOut(tx *gorm.DB, attendance Attendance) (Attendance, error) {
+	db := s.dbOrTx(tx)
+
+	openAttendance, err := s.getOpenAttendance(db, attendance.ChildId.String)
+	if err != nil {
+		return Attendance{}, err
+	}
+
+	res := db.Model(&Attendance{}).
+		Where("attendance_id = ?", openAttendance.AttendanceId.String).
+		Where("check_out IS NULL").
+		Updates(map[string]interface{}{
+			"check_out":           attendance.CheckOut,
+			"picked_up_by":        attendance.PickedUpBy,
+			"checked_out_by":      attendance.CheckedOutBy,
+			"pickup_id":           attendance.PickupId,
+			"unauthorized_pickup": attendance.UnauthorizedPickup.Bool,
+		})
+	if res.Error != nil {
+		return Attendance{}, res.Error
+	}
+	// a concurrent check out closed the attendance since the lookup above
+	if res.RowsAffected == 0 {
+		return Attendance{}, ErrChildNotCheckedIn
+	}
+
+	openAttendance.CheckOut = attendance.CheckOut
+	openAttendance.PickedUpBy = attendance.PickedUpBy
+	openAttendance.CheckedOutBy = attendance.CheckedOutBy
+	openAttendance.PickupId = attendance.PickupId
+	openAttendance.UnauthorizedPickup = sql.NullBool{Bool: attendance.UnauthorizedPickup.Bool, Valid: true}
+	return openAttendance, nil
+}
+
+func (s *Store) getOpenAttendance(tx *gorm.DB, childId string) (Attendance, error) {
+	attendances, err := s.scanAttendanceRows(s.baseAttendanceQuery(tx).
+		Where("attendances.child_id = ?", childId).
+		Where("attendances.check_out IS NULL").
+		Rows())
+	if err != nil {
+		return Attendance{}, err
+	}
+	if len(attendances) == 0 {
+		return Attendance{}, ErrChildNotCheckedIn
+	}
+	return attendances[0], nil
+}
+
+func (s *Store) ListAttendances(tx *gorm.DB, options AttendanceSearchOptions) ([]Attendance, error) {
+	query := s.baseAttendanceQuery(tx)
+	if options.ChildId != "" {
+		query = query.Where("attendances.child_id = ?", options.ChildId)
+	}
+	if !options.From.IsZero() {
+		query = query.Where("attendances.check_in >= ?", options.From)
+	}
+	if !options.To.IsZero() {
+		query = query.Where("attendances.check_in < ?", options.To)
+	}
+
+	return s.scanAttendanceRows(query.Order("attendances.check_in desc").Rows())
+}
+
+func (s *Store) baseAttendanceQuery(tx *gorm.DB) *gorm.DB {
+	db := s.dbOrTx(tx)
+	return db.Table("attendances").
+		Select("attendances.attendance_id," +
+			"attendances.child_id," +
+			"attendances.check_in," +
+			"attendances.check_out," +
+			"attendances.dropped_by," +
+			"attendances.picked_up_by," +
+			"attendances.checked_in_by," +
+			"attendances.checked_out_by," +
+			"attendances.pickup_id," +
+			"attendances.unauthorized_pickup")
+}
+
+func (s *Store) scanAttendanceRows(rows *sql.Rows, err error) ([]Attendance, error) {
+	if err != nil {
+		return []Attendance{}, err
+	}
+	defer rows.Close()
+
+	attendances := []Attendance{}
+	for rows.Next() {
+		currentAttendance := Attendance{}
+		if err := rows.Scan(&currentAttendance.AttendanceId,
+			&currentAttendance.ChildId,
+			&currentAttendance.CheckIn,
+			&currentAttendance.CheckOut,
+			&currentAttendance.DroppedBy,
+			&currentAttendance.PickedUpBy,
+			&currentAttendance.CheckedInBy,
+			&currentAttendance.CheckedOutBy,
+			&currentAttendance.PickupId,
+			&currentAttendance.UnauthorizedPickup,
+		); err != nil {
+			return []Attendance{}, err
+		}
+		attendances = append(attendances, currentAttendance)
+	}
+	return attendances, nil
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrAuthorizedPickupNotFound = errors.New("authorized pickup not found")
+)
+
+type AuthorizedPickup struct {
+	PickupId     sql.NullString
+	ChildId      sql.NullString
+	FirstName    sql.NullString
+	LastName     sql.NullString
+	Phone        sql.NullString
+	Relationship sql.NullString
+	ImageUri     sql.NullString
+	ValidFrom    pq.NullTime
+	ValidUntil   pq.NullTime
+	Approved     sql.NullBool
+	ProposedBy   sql.NullString
+	ApprovedBy   sql.NullString
+
+	// When the signed url of the image stops working
+	ImageUriExpires time.Time `sql:"-"`
+}
+
+// IsValidAt tells whether the pickup can be used at the given time, regardless of its approval
+func (p AuthorizedPickup) IsValidAt(t time.Time) bool {
+	if p.ValidFrom.Valid && t.Before(p.ValidFrom.Time) {
+		return false
+	}
+	// valid_until is a date, the pickup is still allowed during that whole day
+	if p.ValidUntil.Valid && !t.Before(p.ValidUntil.Time.AddDate(0, 0, 1)) {
+		return false
+	}
+	return true
+}
+
+type AuthorizedPickupSearchOptions struct {
+	ChildId      string
+	OnlyApproved bool
+}
+
+func (s *Store) AddAuthorizedPickup(tx *gorm.DB, pickup AuthorizedPickup) (AuthorizedPickup, error) {
+	db := s.dbOrTx(tx)
+
+	pickup.PickupId = s.newId()
+	if err := db.Create(&pickup).Error; err != nil {
+		return AuthorizedPickup{}, err
+	}
+	return pickup, nil
+}
+
+func (s *Store) GetAuthorizedPickup(tx *gorm.DB, pickupId string) (AuthorizedPickup, error) {
+	pickups, err := s.scanAuthorizedPickupRows(s.baseAuthorizedPickupQuery(tx).
+		Where("authorized_pickups.pickup_id = ?", pickupId).
+		Rows())
+	if err != nil {
+		return AuthorizedPickup{}, err
+	}
+	if len(pickups) == 0 {
+		return AuthorizedPickup{}, ErrAuthorizedPickupNotFound
+	}
+	return pickups[0], nil
+}
+
+func (s *Store) ListAuthorizedPickups(tx *gorm.DB, options AuthorizedPickupSearchOptions) ([]AuthorizedPickup, error) {
+	query := s.baseAuthorizedPickupQuery(tx)
+	if options.ChildId != "" {
+		query = query.Where("authorized_pickups.child_id = ?", options.ChildId)
+	}
+	if options.OnlyApproved {
+		query = query.Where("authorized_pickups.approved = true")
+	}
+
+	return s.scanAuthorizedPickupRows(query.Order("authorized_pickups.last_name, authorized_pickups.first_name").Rows())
+}
+
+func (s *Store) UpdateAuthorizedPickup(tx *gorm.DB, pickup AuthorizedPickup) (AuthorizedPickup, error) {
+	db := s.dbOrTx(tx)
+
+	res := db.Model(&AuthorizedPickup{}).Where("pickup_id = ?", pickup.PickupId.String).Updates(pickup)
+	if err := res.Error; err != nil {
+		return AuthorizedPickup{}, err
+	}
+	if res.RowsAffected == 0 {
+		return AuthorizedPickup{}, ErrAuthorizedPickupNotFound
+	}
+
+	return s.GetAuthorizedPickup(db, pickup.PickupId.String)
+}
+
+func (s *Store) ApproveAuthorizedPickup(tx *gorm.DB, pickupId, approvedBy string) error {
+	db := s.dbOrTx(tx)
+
+	res := db.Model(&AuthorizedPickup{}).
+		Where("pickup_id = ?", pickupId).
+		Updates(map[string]interface{}{"approved": true, "approved_by": approvedBy})
+	if err := res.Error; err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return ErrAuthorizedPickupNotFound
+	}
+	return nil
+}
+
+func (s *Store) DeleteAuthorizedPickup(tx *gorm.DB, pickupId string) error {
+	db := s.dbOrTx(tx)
+
+	res := db.Where("pickup_id = ?", pickupId).Delete(&AuthorizedPickup{})
+	if err := res.Error; err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return ErrAuthorizedPickupNotFound
+	}
+	return nil
+}
+
+func (s *Store) baseAuthorizedPickupQuery(tx *gorm.DB) *gorm.DB {
+	db := s.dbOrTx(tx)
+	return db.Table("authorized_pickups").
+		Select("authorized_pickups.pickup_id," +
+			"authorized_pickups.child_id," +
+			"authorized_pickups.first_name," +
+			"authorized_pickups.last_name," +
+			"authorized_pickups.phone," +
+			"authorized_pickups.relationship," +
+			"authorized_pickups.image_uri," +
+			"authorized_pickups.valid_from," +
+			"authorized_pickups.valid_until," +
+			"authorized_pickups.approved," +
+			"authorized_pickups.proposed_by," +
+			"authorized_pickups.approved_by")
+}
+
+func (s *Store) scanAuthorizedPickupRows(rows *sql.Rows, err error) ([]AuthorizedPickup, error) {
+	if err != nil {
+		return []AuthorizedPickup{}, err
+	}
+	defer rows.Close()
+
+	pickups := []AuthorizedPickup{}
+	for rows.Next() {
+		currentPickup := AuthorizedPickup{}
+		if err := rows.Scan(&currentPickup.PickupId,
+			&currentPickup.ChildId,
+			&currentPickup.FirstName,
+			&currentPickup.LastName,
+			&currentPickup.Phone,
+			&currentPickup.Relationship,
+			&currentPickup.ImageUri,
+			&currentPickup.ValidFrom,
+			&currentPickup.ValidUntil,
+			&currentPickup.Approved,
+			&currentPickup.ProposedBy,
+			&currentPickup.ApprovedBy,
+		); err != nil {
+			return []AuthorizedPickup{}, err
+		}
+		pickups = append(pickups, currentPickup)
+	}
+	return pickups, nil
+}
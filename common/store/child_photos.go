@@ -5,6 +5,12 @@ import (
 	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrPhotoNotFound = errors.New("photo not found")
+	ErrPhotoReviewed = errors.New("photo has already been approved or rejected")
 )
 
 type ChildPhoto struct {
@@ -13,50 +19,147 @@ type ChildPhoto struct {
 	PublishedBy     sql.NullString
 	ApprovedBy      sql.NullString
 	ImageUri        sql.NullString
+	ThumbnailUri    sql.NullString `sql:"-"`
 	Approved        sql.NullBool
 	PublicationDate time.Time
+	Rejected        sql.NullBool
+	RejectedBy      sql.NullString
+	RejectionReason sql.NullString
+	// Every child appearing on the photo, including ChildId
+	TaggedChildIds []string `sql:"-"`
+
+	// When the signed urls of the image and of the thumbnail stop working
+	ImageUriExpires     time.Time `sql:"-"`
+	ThumbnailUriExpires time.Time `sql:"-"`
+}
+
+type ChildPhotoTag struct {
+	PhotoId sql.NullString
+	ChildId sql.NullString
 }
 
 func (s *Store) AddChildPhoto(tx *gorm.DB, childPhoto ChildPhoto) error {
-	db := s.dbOrTx(tx)
+	var db *gorm.DB
+	var mustCommitHere bool
+
+	if tx != nil {
+		// Caller is responsible for commiting the transaction
+		mustCommitHere = false
+		db = tx
+	} else {
+		// Transaction is fully handled here
+		mustCommitHere = true
+		db = s.Tx()
+	}
 
 	childPhoto.PhotoId = s.newId()
+	childPhoto.Rejected = sql.NullBool{Bool: false, Valid: true}
 	if err := db.Create(&childPhoto).Error; err != nil {
+		db.Rollback()
 		return err
 	}
+
+	tagged := map[string]bool{}
+	for _, childId := range append([]string{childPhoto.ChildId.String}, childPhoto.TaggedChildIds...) {
+		if childId == "" || tagged[childId] {
+			continue
+		}
+		tagged[childId] = true
+		if err := db.Create(&ChildPhotoTag{
+			PhotoId: childPhoto.PhotoId,
+			ChildId: sql.NullString{String: childId, Valid: true},
+		}).Error; err != nil {
+			db.Rollback()
+			return errors.Wrap(err, "failed to tag child")
+		}
+	}
+
+	if mustCommitHere {
+		db.Commit()
+	}
 	return nil
 }
 
+func (s *Store) GetPhoto(tx *gorm.DB, photoId string) (ChildPhoto, error) {
+	rows, err := s.baseChildPhotoQuery(tx).
+		Where("child_photos.photo_id = ?", photoId).
+		Rows()
+	if err != nil {
+		return ChildPhoto{}, err
+	}
+	photos, err := s.scanChildPhotosRows(rows)
+	if err != nil {
+		return ChildPhoto{}, err
+	}
+	if len(photos) == 0 {
+		return ChildPhoto{}, ErrPhotoNotFound
+	}
+	if err := s.setPhotoTags(tx, photos); err != nil {
+		return ChildPhoto{}, err
+	}
+	return photos[0], nil
+}
+
+// ApprovePhoto returns ErrPhotoReviewed when the photo was approved or rejected in the meantime
 func (s *Store) ApprovePhoto(tx *gorm.DB, photoId, approvedBy string) error {
 	db := s.dbOrTx(tx)
-	return db.Table("child_photos").
-		Where("photo_id = ?", photoId).
-		Update(map[string]interface{}{"approved": true, "approvedBy": approvedBy}).Error
+	res := db.Table("child_photos").
+		Where("photo_id = ? AND approved = false AND rejected = false", photoId).
+		Update(map[string]interface{}{"approved": true, "approved_by": approvedBy})
+	if err := res.Error; err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return ErrPhotoReviewed
+	}
+	return nil
+}
+
+// RejectPhoto returns ErrPhotoReviewed when the photo was approved or rejected in the meantime
+func (s *Store) RejectPhoto(tx *gorm.DB, photoId, rejectedBy, reason string) error {
+	db := s.dbOrTx(tx)
+	res := db.Table("child_photos").
+		Where("photo_id = ? AND approved = false AND rejected = false", photoId).
+		Update(map[string]interface{}{"rejected": true, "rejected_by": rejectedBy, "rejection_reason": reason})
+	if err := res.Error; err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return ErrPhotoReviewed
+	}
+	return nil
 }
 
 func (s *Store) ListPhotos(tx *gorm.DB, options ChildPhotosSearchOptions) ([]ChildPhoto, error) {
 	ret := make([]ChildPhoto, 0)
 
-	db := s.dbOrTx(tx)
-	query := db.Table("child_photos").
-		Select("child_photos.photo_id," +
-			"child_photos.child_id," +
-			"child_photos.published_by," +
-			"child_photos.approved_by," +
-			"child_photos.image_uri," +
-			"child_photos.approved," +
-			"child_photos.publication_date").
-		Joins("join children ON children.child_id = child_photos.child_id")
+	query := s.baseChildPhotoQuery(tx)
 	if options.Approved {
 		query = query.Where("child_photos.approved = true")
 	} else {
-		query = query.Where("child_photos.approved = false")
+		// rejected photos are not waiting for an approval anymore
+		query = query.Where("child_photos.approved = false AND child_photos.rejected = false")
 	}
 	if options.DaycareId != "" {
 		query = query.Where("children.daycare_id = ?", options.DaycareId)
 	}
+	if options.ChildId != "" {
+		query = query.Where("child_photos.photo_id IN (SELECT photo_id FROM child_photo_tags WHERE child_id = ?)", options.ChildId)
+	}
+	if !options.From.IsZero() {
+		query = query.Where("child_photos.publication_date >= ?", options.From)
+	}
+	if !options.To.IsZero() {
+		query = query.Where("child_photos.publication_date < ?", options.To)
+	}
+	if options.Limit > 0 {
+		query = query.Limit(options.Limit)
+	}
+	if options.Offset > 0 {
+		query = query.Offset(options.Offset)
+	}
 
-	rows, err := query.Rows()
+	rows, err := query.Order("child_photos.publication_date desc, child_photos.photo_id").Rows()
 	if err != nil {
 		return ret, err
 	}
@@ -64,16 +167,82 @@ func (s *Store) ListPhotos(tx *gorm.DB, options ChildPhotosSearchOptions) ([]Chi
 	if err != nil {
 		return ret, err
 	}
+	if err := s.setPhotoTags(tx, ret); err != nil {
+		return []ChildPhoto{}, err
+	}
 
 	return ret, nil
 }
 
+// setPhotoTags fills the children tagged on each photo
+func (s *Store) setPhotoTags(tx *gorm.DB, photos []ChildPhoto) error {
+	if len(photos) == 0 {
+		return nil
+	}
+
+	photoIds := []string{}
+	for _, photo := range photos {
+		photoIds = append(photoIds, photo.PhotoId.String)
+	}
+
+	db := s.dbOrTx(tx)
+	rows, err := db.Table("child_photo_tags").
+		Select("child_photo_tags.photo_id, child_photo_tags.child_id").
+		Where("child_photo_tags.photo_id IN (?)", photoIds).
+		Order("child_photo_tags.child_id").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	tags := map[string][]string{}
+	for rows.Next() {
+		var photoId, childId string
+		if err := rows.Scan(&photoId, &childId); err != nil {
+			return err
+		}
+		tags[photoId] = append(tags[photoId], childId)
+	}
+
+	for i := range photos {
+		photos[i].TaggedChildIds = tags[photos[i].PhotoId.String]
+		if photos[i].TaggedChildIds == nil {
+			photos[i].TaggedChildIds = []string{}
+		}
+	}
+	return nil
+}
+
 type ChildPhotosSearchOptions struct {
 	Approved  bool
 	DaycareId string
+	ChildId   string
+	From      time.Time
+	To        time.Time
+	Limit     int
+	Offset    int
+}
+
+func (s *Store) baseChildPhotoQuery(tx *gorm.DB) *gorm.DB {
+	db := s.dbOrTx(tx)
+	return db.Table("child_photos").
+		Select("child_photos.photo_id," +
+			"child_photos.child_id," +
+			"child_photos.published_by," +
+			"child_photos.approved_by," +
+			"child_photos.image_uri," +
+			"child_photos.approved," +
+			"child_photos.publication_date," +
+			"child_photos.rejected," +
+			"child_photos.rejected_by," +
+			"child_photos.rejection_reason").
+		Joins("join children ON children.child_id = child_photos.child_id")
 }
 
 func (s *Store) scanChildPhotosRows(rows *sql.Rows) ([]ChildPhoto, error) {
+	defer rows.Close()
+
 	photos := []ChildPhoto{}
 	for rows.Next() {
 		currentPhoto := ChildPhoto{}
@@ -84,6 +253,9 @@ func (s *Store) scanChildPhotosRows(rows *sql.Rows) ([]ChildPhoto, error) {
 			&currentPhoto.ImageUri,
 			&currentPhoto.Approved,
 			&currentPhoto.PublicationDate,
+			&currentPhoto.Rejected,
+			&currentPhoto.RejectedBy,
+			&currentPhoto.RejectionReason,
 		); err != nil {
 			return []ChildPhoto{}, err
 		}
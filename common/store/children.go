@@ -25,12 +25,17 @@ type Child struct {
 	Gender              sql.NullString
 	StartDate           time.Time
 	ImageUri            sql.NullString
+	ThumbnailUri        sql.NullString `sql:"-"`
 	Notes               sql.NullString
 	SpecialInstructions SpecialInstructions `sql:"-"`
 	Allergies           Allergies           `sql:"-"`
 	ResponsibleId       sql.NullString      `sql:"-"`
 	Relationship        sql.NullString      `sql:"-"`
 	Schedule            Schedule            `sql:"-"`
+
+	// When the signed urls of the image and of the thumbnail stop working
+	ImageUriExpires     time.Time `sql:"-"`
+	ThumbnailUriExpires time.Time `sql:"-"`
 }
 
 func (s *Store) AddChild(tx *gorm.DB, child Child) (Child, error) {
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 )
@@ -14,13 +15,18 @@ var (
 )
 
 type Class struct {
-	ClassId     sql.NullString
-	DaycareId   sql.NullString
-	AgeRangeId  sql.NullString
-	Name        sql.NullString
-	Description sql.NullString
-	ImageUri    sql.NullString
-	AgeRange    AgeRange `sql:"-" gorm:"foreignkey:AgeRangeId association_foreignkey:AgeRangeId"`
+	ClassId      sql.NullString
+	DaycareId    sql.NullString
+	AgeRangeId   sql.NullString
+	Name         sql.NullString
+	Description  sql.NullString
+	ImageUri     sql.NullString
+	ThumbnailUri sql.NullString `sql:"-"`
+	AgeRange     AgeRange       `sql:"-" gorm:"foreignkey:AgeRangeId association_foreignkey:AgeRangeId"`
+
+	// When the signed urls of the image and of the thumbnail stop working
+	ImageUriExpires     time.Time `sql:"-"`
+	ThumbnailUriExpires time.Time `sql:"-"`
 }
 
 func (s *Store) AddClass(tx *gorm.DB, class Class) (Class, error) {
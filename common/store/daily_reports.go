@@ -0,0 +1,137 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+const (
+	DAILY_REPORT_MEAL     = "meal"
+	DAILY_REPORT_NAP      = "nap"
+	DAILY_REPORT_DIAPER   = "diaper"
+	DAILY_REPORT_MOOD     = "mood"
+	DAILY_REPORT_ACTIVITY = "activity"
+)
+
+var (
+	ErrDailyReportEntryNotFound = errors.New("daily report entry not found")
+)
+
+type DailyReportEntry struct {
+	EntryId     sql.NullString
+	ChildId     sql.NullString
+	Type        sql.NullString
+	At          time.Time
+	Amount      sql.NullString
+	Description sql.NullString
+	StartTime   pq.NullTime
+	EndTime     pq.NullTime
+	Diaper      sql.NullString
+	Mood        sql.NullString
+	WrittenBy   sql.NullString
+}
+
+type DailyReportSearchOptions struct {
+	ChildId string
+	From    time.Time
+	To      time.Time
+}
+
+func (s *Store) AddDailyReportEntry(tx *gorm.DB, entry DailyReportEntry) (DailyReportEntry, error) {
+	db := s.dbOrTx(tx)
+
+	entry.EntryId = s.newId()
+	if err := db.Create(&entry).Error; err != nil {
+		return DailyReportEntry{}, err
+	}
+	return entry, nil
+}
+
+func (s *Store) GetDailyReportEntry(tx *gorm.DB, entryId string) (DailyReportEntry, error) {
+	entries, err := s.scanDailyReportEntryRows(s.baseDailyReportEntryQuery(tx).
+		Where("daily_report_entries.entry_id = ?", entryId).
+		Rows())
+	if err != nil {
+		return DailyReportEntry{}, err
+	}
+	if len(entries) == 0 {
+		return DailyReportEntry{}, ErrDailyReportEntryNotFound
+	}
+	return entries[0], nil
+}
+
+func (s *Store) ListDailyReportEntries(tx *gorm.DB, options DailyReportSearchOptions) ([]DailyReportEntry, error) {
+	query := s.baseDailyReportEntryQuery(tx)
+	if options.ChildId != "" {
+		query = query.Where("daily_report_entries.child_id = ?", options.ChildId)
+	}
+	if !options.From.IsZero() {
+		query = query.Where("daily_report_entries.at >= ?", options.From)
+	}
+	if !options.To.IsZero() {
+		query = query.Where("daily_report_entries.at < ?", options.To)
+	}
+
+	return s.scanDailyReportEntryRows(query.Order("daily_report_entries.at").Rows())
+}
+
+func (s *Store) DeleteDailyReportEntry(tx *gorm.DB, entryId string) error {
+	db := s.dbOrTx(tx)
+
+	res := db.Where("entry_id = ?", entryId).Delete(&DailyReportEntry{})
+	if err := res.Error; err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return ErrDailyReportEntryNotFound
+	}
+	return nil
+}
+
+func (s *Store) baseDailyReportEntryQuery(tx *gorm.DB) *gorm.DB {
+	db := s.dbOrTx(tx)
+	return db.Table("daily_report_entries").
+		Select("daily_report_entries.entry_id," +
+			"daily_report_entries.child_id," +
+			"daily_report_entries.type," +
+			"daily_report_entries.at," +
+			"daily_report_entries.amount," +
+			"daily_report_entries.description," +
+			"daily_report_entries.start_time," +
+			"daily_report_entries.end_time," +
+			"daily_report_entries.diaper," +
+			"daily_report_entries.mood," +
+			"daily_report_entries.written_by")
+}
+
+func (s *Store) scanDailyReportEntryRows(rows *sql.Rows, err error) ([]DailyReportEntry, error) {
+	if err != nil {
+		return []DailyReportEntry{}, err
+	}
+	defer rows.Close()
+
+	entries := []DailyReportEntry{}
+	for rows.Next() {
+		currentEntry := DailyReportEntry{}
+		if err := rows.Scan(&currentEntry.EntryId,
+			&currentEntry.ChildId,
+			&currentEntry.Type,
+			&currentEntry.At,
+			&currentEntry.Amount,
+			&currentEntry.Description,
+			&currentEntry.StartTime,
+			&currentEntry.EndTime,
+			&currentEntry.Diaper,
+			&currentEntry.Mood,
+			&currentEntry.WrittenBy,
+		); err != nil {
+			return []DailyReportEntry{}, err
+		}
+		entries = append(entries, currentEntry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// tables holding the name of a stored image in their image_uri column
+var imageTables = []string{"children", "users", "classes", "child_photos", "authorized_pickups"}
+
+// ListImageUris returns the name of every stored image the database refers to, their variants are not included
+func (s *Store) ListImageUris(tx *gorm.DB) ([]string, error) {
+	db := s.dbOrTx(tx)
+
+	query := ""
+	for _, table := range imageTables {
+		if query != "" {
+			query += " UNION "
+		}
+		query += "SELECT image_uri FROM " + table + " WHERE image_uri IS NOT NULL AND image_uri <> ''"
+	}
+
+	rows, err := db.Raw(query).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	imageUris := []string{}
+	for rows.Next() {
+		var imageUri string
+		if err := rows.Scan(&imageUri); err != nil {
+			return nil, err
+		}
+		imageUris = append(imageUris, imageUri)
+	}
+	return imageUris, nil
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvitationNotFound = errors.New("invitation not found")
+)
+
+// Invitation lets someone join a daycare with the roles given by an office manager. Only the hash of its token is
+// kept, the token itself is only issued to the service sending the email
+type Invitation struct {
+	InvitationId string
+	TokenHash    string
+	Email        string
+	FirstName    string
+	LastName     string
+	DaycareId    string
+	InvitedBy    sql.NullString
+	ExpiresAt    time.Time
+	AcceptedAt   pq.NullTime
+	CreatedAt    time.Time
+	// The pending roles, they are deleted once the invitation is accepted
+	Roles []string
+}
+
+func (i Invitation) IsPending(at time.Time) bool {
+	return !i.AcceptedAt.Valid && at.Before(i.ExpiresAt)
+}
+
+type InvitationSearchOptions struct {
+	DaycareId string
+	Email     string
+	// Leaves out the accepted invitations, the expired ones are kept so that they can be sent again
+	NotAccepted bool
+}
+
+// AddInvitation adds the invitation and its pending roles
+func (s *Store) AddInvitation(tx *gorm.DB, invitation Invitation) (Invitation, error) {
+	db := s.dbOrTx(tx)
+
+	invitation.InvitationId = s.newId().String
+	invitation.Email = strings.ToLower(invitation.Email)
+	if err := db.Exec("INSERT INTO invitations (invitation_id, token_hash, email, first_name, last_name, daycare_id, invited_by, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		invitation.InvitationId,
+		invitation.TokenHash,
+		invitation.Email,
+		invitation.FirstName,
+		invitation.LastName,
+		invitation.DaycareId,
+		invitation.InvitedBy,
+		invitation.ExpiresAt).Error; err != nil {
+		return Invitation{}, err
+	}
+	for _, role := range invitation.Roles {
+		if !s.isRoleValid(role) {
+			return Invitation{}, errors.Errorf("role %s is not valid, must be %s", role, enumRoles)
+		}
+		if err := s.CreatePendingConnexionRole(db, PendingConnexionRole{
+			InvitationId: invitation.InvitationId,
+			Email:        invitation.Email,
+			Role:         role,
+		}); err != nil {
+			return Invitation{}, err
+		}
+	}
+	return s.GetInvitation(db, invitation.InvitationId)
+}
+
+func (s *Store) GetInvitation(tx *gorm.DB, invitationId string) (Invitation, error) {
+	invitations, err := s.findInvitations(s.baseInvitationQuery(tx).Where("invitations.invitation_id = ?", invitationId))
+	if err != nil {
+		return Invitation{}, err
+	}
+	if len(invitations) == 0 {
+		return Invitation{}, ErrInvitationNotFound
+	}
+	return invitations[0], nil
+}
+
+func (s *Store) GetInvitationByTokenHash(tx *gorm.DB, tokenHash string) (Invitation, error) {
+	invitations, err := s.findInvitations(s.baseInvitationQuery(tx).Where("invitations.token_hash = ?", tokenHash))
+	if err != nil {
+		return Invitation{}, err
+	}
+	if len(invitations) == 0 {
+		return Invitation{}, ErrInvitationNotFound
+	}
+	return invitations[0], nil
+}
+
+// ListInvitations returns the invitations of the daycare, the newest first
+func (s *Store) ListInvitations(tx *gorm.DB, options InvitationSearchOptions) ([]Invitation, error) {
+	query := s.baseInvitationQuery(tx).Where("invitations.daycare_id = ?", options.DaycareId)
+	if options.Email != "" {
+		query = query.Where("invitations.email = ?", strings.ToLower(options.Email))
+	}
+	if options.NotAccepted {
+		query = query.Where("invitations.accepted_at IS NULL")
+	}
+	return s.findInvitations(query.Order("invitations.created_at DESC"))
+}
+
+// AcceptInvitation returns false when the invitation was already accepted, e.g by a concurrent request, or expired
+func (s *Store) AcceptInvitation(tx *gorm.DB, invitationId string) (bool, error) {
+	db := s.dbOrTx(tx)
+	res := db.Exec("UPDATE invitations SET accepted_at = now() WHERE invitation_id = ? AND accepted_at IS NULL AND expires_at > now()", invitationId)
+	return res.RowsAffected > 0, res.Error
+}
+
+// RenewInvitationToken replaces the token hash of a pending invitation, the previous link stops working.
+// It returns false when the invitation was accepted or expired meanwhile
+func (s *Store) RenewInvitationToken(tx *gorm.DB, invitationId, tokenHash string) (bool, error) {
+	db := s.dbOrTx(tx)
+	res := db.Exec("UPDATE invitations SET token_hash = ? WHERE invitation_id = ? AND accepted_at IS NULL AND expires_at > now()", tokenHash, invitationId)
+	return res.RowsAffected > 0, res.Error
+}
+
+// DeleteInvitation deletes the invitation and its pending roles
+func (s *Store) DeleteInvitation(tx *gorm.DB, invitationId string) error {
+	db := s.dbOrTx(tx)
+	res := db.Exec("DELETE FROM invitations WHERE invitation_id = ?", invitationId)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+func (s *Store) baseInvitationQuery(tx *gorm.DB) *gorm.DB {
+	db := s.dbOrTx(tx)
+	return db.Table("invitations").
+		Select("invitations.invitation_id," +
+			"invitations.token_hash," +
+			"invitations.email," +
+			"invitations.first_name," +
+			"invitations.last_name," +
+			"invitations.daycare_id," +
+			"invitations.invited_by," +
+			"invitations.expires_at," +
+			"invitations.accepted_at," +
+			"invitations.created_at," +
+			"string_agg(pending_connexion_roles.role, ',' ORDER BY pending_connexion_roles.role)").
+		Joins("left join pending_connexion_roles ON pending_connexion_roles.invitation_id = invitations.invitation_id").
+		Group("invitations.invitation_id")
+}
+
+func (s *Store) findInvitations(query *gorm.DB) ([]Invitation, error) {
+	rows, err := query.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []Invitation{}
+	for rows.Next() {
+		invitation := Invitation{}
+		var roles sql.NullString
+		if err := rows.Scan(&invitation.InvitationId,
+			&invitation.TokenHash,
+			&invitation.Email,
+			&invitation.FirstName,
+			&invitation.LastName,
+			&invitation.DaycareId,
+			&invitation.InvitedBy,
+			&invitation.ExpiresAt,
+			&invitation.AcceptedAt,
+			&invitation.CreatedAt,
+			&roles,
+		); err != nil {
+			return nil, err
+		}
+		invitation.Roles = []string{}
+		if roles.String != "" {
+			invitation.Roles = strings.Split(roles.String, ",")
+		}
+		invitations = append(invitations, invitation)
+	}
+	return invitations, rows.Err()
+}
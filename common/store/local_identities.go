@@ -0,0 +1,230 @@
+package store
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrLocalIdentityNotFound      = errors.New("identity not found")
+	ErrLocalIdentityAlreadyExists = errors.New("an identity already exists with this email")
+	ErrRefreshTokenNotFound       = errors.New("refresh token not found")
+	ErrSigningKeyNotFound         = errors.New("signing key not found")
+)
+
+// LocalIdentity is an account of the local identity provider
+type LocalIdentity struct {
+	Uid          string
+	Email        string
+	PasswordHash string
+	Claims       map[string]interface{}
+	CreatedAt    time.Time
+}
+
+type RefreshToken struct {
+	TokenHash string
+	Uid       string
+	ExpiresAt time.Time
+	Revoked   bool
+}
+
+// SigningKey is a RSA private key of the local identity provider, PEM encoded
+type SigningKey struct {
+	KeyId      string
+	PrivateKey string
+	CreatedAt  time.Time
+}
+
+func (s *Store) AddLocalIdentity(tx *gorm.DB, identity LocalIdentity) (LocalIdentity, error) {
+	db := s.dbOrTx(tx)
+	if identity.Claims == nil {
+		identity.Claims = map[string]interface{}{}
+	}
+	claims, err := json.Marshal(identity.Claims)
+	if err != nil {
+		return LocalIdentity{}, err
+	}
+
+	identity.Uid = s.newId().String
+	if err := db.Exec("INSERT INTO local_identities (uid, email, password_hash, claims) VALUES (?, ?, ?, ?)",
+		identity.Uid, strings.ToLower(identity.Email), identity.PasswordHash, string(claims)).Error; err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint \"local_identities_email_key\"") {
+			return LocalIdentity{}, ErrLocalIdentityAlreadyExists
+		}
+		return LocalIdentity{}, err
+	}
+	return s.GetLocalIdentity(db, identity.Uid)
+}
+
+func (s *Store) GetLocalIdentity(tx *gorm.DB, uid string) (LocalIdentity, error) {
+	return s.findLocalIdentity(s.baseLocalIdentityQuery(tx).Where("uid = ?", uid))
+}
+
+// GetLocalIdentityByEmail ignores the case of the email
+func (s *Store) GetLocalIdentityByEmail(tx *gorm.DB, email string) (LocalIdentity, error) {
+	return s.findLocalIdentity(s.baseLocalIdentityQuery(tx).Where("email = ?", strings.ToLower(email)))
+}
+
+func (s *Store) SetLocalIdentityClaims(tx *gorm.DB, uid string, claims map[string]interface{}) error {
+	db := s.dbOrTx(tx)
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return err
+	}
+	res := db.Exec("UPDATE local_identities SET claims = ? WHERE uid = ?", string(data), uid)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrLocalIdentityNotFound
+	}
+	return nil
+}
+
+// DeleteLocalIdentityByEmail deletes the identity and its refresh tokens
+func (s *Store) DeleteLocalIdentityByEmail(tx *gorm.DB, email string) error {
+	db := s.dbOrTx(tx)
+	res := db.Exec("DELETE FROM local_identities WHERE email = ?", strings.ToLower(email))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrLocalIdentityNotFound
+	}
+	return nil
+}
+
+func (s *Store) baseLocalIdentityQuery(tx *gorm.DB) *gorm.DB {
+	db := s.dbOrTx(tx)
+	return db.Table("local_identities").
+		Select("uid," +
+			"email," +
+			"password_hash," +
+			"claims," +
+			"created_at")
+}
+
+func (s *Store) findLocalIdentity(query *gorm.DB) (LocalIdentity, error) {
+	rows, err := query.Rows()
+	if err != nil {
+		return LocalIdentity{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return LocalIdentity{}, ErrLocalIdentityNotFound
+	}
+	identity := LocalIdentity{}
+	var claims []byte
+	if err := rows.Scan(&identity.Uid,
+		&identity.Email,
+		&identity.PasswordHash,
+		&claims,
+		&identity.CreatedAt,
+	); err != nil {
+		return LocalIdentity{}, err
+	}
+	if err := json.Unmarshal(claims, &identity.Claims); err != nil {
+		return LocalIdentity{}, errors.Wrapf(err, "invalid claims for identity %s", identity.Uid)
+	}
+	return identity, nil
+}
+
+func (s *Store) AddRefreshToken(tx *gorm.DB, token RefreshToken) error {
+	db := s.dbOrTx(tx)
+	return db.Exec("INSERT INTO refresh_tokens (token_hash, uid, expires_at) VALUES (?, ?, ?)",
+		token.TokenHash, token.Uid, token.ExpiresAt).Error
+}
+
+func (s *Store) GetRefreshToken(tx *gorm.DB, tokenHash string) (RefreshToken, error) {
+	db := s.dbOrTx(tx)
+	rows, err := db.Table("refresh_tokens").
+		Select("token_hash, uid, expires_at, revoked").
+		Where("token_hash = ?", tokenHash).
+		Rows()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return RefreshToken{}, ErrRefreshTokenNotFound
+	}
+	token := RefreshToken{}
+	if err := rows.Scan(&token.TokenHash, &token.Uid, &token.ExpiresAt, &token.Revoked); err != nil {
+		return RefreshToken{}, err
+	}
+	return token, nil
+}
+
+// RevokeRefreshToken returns false when the token was already revoked, e.g by a concurrent refresh
+func (s *Store) RevokeRefreshToken(tx *gorm.DB, tokenHash string) (bool, error) {
+	db := s.dbOrTx(tx)
+	res := db.Exec("UPDATE refresh_tokens SET revoked = true WHERE token_hash = ? AND NOT revoked", tokenHash)
+	return res.RowsAffected > 0, res.Error
+}
+
+// RevokeRefreshTokens revokes every refresh token of the identity and forgets the expired ones
+func (s *Store) RevokeRefreshTokens(tx *gorm.DB, uid string) error {
+	db := s.dbOrTx(tx)
+	if err := db.Exec("DELETE FROM refresh_tokens WHERE uid = ? AND expires_at < now()", uid).Error; err != nil {
+		return err
+	}
+	return db.Exec("UPDATE refresh_tokens SET revoked = true WHERE uid = ?", uid).Error
+}
+
+func (s *Store) AddSigningKey(tx *gorm.DB, key SigningKey) (SigningKey, error) {
+	db := s.dbOrTx(tx)
+	if err := db.Exec("INSERT INTO signing_keys (key_id, private_key) VALUES (?, ?)", key.KeyId, key.PrivateKey).Error; err != nil {
+		return SigningKey{}, err
+	}
+	return s.GetSigningKey(db, key.KeyId)
+}
+
+func (s *Store) GetSigningKey(tx *gorm.DB, keyId string) (SigningKey, error) {
+	keys, err := s.findSigningKeys(s.baseSigningKeyQuery(tx).Where("key_id = ?", keyId))
+	if err != nil {
+		return SigningKey{}, err
+	}
+	if len(keys) == 0 {
+		return SigningKey{}, ErrSigningKeyNotFound
+	}
+	return keys[0], nil
+}
+
+// ListSigningKeys returns the keys created after the given time, the newest first
+func (s *Store) ListSigningKeys(tx *gorm.DB, createdAfter time.Time) ([]SigningKey, error) {
+	return s.findSigningKeys(s.baseSigningKeyQuery(tx).Where("created_at > ?", createdAfter).Order("created_at DESC"))
+}
+
+func (s *Store) DeleteSigningKeys(tx *gorm.DB, createdBefore time.Time) error {
+	db := s.dbOrTx(tx)
+	return db.Exec("DELETE FROM signing_keys WHERE created_at < ?", createdBefore).Error
+}
+
+func (s *Store) baseSigningKeyQuery(tx *gorm.DB) *gorm.DB {
+	db := s.dbOrTx(tx)
+	return db.Table("signing_keys").Select("key_id, private_key, created_at")
+}
+
+func (s *Store) findSigningKeys(query *gorm.DB) ([]SigningKey, error) {
+	rows, err := query.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []SigningKey{}
+	for rows.Next() {
+		key := SigningKey{}
+		if err := rows.Scan(&key.KeyId, &key.PrivateKey, &key.CreatedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
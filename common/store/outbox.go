@@ -0,0 +1,127 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/common/events"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// key of the advisory lock held by the relay publishing the outbox, only one at a time keeps the events in order
+const outboxLock = 7340291
+
+// key of the advisory lock held by a transaction adding events to the outbox until it ends. A sequence is taken when
+// the row is inserted, not when the transaction commits: without it a later sequence could be committed, and
+// published, before an earlier one
+const outboxWriteLock = 7340292
+
+// AddOutboxEvent records an event in the transaction of the change it describes, the relay of the event-manager
+// publishes it once committed. The other transactions adding events wait for this one to end, so the events are
+// published in the order of their commits
+func (s *Store) AddOutboxEvent(tx *gorm.DB, daycareId string, payload events.Payload) error {
+	db := s.dbOrTx(tx)
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	// the lock is taken by the insert itself so that it also holds without transaction
+	if err := db.Exec("INSERT INTO outbox (event_id, type, version, daycare_id, payload) SELECT ?, ?, ?::integer, ?, ?::jsonb FROM (SELECT pg_advisory_xact_lock(?)) AS write_lock",
+		s.StringGenerator.GenerateUuid(),
+		payload.EventType(),
+		payload.EventVersion(),
+		daycareId,
+		string(data),
+		outboxWriteLock).Error; err != nil {
+		return errors.Wrapf(err, "failed to add %s event", payload.EventType())
+	}
+	return nil
+}
+
+// RelayOutboxEvents hands the oldest unpublished events to publish, in order, up to limit. It stops at the first
+// failure, the events published until then are not handed again. Nothing is relayed while another relay is running
+func (s *Store) RelayOutboxEvents(limit int, publish func(envelope events.Envelope) error) (int, error) {
+	tx := s.Db.Begin()
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", outboxLock).Row().Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	envelopes, err := s.listOutboxEvents(tx, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	published := []string{}
+	var publishErr error
+	for _, envelope := range envelopes {
+		if publishErr = publish(envelope); publishErr != nil {
+			break
+		}
+		published = append(published, envelope.Id)
+	}
+
+	if len(published) > 0 {
+		if err := tx.Exec("UPDATE outbox SET published_at = now() WHERE event_id IN (?)", published).Error; err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+	return len(published), publishErr
+}
+
+// DeleteOutboxEvents forgets the events published before the given time
+func (s *Store) DeleteOutboxEvents(tx *gorm.DB, publishedBefore time.Time) (int64, error) {
+	db := s.dbOrTx(tx)
+	res := db.Exec("DELETE FROM outbox WHERE published_at < ?", publishedBefore)
+	return res.RowsAffected, res.Error
+}
+
+func (s *Store) listOutboxEvents(tx *gorm.DB, limit int) ([]events.Envelope, error) {
+	rows, err := tx.Table("outbox").
+		Select("event_id," +
+			"type," +
+			"version," +
+			"daycare_id," +
+			"payload," +
+			"occurred_at").
+		Where("published_at IS NULL").
+		Order("sequence").
+		Limit(limit).
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	envelopes := []events.Envelope{}
+	for rows.Next() {
+		envelope := events.Envelope{}
+		var payload []byte
+		if err := rows.Scan(&envelope.Id,
+			&envelope.Type,
+			&envelope.Version,
+			&envelope.DaycareId,
+			&payload,
+			&envelope.OccurredAt,
+		); err != nil {
+			return nil, err
+		}
+		envelope.Payload = payload
+		envelopes = append(envelopes, envelope)
+	}
+	return envelopes, rows.Err()
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Photos can be seen by the daycare staff and the child's own responsibles only
+	PHOTO_CONSENT_INTERNAL = "internal"
+	// Photos can also be seen by the responsibles of other children, e.g on a group photo
+	PHOTO_CONSENT_SHAREABLE = "shareable"
+)
+
+var (
+	ErrPhotoConsentNotFound = errors.New("photo consent not found")
+)
+
+type PhotoConsent struct {
+	ChildId   sql.NullString
+	Granted   sql.NullBool
+	Scope     sql.NullString
+	GrantedBy sql.NullString
+	GrantedAt time.Time
+}
+
+// Allows tells whether the child can appear on a photo. A group photo is seen by the responsibles
+// of every tagged child so it requires a shareable consent
+func (c PhotoConsent) Allows(groupPhoto bool) bool {
+	if !c.Granted.Bool {
+		return false
+	}
+	if groupPhoto {
+		return c.Scope.String == PHOTO_CONSENT_SHAREABLE
+	}
+	return true
+}
+
+// ChildWithoutPhotoConsent returns the first child appearing on a photo whose consent does not allow it, or an
+// empty string when every child can appear. getConsent returns a zero consent for a child having none
+func ChildWithoutPhotoConsent(childIds []string, getConsent func(childId string) (PhotoConsent, error)) (string, error) {
+	distinctChildIds := []string{}
+	seen := map[string]bool{}
+	for _, childId := range childIds {
+		if childId == "" || seen[childId] {
+			continue
+		}
+		seen[childId] = true
+		distinctChildIds = append(distinctChildIds, childId)
+	}
+
+	for _, childId := range distinctChildIds {
+		consent, err := getConsent(childId)
+		if err != nil {
+			return "", err
+		}
+		if !consent.Allows(len(distinctChildIds) > 1) {
+			return childId, nil
+		}
+	}
+	return "", nil
+}
+
+func (s *Store) GetPhotoConsent(tx *gorm.DB, childId string) (PhotoConsent, error) {
+	rows, err := s.basePhotoConsentQuery(tx).
+		Where("photo_consents.child_id = ?", childId).
+		Rows()
+	if err != nil {
+		return PhotoConsent{}, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return PhotoConsent{}, ErrPhotoConsentNotFound
+	}
+	consent := PhotoConsent{}
+	if err := rows.Scan(&consent.ChildId,
+		&consent.Granted,
+		&consent.Scope,
+		&consent.GrantedBy,
+		&consent.GrantedAt,
+	); err != nil {
+		return PhotoConsent{}, err
+	}
+	return consent, nil
+}
+
+// SetPhotoConsent creates or replaces the consent of a child, there is only one per child
+func (s *Store) SetPhotoConsent(tx *gorm.DB, consent PhotoConsent) (PhotoConsent, error) {
+	db := s.dbOrTx(tx)
+
+	res := db.Model(&PhotoConsent{}).
+		Where("child_id = ?", consent.ChildId.String).
+		Updates(map[string]interface{}{
+			"granted":    consent.Granted,
+			"scope":      consent.Scope,
+			"granted_by": consent.GrantedBy,
+			"granted_at": consent.GrantedAt,
+		})
+	if err := res.Error; err != nil {
+		return PhotoConsent{}, err
+	}
+	if res.RowsAffected == 0 {
+		if err := db.Create(&consent).Error; err != nil {
+			return PhotoConsent{}, err
+		}
+	}
+
+	return s.GetPhotoConsent(db, consent.ChildId.String)
+}
+
+func (s *Store) basePhotoConsentQuery(tx *gorm.DB) *gorm.DB {
+	db := s.dbOrTx(tx)
+	return db.Table("photo_consents").
+		Select("photo_consents.child_id," +
+			"photo_consents.granted," +
+			"photo_consents.scope," +
+			"photo_consents.granted_by," +
+			"photo_consents.granted_at")
+}
@@ -51,6 +51,9 @@ func (s *Store) AddRole(tx *gorm.DB, role Role) (Role, error) {
 	if err := db.Create(&role).Error; err != nil {
 		return Role{}, err
 	}
+	if err := s.bumpClaimsVersion(db, role.UserId); err != nil {
+		return Role{}, err
+	}
 	return role, nil
 }
 
@@ -73,9 +76,11 @@ func (s *Store) GetUserRoles(tx *gorm.DB, userId string) ([]Role, error) {
 	return roles, nil
 }
 
+// PendingConnexionRole is a role given to the user of an invitation once accepted
 type PendingConnexionRole struct {
-	Email string
-	Role  string
+	InvitationId string
+	Email        string
+	Role         string
 }
 
 func (s *Store) GetPendingConnexionRoles(tx *gorm.DB, email string) ([]PendingConnexionRole, error) {
@@ -93,7 +98,11 @@ func (s *Store) CreatePendingConnexionRole(tx *gorm.DB, role PendingConnexionRol
 	return db.Model(PendingConnexionRole{}).Create(&role).Error
 }
 
+// DeletePendingConnexionRole deletes the roles of the invitation, or every role pending for the email without one
 func (s *Store) DeletePendingConnexionRole(tx *gorm.DB, role PendingConnexionRole) error {
 	db := s.dbOrTx(tx)
+	if role.InvitationId != "" {
+		return db.Where("invitation_id = ?", role.InvitationId).Delete(PendingConnexionRole{}).Error
+	}
 	return db.Where("email = ?", role.Email).Delete(PendingConnexionRole{}).Error
 }
@@ -2,7 +2,6 @@ package store
 
 import (
 	"database/sql"
-	"firebase.google.com/go/auth"
 	"github.com/Vinubaba/SANTC-API/api/shared"
 	"github.com/jinzhu/gorm"
 )
@@ -12,8 +11,7 @@ type Store struct {
 	StringGenerator interface {
 		GenerateUuid() string
 	} `inject:""`
-	FirebaseClient *auth.Client      `inject:""`
-	Config         *shared.AppConfig `inject:""`
+	Config *shared.AppConfig `inject:""`
 }
 
 func (s *Store) Tx() *gorm.DB {
@@ -2,6 +2,7 @@ package store
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/Vinubaba/SANTC-API/common/roles"
 	"github.com/jinzhu/gorm"
@@ -26,7 +27,8 @@ type User struct {
 	Zip           sql.NullString
 	Gender        sql.NullString
 	ImageUri      sql.NullString
-	Roles         Roles `sql:"-"`
+	ThumbnailUri  sql.NullString `sql:"-"`
+	Roles         Roles          `sql:"-"`
 	DaycareId     sql.NullString
 	WorkAddress_1 sql.NullString
 	WorkAddress_2 sql.NullString
@@ -34,6 +36,10 @@ type User struct {
 	WorkState     sql.NullString
 	WorkZip       sql.NullString
 	WorkPhone     sql.NullString
+
+	// When the signed urls of the image and of the thumbnail stop working
+	ImageUriExpires     time.Time `sql:"-"`
+	ThumbnailUriExpires time.Time `sql:"-"`
 }
 
 type TeacherClass struct {
@@ -152,6 +158,14 @@ func (s *Store) GetUserByEmail(tx *gorm.DB, email string) (User, error) {
 func (s *Store) UpdateUser(tx *gorm.DB, user User) (User, error) {
 	db := s.dbOrTx(tx)
 
+	// the claims name the daycare of the user
+	if user.DaycareId.Valid {
+		res := db.Exec("UPDATE users SET claims_version = claims_version + 1 WHERE user_id = ? AND daycare_id IS DISTINCT FROM ?", user.UserId, user.DaycareId)
+		if err := res.Error; err != nil {
+			return User{}, errors.Wrap(err, "failed to update claims version")
+		}
+	}
+
 	res := db.Where("user_id = ?", user.UserId).Model(&User{}).Updates(&user).First(&user)
 	if err := res.Error; err != nil {
 		return User{}, err
@@ -181,6 +195,34 @@ func (s *Store) DeleteUser(tx *gorm.DB, userId string) (err error) {
 	return nil
 }
 
+// GetClaimsVersion returns the version of the roles and daycare of the user, a deleted user has none
+func (s *Store) GetClaimsVersion(tx *gorm.DB, userId string) (int, error) {
+	db := s.dbOrTx(tx)
+
+	rows, err := db.Raw("SELECT claims_version FROM users WHERE user_id = ?", userId).Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, ErrUserNotFound
+	}
+	var version int
+	if err := rows.Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// bumpClaimsVersion makes the claims given to the identity of the user before the change outdated
+func (s *Store) bumpClaimsVersion(db *gorm.DB, userId string) error {
+	if err := db.Exec("UPDATE users SET claims_version = claims_version + 1 WHERE user_id = ?", userId).Error; err != nil {
+		return errors.Wrap(err, "failed to update claims version")
+	}
+	return nil
+}
+
 func (s *Store) ListDaycareUsers(tx *gorm.DB, roleConstraint string, options SearchOptions) ([]User, error) {
 	db := s.dbOrTx(tx)
 	query := db.Table("users, children, teacher_classes, roles").
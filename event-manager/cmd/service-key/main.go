@@ -0,0 +1,42 @@
+// service-key generates the key a service signs its tokens to the api with. The private key is written to the
+// file given, the public key is printed as a JWKS to add to the SERVICE_KEYS_FILE of the api:
+//
+//	service-key -name event-manager -out event-manager-key.pem
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/Vinubaba/SANTC-API/common/jwt"
+)
+
+func main() {
+	name := flag.String("name", "event-manager", "name of the service, the api gives it the scopes of this name")
+	out := flag.String("out", "service-key.pem", "file the private key is written to")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	checkErrAndExit(err)
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	checkErrAndExit(ioutil.WriteFile(*out, encoded, 0600))
+
+	keySet, err := json.MarshalIndent(jwt.KeySet{Keys: []jwt.JSONWebKey{jwt.NewJSONWebKey(*name, &key.PublicKey)}}, "", "  ")
+	checkErrAndExit(err)
+	fmt.Println(string(keySet))
+}
+
+func checkErrAndExit(err error) {
+	if err == nil {
+		return
+	}
+	fmt.Println(err.Error())
+	os.Exit(1)
+}
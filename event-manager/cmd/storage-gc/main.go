@@ -0,0 +1,68 @@
+// storage-gc reports the files of the bucket the database does not refer to anymore, and deletes them with
+// -dry-run=false. It reads the configuration of the event-manager from the environment:
+//
+//	storage-gc -daycare peyredragon -grace-period 1h -dry-run=false
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/store"
+	"github.com/Vinubaba/SANTC-API/event-manager/shared"
+	"github.com/Vinubaba/SANTC-API/event-manager/tasks"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", true, "only report the orphaned files")
+	daycareId := flag.String("daycare", "", "only collect the files of this daycare")
+	gracePeriod := flag.Duration("grace-period", -1, "files younger than that are kept, EVENT_MANAGER_STORAGE_GC_GRACE_PERIOD by default")
+	flag.Parse()
+
+	ctx := context.Background()
+	logger := log.NewLogger("storage-gc")
+
+	config, err := shared.InitAppConfiguration()
+	checkErrAndExit(err)
+	if *gracePeriod < 0 {
+		*gracePeriod = config.StorageGcGracePeriod
+	}
+
+	db, err := shared.OpenDb(config)
+	checkErrAndExit(err)
+	defer db.Close()
+
+	fileStorage, err := shared.NewStorage(ctx, config)
+	checkErrAndExit(err)
+
+	collector := &tasks.StorageCollector{
+		Store:   &store.Store{Db: db},
+		Storage: fileStorage,
+		Logger:  logger,
+	}
+	report, err := collector.Collect(ctx, tasks.StorageCollectorOptions{
+		DryRun:      *dryRun,
+		GracePeriod: *gracePeriod,
+		DaycareId:   *daycareId,
+	})
+	checkErrAndExit(err)
+
+	for _, orphan := range report.Orphans {
+		fmt.Println(orphan)
+	}
+	fmt.Printf("%d files scanned, %d orphans, %d deleted, %d failed\n", report.Scanned, len(report.Orphans), report.Deleted, report.Failed)
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func checkErrAndExit(err error) {
+	if err == nil {
+		return
+	}
+	fmt.Println(err.Error())
+	os.Exit(1)
+}
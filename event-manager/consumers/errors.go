@@ -0,0 +1,79 @@
+package consumers
+
+import (
+	"github.com/Vinubaba/SANTC-API/common/api"
+	"github.com/Vinubaba/SANTC-API/common/mail"
+	"github.com/Vinubaba/SANTC-API/common/storage"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNoHandler = errors.New("no handlers were able to consume this message")
+)
+
+var (
+	// retrying cannot fix these, the message goes to the dead letters right away
+	permanentCauses = []error{
+		ErrNoHandler,
+		api.ErrServerBadRequest,
+		api.ErrServerGone,
+		storage.ErrUnsupportedFileFormat,
+		storage.ErrMismatchingFileFormat,
+		storage.ErrFileTooLarge,
+		mail.ErrInvalidAddress,
+	}
+	// the event was handled by refusing it, the message is acked
+	refusalCauses = []error{
+		ErrNoPhotoConsent,
+	}
+)
+
+type permanentError struct {
+	cause error
+}
+
+func (e permanentError) Error() string {
+	return e.cause.Error()
+}
+
+func (e permanentError) Cause() error {
+	return e.cause
+}
+
+// Permanent marks an error retrying cannot fix, e.g an invalid event
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{cause: err}
+}
+
+func IsPermanent(err error) bool {
+	for err != nil {
+		if _, ok := err.(permanentError); ok {
+			return true
+		}
+		cause, ok := err.(interface {
+			Cause() error
+		})
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+	return isOneOf(err, permanentCauses)
+}
+
+func isRefusal(err error) bool {
+	return isOneOf(errors.Cause(err), refusalCauses)
+}
+
+func isOneOf(err error, causes []error) bool {
+	for _, cause := range causes {
+		if err == cause {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,52 @@
 package consumers
 
+import (
+	"encoding/json"
+
+	"github.com/Vinubaba/SANTC-API/common/events"
+)
+
+// Event is a message of the broker. Its payload is decoded in Data by the decoder registered for its type and
+// version, e.g an *ImageApproval
 type Event struct {
-	Type     string `json:"type"`
+	events.Envelope
+	// Who sent the event, only set by the mobile applications
 	SenderId string `json:"senderId"`
-	*ImageApproval
+
+	// Events having the same key are only handled once, defaults to the id of the event then of the message
+	IdempotencyKey string `json:"idempotencyKey"`
+
+	Data interface{} `json:"-"`
+}
+
+// ParseEvent reads an envelope. The messages published before the envelopes carry neither a version nor a
+// payload, they are read as the version 1 of their type with the whole message as payload
+func ParseEvent(data []byte) (Event, error) {
+	event := Event{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return Event{}, err
+	}
+	if event.Version == 0 && len(event.Payload) == 0 {
+		event.Version = 1
+		event.Payload = json.RawMessage(data)
+	}
+	return event, nil
 }
 
 type ImageApproval struct {
 	Image   string `json:"image"`
 	ChildId string `json:"childId"`
+	// Other children appearing on a group photo
+	TaggedChildIds []string `json:"taggedChildIds"`
 }
+
+// the fields of the legacy message around the payload are allowed
+const imageApprovalSchema = `{
+	"type": "object",
+	"required": ["childId", "image"],
+	"properties": {
+		"childId": {"type": "string", "minLength": 1},
+		"image": {"type": "string", "minLength": 1},
+		"taggedChildIds": {"type": ["array", "null"], "items": {"type": "string"}}
+	}
+}`
@@ -2,11 +2,13 @@ package consumers
 
 import (
 	"context"
+	"database/sql"
 	"path"
 
 	"github.com/Vinubaba/SANTC-API/common/api"
 	"github.com/Vinubaba/SANTC-API/common/log"
 	"github.com/Vinubaba/SANTC-API/common/storage"
+	"github.com/Vinubaba/SANTC-API/common/store"
 	"github.com/Vinubaba/SANTC-API/event-manager/shared"
 
 	"github.com/pkg/errors"
@@ -16,6 +18,10 @@ const (
 	imageApprovalEventType = "imageApproval"
 )
 
+var (
+	ErrNoPhotoConsent = errors.New("photo consent has not been granted for every child on the photo")
+)
+
 type ImageApprovalHandler struct {
 	Storage   storage.Storage   `inject:""`
 	Config    *shared.AppConfig `inject:""`
@@ -23,8 +29,11 @@ type ImageApprovalHandler struct {
 	ApiClient api.Client        `inject:""`
 }
 
-func (h *ImageApprovalHandler) CanHandle(event Event) bool {
-	return event.Type == imageApprovalEventType
+// Register makes the handler receive the image approvals, the version 1 is also the one of the legacy messages
+func (h *ImageApprovalHandler) Register(registry *Registry) error {
+	return registry.Register(imageApprovalEventType, 1, imageApprovalSchema, JSONDecoder(func() interface{} {
+		return &ImageApproval{}
+	}), h)
 }
 
 func (h *ImageApprovalHandler) Name() string {
@@ -32,33 +41,57 @@ func (h *ImageApprovalHandler) Name() string {
 }
 
 func (h *ImageApprovalHandler) Handle(ctx context.Context, event Event) error {
-	if event.ImageApproval == nil {
-		return errors.New("image approval is empty")
-	}
-	if event.ImageApproval.ChildId == "" {
-		return errors.New("childId is mandatory")
-	}
-	if event.ImageApproval.Image == "" {
-		return errors.New("image is empty")
+	approval, ok := event.Data.(*ImageApproval)
+	if !ok || approval == nil {
+		return Permanent(errors.New("image approval is empty"))
 	}
 
-	child, err := h.ApiClient.GetChild(ctx, event.ImageApproval.ChildId)
+	child, err := h.ApiClient.GetChild(ctx, approval.ChildId)
 	if err != nil {
 		return errors.Wrap(err, "failed to get child")
 	}
 
-	filename, err := h.Storage.Store(ctx, event.Image, path.Join("daycares", *child.DaycareId, "children", *child.Id))
+	// a photo without consent is dropped before being stored anywhere
+	if err := h.checkPhotoConsent(ctx, append([]string{approval.ChildId}, approval.TaggedChildIds...)); err != nil {
+		return err
+	}
+
+	filename, err := h.Storage.Store(ctx, approval.Image, path.Join("daycares", *child.DaycareId, "children", *child.Id))
 	if err != nil {
 		return errors.Wrap(err, "failed to store image")
 	}
 
 	if err := h.ApiClient.AddImageApprovalRequest(ctx, api.PhotoRequestTransport{
-		ChildId:     &event.ChildId,
-		PublishedBy: &event.SenderId,
-		Filename:    &filename,
+		ChildId:        &approval.ChildId,
+		PublishedBy:    &event.SenderId,
+		Filename:       &filename,
+		TaggedChildIds: approval.TaggedChildIds,
 	}); err != nil {
 		return errors.Wrap(err, "failed to perform AddImageApprovalRequest")
 	}
 
 	return nil
 }
+
+// checkPhotoConsent applies the rule of the api to the consents it returns
+func (h *ImageApprovalHandler) checkPhotoConsent(ctx context.Context, childIds []string) error {
+	childId, err := store.ChildWithoutPhotoConsent(childIds, func(childId string) (store.PhotoConsent, error) {
+		consent, err := h.ApiClient.GetPhotoConsent(ctx, childId)
+		if err != nil {
+			return store.PhotoConsent{}, err
+		}
+		return store.PhotoConsent{
+			ChildId: store.DbNullString(consent.ChildId),
+			Granted: sql.NullBool{Bool: consent.Granted != nil && *consent.Granted, Valid: consent.Granted != nil},
+			Scope:   store.DbNullString(consent.Scope),
+		}, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to get photo consent")
+	}
+	if childId != "" {
+		h.Logger.Warn(ctx, "photo refused, consent is missing", "childId", childId)
+		return ErrNoPhotoConsent
+	}
+	return nil
+}
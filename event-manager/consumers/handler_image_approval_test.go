@@ -24,6 +24,7 @@ var _ = Describe("HandlerImageApproval", func() {
 		imageApprovalHandler *ImageApprovalHandler
 		ctx                  context.Context
 		event                Event
+		approval             *ImageApproval
 		mockConfig           *shared.AppConfig
 		mockStorage          = &MockGcs{}
 		logger               *log.Logger
@@ -31,10 +32,16 @@ var _ = Describe("HandlerImageApproval", func() {
 		mockHttpServer       *httptest.Server
 		router               *mux.Router
 		returnedError        error
+		consents             map[string]string
 	)
 
 	BeforeEach(func() {
 		returnedError = nil
+		consents = map[string]string{
+			"4d8b9d3f-1478-4215-a240-85974b940c97": `{"childId": "4d8b9d3f-1478-4215-a240-85974b940c97", "granted": true, "scope": "shareable"}`,
+			"childid-internal":                     `{"childId": "childid-internal", "granted": true, "scope": "internal"}`,
+			"childid-denied":                       `{"childId": "childid-denied", "granted": false, "scope": null}`,
+		}
 		mockConfig = &shared.AppConfig{}
 		logger = log.NewLogger("HandlerImageApprovalTest")
 
@@ -42,6 +49,10 @@ var _ = Describe("HandlerImageApproval", func() {
 		router.HandleFunc("/api/v1/children/{childId}/photos", func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusCreated)
 		})
+		router.HandleFunc("/api/v1/children/{childId}/photo-consent", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(consents[mux.Vars(r)["childId"]]))
+		})
 		router.HandleFunc("/api/v1/children/{childId}", func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte(`{
@@ -77,7 +88,7 @@ var _ = Describe("HandlerImageApproval", func() {
 		mockHttpServer = httptest.NewServer(router)
 
 		var err error
-		apiClient, err = api.NewDefaultClient("http", strings.TrimPrefix(mockHttpServer.URL, "http://"))
+		apiClient, err = api.NewDefaultClient("http", strings.TrimPrefix(mockHttpServer.URL, "http://"), nil)
 		if err != nil {
 			panic(err)
 		}
@@ -89,13 +100,13 @@ var _ = Describe("HandlerImageApproval", func() {
 		}
 
 		ctx = context.Background()
+		approval = &ImageApproval{
+			Image:   b64ImageExample,
+			ChildId: "4d8b9d3f-1478-4215-a240-85974b940c97",
+		}
 		event = Event{
-			Type:     "imageApproval",
 			SenderId: "dd3a81f0-6432-4ddf-842a-b82a3911dadb",
-			ImageApproval: &ImageApproval{
-				Image:   b64ImageExample,
-				ChildId: "4d8b9d3f-1478-4215-a240-85974b940c97",
-			},
+			Data:     approval,
 		}
 	})
 
@@ -116,4 +127,28 @@ var _ = Describe("HandlerImageApproval", func() {
 			Expect(returnedError).To(BeNil())
 		})
 	})
+
+	Context("When the child has no photo consent", func() {
+		BeforeEach(func() {
+			approval.ChildId = "childid-denied"
+		})
+		It("should refuse the photo", func() {
+			Expect(returnedError).To(Equal(ErrNoPhotoConsent))
+		})
+		It("should not store the image", func() {
+			Expect(mockStorage.CallsForMethod("Store")).To(BeEmpty())
+		})
+	})
+
+	Context("When a child with an internal consent is tagged on a group photo", func() {
+		BeforeEach(func() {
+			approval.TaggedChildIds = []string{"childid-internal"}
+		})
+		It("should refuse the photo", func() {
+			Expect(returnedError).To(Equal(ErrNoPhotoConsent))
+		})
+		It("should not store the image", func() {
+			Expect(mockStorage.CallsForMethod("Store")).To(BeEmpty())
+		})
+	})
 })
@@ -0,0 +1,109 @@
+package consumers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/common/api"
+	"github.com/Vinubaba/SANTC-API/common/events"
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/mail"
+	"github.com/Vinubaba/SANTC-API/common/roles"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	invitationMailHandlerName = "invitationMail"
+)
+
+var roleNames = map[string]string{
+	roles.ROLE_TEACHER:        "teacher",
+	roles.ROLE_ADULT:          "parent",
+	roles.ROLE_OFFICE_MANAGER: "office manager",
+}
+
+// InvitationMailHandler sends the email of the invitations created by the api. The event holds no token, the api
+// issues the link of the email when it is sent
+type InvitationMailHandler struct {
+	ApiClient api.Client  `inject:""`
+	Sender    mail.Sender `inject:""`
+	Logger    *log.Logger `inject:""`
+}
+
+func (h *InvitationMailHandler) Register(registry *Registry) error {
+	schema, _ := events.PayloadSchema(events.TYPE_INVITATION_CREATED, 1)
+	return registry.Register(events.TYPE_INVITATION_CREATED, 1, schema, JSONDecoder(func() interface{} {
+		return &events.InvitationCreatedV1{}
+	}), h)
+}
+
+func (h *InvitationMailHandler) Name() string {
+	return invitationMailHandlerName
+}
+
+func (h *InvitationMailHandler) Handle(ctx context.Context, event Event) error {
+	invitation, ok := event.Data.(*events.InvitationCreatedV1)
+	if !ok || invitation == nil {
+		return Permanent(errors.New("invitation is empty"))
+	}
+	// a late redelivery would send a link which no longer works
+	if time.Now().After(invitation.ExpiresAt) {
+		h.Logger.Warn(ctx, "invitation expired before its email was sent", "invitationId", invitation.InvitationId)
+		return nil
+	}
+
+	// a new link for each attempt, the ones of the failed attempts stop working
+	token, err := h.ApiClient.IssueInvitationToken(ctx, invitation.InvitationId)
+	if errors.Cause(err) == api.ErrServerGone {
+		h.Logger.Warn(ctx, "invitation accepted, expired or deleted before its email was sent", "invitationId", invitation.InvitationId)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to issue invitation token")
+	}
+	if api.IsNilOrEmpty(token.AcceptUrl) {
+		return errors.New("failed to issue invitation token: empty link")
+	}
+
+	if err := h.Sender.Send(ctx, invitationMessage(invitation, *token.AcceptUrl)); err != nil {
+		return errors.Wrap(err, "failed to send invitation")
+	}
+	return nil
+}
+
+func invitationMessage(invitation *events.InvitationCreatedV1, acceptUrl string) mail.Message {
+	daycare := invitation.DaycareName
+	if daycare == "" {
+		daycare = "a daycare"
+	}
+	names := []string{}
+	for _, role := range invitation.Roles {
+		if name, ok := roleNames[role]; ok {
+			names = append(names, name)
+		}
+	}
+
+	body := &strings.Builder{}
+	if invitation.FirstName != "" {
+		fmt.Fprintf(body, "Hello %s,\n\n", invitation.FirstName)
+	} else {
+		body.WriteString("Hello,\n\n")
+	}
+	fmt.Fprintf(body, "You are invited to join %s on TeddyCare", daycare)
+	if len(names) > 0 {
+		fmt.Fprintf(body, " as %s", strings.Join(names, " and "))
+	}
+	body.WriteString(".\n\n")
+	fmt.Fprintf(body, "Accept the invitation before %s with this link, it only works once:\n%s\n\n",
+		invitation.ExpiresAt.UTC().Format("January 2, 2006 15:04 MST"), acceptUrl)
+	body.WriteString("If you were not expecting this email, you can ignore it.\n")
+
+	return mail.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("Your invitation to %s on TeddyCare", daycare),
+		Body:    body.String(),
+	}
+}
@@ -0,0 +1,169 @@
+package consumers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/common/api"
+	"github.com/Vinubaba/SANTC-API/common/events"
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/mail"
+	. "github.com/Vinubaba/SANTC-API/event-manager/consumers"
+
+	"github.com/gorilla/mux"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeSender struct {
+	sent []mail.Message
+	err  error
+}
+
+func (s *fakeSender) Send(ctx context.Context, message mail.Message) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, message)
+	return nil
+}
+
+var _ = Describe("HandlerInvitation", func() {
+
+	var (
+		handler        *InvitationMailHandler
+		registry       *Registry
+		sender         *fakeSender
+		mockHttpServer *httptest.Server
+		tokenStatus    int
+		issuedTokens   []string
+		event          Event
+		invitation     events.InvitationCreatedV1
+		returnedError  error
+	)
+
+	BeforeEach(func() {
+		tokenStatus = http.StatusOK
+		issuedTokens = []string{}
+		router := mux.NewRouter()
+		router.HandleFunc("/api/v1/invitations/{invitationId}/token", func(w http.ResponseWriter, r *http.Request) {
+			issuedTokens = append(issuedTokens, mux.Vars(r)["invitationId"])
+			w.WriteHeader(tokenStatus)
+			if tokenStatus == http.StatusOK {
+				w.Write([]byte(`{"acceptUrl": "https://teddycare.net/invitations/accept?token=foo"}`))
+			}
+		}).Methods(http.MethodPost)
+		mockHttpServer = httptest.NewServer(router)
+
+		apiClient, err := api.NewDefaultClient("http", strings.TrimPrefix(mockHttpServer.URL, "http://"), nil)
+		Expect(err).To(BeNil())
+		sender = &fakeSender{}
+		handler = &InvitationMailHandler{
+			ApiClient: apiClient,
+			Sender:    sender,
+			Logger:    log.NewLogger("HandlerInvitationTest"),
+		}
+		registry = NewRegistry()
+		Expect(handler.Register(registry)).To(BeNil())
+
+		invitation = events.InvitationCreatedV1{
+			InvitationId: "invitationid-1",
+			Email:        "arya.stark@got.com",
+			FirstName:    "Arya",
+			DaycareName:  "Winterfell",
+			Roles:        []string{"teacher"},
+			ExpiresAt:    time.Now().Add(time.Hour),
+		}
+	})
+
+	AfterEach(func() {
+		mockHttpServer.Close()
+	})
+
+	JustBeforeEach(func() {
+		envelope, err := events.NewEnvelope("eventid-1", "peyredragon", time.Now(), invitation)
+		Expect(err).To(BeNil())
+		data, _ := json.Marshal(envelope)
+		event, err = ParseEvent(data)
+		Expect(err).To(BeNil())
+
+		decodedHandler, err := registry.Decode(&event)
+		Expect(err).To(BeNil())
+		returnedError = decodedHandler.Handle(context.Background(), event)
+	})
+
+	Context("When the invitation is pending", func() {
+		It("should send the link to the invited email", func() {
+			Expect(returnedError).To(BeNil())
+			Expect(sender.sent).To(HaveLen(1))
+			Expect(sender.sent[0].To).To(Equal("arya.stark@got.com"))
+			Expect(sender.sent[0].Subject).To(Equal("Your invitation to Winterfell on TeddyCare"))
+			Expect(sender.sent[0].Body).To(ContainSubstring("Hello Arya,"))
+			Expect(sender.sent[0].Body).To(ContainSubstring("join Winterfell on TeddyCare as teacher."))
+			Expect(sender.sent[0].Body).To(ContainSubstring("https://teddycare.net/invitations/accept?token=foo"))
+		})
+		It("should get the link from the api", func() {
+			Expect(issuedTokens).To(Equal([]string{"invitationid-1"}))
+		})
+		It("should not publish the link in the event", func() {
+			data, _ := json.Marshal(invitation)
+			Expect(string(data)).NotTo(ContainSubstring("token"))
+		})
+	})
+
+	Context("When the invitation was accepted or deleted meanwhile", func() {
+		BeforeEach(func() {
+			tokenStatus = http.StatusGone
+		})
+		It("should not send the email", func() {
+			Expect(returnedError).To(BeNil())
+			Expect(sender.sent).To(BeEmpty())
+		})
+	})
+
+	Context("When the api is unavailable", func() {
+		BeforeEach(func() {
+			tokenStatus = http.StatusServiceUnavailable
+		})
+		It("should be retried", func() {
+			Expect(returnedError).NotTo(BeNil())
+			Expect(IsPermanent(returnedError)).To(BeFalse())
+			Expect(sender.sent).To(BeEmpty())
+		})
+	})
+
+	Context("When the invitation expired", func() {
+		BeforeEach(func() {
+			invitation.ExpiresAt = time.Now().Add(-time.Hour)
+		})
+		It("should not send the email", func() {
+			Expect(returnedError).To(BeNil())
+			Expect(sender.sent).To(BeEmpty())
+			Expect(issuedTokens).To(BeEmpty())
+		})
+	})
+
+	Context("When the smtp server is unavailable", func() {
+		BeforeEach(func() {
+			sender.err = errors.New("connection refused")
+		})
+		It("should be retried", func() {
+			Expect(returnedError).NotTo(BeNil())
+			Expect(IsPermanent(returnedError)).To(BeFalse())
+		})
+	})
+
+	Context("When the email is invalid", func() {
+		BeforeEach(func() {
+			sender.err = mail.ErrInvalidAddress
+		})
+		It("should not be retried", func() {
+			Expect(IsPermanent(returnedError)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,112 @@
+package consumers
+
+import (
+	"context"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/common/log"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ProcessedEvents remembers the events handled recently, so that a message delivered twice is only handled once.
+// The key of an event is reserved before handling it, the deliveries handled at the same time wait for it to be
+// completed or released
+type ProcessedEvents struct {
+	Db     *gorm.DB    `inject:""`
+	Logger *log.Logger `inject:""`
+}
+
+type Reservation int
+
+const (
+	Reserved Reservation = iota
+	AlreadyProcessed
+	InProgress
+)
+
+const (
+	statusProcessing = "processing"
+	statusDone       = "done"
+)
+
+// Reserve takes the key for the caller. A reservation older than lease is taken over as its handler is deemed gone,
+// a reservation is never taken over when lease is not positive
+func (p *ProcessedEvents) Reserve(idempotencyKey string, lease time.Duration) (Reservation, error) {
+	res := p.Db.Exec("INSERT INTO processed_events (idempotency_key, status) VALUES (?, ?) ON CONFLICT DO NOTHING",
+		idempotencyKey, statusProcessing)
+	if res.Error != nil {
+		return InProgress, res.Error
+	}
+	if res.RowsAffected == 1 {
+		return Reserved, nil
+	}
+
+	if lease > 0 {
+		res = p.Db.Exec("UPDATE processed_events SET processed_at = now() WHERE idempotency_key = ? AND status = ? AND processed_at < ?",
+			idempotencyKey, statusProcessing, time.Now().Add(-lease))
+		if res.Error != nil {
+			return InProgress, res.Error
+		}
+		if res.RowsAffected == 1 {
+			return Reserved, nil
+		}
+	}
+
+	rows, err := p.Db.Raw("SELECT status FROM processed_events WHERE idempotency_key = ?", idempotencyKey).Rows()
+	if err != nil {
+		return InProgress, err
+	}
+	defer rows.Close()
+	status := ""
+	for rows.Next() {
+		if err := rows.Scan(&status); err != nil {
+			return InProgress, err
+		}
+	}
+	if status == statusDone {
+		return AlreadyProcessed, nil
+	}
+	// released in the meantime, the next delivery reserves it again
+	return InProgress, nil
+}
+
+// Complete keeps the reserved key to skip the next deliveries
+func (p *ProcessedEvents) Complete(idempotencyKey, handler string) error {
+	return p.Db.Exec("UPDATE processed_events SET status = ?, handler = ?, processed_at = now() WHERE idempotency_key = ?",
+		statusDone, handler, idempotencyKey).Error
+}
+
+// Release forgets the reserved key, so that the event can be handled again
+func (p *ProcessedEvents) Release(idempotencyKey string) error {
+	return p.Db.Exec("DELETE FROM processed_events WHERE idempotency_key = ? AND status = ?", idempotencyKey, statusProcessing).Error
+}
+
+// Clean forgets the events processed more than ttl ago, the messages are not delivered again after that long. The
+// reservations are only forgotten after reservationTtl, their handler may still be running
+func (p *ProcessedEvents) Clean(ttl, reservationTtl time.Duration) (int64, error) {
+	now := time.Now()
+	res := p.Db.Exec("DELETE FROM processed_events WHERE (status = ? AND processed_at < ?) OR (status = ? AND processed_at < ?)",
+		statusDone, now.Add(-ttl), statusProcessing, now.Add(-reservationTtl))
+	return res.RowsAffected, res.Error
+}
+
+// Schedule cleans the processed events every interval until the context is done
+func (p *ProcessedEvents) Schedule(ctx context.Context, interval, ttl, reservationTtl time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := p.Clean(ttl, reservationTtl)
+			if err != nil {
+				p.Logger.Err(ctx, "failed to clean processed events", "err", err)
+				continue
+			}
+			p.Logger.Info(ctx, "processed events cleaned", "deleted", deleted)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package consumers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Vinubaba/SANTC-API/common/events"
+
+	"github.com/pkg/errors"
+)
+
+type Handler interface {
+	Handle(ctx context.Context, event Event) error
+	Name() string
+}
+
+// Decoder turns a validated payload into the value handed to the handler in Event.Data
+type Decoder func(payload json.RawMessage) (interface{}, error)
+
+// JSONDecoder unmarshals the payloads into the values returned by newPayload
+func JSONDecoder(newPayload func() interface{}) Decoder {
+	return func(payload json.RawMessage) (interface{}, error) {
+		value := newPayload()
+		if err := json.Unmarshal(payload, value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+}
+
+type registration struct {
+	schema  *events.Schema
+	decode  Decoder
+	handler Handler
+}
+
+// Registry knows, for every version of every event type, how to validate and decode its payload and who handles it.
+// A new version of an event is registered next to the previous one, both are handled until no one publishes the
+// previous one anymore
+type Registry struct {
+	registrations map[string]map[int]registration
+}
+
+func NewRegistry() *Registry {
+	return &Registry{registrations: map[string]map[int]registration{}}
+}
+
+// Register fails when the schema is invalid or when the version of the type is already registered
+func (r *Registry) Register(eventType string, version int, schema string, decode Decoder, handler Handler) error {
+	parsedSchema, err := events.ParseSchema(schema)
+	if err != nil {
+		return errors.Wrapf(err, "failed to register %s v%d", eventType, version)
+	}
+	if _, ok := r.registrations[eventType][version]; ok {
+		return fmt.Errorf("%s v%d is already registered", eventType, version)
+	}
+	if r.registrations[eventType] == nil {
+		r.registrations[eventType] = map[int]registration{}
+	}
+	r.registrations[eventType][version] = registration{schema: parsedSchema, decode: decode, handler: handler}
+	return nil
+}
+
+// Decode validates the payload of the event against the schema of its version and decodes it in Data. It returns
+// ErrNoHandler when the version is not registered, a permanent error when the payload is invalid
+func (r *Registry) Decode(event *Event) (Handler, error) {
+	registration, ok := r.registrations[event.Type][event.Version]
+	if !ok {
+		return nil, ErrNoHandler
+	}
+	if err := registration.schema.Validate(event.Payload); err != nil {
+		return registration.handler, Permanent(errors.Wrapf(err, "invalid %s v%d payload", event.Type, event.Version))
+	}
+	data, err := registration.decode(event.Payload)
+	if err != nil {
+		return registration.handler, Permanent(errors.Wrapf(err, "failed to decode %s v%d payload", event.Type, event.Version))
+	}
+	event.Data = data
+	return registration.handler, nil
+}
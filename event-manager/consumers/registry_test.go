@@ -0,0 +1,109 @@
+package consumers_test
+
+import (
+	"context"
+
+	"github.com/Vinubaba/SANTC-API/common/events"
+	. "github.com/Vinubaba/SANTC-API/event-manager/consumers"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type namedHandler string
+
+func (h namedHandler) Handle(ctx context.Context, event Event) error {
+	return nil
+}
+
+func (h namedHandler) Name() string {
+	return string(h)
+}
+
+var _ = Describe("Registry", func() {
+
+	var (
+		registry *Registry
+	)
+
+	var decoder = JSONDecoder(func() interface{} {
+		return &events.ChildEnrolledV1{}
+	})
+
+	var parse = func(data string) Event {
+		event, err := ParseEvent([]byte(data))
+		Expect(err).To(BeNil())
+		return event
+	}
+
+	BeforeEach(func() {
+		registry = NewRegistry()
+		schema, _ := events.PayloadSchema(events.TYPE_CHILD_ENROLLED, 1)
+		Expect(registry.Register(events.TYPE_CHILD_ENROLLED, 1, schema, decoder, namedHandler("enrolled-v1"))).To(BeNil())
+		Expect(registry.Register(events.TYPE_CHILD_ENROLLED, 2, `{"type": "object"}`, decoder, namedHandler("enrolled-v2"))).To(BeNil())
+	})
+
+	It("should decode the payload for the handler of the version", func() {
+		event := parse(`{"id": "event-1", "type": "childEnrolled", "version": 1, "daycareId": "namek", "payload": {"childId": "goku", "classId": "blue"}}`)
+
+		handler, err := registry.Decode(&event)
+		Expect(err).To(BeNil())
+		Expect(handler.Name()).To(Equal("enrolled-v1"))
+		Expect(event.Id).To(Equal("event-1"))
+		Expect(event.DaycareId).To(Equal("namek"))
+		Expect(event.Data).To(Equal(&events.ChildEnrolledV1{ChildId: "goku", ClassId: "blue"}))
+	})
+
+	It("should tell the versions apart", func() {
+		event := parse(`{"type": "childEnrolled", "version": 2, "payload": {}}`)
+
+		handler, err := registry.Decode(&event)
+		Expect(err).To(BeNil())
+		Expect(handler.Name()).To(Equal("enrolled-v2"))
+	})
+
+	It("should refuse the payloads not matching the schema", func() {
+		event := parse(`{"type": "childEnrolled", "version": 1, "payload": {"childId": "goku"}}`)
+
+		_, err := registry.Decode(&event)
+		Expect(IsPermanent(err)).To(BeTrue())
+		Expect(event.Data).To(BeNil())
+	})
+
+	It("should not handle the unregistered versions", func() {
+		event := parse(`{"type": "childEnrolled", "version": 3, "payload": {}}`)
+
+		_, err := registry.Decode(&event)
+		Expect(err).To(Equal(ErrNoHandler))
+	})
+
+	It("should refuse to register a version twice", func() {
+		Expect(registry.Register(events.TYPE_CHILD_ENROLLED, 1, `{}`, decoder, namedHandler("again"))).NotTo(BeNil())
+	})
+
+	It("should refuse invalid schemas", func() {
+		Expect(registry.Register(events.TYPE_CHILD_CREATED, 1, `{"type": "uuid"}`, decoder, namedHandler("created"))).NotTo(BeNil())
+	})
+
+	Context("When the message was published before the envelopes", func() {
+		It("should be the version 1 of its type", func() {
+			Expect((&ImageApprovalHandler{}).Register(registry)).To(BeNil())
+			event := parse(`{"type": "imageApproval", "senderId": "sender", "image": "data", "childId": "aaa", "taggedChildIds": ["bbb"]}`)
+
+			handler, err := registry.Decode(&event)
+			Expect(err).To(BeNil())
+			Expect(handler.Name()).To(Equal("imageApproval"))
+			Expect(event.Version).To(Equal(1))
+			Expect(event.SenderId).To(Equal("sender"))
+			Expect(event.Data).To(Equal(&ImageApproval{Image: "data", ChildId: "aaa", TaggedChildIds: []string{"bbb"}}))
+		})
+
+		It("should refuse an image approval without image", func() {
+			Expect((&ImageApprovalHandler{}).Register(registry)).To(BeNil())
+			event := parse(`{"type": "imageApproval", "senderId": "sender", "childId": "aaa"}`)
+
+			_, err := registry.Decode(&event)
+			Expect(IsPermanent(err)).To(BeTrue())
+		})
+	})
+})
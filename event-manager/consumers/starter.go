@@ -2,31 +2,54 @@ package consumers
 
 import (
 	"context"
-	"encoding/json"
+	"sync"
 	"time"
 
+	"github.com/Vinubaba/SANTC-API/common/events"
 	"github.com/Vinubaba/SANTC-API/common/log"
 	"github.com/Vinubaba/SANTC-API/common/messaging"
+	"github.com/Vinubaba/SANTC-API/event-manager/deadletters"
 	"github.com/Vinubaba/SANTC-API/event-manager/shared"
 
 	"github.com/pkg/errors"
 )
 
 type Consumer struct {
-	Config        *shared.AppConfig `inject:""`
-	Logger        *log.Logger       `inject:""`
-	PubSubClient  *messaging.Client `inject:""`
-	EventHandlers []interface {
-		CanHandle(event Event) bool
-		Handle(ctx context.Context, event Event) error
-		Name() string
-	}
+	Config     *shared.AppConfig    `inject:""`
+	Logger     *log.Logger          `inject:""`
+	Subscriber messaging.Subscriber `inject:""`
+	Registry   *Registry            `inject:""`
+
+	DeadLetters interface {
+		Add(deadLetter deadletters.DeadLetter) error
+	} `inject:""`
+	ProcessedEvents interface {
+		Reserve(idempotencyKey string, lease time.Duration) (Reservation, error)
+		Complete(idempotencyKey, handler string) error
+		Release(idempotencyKey string) error
+	} `inject:""`
+
+	// delivery attempts of the messages in progress, for the brokers not counting them
+	attempts      map[string]int
+	attemptsMutex sync.Mutex
+
+	// a message is pulled once one of the workers is free, the handlers can be limited to fewer of them
+	workers      chan struct{}
+	handlerSlots map[string]chan struct{}
+	inFlight     sync.WaitGroup
+	workCtx      context.Context
+	cancelWork   context.CancelFunc
+	stats        stats
 }
 
+// Start pulls and handles the messages until the context is done, it then waits for the messages in progress
+// before returning, cancelling them after EventDrainTimeout
 func (c *Consumer) Start(ctx context.Context) {
+	c.init()
 	for {
 		select {
 		case <-ctx.Done():
+			c.drain()
 			return
 		default:
 			c.Logger.Info(ctx, "starting consumer")
@@ -38,31 +61,279 @@ func (c *Consumer) Start(ctx context.Context) {
 	}
 }
 
+// Stats returns the counters of the messages, by handler
+func (c *Consumer) Stats() Stats {
+	return c.stats.snapshot()
+}
+
+func (c *Consumer) init() {
+	workers := c.Config.EventWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	c.workers = make(chan struct{}, workers)
+	c.handlerSlots = map[string]chan struct{}{}
+	for handler, limit := range c.Config.EventHandlerConcurrency {
+		if limit > 0 {
+			c.handlerSlots[handler] = make(chan struct{}, limit)
+		}
+	}
+	// not derived from the context of Start, the messages in progress outlive it until they are drained
+	c.workCtx, c.cancelWork = context.WithCancel(context.Background())
+}
+
+// subscribe hands every message to a worker, no message is pulled while they are all busy. The ack deadline of a
+// message is extended from the moment it is pulled, waiting for a worker included, until it is acked or nacked
 func (c *Consumer) subscribe(ctx context.Context) error {
 	callback := func(ctx context.Context, msg messaging.Message) {
-		msg.Ack()
-
-		event := Event{}
-		if err := json.Unmarshal(msg.Data, &event); err != nil {
-			c.Logger.Err(ctx, "failed to unmarshal the message data", "err", err, "messageId", msg.ID)
+		msg = c.keepAlive(msg)
+		select {
+		case c.workers <- struct{}{}:
+		case <-ctx.Done():
+			msg.Nack()
 			return
 		}
+		c.inFlight.Add(1)
+		go func() {
+			defer func() {
+				<-c.workers
+				c.inFlight.Done()
+			}()
+			c.process(c.workCtx, msg)
+		}()
+	}
+	if err := c.Subscriber.Subscribe(ctx, callback); err != nil {
+		return errors.Wrap(err, "failed to subscribe to the messaging system")
+	}
+	return nil
+}
 
-		for _, eventHandler := range c.EventHandlers {
-			if eventHandler.CanHandle(event) {
-				if err := eventHandler.Handle(ctx, event); err != nil {
-					c.Logger.Err(ctx, "failed to handle message", "err", err, "messageId", msg.ID, "handler", eventHandler.Name())
-				} else {
-					c.Logger.Info(ctx, "message successfully handled !", "messageId", msg.ID, "handler", eventHandler.Name())
-				}
+// keepAlive extends the ack deadline of the message every half of MessagingAckDeadline. The returned message stops
+// extending it once acked or nacked, before the broker is told
+func (c *Consumer) keepAlive(msg messaging.Message) messaging.Message {
+	deadline := c.Config.MessagingAckDeadline
+	if deadline <= 0 {
+		return msg
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(deadline / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
 				return
+			case <-ticker.C:
+				if err := msg.ExtendDeadline(deadline); err != nil {
+					c.Logger.Warn(c.workCtx, "failed to extend the ack deadline", "err", err, "messageId", msg.ID)
+				}
 			}
 		}
-		c.Logger.Warn(ctx, "no handlers were able to consume this message", "messageId", msg.ID)
+	}()
+	once := &sync.Once{}
+	stop := func() {
+		once.Do(func() { close(done) })
+		<-stopped
+	}
+
+	kept := msg
+	kept.RegisterAck(func() error {
+		stop()
+		return msg.Ack()
+	})
+	kept.RegisterNack(func() error {
+		stop()
+		return msg.Nack()
+	})
+	kept.RegisterNackAfter(func(delay time.Duration) error {
+		stop()
+		return msg.NackAfter(delay)
+	})
+	return kept
+}
+
+func (c *Consumer) drain() {
+	drained := make(chan struct{})
+	go func() {
+		c.inFlight.Wait()
+		close(drained)
+	}()
+
+	c.Logger.Info(c.workCtx, "draining consumer", "inFlight", c.stats.snapshot().InFlight)
+	var timeout <-chan time.Time
+	if c.Config.EventDrainTimeout > 0 {
+		timeout = time.After(c.Config.EventDrainTimeout)
+	}
+	select {
+	case <-drained:
+	case <-timeout:
+		c.Logger.Warn(c.workCtx, "drain timed out, cancelling the messages in progress")
+		c.cancelWork()
+		<-drained
+	}
+	c.cancelWork()
+	c.Logger.Info(c.workCtx, "consumer drained")
+}
+
+func (c *Consumer) process(ctx context.Context, msg messaging.Message) {
+	attempt := c.attempt(msg)
+
+	event, err := ParseEvent(msg.Data)
+	if err != nil {
+		c.Logger.Err(ctx, "failed to unmarshal the message data", "err", err, "messageId", msg.ID)
+		c.deadLetter(ctx, msg, "", errors.Wrap(err, "failed to unmarshal the message data"), attempt)
 		return
 	}
-	if err := c.PubSubClient.Subscribe(ctx, callback); err != nil {
-		return errors.Wrap(err, "failed to subscribe to the messaging system")
+	idempotencyKey := event.IdempotencyKey
+	if idempotencyKey == "" {
+		idempotencyKey = event.Id
 	}
-	return nil
+	if idempotencyKey == "" {
+		idempotencyKey = msg.ID
+	}
+
+	// the handler is given EventTimeout, a reservation older than that is left by a consumer gone meanwhile
+	reservation, err := c.ProcessedEvents.Reserve(idempotencyKey, c.Config.EventTimeout)
+	if err != nil {
+		c.Logger.Warn(ctx, "failed to reserve the message, will retry", "err", err, "messageId", msg.ID)
+		msg.NackAfter(c.backoff(attempt))
+		return
+	}
+	switch reservation {
+	case AlreadyProcessed:
+		c.Logger.Info(ctx, "message already processed", "messageId", msg.ID, "idempotencyKey", idempotencyKey)
+		c.done(msg)
+		return
+	case InProgress:
+		c.Logger.Info(ctx, "message in progress elsewhere, will retry", "messageId", msg.ID, "idempotencyKey", idempotencyKey)
+		msg.NackAfter(c.backoff(attempt))
+		return
+	}
+
+	handler, err := c.handle(ctx, event)
+	// domain events of the api are published for other services as well
+	ignored := err == ErrNoHandler && msg.Attributes[events.ATTRIBUTE_VERSION] != ""
+	if !ignored {
+		c.stats.handled(handler, err == nil || isRefusal(err))
+	}
+	switch {
+	case err == nil:
+		c.Logger.Info(ctx, "message successfully handled !", "messageId", msg.ID, "handler", handler)
+		c.processed(ctx, msg, idempotencyKey, handler)
+	case ignored:
+		c.Logger.Info(ctx, "no handler for domain event", "messageId", msg.ID, "type", event.Type)
+		c.release(ctx, msg, idempotencyKey)
+		c.done(msg)
+	case isRefusal(err):
+		c.Logger.Warn(ctx, "message refused", "err", err, "messageId", msg.ID, "handler", handler)
+		c.processed(ctx, msg, idempotencyKey, handler)
+	case IsPermanent(err) || attempt >= c.Config.EventMaxAttempts:
+		c.Logger.Err(ctx, "failed to handle message, giving up", "err", err, "messageId", msg.ID, "handler", handler, "attempt", attempt)
+		c.release(ctx, msg, idempotencyKey)
+		c.deadLetter(ctx, msg, handler, err, attempt)
+	default:
+		delay := c.backoff(attempt)
+		c.Logger.Warn(ctx, "failed to handle message, will retry", "err", err, "messageId", msg.ID, "handler", handler, "attempt", attempt, "delay", delay)
+		c.release(ctx, msg, idempotencyKey)
+		msg.NackAfter(delay)
+	}
+}
+
+// handle hands the event to the handler registered for its type and version, it returns the name of this handler.
+// The handler is given EventTimeout, waiting for one of its slots included
+func (c *Consumer) handle(ctx context.Context, event Event) (string, error) {
+	handler, err := c.Registry.Decode(&event)
+	if handler == nil {
+		return "", err
+	}
+	if err != nil {
+		return handler.Name(), err
+	}
+
+	if c.Config.EventTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Config.EventTimeout)
+		defer cancel()
+	}
+
+	c.stats.started(handler.Name())
+	defer c.stats.stopped(handler.Name())
+	if slots, ok := c.handlerSlots[handler.Name()]; ok {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		case <-ctx.Done():
+			return handler.Name(), errors.Wrap(ctx.Err(), "no slot left for the handler")
+		}
+	}
+	return handler.Name(), handler.Handle(ctx, event)
+}
+
+// processed acks the message. Its key is kept to skip its redeliveries, failing to keep it only risks a duplicate
+// once the reservation is taken over
+func (c *Consumer) processed(ctx context.Context, msg messaging.Message, idempotencyKey, handler string) {
+	if err := c.ProcessedEvents.Complete(idempotencyKey, handler); err != nil {
+		c.Logger.Err(ctx, "failed to record processed message", "err", err, "messageId", msg.ID)
+	}
+	c.done(msg)
+}
+
+// release lets the next delivery of the message handle it, failing to do so delays it until the reservation is taken over
+func (c *Consumer) release(ctx context.Context, msg messaging.Message, idempotencyKey string) {
+	if err := c.ProcessedEvents.Release(idempotencyKey); err != nil {
+		c.Logger.Err(ctx, "failed to release the message", "err", err, "messageId", msg.ID)
+	}
+}
+
+// deadLetter keeps the message aside for an admin to look at. It is delivered again if it cannot be kept
+func (c *Consumer) deadLetter(ctx context.Context, msg messaging.Message, handler string, cause error, attempt int) {
+	if err := c.DeadLetters.Add(deadletters.DeadLetter{
+		MessageId:        msg.ID,
+		Data:             msg.Data,
+		Attributes:       msg.Attributes,
+		PublishTime:      msg.PublishTime,
+		Handler:          handler,
+		Error:            cause.Error(),
+		DeliveryAttempts: attempt,
+	}); err != nil {
+		c.Logger.Err(ctx, "failed to store dead letter", "err", err, "messageId", msg.ID)
+		msg.NackAfter(c.backoff(attempt))
+		return
+	}
+	c.done(msg)
+}
+
+// backoff doubles the delay before every new attempt, up to EventMaxRetryDelay
+func (c *Consumer) backoff(attempt int) time.Duration {
+	delay := c.Config.EventRetryDelay
+	for i := 1; i < attempt && delay < c.Config.EventMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > c.Config.EventMaxRetryDelay {
+		delay = c.Config.EventMaxRetryDelay
+	}
+	return delay
+}
+
+func (c *Consumer) attempt(msg messaging.Message) int {
+	if msg.DeliveryAttempt > 0 {
+		return msg.DeliveryAttempt
+	}
+	c.attemptsMutex.Lock()
+	defer c.attemptsMutex.Unlock()
+	if c.attempts == nil {
+		c.attempts = map[string]int{}
+	}
+	c.attempts[msg.ID]++
+	return c.attempts[msg.ID]
+}
+
+func (c *Consumer) done(msg messaging.Message) {
+	msg.Ack()
+	c.attemptsMutex.Lock()
+	defer c.attemptsMutex.Unlock()
+	delete(c.attempts, msg.ID)
 }
@@ -0,0 +1,446 @@
+package consumers_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/common/api"
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/messaging"
+	. "github.com/Vinubaba/SANTC-API/event-manager/consumers"
+	"github.com/Vinubaba/SANTC-API/event-manager/deadletters"
+	"github.com/Vinubaba/SANTC-API/event-manager/shared"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeHandler struct {
+	events chan Event
+	// returned by the successive calls to Handle, nil once they are all returned
+	errs []error
+	sync.Mutex
+}
+
+func (h *fakeHandler) Handle(ctx context.Context, event Event) error {
+	h.Lock()
+	defer h.Unlock()
+	h.events <- event
+	if len(h.errs) == 0 {
+		return nil
+	}
+	err := h.errs[0]
+	h.errs = h.errs[1:]
+	return err
+}
+
+func (h *fakeHandler) Name() string {
+	return "fake"
+}
+
+// slowHandler handles its events once released, or fails when its context is done first
+type slowHandler struct {
+	started chan Event
+	release chan struct{}
+}
+
+func (h *slowHandler) Handle(ctx context.Context, event Event) error {
+	h.started <- event
+	select {
+	case <-h.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *slowHandler) Name() string {
+	return "slow"
+}
+
+type fakeDeadLetters struct {
+	deadLetters chan deadletters.DeadLetter
+	err         error
+}
+
+func (d *fakeDeadLetters) Add(deadLetter deadletters.DeadLetter) error {
+	if d.err != nil {
+		return d.err
+	}
+	d.deadLetters <- deadLetter
+	return nil
+}
+
+type fakeProcessedEvents struct {
+	keys       map[string]string
+	inProgress map[string]bool
+	sync.Mutex
+}
+
+func (p *fakeProcessedEvents) Reserve(idempotencyKey string, lease time.Duration) (Reservation, error) {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.keys[idempotencyKey]; ok {
+		return AlreadyProcessed, nil
+	}
+	if p.inProgress[idempotencyKey] {
+		return InProgress, nil
+	}
+	p.inProgress[idempotencyKey] = true
+	return Reserved, nil
+}
+
+func (p *fakeProcessedEvents) Complete(idempotencyKey, handler string) error {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.inProgress, idempotencyKey)
+	p.keys[idempotencyKey] = handler
+	return nil
+}
+
+func (p *fakeProcessedEvents) Release(idempotencyKey string) error {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.inProgress, idempotencyKey)
+	return nil
+}
+
+func (p *fakeProcessedEvents) Keys() map[string]string {
+	p.Lock()
+	defer p.Unlock()
+	keys := map[string]string{}
+	for key, handler := range p.keys {
+		keys[key] = handler
+	}
+	return keys
+}
+
+// extendingSubscriber records the ack deadline extensions of the messages of the broker
+type extendingSubscriber struct {
+	*messaging.MemoryBroker
+	extensions chan time.Duration
+}
+
+func (s *extendingSubscriber) Subscribe(ctx context.Context, callback messaging.SubscribeCallbackFunc) error {
+	return s.MemoryBroker.Subscribe(ctx, func(ctx context.Context, msg messaging.Message) {
+		msg.RegisterExtendDeadline(func(deadline time.Duration) error {
+			s.extensions <- deadline
+			return nil
+		})
+		callback(ctx, msg)
+	})
+}
+
+var _ = Describe("Consumer", func() {
+
+	var (
+		consumer    *Consumer
+		broker      *messaging.MemoryBroker
+		handler     *fakeHandler
+		slow        *slowHandler
+		deadLetters *fakeDeadLetters
+		processed   *fakeProcessedEvents
+		ctx         context.Context
+		cancel      context.CancelFunc
+		stopped     chan struct{}
+	)
+
+	var publish = func(data string) {
+		Expect(broker.Publish(ctx, messaging.Message{ID: "message-1", Data: []byte(data)})).To(BeNil())
+	}
+
+	BeforeEach(func() {
+		broker = messaging.NewMemoryBroker(10)
+		handler = &fakeHandler{events: make(chan Event, 10)}
+		deadLetters = &fakeDeadLetters{deadLetters: make(chan deadletters.DeadLetter, 10)}
+		processed = &fakeProcessedEvents{keys: map[string]string{}, inProgress: map[string]bool{}}
+		registry := NewRegistry()
+		Expect(registry.Register("imageApproval", 1, `{"type": "object"}`, JSONDecoder(func() interface{} {
+			return &ImageApproval{}
+		}), handler)).To(BeNil())
+		slow = &slowHandler{started: make(chan Event, 10), release: make(chan struct{})}
+		Expect(registry.Register("slow", 1, `{}`, JSONDecoder(func() interface{} {
+			return &map[string]interface{}{}
+		}), slow)).To(BeNil())
+		consumer = &Consumer{
+			Config: &shared.AppConfig{
+				EventMaxAttempts:        3,
+				EventRetryDelay:         10 * time.Millisecond,
+				EventMaxRetryDelay:      20 * time.Millisecond,
+				EventWorkers:            4,
+				EventHandlerConcurrency: map[string]int{"slow": 2},
+			},
+			Logger:          log.NewLogger("ConsumerTest"),
+			Subscriber:      broker,
+			Registry:        registry,
+			DeadLetters:     deadLetters,
+			ProcessedEvents: processed,
+		}
+		ctx, cancel = context.WithCancel(context.Background())
+	})
+
+	JustBeforeEach(func() {
+		stopped = make(chan struct{})
+		go func() {
+			consumer.Start(ctx)
+			close(stopped)
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(stopped).Should(BeClosed())
+	})
+
+	It("should hand the published events to their handler", func() {
+		publish(`{"type": "imageApproval", "senderId": "sender", "image": "data", "childId": "aaa"}`)
+
+		var event Event
+		Eventually(handler.events).Should(Receive(&event))
+		Expect(event.SenderId).To(Equal("sender"))
+		Expect(event.Data).To(Equal(&ImageApproval{Image: "data", ChildId: "aaa"}))
+		Consistently(handler.events).ShouldNot(Receive())
+	})
+
+	It("should remember the processed messages", func() {
+		publish(`{"type": "imageApproval", "childId": "aaa"}`)
+
+		Eventually(handler.events).Should(Receive())
+		Eventually(processed.Keys).Should(Equal(map[string]string{"message-1": "fake"}))
+	})
+
+	Context("When the message was already processed", func() {
+		BeforeEach(func() {
+			processed.keys["message-1"] = "fake"
+		})
+		It("should not handle it again", func() {
+			publish(`{"type": "imageApproval", "childId": "aaa"}`)
+			Consistently(handler.events).ShouldNot(Receive())
+		})
+	})
+
+	Context("When another delivery of the message is in progress", func() {
+		BeforeEach(func() {
+			processed.inProgress["message-1"] = true
+		})
+		It("should deliver it again once the other one is done", func() {
+			publish(`{"type": "imageApproval", "childId": "aaa"}`)
+			Consistently(handler.events).ShouldNot(Receive())
+
+			Expect(processed.Release("message-1")).To(BeNil())
+			Eventually(handler.events).Should(Receive())
+		})
+	})
+
+	Context("When the event has an idempotency key", func() {
+		It("should handle only once the events having the same key", func() {
+			Expect(broker.Publish(ctx, messaging.Message{ID: "message-1", Data: []byte(`{"type": "imageApproval", "idempotencyKey": "photo-1"}`)})).To(BeNil())
+			Eventually(handler.events).Should(Receive())
+			Expect(broker.Publish(ctx, messaging.Message{ID: "message-2", Data: []byte(`{"type": "imageApproval", "idempotencyKey": "photo-1"}`)})).To(BeNil())
+			Consistently(handler.events).ShouldNot(Receive())
+		})
+	})
+
+	Context("When the handler fails", func() {
+		BeforeEach(func() {
+			handler.errs = []error{errors.New("api is down")}
+		})
+		It("should deliver the message again", func() {
+			publish(`{"type": "imageApproval", "childId": "aaa"}`)
+
+			Eventually(handler.events).Should(Receive())
+			Eventually(handler.events).Should(Receive())
+			Consistently(handler.events).ShouldNot(Receive())
+			Expect(deadLetters.deadLetters).NotTo(Receive())
+		})
+	})
+
+	Context("When the handler keeps failing", func() {
+		BeforeEach(func() {
+			handler.errs = []error{errors.New("api is down"), errors.New("api is down"), errors.New("api is still down")}
+		})
+		It("should give up after EventMaxAttempts and keep a dead letter", func() {
+			publish(`{"type": "imageApproval", "childId": "aaa"}`)
+
+			var deadLetter deadletters.DeadLetter
+			Eventually(deadLetters.deadLetters).Should(Receive(&deadLetter))
+			Expect(handler.events).To(HaveLen(3))
+			Expect(deadLetter.MessageId).To(Equal("message-1"))
+			Expect(deadLetter.Handler).To(Equal("fake"))
+			Expect(deadLetter.Error).To(Equal("api is still down"))
+			Expect(deadLetter.DeliveryAttempts).To(Equal(3))
+			Expect(string(deadLetter.Data)).To(Equal(`{"type": "imageApproval", "childId": "aaa"}`))
+			Consistently(handler.events).Should(HaveLen(3))
+		})
+	})
+
+	Context("When the error is permanent", func() {
+		BeforeEach(func() {
+			handler.errs = []error{api.ErrServerBadRequest}
+		})
+		It("should keep a dead letter right away", func() {
+			publish(`{"type": "imageApproval", "childId": "aaa"}`)
+
+			Eventually(deadLetters.deadLetters).Should(Receive())
+			Expect(handler.events).To(HaveLen(1))
+		})
+	})
+
+	Context("When the photo is refused", func() {
+		BeforeEach(func() {
+			handler.errs = []error{ErrNoPhotoConsent}
+		})
+		It("should neither retry nor keep a dead letter", func() {
+			publish(`{"type": "imageApproval", "childId": "aaa"}`)
+
+			Eventually(handler.events).Should(Receive())
+			Consistently(handler.events).ShouldNot(Receive())
+			Expect(deadLetters.deadLetters).NotTo(Receive())
+			Expect(processed.Keys()).To(HaveKey("message-1"))
+		})
+	})
+
+	Context("When the message is not an event", func() {
+		It("should keep a dead letter", func() {
+			publish(`not json`)
+
+			var deadLetter deadletters.DeadLetter
+			Eventually(deadLetters.deadLetters).Should(Receive(&deadLetter))
+			Expect(deadLetter.Handler).To(BeEmpty())
+		})
+	})
+
+	Context("When no handler can handle the event", func() {
+		It("should keep a dead letter", func() {
+			publish(`{"type": "unknown"}`)
+
+			var deadLetter deadletters.DeadLetter
+			Eventually(deadLetters.deadLetters).Should(Receive(&deadLetter))
+			Expect(deadLetter.Error).To(Equal(ErrNoHandler.Error()))
+		})
+	})
+
+	It("should count the handled messages", func() {
+		publish(`{"type": "imageApproval", "childId": "aaa"}`)
+
+		Eventually(handler.events).Should(Receive())
+		Eventually(func() HandlerStats { return consumer.Stats().Handlers["fake"] }).Should(Equal(HandlerStats{Processed: 1}))
+		Expect(consumer.Stats().HandlerStats).To(Equal(HandlerStats{Processed: 1}))
+	})
+
+	Context("When the handler is limited to fewer workers", func() {
+		It("should not run more messages at the same time", func() {
+			for _, id := range []string{"slow-1", "slow-2", "slow-3"} {
+				Expect(broker.Publish(ctx, messaging.Message{ID: id, Data: []byte(`{"type": "slow"}`)})).To(BeNil())
+			}
+
+			Eventually(slow.started).Should(Receive())
+			Eventually(slow.started).Should(Receive())
+			Consistently(slow.started).ShouldNot(Receive())
+			Expect(consumer.Stats().Handlers["slow"].InFlight).To(Equal(int64(3)))
+
+			close(slow.release)
+			Eventually(slow.started).Should(Receive())
+			Eventually(func() HandlerStats { return consumer.Stats().Handlers["slow"] }).Should(Equal(HandlerStats{Processed: 3}))
+		})
+	})
+
+	Context("When the handler takes too long", func() {
+		BeforeEach(func() {
+			consumer.Config.EventTimeout = 20 * time.Millisecond
+		})
+		It("should cancel it and deliver the message again", func() {
+			publish(`{"type": "slow"}`)
+
+			Eventually(slow.started).Should(Receive())
+			Eventually(slow.started).Should(Receive())
+			Expect(consumer.Stats().Handlers["slow"].Failed).To(BeNumerically(">=", 1))
+		})
+	})
+
+	Context("When the message takes longer than the ack deadline", func() {
+		var subscriber *extendingSubscriber
+
+		BeforeEach(func() {
+			subscriber = &extendingSubscriber{MemoryBroker: broker, extensions: make(chan time.Duration, 100)}
+			consumer.Subscriber = subscriber
+			consumer.Config.MessagingAckDeadline = 20 * time.Millisecond
+		})
+		It("should extend it until the message is acked", func() {
+			publish(`{"type": "slow"}`)
+			Eventually(slow.started).Should(Receive())
+
+			Eventually(subscriber.extensions).Should(Receive(Equal(20 * time.Millisecond)))
+			close(slow.release)
+			Eventually(processed.Keys).Should(HaveKey("message-1"))
+			for len(subscriber.extensions) > 0 {
+				<-subscriber.extensions
+			}
+			Consistently(subscriber.extensions).ShouldNot(Receive())
+		})
+	})
+
+	Context("When the consumer is stopped", func() {
+		It("should finish the messages in progress first", func() {
+			publish(`{"type": "slow"}`)
+			Eventually(slow.started).Should(Receive())
+
+			cancel()
+			Consistently(stopped).ShouldNot(BeClosed())
+			close(slow.release)
+			Eventually(stopped).Should(BeClosed())
+			Expect(processed.Keys()).To(HaveKey("message-1"))
+		})
+
+		Context("When the messages in progress take longer than EventDrainTimeout", func() {
+			BeforeEach(func() {
+				consumer.Config.EventDrainTimeout = 50 * time.Millisecond
+			})
+			It("should cancel them", func() {
+				publish(`{"type": "slow"}`)
+				Eventually(slow.started).Should(Receive())
+
+				cancel()
+				Eventually(stopped).Should(BeClosed())
+				Expect(processed.Keys()).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("When no handler can handle a domain event", func() {
+		It("should ack it without keeping a dead letter", func() {
+			Expect(broker.Publish(ctx, messaging.Message{
+				ID:         "event-1",
+				Data:       []byte(`{"type": "childCreated", "version": 1, "payload": {}}`),
+				Attributes: map[string]string{"type": "childCreated", "version": "1"},
+			})).To(BeNil())
+
+			Consistently(deadLetters.deadLetters).ShouldNot(Receive())
+			Expect(handler.events).To(BeEmpty())
+		})
+	})
+
+	Context("When the dead letter cannot be kept", func() {
+		BeforeEach(func() {
+			deadLetters.err = errors.New("database is down")
+			handler.errs = []error{api.ErrServerBadRequest}
+		})
+		It("should deliver the message again", func() {
+			publish(`{"type": "imageApproval", "childId": "aaa"}`)
+
+			Eventually(handler.events).Should(Receive())
+			Eventually(handler.events).Should(Receive())
+		})
+	})
+})
+
+var _ = Describe("Errors", func() {
+	It("should tell permanent errors apart", func() {
+		Expect(IsPermanent(Permanent(errors.New("invalid event")))).To(BeTrue())
+		Expect(IsPermanent(ErrNoHandler)).To(BeTrue())
+		Expect(IsPermanent(errors.New("api is down"))).To(BeFalse())
+		Expect(IsPermanent(nil)).To(BeFalse())
+	})
+})
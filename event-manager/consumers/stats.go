@@ -0,0 +1,75 @@
+package consumers
+
+import (
+	"sync"
+)
+
+type HandlerStats struct {
+	// Messages being handled, or waiting for a slot of their handler
+	InFlight int64 `json:"inFlight"`
+	// Messages handled or refused, they will not be handled again
+	Processed int64 `json:"processed"`
+	// Failed attempts, whether the message is retried or goes to the dead letters
+	Failed int64 `json:"failed"`
+}
+
+// Stats counts the messages since the start of the consumer. The messages no handler could take only count in
+// the totals
+type Stats struct {
+	HandlerStats
+	Handlers map[string]HandlerStats `json:"handlers"`
+}
+
+type stats struct {
+	total    HandlerStats
+	handlers map[string]*HandlerStats
+	sync.Mutex
+}
+
+func (s *stats) started(handler string) {
+	s.update(handler, func(stats *HandlerStats) {
+		stats.InFlight++
+	})
+}
+
+func (s *stats) stopped(handler string) {
+	s.update(handler, func(stats *HandlerStats) {
+		stats.InFlight--
+	})
+}
+
+func (s *stats) handled(handler string, ok bool) {
+	s.update(handler, func(stats *HandlerStats) {
+		if ok {
+			stats.Processed++
+		} else {
+			stats.Failed++
+		}
+	})
+}
+
+func (s *stats) update(handler string, apply func(stats *HandlerStats)) {
+	s.Lock()
+	defer s.Unlock()
+	apply(&s.total)
+	if handler == "" {
+		return
+	}
+	if s.handlers == nil {
+		s.handlers = map[string]*HandlerStats{}
+	}
+	if s.handlers[handler] == nil {
+		s.handlers[handler] = &HandlerStats{}
+	}
+	apply(s.handlers[handler])
+}
+
+func (s *stats) snapshot() Stats {
+	s.Lock()
+	defer s.Unlock()
+	snapshot := Stats{HandlerStats: s.total, Handlers: map[string]HandlerStats{}}
+	for handler, stats := range s.handlers {
+		snapshot.Handlers[handler] = *stats
+	}
+	return snapshot
+}
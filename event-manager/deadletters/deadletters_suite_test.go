@@ -0,0 +1,13 @@
+package deadletters_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDeadLetters(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "DeadLetters Suite")
+}
@@ -0,0 +1,72 @@
+package deadletters
+
+import (
+	"context"
+
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/messaging"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrEmptyDeadLetter = errors.New("id cannot be empty")
+)
+
+type Service interface {
+	ListDeadLetters(ctx context.Context) ([]DeadLetter, error)
+	GetDeadLetter(ctx context.Context, request DeadLetterTransport) (DeadLetter, error)
+	ReplayDeadLetter(ctx context.Context, request DeadLetterTransport) error
+	DiscardDeadLetter(ctx context.Context, request DeadLetterTransport) error
+}
+
+type DeadLetterService struct {
+	Store interface {
+		Get(messageId string) (DeadLetter, error)
+		List() ([]DeadLetter, error)
+		Delete(messageId string) error
+	} `inject:""`
+	Publisher messaging.Publisher `inject:""`
+	Logger    *log.Logger         `inject:""`
+}
+
+func (s *DeadLetterService) ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	return s.Store.List()
+}
+
+func (s *DeadLetterService) GetDeadLetter(ctx context.Context, request DeadLetterTransport) (DeadLetter, error) {
+	if request.Id == nil || *request.Id == "" {
+		return DeadLetter{}, ErrEmptyDeadLetter
+	}
+	return s.Store.Get(*request.Id)
+}
+
+// ReplayDeadLetter publishes the message again, with its id, and forgets about its failure
+func (s *DeadLetterService) ReplayDeadLetter(ctx context.Context, request DeadLetterTransport) error {
+	deadLetter, err := s.GetDeadLetter(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Publisher.Publish(ctx, messaging.Message{
+		ID:         deadLetter.MessageId,
+		Data:       deadLetter.Data,
+		Attributes: deadLetter.Attributes,
+	}); err != nil {
+		return errors.Wrap(err, "failed to publish the message again")
+	}
+	s.Logger.Info(ctx, "dead letter replayed", "messageId", deadLetter.MessageId)
+
+	return s.Store.Delete(deadLetter.MessageId)
+}
+
+func (s *DeadLetterService) DiscardDeadLetter(ctx context.Context, request DeadLetterTransport) error {
+	if request.Id == nil || *request.Id == "" {
+		return ErrEmptyDeadLetter
+	}
+	if err := s.Store.Delete(*request.Id); err != nil {
+		return err
+	}
+	s.Logger.Info(ctx, "dead letter discarded", "messageId", *request.Id)
+	return nil
+}
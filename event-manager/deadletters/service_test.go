@@ -0,0 +1,142 @@
+package deadletters_test
+
+import (
+	"context"
+
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/messaging"
+	. "github.com/Vinubaba/SANTC-API/event-manager/deadletters"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+type fakeStore struct {
+	deadLetters map[string]DeadLetter
+}
+
+func (s *fakeStore) Get(messageId string) (DeadLetter, error) {
+	deadLetter, ok := s.deadLetters[messageId]
+	if !ok {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+	return deadLetter, nil
+}
+
+func (s *fakeStore) List() ([]DeadLetter, error) {
+	deadLetters := []DeadLetter{}
+	for _, deadLetter := range s.deadLetters {
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	return deadLetters, nil
+}
+
+func (s *fakeStore) Delete(messageId string) error {
+	if _, ok := s.deadLetters[messageId]; !ok {
+		return ErrDeadLetterNotFound
+	}
+	delete(s.deadLetters, messageId)
+	return nil
+}
+
+var _ = Describe("Service", func() {
+
+	var (
+		deadLetterService *DeadLetterService
+		store             *fakeStore
+		broker            *messaging.MemoryBroker
+		ctx               context.Context
+		returnedError     error
+	)
+
+	var id = func(value string) DeadLetterTransport {
+		return DeadLetterTransport{Id: &value}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		store = &fakeStore{deadLetters: map[string]DeadLetter{
+			"message-1": {
+				MessageId:  "message-1",
+				Data:       []byte(`{"type": "imageApproval"}`),
+				Attributes: map[string]string{"origin": "mobile"},
+				Error:      "api is down",
+			},
+		}}
+		broker = messaging.NewMemoryBroker(10)
+		deadLetterService = &DeadLetterService{
+			Store:     store,
+			Publisher: broker,
+			Logger:    log.NewLogger("DeadLettersTest"),
+		}
+	})
+
+	Describe("Replay", func() {
+		var request DeadLetterTransport
+
+		BeforeEach(func() {
+			request = id("message-1")
+		})
+
+		JustBeforeEach(func() {
+			returnedError = deadLetterService.ReplayDeadLetter(ctx, request)
+		})
+
+		It("should publish the message again", func() {
+			Expect(returnedError).To(BeNil())
+			received := make(chan messaging.Message, 1)
+			subscribeCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			go broker.Subscribe(subscribeCtx, func(ctx context.Context, msg messaging.Message) {
+				received <- msg
+			})
+
+			var msg messaging.Message
+			Eventually(received).Should(Receive(&msg))
+			Expect(msg.ID).To(Equal("message-1"))
+			Expect(string(msg.Data)).To(Equal(`{"type": "imageApproval"}`))
+			Expect(msg.Attributes).To(Equal(map[string]string{"origin": "mobile"}))
+		})
+		It("should forget the dead letter", func() {
+			Expect(store.deadLetters).To(BeEmpty())
+		})
+
+		Context("When the dead letter does not exist", func() {
+			BeforeEach(func() {
+				request = id("unknown")
+			})
+			It("should return ErrDeadLetterNotFound", func() {
+				Expect(errors.Cause(returnedError)).To(Equal(ErrDeadLetterNotFound))
+			})
+		})
+
+		Context("When the id is empty", func() {
+			BeforeEach(func() {
+				request = DeadLetterTransport{}
+			})
+			It("should return ErrEmptyDeadLetter", func() {
+				Expect(returnedError).To(Equal(ErrEmptyDeadLetter))
+			})
+		})
+	})
+
+	Describe("Discard", func() {
+		It("should forget the dead letter", func() {
+			Expect(deadLetterService.DiscardDeadLetter(ctx, id("message-1"))).To(BeNil())
+			Expect(store.deadLetters).To(BeEmpty())
+		})
+		It("should return ErrDeadLetterNotFound when it does not exist", func() {
+			Expect(deadLetterService.DiscardDeadLetter(ctx, id("unknown"))).To(Equal(ErrDeadLetterNotFound))
+		})
+	})
+
+	Describe("List", func() {
+		It("should return the dead letters", func() {
+			deadLetters, err := deadLetterService.ListDeadLetters(ctx)
+			Expect(err).To(BeNil())
+			Expect(deadLetters).To(HaveLen(1))
+			Expect(deadLetters[0].Error).To(Equal("api is down"))
+		})
+	})
+})
@@ -0,0 +1,122 @@
+package deadletters
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+)
+
+// DeadLetter is a message the consumer gave up on, it is kept until an admin replays or discards it
+type DeadLetter struct {
+	MessageId   string
+	Data        []byte
+	Attributes  map[string]string
+	PublishTime time.Time
+	// Name of the handler that failed, empty when no handler could take the message
+	Handler          string
+	Error            string
+	DeliveryAttempts int
+	FailedAt         time.Time
+}
+
+type Store struct {
+	Db *gorm.DB `inject:""`
+}
+
+// Add keeps a dead letter, replacing the previous failure of the same message
+func (s *Store) Add(deadLetter DeadLetter) error {
+	attributes, err := json.Marshal(deadLetter.Attributes)
+	if err != nil {
+		return err
+	}
+	return s.Db.Exec(`INSERT INTO dead_letters
+		(message_id, data, attributes, publish_time, handler, error, delivery_attempts, failed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, now())
+		ON CONFLICT (message_id) DO UPDATE SET
+		handler = excluded.handler,
+		error = excluded.error,
+		delivery_attempts = dead_letters.delivery_attempts + excluded.delivery_attempts,
+		failed_at = excluded.failed_at`,
+		deadLetter.MessageId,
+		deadLetter.Data,
+		string(attributes),
+		deadLetter.PublishTime,
+		deadLetter.Handler,
+		deadLetter.Error,
+		deadLetter.DeliveryAttempts).Error
+}
+
+func (s *Store) Get(messageId string) (DeadLetter, error) {
+	deadLetters, err := s.find(s.baseQuery().Where("message_id = ?", messageId))
+	if err != nil {
+		return DeadLetter{}, err
+	}
+	if len(deadLetters) == 0 {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+	return deadLetters[0], nil
+}
+
+// List returns the dead letters, the last failures first
+func (s *Store) List() ([]DeadLetter, error) {
+	return s.find(s.baseQuery().Order("failed_at DESC"))
+}
+
+func (s *Store) Delete(messageId string) error {
+	res := s.Db.Exec("DELETE FROM dead_letters WHERE message_id = ?", messageId)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+func (s *Store) baseQuery() *gorm.DB {
+	return s.Db.Table("dead_letters").
+		Select("message_id," +
+			"data," +
+			"attributes," +
+			"publish_time," +
+			"handler," +
+			"error," +
+			"delivery_attempts," +
+			"failed_at")
+}
+
+func (s *Store) find(query *gorm.DB) ([]DeadLetter, error) {
+	rows, err := query.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deadLetters := []DeadLetter{}
+	for rows.Next() {
+		deadLetter := DeadLetter{}
+		var attributes []byte
+		if err := rows.Scan(&deadLetter.MessageId,
+			&deadLetter.Data,
+			&attributes,
+			&deadLetter.PublishTime,
+			&deadLetter.Handler,
+			&deadLetter.Error,
+			&deadLetter.DeliveryAttempts,
+			&deadLetter.FailedAt,
+		); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(attributes, &deadLetter.Attributes); err != nil {
+			return nil, errors.Wrapf(err, "invalid attributes for dead letter %s", deadLetter.MessageId)
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	return deadLetters, rows.Err()
+}
@@ -0,0 +1,163 @@
+package deadletters
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/api/shared"
+
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrBadRouting = errors.New("inconsistent mapping between route and handler (programmer error)")
+)
+
+type DeadLetterTransport struct {
+	Id *string `json:"id"`
+	// The message as it was published, usually json
+	Data             *string           `json:"data"`
+	Attributes       map[string]string `json:"attributes"`
+	PublishTime      *string           `json:"publishTime"`
+	Handler          *string           `json:"handler"`
+	Error            *string           `json:"error"`
+	DeliveryAttempts *int              `json:"deliveryAttempts"`
+	FailedAt         *string           `json:"failedAt"`
+}
+
+type HandlerFactory struct {
+	Service Service `inject:""`
+}
+
+func (h *HandlerFactory) List(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeListEndpoint(h.Service),
+		ignorePayload,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) Get(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeGetEndpoint(h.Service),
+		decodeDeadLetterIdRequest,
+		shared.EncodeResponse200,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) Replay(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeReplayEndpoint(h.Service),
+		decodeDeadLetterIdRequest,
+		shared.EncodeResponse204,
+		opts...,
+	)
+}
+
+func (h *HandlerFactory) Discard(opts []kithttp.ServerOption) *kithttp.Server {
+	return kithttp.NewServer(
+		makeDiscardEndpoint(h.Service),
+		decodeDeadLetterIdRequest,
+		shared.EncodeResponse204,
+		opts...,
+	)
+}
+
+func makeListEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		deadLetters, err := svc.ListDeadLetters(ctx)
+		if err != nil {
+			return nil, err
+		}
+		deadLettersRet := []DeadLetterTransport{}
+
+		for _, deadLetter := range deadLetters {
+			deadLettersRet = append(deadLettersRet, deadLetterToTransport(deadLetter))
+		}
+
+		return deadLettersRet, nil
+	}
+}
+
+func makeGetEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(DeadLetterTransport)
+		deadLetter, err := svc.GetDeadLetter(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return deadLetterToTransport(deadLetter), nil
+	}
+}
+
+func makeReplayEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(DeadLetterTransport)
+		if err := svc.ReplayDeadLetter(ctx, req); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+}
+
+func makeDiscardEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(DeadLetterTransport)
+		if err := svc.DiscardDeadLetter(ctx, req); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+}
+
+func deadLetterToTransport(deadLetter DeadLetter) DeadLetterTransport {
+	data := string(deadLetter.Data)
+	publishTime := deadLetter.PublishTime.UTC().Format(time.RFC3339)
+	failedAt := deadLetter.FailedAt.UTC().Format(time.RFC3339)
+	return DeadLetterTransport{
+		Id:               &deadLetter.MessageId,
+		Data:             &data,
+		Attributes:       deadLetter.Attributes,
+		PublishTime:      &publishTime,
+		Handler:          &deadLetter.Handler,
+		Error:            &deadLetter.Error,
+		DeliveryAttempts: &deadLetter.DeliveryAttempts,
+		FailedAt:         &failedAt,
+	}
+}
+
+func decodeDeadLetterIdRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	id, ok := vars["deadLetterId"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+	return DeadLetterTransport{Id: &id}, nil
+}
+
+func ignorePayload(_ context.Context, r *http.Request) (interface{}, error) {
+	return nil, nil
+}
+
+// encode errors from business-logic
+func EncodeError(_ context.Context, err error, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	switch errors.Cause(err) {
+	case ErrDeadLetterNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case ErrEmptyDeadLetter:
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": err.Error(),
+	})
+}
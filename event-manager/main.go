@@ -2,31 +2,35 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	teddyFirebase "github.com/Vinubaba/SANTC-API/common/firebase"
 	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/mail"
 	"github.com/Vinubaba/SANTC-API/common/messaging"
 	"github.com/Vinubaba/SANTC-API/common/storage"
 	"github.com/Vinubaba/SANTC-API/common/store"
+	"github.com/Vinubaba/SANTC-API/common/store/migrations"
+	"github.com/Vinubaba/SANTC-API/event-manager/deadletters"
 	. "github.com/Vinubaba/SANTC-API/event-manager/shared"
 
 	"cloud.google.com/go/pubsub"
-	"firebase.google.com/go"
-	"firebase.google.com/go/auth"
 	"github.com/Vinubaba/SANTC-API/common/api"
 	"github.com/Vinubaba/SANTC-API/common/generator"
 	"github.com/Vinubaba/SANTC-API/event-manager/consumers"
+	"github.com/Vinubaba/SANTC-API/event-manager/tasks"
 	"github.com/facebookgo/inject"
+	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 	"github.com/pkg/errors"
 	"google.golang.org/api/iterator"
-	"google.golang.org/api/option"
 )
 
 var (
@@ -36,49 +40,81 @@ var (
 	config  *AppConfig
 	db      *gorm.DB
 
-	teddyFirebaseClient = &teddyFirebase.Client{}
-
-	dbStore    = &store.Store{}
-	gcsStorage *storage.GoogleStorage
-
-	firebaseClient *auth.Client
+	dbStore     = &store.Store{}
+	fileStorage storage.Storage
 
 	pubSubClient *messaging.Client
-
-	consumer             *consumers.Consumer
-	imageApprovalHandler *consumers.ImageApprovalHandler
-	stringGenerator      = &generator.StringGenerator{}
-	apiClient            api.Client
+	broker       messaging.Broker
+
+	consumer              *consumers.Consumer
+	imageApprovalHandler  *consumers.ImageApprovalHandler
+	invitationMailHandler *consumers.InvitationMailHandler
+	mailSender            mail.Sender
+	storageCollector      = &tasks.StorageCollector{}
+	stringGenerator       = &generator.StringGenerator{}
+	apiClient             api.Client
+
+	deadLetterStore           = &deadletters.Store{}
+	deadLetterService         = &deadletters.DeadLetterService{}
+	deadLettersHandlerFactory = &deadletters.HandlerFactory{}
+	authenticator             = &Authenticator{}
+	processedEvents           = &consumers.ProcessedEvents{}
+	outboxRelay               = &tasks.OutboxRelay{}
+	registry                  = consumers.NewRegistry()
 )
 
 func init() {
 	checkErrAndExit(initAppConfiguration())
 	checkErrAndExit(initApiClient())
 	checkErrAndExit(initStorage())
+	checkErrAndExit(initMailSender())
 	checkErrAndExit(initConsumerStarter())
-	checkErrAndExit(initPubSubClient())
 	checkErrAndExit(initPostgresConnection())
-	checkErrAndExit(initFirebase())
+	checkErrAndExit(initBroker())
 	checkErrAndExit(initApplicationGraph())
 }
 
 func initConsumerStarter() (err error) {
 	imageApprovalHandler = &consumers.ImageApprovalHandler{}
+	invitationMailHandler = &consumers.InvitationMailHandler{}
 	consumer = &consumers.Consumer{}
-	consumer.EventHandlers = append(consumer.EventHandlers, imageApprovalHandler)
+	if err = imageApprovalHandler.Register(registry); err != nil {
+		return
+	}
+	return invitationMailHandler.Register(registry)
+}
+
+func initMailSender() (err error) {
+	switch config.MailBackend {
+	case mail.BACKEND_SMTP:
+		mailSender = &mail.SMTPSender{
+			Addr:     config.SmtpAddr,
+			From:     config.SmtpFrom,
+			Username: config.SmtpUsername,
+			Password: config.SmtpPassword,
+		}
+	case mail.BACKEND_LOG:
+		mailSender = &mail.LogSender{}
+	default:
+		err = fmt.Errorf("unknown mail backend %s", config.MailBackend)
+	}
 	return
 }
 
 func initStorage() (err error) {
-	gcsStorage, err = storage.New(ctx, storage.Options{
-		BucketName:      config.BucketName,
-		CredentialsFile: config.ServiceAccount,
-	})
+	fileStorage, err = NewStorage(ctx, config)
 	return
 }
 
 func initApiClient() (err error) {
-	apiClient, err = api.NewDefaultClient("http", config.ApiServerHostname)
+	var credentials *api.ServiceCredentials
+	if config.ServiceKeyFile != "" {
+		credentials, err = api.LoadServiceCredentials(config.ServiceName, config.ServiceTokenAudience, config.ServiceKeyFile)
+		if err != nil {
+			return
+		}
+	}
+	apiClient, err = api.NewDefaultClient("http", config.ApiServerHostname, credentials)
 	return
 }
 
@@ -88,13 +124,7 @@ func initAppConfiguration() (err error) {
 }
 
 func initPostgresConnection() (err error) {
-	connectString := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		config.PgContactPoint,
-		config.PgContactPort,
-		config.PgUsername,
-		config.PgPassword,
-		config.PgDbName)
-	db, err = gorm.Open("postgres", connectString)
+	db, err = OpenDb(config)
 	if err != nil {
 		return
 	}
@@ -104,23 +134,6 @@ func initPostgresConnection() (err error) {
 	return
 }
 
-func initFirebase() error {
-	opt := option.WithCredentialsFile(config.ServiceAccount)
-	config := &firebase.Config{ProjectID: config.GcpProjectID}
-
-	firebaseApp, err := firebase.NewApp(context.Background(), config, opt)
-	if err != nil {
-		return err
-	}
-
-	firebaseClient, err = firebaseApp.Auth(context.Background())
-	if err != nil {
-		return errors.Wrap(err, "error getting Auth client")
-	}
-
-	return nil
-}
-
 func initApplicationGraph() error {
 
 	g := inject.Graph{}
@@ -128,14 +141,22 @@ func initApplicationGraph() error {
 		&inject.Object{Value: config},
 		&inject.Object{Value: db},
 		&inject.Object{Value: dbStore},
-		&inject.Object{Value: gcsStorage},
-		&inject.Object{Value: teddyFirebaseClient, Name: "teddyFirebaseClient"},
-		&inject.Object{Value: firebaseClient},
+		&inject.Object{Value: fileStorage},
 		&inject.Object{Value: logger},
 		&inject.Object{Value: imageApprovalHandler},
+		&inject.Object{Value: invitationMailHandler},
+		&inject.Object{Value: mailSender},
 		&inject.Object{Value: consumer},
+		&inject.Object{Value: storageCollector},
+		&inject.Object{Value: deadLetterStore},
+		&inject.Object{Value: deadLetterService},
+		&inject.Object{Value: deadLettersHandlerFactory},
+		&inject.Object{Value: authenticator},
+		&inject.Object{Value: processedEvents},
+		&inject.Object{Value: outboxRelay},
+		&inject.Object{Value: registry},
 		&inject.Object{Value: stringGenerator},
-		&inject.Object{Value: pubSubClient},
+		&inject.Object{Value: broker},
 		&inject.Object{Value: apiClient},
 	)
 	if err := g.Populate(); err != nil {
@@ -145,15 +166,64 @@ func initApplicationGraph() error {
 }
 
 func main() {
-	go consumer.Start(ctx)
-	/*	if config.StartupMigration {
+	if config.StartupMigration {
 		applySqlSchemaMigrations(ctx)
-	}*/
-	startHttpServer(ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	consumerStopped := make(chan struct{})
+	go func() {
+		consumer.Start(ctx)
+		close(consumerStopped)
+	}()
+	go processedEvents.Schedule(ctx, config.ProcessedEventsCleanInterval, config.ProcessedEventsTtl, config.ProcessedEventsReservationTtl)
+	if config.StorageGcInterval > 0 {
+		go storageCollector.Schedule(ctx, config.StorageGcInterval, tasks.StorageCollectorOptions{
+			DryRun:      config.StorageGcDryRun,
+			GracePeriod: config.StorageGcGracePeriod,
+		})
+	}
+	if config.OutboxRelayInterval > 0 {
+		go outboxRelay.Schedule(ctx, config.OutboxRelayInterval, tasks.OutboxRelayOptions{
+			BatchSize: config.OutboxRelayBatchSize,
+			Retention: config.OutboxRetention,
+		})
+	}
+	server := newHttpServer()
+	go shutdownOnSignal(cancel, consumerStopped, server)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		checkErrAndExit(err)
+	}
+}
+
+// shutdownOnSignal stops pulling messages, waits for the consumer to drain the ones in progress then stops the server
+func shutdownOnSignal(cancel context.CancelFunc, consumerStopped <-chan struct{}, server *http.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+
+	logger.Info(ctx, "shutting down", "signal", sig.String())
+	cancel()
+	<-consumerStopped
+	if err := server.Shutdown(context.Background()); err != nil {
+		logger.Err(ctx, "failed to shut the http server down", "err", err)
+	}
+}
 
+func applySqlSchemaMigrations(ctx context.Context) {
+	logger.Info(ctx, "applying sql schema migrations")
+	// the api keeps the version of its own schema in schema_migrations
+	migrationResult := migrations.Up(migrations.ApplyOptions{
+		SourceURL: fmt.Sprintf("file://%s", config.SqlMigrationsSourceDir),
+		DatabaseURL: fmt.Sprintf("postgres://%v:%v/%v?sslmode=disable&user=%s&password=%s&x-migrations-table=event_manager_schema_migrations",
+			config.PgContactPoint, config.PgContactPort, config.PgDbName, config.PgUsername, config.PgPassword),
+	})
+	checkErrAndExit(migrationResult.Err)
+	if !migrationResult.Changes {
+		logger.Info(ctx, "no new migrations applied")
+	}
 }
 
-func startHttpServer(ctx context.Context) {
+func newHttpServer() *http.Server {
 
 	router := mux.NewRouter()
 
@@ -170,7 +240,21 @@ func startHttpServer(ctx context.Context) {
 		w.Write(swagger)
 	})
 
-	checkErrAndExit(http.ListenAndServe("0.0.0.0:8080", router))
+	deadLettersOpts := []kithttp.ServerOption{
+		kithttp.ServerErrorLogger(logger),
+		kithttp.ServerErrorEncoder(deadletters.EncodeError),
+	}
+	router.Handle("/dead-letters", authenticator.Admin(deadLettersHandlerFactory.List(deadLettersOpts))).Methods(http.MethodGet)
+	router.Handle("/dead-letters/{deadLetterId}", authenticator.Admin(deadLettersHandlerFactory.Get(deadLettersOpts))).Methods(http.MethodGet)
+	router.Handle("/dead-letters/{deadLetterId}", authenticator.Admin(deadLettersHandlerFactory.Discard(deadLettersOpts))).Methods(http.MethodDelete)
+	router.Handle("/dead-letters/{deadLetterId}/replay", authenticator.Admin(deadLettersHandlerFactory.Replay(deadLettersOpts))).Methods(http.MethodPost)
+
+	router.Handle("/stats", authenticator.Admin(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(consumer.Stats())
+	}))).Methods(http.MethodGet)
+
+	return &http.Server{Addr: "0.0.0.0:8080", Handler: router}
 }
 
 func checkErrAndExit(err error) {
@@ -181,12 +265,34 @@ func checkErrAndExit(err error) {
 	os.Exit(1)
 }
 
+func initBroker() (err error) {
+	switch config.MessagingBroker {
+	case messaging.BROKER_PUBSUB:
+		err = initPubSubClient()
+		broker = pubSubClient
+	case messaging.BROKER_POSTGRES:
+		broker, err = messaging.NewPostgresBroker(messaging.PostgresOptions{
+			Db:            db,
+			ConnectString: ConnectString(config),
+			Topic:         config.GcpTopic,
+			PollInterval:  config.MessagingPollInterval,
+			AckDeadline:   config.MessagingAckDeadline,
+		})
+	case messaging.BROKER_MEMORY:
+		broker = messaging.NewMemoryBroker(100)
+	default:
+		err = fmt.Errorf("unknown messaging broker %s", config.MessagingBroker)
+	}
+	return
+}
+
 func initPubSubClient() (err error) {
 	pubSubClient, err = messaging.New(messaging.ClientOptions{
 		ProjectID:      config.GcpProjectID,
 		Subscription:   config.GcpSubscription,
 		Topic:          config.GcpTopic,
 		CredentialPath: config.ServiceAccount,
+		MaxNackDelay:   config.MessagingAckDeadline / 4,
 	})
 	if err != nil {
 		return err
@@ -214,7 +320,7 @@ func ensureTopicAndSubscriptionsAreCreated() {
 					_, err := pubSubClient.GetPubSubClient().CreateSubscription(ctx, config.GcpSubscription, pubsub.SubscriptionConfig{
 						Topic:               topic,
 						RetainAckedMessages: false,
-						AckDeadline:         20 * time.Second,
+						AckDeadline:         config.MessagingAckDeadline,
 					})
 					if err != nil {
 						logger.Err(ctx, "errors while creating subscription", "err", err)
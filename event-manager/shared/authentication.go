@@ -0,0 +1,64 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/Vinubaba/SANTC-API/common/api"
+	"github.com/Vinubaba/SANTC-API/common/roles"
+
+	"github.com/pkg/errors"
+)
+
+// Authenticator protects the administration endpoints of the event-manager with the tokens of the api users. The
+// api checks them with its identity provider, whichever it is
+type Authenticator struct {
+	ApiClient interface {
+		Me(ctx context.Context, token string) (api.MeTransport, error)
+	} `inject:""`
+}
+
+// Admin only lets through the users having the admin role in the api
+func (a *Authenticator) Admin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		bearerToken := strings.Split(req.Header.Get("authorization"), " ")
+		if len(bearerToken) != 2 {
+			writeError(w, "invalid authorization token", http.StatusBadRequest)
+			return
+		}
+
+		user, err := a.ApiClient.Me(req.Context(), bearerToken[1])
+		if errors.Cause(err) == api.ErrServerBadRequest {
+			writeError(w, "invalid authorization token", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			writeError(w, "failed to verify the authorization token: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		if !isAdmin(user) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func isAdmin(user api.MeTransport) bool {
+	for _, role := range user.Roles {
+		if role == roles.ROLE_ADMIN {
+			return true
+		}
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, description string, code int) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": description,
+	})
+}
@@ -2,6 +2,7 @@ package shared
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
@@ -15,16 +16,93 @@ type AppConfig struct {
 	PgContactPort          string `split_words:"true" default:"5432"`
 	PgDbName               string `split_words:"true" default:"teddycare"`
 	SqlMigrationsSourceDir string `split_words:"true" default:"C:\\Users\\arthur\\gocode\\src\\github.com\\Vinubaba\\SANTC-API\\api\\sql"`
-	LocalStoragePath       string `split_words:"true"`
 	ApiServerHostname      string `split_words:"true" default:"teddycare"`
 
+	// The event-manager calls the api with tokens signed by this PEM private key, the api knows its public key by
+	// ServiceName. Without key the requests are sent without authorization
+	ServiceName          string `split_words:"true" default:"event-manager"`
+	ServiceKeyFile       string `split_words:"true"`
+	ServiceTokenAudience string `split_words:"true" default:"teddycare-api"`
+
 	GcpProjectID    string `split_words:"true" default:"teddy-care"`
 	GcpSubscription string `split_words:"true" default:"events"`
 	GcpTopic        string `split_words:"true" default:"events"`
+	// Credentials of the pubsub broker and of the gcs storage backend, the other backends don't need it
+	ServiceAccount string `split_words:"true" default:"C:\\Users\\arthur\\code\\kubernetes-configuration\\event-manager-sa.json"`
+
+	// Where the events come from: "pubsub", "postgres" (the messages table, topic GcpTopic) or "memory" (nothing
+	// survives a restart)
+	MessagingBroker string `split_words:"true" default:"pubsub"`
+	// How often the postgres broker looks for messages when no notification woke it up
+	MessagingPollInterval time.Duration `split_words:"true" default:"5s"`
+	// A message neither acked nor nacked is delivered again after that, it must be longer than EventTimeout. It is
+	// extended while the message waits for a worker or is handled. Pub/Sub accepts up to 10m
+	MessagingAckDeadline time.Duration `split_words:"true" default:"3m"`
+	// Messages handled at the same time, no message is pulled while they are all busy
+	EventWorkers int `split_words:"true" default:"10"`
+	// Limits some handlers to fewer workers, e.g imageApproval:2
+	EventHandlerConcurrency map[string]int `split_words:"true"`
+	// Time given to a handler for a message, the attempt fails after that. 0 disables it
+	EventTimeout time.Duration `split_words:"true" default:"2m"`
+	// On shutdown, the messages in progress are given that long to finish before being cancelled. 0 waits for them
+	EventDrainTimeout time.Duration `split_words:"true" default:"30s"`
+	// A message failing that many times goes to the dead letters, see /dead-letters
+	EventMaxAttempts int `split_words:"true" default:"5"`
+	// Delay before the second attempt, it doubles with every attempt up to EventMaxRetryDelay. With pubsub the
+	// event-manager holds the message meanwhile, the delay is at most a quarter of MessagingAckDeadline
+	EventRetryDelay    time.Duration `split_words:"true" default:"10s"`
+	EventMaxRetryDelay time.Duration `split_words:"true" default:"10m"`
+	// How long the ids of the handled messages are kept to skip their redeliveries. Pub/Sub keeps messages 7 days
+	ProcessedEventsTtl           time.Duration `split_words:"true" default:"168h"`
+	ProcessedEventsCleanInterval time.Duration `split_words:"true" default:"1h"`
+	// A message still in progress after that is deemed abandoned by a consumer gone meanwhile and its reservation is
+	// forgotten, it must be longer than EventTimeout
+	ProcessedEventsReservationTtl time.Duration `split_words:"true" default:"24h"`
+	// How often the domain events recorded by the api are published, 0 disables it
+	OutboxRelayInterval  time.Duration `split_words:"true" default:"1s"`
+	OutboxRelayBatchSize int           `split_words:"true" default:"100"`
+	// Published events are kept that long in the outbox, 0 keeps them forever
+	OutboxRetention time.Duration `split_words:"true" default:"168h"`
+
+	// Where the images are kept: "gcs", "local" or "s3". Local files must be shared with the api, with the same secret
+	StorageBackend     string `split_words:"true" default:"gcs"`
+	LocalStoragePath   string `split_words:"true"`
+	LocalStorageUrl    string `split_words:"true" default:"http://localhost:8080/files"`
+	LocalStorageSecret string `split_words:"true"`
+	// S3 compatible service, e.g http://localhost:9000 for MinIO. The files go to BucketName
+	S3Endpoint  string `split_words:"true"`
+	S3Region    string `split_words:"true" default:"us-east-1"`
+	S3AccessKey string `split_words:"true"`
+	S3SecretKey string `split_words:"true"`
+	// MinIO expects the bucket in the path of the urls rather than in the host name
+	S3PathStyle bool `split_words:"true" default:"false"`
 
 	BucketName string `split_words:"true" default:"teddycare"`
+	// Longest side, in pixels, of the stored images and of their thumbnails
+	MaxImageSize  int `split_words:"true" default:"2048"`
+	ThumbnailSize int `split_words:"true" default:"320"`
+	// Program converting webp and heic uploads to jpeg, see the Dockerfile
+	ImageConverterCommand string        `split_words:"true" default:"convert"`
+	ImageConverterTimeout time.Duration `split_words:"true" default:"30s"`
+	// Biggest uploaded image, in bytes, whether it is sent as a file or as a data uri
+	MaxFileSize int64 `split_words:"true" default:"10485760"`
+	// Most pixels, width times height, of an uploaded image. A small file can declare a huge image
+	MaxImagePixels int `split_words:"true" default:"50000000"`
 
-	ServiceAccount string `split_words:"true" default:"C:\\Users\\arthur\\code\\kubernetes-configuration\\event-manager-sa.json"`
+	// Removes the files of the bucket no row refers to anymore every interval, 0 disables it. See cmd/storage-gc
+	StorageGcInterval time.Duration `split_words:"true" default:"24h"`
+	// Only logs the orphaned files, set it to false to delete them
+	StorageGcDryRun bool `split_words:"true" default:"true"`
+	// Files younger than that are never collected, they may belong to a transaction in progress
+	StorageGcGracePeriod time.Duration `split_words:"true" default:"24h"`
+
+	// How the emails are sent: "smtp", or "log" to only log them. In development SmtpAddr can be a sink such as
+	// MailHog, localhost:1025
+	MailBackend  string `split_words:"true" default:"smtp"`
+	SmtpAddr     string `split_words:"true" default:"localhost:1025"`
+	SmtpFrom     string `split_words:"true" default:"TeddyCare <noreply@teddycare.net>"`
+	SmtpUsername string `split_words:"true"`
+	SmtpPassword string `split_words:"true"`
 
 	StartupMigration bool `split_words:"true" default:"false"`
 }
@@ -35,6 +113,15 @@ func InitAppConfiguration() (config *AppConfig, err error) {
 	if err := envconfig.Process(CONFIG_PREFIX, config); err != nil {
 		return nil, fmt.Errorf("failed to parse env vars: %v", err)
 	}
+	if config.MessagingAckDeadline <= 0 {
+		return nil, fmt.Errorf("EVENT_MANAGER_MESSAGING_ACK_DEADLINE must be positive")
+	}
+	if config.EventTimeout > 0 && config.MessagingAckDeadline <= config.EventTimeout {
+		return nil, fmt.Errorf("EVENT_MANAGER_MESSAGING_ACK_DEADLINE (%v) must be longer than EVENT_MANAGER_EVENT_TIMEOUT (%v)", config.MessagingAckDeadline, config.EventTimeout)
+	}
+	if config.ProcessedEventsReservationTtl <= config.EventTimeout {
+		return nil, fmt.Errorf("EVENT_MANAGER_PROCESSED_EVENTS_RESERVATION_TTL (%v) must be longer than EVENT_MANAGER_EVENT_TIMEOUT (%v)", config.ProcessedEventsReservationTtl, config.EventTimeout)
+	}
 
 	return
 }
@@ -0,0 +1,65 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Vinubaba/SANTC-API/common/storage"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+)
+
+// NewStorage connects to the storage backend of the configuration
+func NewStorage(ctx context.Context, config *AppConfig) (storage.Storage, error) {
+	imageOptions := storage.ImageOptions{
+		MaxImageSize:          config.MaxImageSize,
+		ThumbnailSize:         config.ThumbnailSize,
+		ImageConverterCommand: config.ImageConverterCommand,
+		ImageConverterTimeout: config.ImageConverterTimeout,
+		MaxFileSize:           config.MaxFileSize,
+		MaxImagePixels:        config.MaxImagePixels,
+	}
+
+	switch config.StorageBackend {
+	case storage.BACKEND_GCS:
+		return storage.New(ctx, storage.Options{
+			BucketName:      config.BucketName,
+			CredentialsFile: config.ServiceAccount,
+			ImageOptions:    imageOptions,
+		})
+	case storage.BACKEND_LOCAL:
+		return storage.NewLocal(storage.LocalOptions{
+			Path:         config.LocalStoragePath,
+			Url:          config.LocalStorageUrl,
+			Secret:       config.LocalStorageSecret,
+			ImageOptions: imageOptions,
+		})
+	case storage.BACKEND_S3:
+		return storage.NewS3(storage.S3Options{
+			Endpoint:     config.S3Endpoint,
+			Region:       config.S3Region,
+			BucketName:   config.BucketName,
+			AccessKey:    config.S3AccessKey,
+			SecretKey:    config.S3SecretKey,
+			PathStyle:    config.S3PathStyle,
+			ImageOptions: imageOptions,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %s", config.StorageBackend)
+	}
+}
+
+// OpenDb connects to the postgres database of the configuration
+func OpenDb(config *AppConfig) (*gorm.DB, error) {
+	return gorm.Open("postgres", ConnectString(config))
+}
+
+func ConnectString(config *AppConfig) string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		config.PgContactPoint,
+		config.PgContactPort,
+		config.PgUsername,
+		config.PgPassword,
+		config.PgDbName)
+}
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS dead_letters (
+  message_id varchar NOT NULL PRIMARY KEY,
+  data bytea,
+  attributes jsonb NOT NULL default '{}',
+  publish_time timestamp with time zone NOT NULL default now(),
+  handler varchar NOT NULL default '',
+  error text NOT NULL,
+  delivery_attempts integer NOT NULL default 0,
+  failed_at timestamp with time zone NOT NULL default now()
+);
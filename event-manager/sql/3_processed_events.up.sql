@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS processed_events (
+  idempotency_key varchar NOT NULL PRIMARY KEY,
+  handler varchar NOT NULL default '',
+  processed_at timestamp with time zone NOT NULL default now()
+);
+CREATE INDEX IF NOT EXISTS processed_events_processed_at_idx ON processed_events (processed_at);
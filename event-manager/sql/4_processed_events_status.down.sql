@@ -0,0 +1 @@
+ALTER TABLE processed_events DROP COLUMN IF EXISTS status;
@@ -0,0 +1 @@
+ALTER TABLE processed_events ADD COLUMN IF NOT EXISTS status varchar NOT NULL default 'done';
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS messages (
+  message_id varchar NOT NULL PRIMARY KEY,
+  topic varchar NOT NULL,
+  data bytea,
+  attributes jsonb NOT NULL default '{}',
+  publish_time timestamp with time zone NOT NULL default now(),
+  -- the message is hidden from the subscribers until then, while one of them handles it
+  deliver_after timestamp with time zone NOT NULL default now(),
+  delivery_attempts integer NOT NULL default 0
+);
+CREATE INDEX IF NOT EXISTS messages_topic_deliver_after_idx ON messages (topic, deliver_after);
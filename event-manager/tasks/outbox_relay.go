@@ -0,0 +1,103 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/common/events"
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/messaging"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+type OutboxRelayOptions struct {
+	// Most events published in one transaction. Defaults to 100
+	BatchSize int
+	// Published events are deleted after that long, 0 keeps them
+	Retention time.Duration
+}
+
+// OutboxRelay publishes the domain events the api recorded in the outbox, in the order they were recorded. An event
+// is published at least once: it is published again if the relay stops before marking it
+type OutboxRelay struct {
+	Store interface {
+		RelayOutboxEvents(limit int, publish func(envelope events.Envelope) error) (int, error)
+		DeleteOutboxEvents(tx *gorm.DB, publishedBefore time.Time) (int64, error)
+	} `inject:""`
+	Publisher messaging.Publisher `inject:""`
+	Logger    *log.Logger         `inject:""`
+}
+
+// Relay publishes the pending events, it returns how many were published
+func (r *OutboxRelay) Relay(ctx context.Context, options OutboxRelayOptions) (int, error) {
+	options = options.withDefaults()
+	published, err := r.Store.RelayOutboxEvents(options.BatchSize, func(envelope events.Envelope) error {
+		data, err := json.Marshal(envelope)
+		if err != nil {
+			return err
+		}
+		return r.Publisher.Publish(ctx, messaging.Message{
+			// the event id lets consumers skip the events published twice
+			ID:   envelope.Id,
+			Data: data,
+			Attributes: map[string]string{
+				events.ATTRIBUTE_TYPE:    envelope.Type,
+				events.ATTRIBUTE_VERSION: strconv.Itoa(envelope.Version),
+			},
+		})
+	})
+	if err != nil {
+		return published, errors.Wrap(err, "failed to relay outbox events")
+	}
+	return published, nil
+}
+
+// Schedule relays the events every interval until the context is done, a full batch is followed by the next one
+// right away
+func (r *OutboxRelay) Schedule(ctx context.Context, interval time.Duration, options OutboxRelayOptions) {
+	options = options.withDefaults()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastClean := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				published, err := r.Relay(ctx, options)
+				if err != nil {
+					r.Logger.Err(ctx, "failed to relay outbox events", "err", err, "published", published)
+				}
+				if err != nil || published < options.BatchSize {
+					break
+				}
+			}
+			if options.Retention > 0 && time.Since(lastClean) > options.Retention {
+				r.clean(ctx, options.Retention)
+				lastClean = time.Now()
+			}
+		}
+	}
+}
+
+func (r *OutboxRelay) clean(ctx context.Context, retention time.Duration) {
+	deleted, err := r.Store.DeleteOutboxEvents(nil, time.Now().Add(-retention))
+	if err != nil {
+		r.Logger.Err(ctx, "failed to delete published outbox events", "err", err)
+		return
+	}
+	r.Logger.Info(ctx, "published outbox events deleted", "deleted", deleted)
+}
+
+func (o OutboxRelayOptions) withDefaults() OutboxRelayOptions {
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+	return o
+}
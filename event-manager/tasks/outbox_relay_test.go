@@ -0,0 +1,143 @@
+package tasks_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/common/events"
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/messaging"
+	. "github.com/Vinubaba/SANTC-API/event-manager/tasks"
+
+	"github.com/jinzhu/gorm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeOutbox struct {
+	pending   []events.Envelope
+	published []string
+}
+
+func (o *fakeOutbox) RelayOutboxEvents(limit int, publish func(envelope events.Envelope) error) (int, error) {
+	count := 0
+	for len(o.pending) > 0 && count < limit {
+		if err := publish(o.pending[0]); err != nil {
+			return count, err
+		}
+		o.published = append(o.published, o.pending[0].Id)
+		o.pending = o.pending[1:]
+		count++
+	}
+	return count, nil
+}
+
+func (o *fakeOutbox) DeleteOutboxEvents(tx *gorm.DB, publishedBefore time.Time) (int64, error) {
+	return 0, nil
+}
+
+type failingPublisher struct {
+	messaging.Publisher
+	failAfter int
+}
+
+func (p *failingPublisher) Publish(ctx context.Context, message messaging.Message) error {
+	if p.failAfter == 0 {
+		return errors.New("broker is down")
+	}
+	p.failAfter--
+	return p.Publisher.Publish(ctx, message)
+}
+
+var _ = Describe("OutboxRelay", func() {
+
+	var (
+		relay  *OutboxRelay
+		outbox *fakeOutbox
+		broker *messaging.MemoryBroker
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+
+	var envelope = func(id string, payload events.Payload) events.Envelope {
+		e, err := events.NewEnvelope(id, "namek", time.Now(), payload)
+		Expect(err).To(BeNil())
+		return e
+	}
+
+	var received = func() []messaging.Message {
+		messages := make(chan messaging.Message, 10)
+		subscribeCtx, stop := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer stop()
+		broker.Subscribe(subscribeCtx, func(ctx context.Context, msg messaging.Message) {
+			msg.Ack()
+			messages <- msg
+		})
+		close(messages)
+		result := []messaging.Message{}
+		for msg := range messages {
+			result = append(result, msg)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		broker = messaging.NewMemoryBroker(10)
+		outbox = &fakeOutbox{pending: []events.Envelope{
+			envelope("event-1", events.ChildCreatedV1{ChildId: "goku", ClassId: "blue"}),
+			envelope("event-2", events.ChildEnrolledV1{ChildId: "goku", ClassId: "blue"}),
+			envelope("event-3", events.UserDeletedV1{UserId: "vegeta", Roles: []string{"adult"}}),
+		}}
+		relay = &OutboxRelay{
+			Store:     outbox,
+			Publisher: broker,
+			Logger:    log.NewLogger("OutboxRelayTest"),
+		}
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("should publish the pending events in order", func() {
+		published, err := relay.Relay(ctx, OutboxRelayOptions{BatchSize: 10})
+		Expect(err).To(BeNil())
+		Expect(published).To(Equal(3))
+
+		messages := received()
+		Expect(messages).To(HaveLen(3))
+		Expect(messages[0].ID).To(Equal("event-1"))
+		Expect(messages[1].ID).To(Equal("event-2"))
+		Expect(messages[2].ID).To(Equal("event-3"))
+		Expect(messages[0].Attributes).To(Equal(map[string]string{"type": "childCreated", "version": "1"}))
+
+		var published0 events.Envelope
+		Expect(json.Unmarshal(messages[0].Data, &published0)).To(BeNil())
+		Expect(published0.Type).To(Equal(events.TYPE_CHILD_CREATED))
+		Expect(published0.DaycareId).To(Equal("namek"))
+		Expect(string(published0.Payload)).To(MatchJSON(`{"childId": "goku", "classId": "blue"}`))
+	})
+
+	It("should publish at most a batch", func() {
+		published, err := relay.Relay(ctx, OutboxRelayOptions{BatchSize: 2})
+		Expect(err).To(BeNil())
+		Expect(published).To(Equal(2))
+		Expect(outbox.pending).To(HaveLen(1))
+	})
+
+	Context("When the publication fails", func() {
+		BeforeEach(func() {
+			relay.Publisher = &failingPublisher{Publisher: broker, failAfter: 1}
+		})
+		It("should keep the remaining events for later", func() {
+			published, err := relay.Relay(ctx, OutboxRelayOptions{BatchSize: 10})
+			Expect(err).NotTo(BeNil())
+			Expect(published).To(Equal(1))
+			Expect(outbox.published).To(Equal([]string{"event-1"}))
+			Expect(outbox.pending).To(HaveLen(2))
+		})
+	})
+})
@@ -0,0 +1,113 @@
+package tasks
+
+import (
+	"context"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/storage"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+type StorageCollectorOptions struct {
+	// Only reports the orphans, nothing is deleted
+	DryRun bool
+	// Files younger than that are never collected, they may belong to a transaction not committed yet
+	GracePeriod time.Duration
+	// Restricts the collection to the files of one daycare, every daycare when empty
+	DaycareId string
+}
+
+type StorageReport struct {
+	// Files found under the collected folder
+	Scanned int
+	// Files no row refers to, their variants included
+	Orphans []string
+	Deleted int
+	Failed  int
+}
+
+// StorageCollector removes the files of the bucket the database does not refer to anymore: images replaced by an
+// update, or stored by a transaction that failed afterwards
+type StorageCollector struct {
+	Store interface {
+		ListImageUris(tx *gorm.DB) ([]string, error)
+	} `inject:""`
+	Storage storage.Storage `inject:""`
+	Logger  *log.Logger     `inject:""`
+}
+
+func (c *StorageCollector) Collect(ctx context.Context, options StorageCollectorOptions) (StorageReport, error) {
+	report := StorageReport{Orphans: []string{}}
+
+	prefix := "daycares/"
+	if options.DaycareId != "" {
+		prefix += options.DaycareId + "/"
+	}
+	// listed first: a file stored between the two calls is younger than the grace period anyway
+	files, err := c.Storage.List(ctx, prefix)
+	if err != nil {
+		return report, errors.Wrap(err, "failed to list stored files")
+	}
+	imageUris, err := c.Store.ListImageUris(nil)
+	if err != nil {
+		return report, errors.Wrap(err, "failed to list image uris")
+	}
+
+	referenced := map[string]bool{}
+	for _, imageUri := range imageUris {
+		referenced[imageUri] = true
+		referenced[storage.ThumbnailName(imageUri)] = true
+	}
+
+	// thumbnails of orphans, deleted with them
+	variants := map[string]bool{}
+	threshold := time.Now().Add(-options.GracePeriod)
+	report.Scanned = len(files)
+	for _, file := range files {
+		if referenced[file.Name] || file.Updated.After(threshold) {
+			continue
+		}
+		variants[storage.ThumbnailName(file.Name)] = true
+		report.Orphans = append(report.Orphans, file.Name)
+	}
+
+	for _, orphan := range report.Orphans {
+		c.Logger.Info(ctx, "orphaned file", "file", orphan, "dryRun", options.DryRun)
+		if options.DryRun {
+			continue
+		}
+		if variants[orphan] {
+			continue
+		}
+		if err := c.Storage.Delete(ctx, orphan); err != nil {
+			c.Logger.Warn(ctx, "failed to delete orphaned file", "file", orphan, "err", err.Error())
+			report.Failed++
+			continue
+		}
+		report.Deleted++
+	}
+
+	c.Logger.Info(ctx, "storage collected", "prefix", prefix, "scanned", report.Scanned, "orphans", len(report.Orphans),
+		"deleted", report.Deleted, "failed", report.Failed, "dryRun", options.DryRun)
+	return report, nil
+}
+
+// Schedule collects the storage every interval until the context is done
+func (c *StorageCollector) Schedule(ctx context.Context, interval time.Duration, options StorageCollectorOptions) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := c.Collect(ctx, options); err != nil {
+				c.Logger.Err(ctx, "failed to collect storage", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package tasks_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Vinubaba/SANTC-API/common/log"
+	"github.com/Vinubaba/SANTC-API/common/storage"
+	. "github.com/Vinubaba/SANTC-API/common/storage/mocks"
+	. "github.com/Vinubaba/SANTC-API/event-manager/tasks"
+
+	"github.com/jinzhu/gorm"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+)
+
+type fakeStore struct {
+	imageUris []string
+}
+
+func (s *fakeStore) ListImageUris(tx *gorm.DB) ([]string, error) {
+	return s.imageUris, nil
+}
+
+var _ = Describe("StorageCollector", func() {
+
+	var (
+		collector     *StorageCollector
+		mockStorage   *MockGcs
+		options       StorageCollectorOptions
+		report        StorageReport
+		returnedError error
+		ctx           = context.Background()
+		old           = time.Now().Add(-48 * time.Hour)
+	)
+
+	var deleted = func() []string {
+		files := []string{}
+		for _, call := range mockStorage.CallsForMethod("Delete") {
+			files = append(files, call.Arguments.String(1))
+		}
+		return files
+	}
+
+	BeforeEach(func() {
+		mockStorage = &MockGcs{}
+		mockStorage.On("List", mock.Anything, "daycares/").Return([]storage.StoredFile{
+			{Name: "daycares/namek/children/kept.jpg", Updated: old},
+			{Name: "daycares/namek/children/kept_thumb.jpg", Updated: old},
+			{Name: "daycares/namek/children/replaced.jpg", Updated: old},
+			{Name: "daycares/namek/children/replaced_thumb.jpg", Updated: old},
+			{Name: "daycares/namek/classes/lonely_thumb.jpg", Updated: old},
+			{Name: "daycares/namek/users/uploading.png", Updated: time.Now()},
+		}, nil)
+		mockStorage.On("Delete", mock.Anything, mock.Anything).Return(nil)
+
+		collector = &StorageCollector{
+			Store:   &fakeStore{imageUris: []string{"daycares/namek/children/kept.jpg", "gs://foo/legacy.jpg"}},
+			Storage: mockStorage,
+			Logger:  log.NewLogger("StorageCollectorTest"),
+		}
+		options = StorageCollectorOptions{GracePeriod: 24 * time.Hour}
+	})
+
+	JustBeforeEach(func() {
+		report, returnedError = collector.Collect(ctx, options)
+	})
+
+	It("should not return an error", func() {
+		Expect(returnedError).To(BeNil())
+	})
+
+	It("should report the files no row refers to", func() {
+		Expect(report.Scanned).To(Equal(6))
+		Expect(report.Orphans).To(ConsistOf(
+			"daycares/namek/children/replaced.jpg",
+			"daycares/namek/children/replaced_thumb.jpg",
+			"daycares/namek/classes/lonely_thumb.jpg",
+		))
+	})
+
+	It("should delete the orphans, the thumbnails going with their image", func() {
+		Expect(deleted()).To(ConsistOf("daycares/namek/children/replaced.jpg", "daycares/namek/classes/lonely_thumb.jpg"))
+		Expect(report.Deleted).To(Equal(2))
+	})
+
+	Context("When it is a dry run", func() {
+		BeforeEach(func() {
+			options.DryRun = true
+		})
+		It("should only report the orphans", func() {
+			Expect(report.Orphans).To(HaveLen(3))
+			Expect(deleted()).To(BeEmpty())
+			Expect(report.Deleted).To(Equal(0))
+		})
+	})
+
+	Context("When the collection is restricted to a daycare", func() {
+		BeforeEach(func() {
+			options.DaycareId = "peyredragon"
+			mockStorage.On("List", mock.Anything, "daycares/peyredragon/").Return([]storage.StoredFile{}, nil)
+		})
+		It("should only list the files of the daycare", func() {
+			Expect(report.Scanned).To(Equal(0))
+			Expect(report.Orphans).To(BeEmpty())
+		})
+	})
+
+	Context("When a file cannot be deleted", func() {
+		BeforeEach(func() {
+			mockStorage.ExpectedCalls = nil
+			mockStorage.On("List", mock.Anything, "daycares/").Return([]storage.StoredFile{
+				{Name: "daycares/namek/children/a.jpg", Updated: old},
+				{Name: "daycares/namek/children/b.jpg", Updated: old},
+			}, nil)
+			mockStorage.On("Delete", mock.Anything, "daycares/namek/children/a.jpg").Return(errors.New("forbidden"))
+			mockStorage.On("Delete", mock.Anything, "daycares/namek/children/b.jpg").Return(nil)
+		})
+		It("should go on with the other files", func() {
+			Expect(returnedError).To(BeNil())
+			Expect(report.Failed).To(Equal(1))
+			Expect(report.Deleted).To(Equal(1))
+		})
+	})
+})